@@ -36,6 +36,7 @@ import (
 	"ai-gateway/internal/providers"
 	"ai-gateway/internal/services"
 	"ai-gateway/internal/templates"
+	"ai-gateway/internal/tokenizer"
 
 	_ "ai-gateway/docs"
 	"github.com/go-chi/chi/v5"
@@ -93,6 +94,9 @@ func main() {
 	if err := os.MkdirAll("./logs", 0755); err != nil {
 		log.Fatalf("Failed to create logs directory: %v", err)
 	}
+	if err := os.MkdirAll(cfg.Batch.Dir, 0755); err != nil {
+		log.Fatalf("Failed to create batch files directory: %v", err)
+	}
 
 	db, err := initDatabase(cfg)
 	if err != nil {
@@ -107,6 +111,17 @@ func main() {
 	geminiService := services.NewGeminiService(db, cfg)
 	statsService := services.NewStatsService(db)
 	toolService := services.NewToolService(cfg.ServerTools.Tools)
+	batchService := services.NewBatchService(db, cfg.Batch.Dir)
+	jobService := services.NewJobService(db)
+	moderationService, err := services.NewModerationService(cfg.Moderation)
+	if err != nil {
+		log.Fatalf("Failed to load moderation rules: %v", err)
+	}
+
+	if cfg.Tokenizer.VocabDir != "" {
+		tokenizer.SetVocabDir(cfg.Tokenizer.VocabDir)
+	}
+	tokenizer.SetContextWindows(cfg.Tokenizer.ContextWindows)
 
 	// Build the multi-backend provider registry from config
 	providerRegistry := providers.BuildRegistry(cfg)
@@ -119,12 +134,16 @@ func main() {
 
 	router.Use(middleware.Recovery)
 	router.Use(middleware.SecurityHeaders)
-	router.Use(middleware.MaxRequestSize(10 << 20))
+	router.Use(middleware.MaxRequestSize(10<<20, "/v1/audio/transcriptions", "/v1/audio/translations", "/v1/images/edits", "/v1/files"))
 
-	proxyHandler := handlers.NewProxyHandler(geminiService, statsService)
+	proxyHandler := handlers.NewProxyHandler(geminiService, statsService, providerRegistry)
 	healthHandler := handlers.NewHealthHandler(db)
 	healthHandler.RegisterRoutes(router)
 	openaiHandler := handlers.NewOpenAIHandler(geminiService, clientService, statsService, providerRegistry, toolService)
+	ollamaHandler := handlers.NewOllamaHandler(geminiService, statsService, providerRegistry)
+	batchHandler := handlers.NewBatchHandler(batchService, openaiHandler, cfg.Batch.MaxUploadMB, cfg.Batch.Workers)
+	jobHandler := handlers.NewJobHandler(jobService, openaiHandler, cfg.Jobs.Workers, cfg.Jobs.AllowPrivateWebhooks)
+	moderationHandler := handlers.NewModerationHandler(moderationService, openaiHandler)
 
 	rateLimiter := middleware.NewRateLimiter()
 	authMiddleware := middleware.NewAuthMiddleware(clientService)
@@ -134,7 +153,13 @@ func main() {
 		r.Use(rateLimiter.Middleware)
 		proxyHandler.RegisterRoutes(r)
 		openaiHandler.RegisterRoutes(r)
+		ollamaHandler.RegisterRoutes(r)
+		batchHandler.RegisterRoutes(r)
+		jobHandler.RegisterRoutes(r)
+		moderationHandler.RegisterRoutes(r)
 	})
+	batchHandler.Resume()
+	jobHandler.Resume()
 
 	adminHandler, err := handlers.NewAdminHandler(cfg, clientService, statsService, geminiService, dashboardHub, toolService)
 	if err != nil {
@@ -240,6 +265,9 @@ func autoMigrate(db *gorm.DB) error {
 		&models.Client{},
 		&models.RequestLog{},
 		&models.DailyUsage{},
+		&models.File{},
+		&models.Batch{},
+		&models.Job{},
 	)
 }
 
@@ -19,6 +19,10 @@ type Config struct {
 	Logging     LoggingConfig             `yaml:"logging"`
 	Prometheus  PrometheusConfig          `yaml:"prometheus"`
 	ServerTools ServerToolsConfig         `yaml:"server_tools"`
+	Tokenizer   TokenizerConfig           `yaml:"tokenizer"`
+	Batch       BatchConfig               `yaml:"batch"`
+	Jobs        JobsConfig                `yaml:"jobs"`
+	Moderation  ModerationConfig          `yaml:"moderation"`
 
 	// Deprecated: kept for backward compat with existing config files.
 	// On load, this is migrated into Providers["gemini"].
@@ -27,13 +31,26 @@ type Config struct {
 
 // ProviderConfig is the unified configuration for any upstream AI backend.
 type ProviderConfig struct {
-	// Type identifies the backend: gemini, openai, anthropic, mistral, ollama, lmstudio
+	// Type identifies the backend: gemini, openai, anthropic, mistral, ollama, lmstudio, llamacpp
 	Type           string   `yaml:"type" json:"type"`
 	APIKey         string   `yaml:"api_key,omitempty" json:"api_key,omitempty"`
 	BaseURL        string   `yaml:"base_url,omitempty" json:"base_url,omitempty"`
 	DefaultModel   string   `yaml:"default_model,omitempty" json:"default_model,omitempty"`
 	AllowedModels  []string `yaml:"allowed_models,omitempty" json:"allowed_models,omitempty"`
 	TimeoutSeconds int      `yaml:"timeout_seconds,omitempty" json:"timeout_seconds,omitempty"`
+	// ToolCallParser extracts tool calls that models write into their text
+	// instead of returning structured calls: "hermes" (<tool_call> tags, as
+	// Hermes and Qwen models do), "mistral" ([TOOL_CALLS]), "auto" (either),
+	// or empty for none. OpenAI-compatible, vLLM and Ollama backends only.
+	ToolCallParser string `yaml:"tool_call_parser,omitempty" json:"tool_call_parser,omitempty"`
+	// ToolEmulation describes tools in the system prompt instead of sending
+	// them, for backends or models without tool support, and parses the calls
+	// back from <tool_call> tags.
+	ToolEmulation bool `yaml:"tool_emulation,omitempty" json:"tool_emulation,omitempty"`
+	// PromptCaching controls cache_control breakpoints the gateway adds on
+	// its own after the system prompt and tool definitions: empty (or "auto")
+	// to add them, "off" to send only those the client set. Anthropic only.
+	PromptCaching string `yaml:"prompt_caching,omitempty" json:"prompt_caching,omitempty"`
 }
 
 // LegacyGeminiConfig supports the old config.yaml format with a top-level gemini: key.
@@ -79,6 +96,11 @@ type QuotaDefaults struct {
 	MaxRequestsPerDay     int `yaml:"max_requests_per_day"`
 	MaxInputTokens        int `yaml:"max_input_tokens"`
 	MaxOutputTokens       int `yaml:"max_output_tokens"`
+	// MaxAudioSecondsPerDay is 0 (unlimited) unless set
+	MaxAudioSecondsPerDay int `yaml:"max_audio_seconds_per_day"`
+	MaxAudioUploadMB      int `yaml:"max_audio_upload_mb"`
+	// MaxImagesPerDay is 0 (unlimited) unless set
+	MaxImagesPerDay int `yaml:"max_images_per_day"`
 }
 
 type DatabaseConfig struct {
@@ -96,6 +118,54 @@ type PrometheusConfig struct {
 	Password string `yaml:"password"`
 }
 
+// TokenizerConfig points at extra vocabulary files for token counting, for
+// vocabularies not embedded in the binary, and sets context lengths for
+// models the built-in table doesn't know (local models, fine-tunes).
+type TokenizerConfig struct {
+	VocabDir       string         `yaml:"vocab_dir"`
+	ContextWindows map[string]int `yaml:"context_windows,omitempty"`
+}
+
+// BatchConfig sets where /v1/files content is stored, the largest file a
+// client can upload and how many batch requests run at once across all jobs.
+type BatchConfig struct {
+	Dir         string `yaml:"dir"`
+	MaxUploadMB int    `yaml:"max_upload_mb"`
+	Workers     int    `yaml:"workers"`
+}
+
+// JobsConfig controls /v1/jobs. Workers bounds the jobs run at once; the
+// rest wait queued. Webhooks may only reach public addresses unless
+// AllowPrivateWebhooks is set, e.g. for a receiver on the gateway's own network.
+type JobsConfig struct {
+	Workers              int  `yaml:"workers"`
+	AllowPrivateWebhooks bool `yaml:"allow_private_webhooks"`
+}
+
+// ModerationConfig controls /v1/moderations. Mode "upstream" asks the
+// client's backend (OpenAI's moderation models), "local" runs the rules
+// below, and "auto" (the default) uses the backend when it has a moderation
+// endpoint and the rules otherwise.
+type ModerationConfig struct {
+	Mode string `yaml:"mode"`
+	// Threshold is the category score at or above which input is flagged.
+	Threshold float64          `yaml:"threshold"`
+	Rules     []ModerationRule `yaml:"rules,omitempty"`
+}
+
+// ModerationRule scores input matching any of its keywords (whole words,
+// case-insensitive) or regular expressions in each of its categories, which
+// are OpenAI category names such as "hate" or "violence/graphic", or custom
+// ones.
+type ModerationRule struct {
+	Name       string   `yaml:"name"`
+	Categories []string `yaml:"categories"`
+	Keywords   []string `yaml:"keywords,omitempty"`
+	Patterns   []string `yaml:"patterns,omitempty"`
+	// Score is given to each category on a match; 0 means 1.
+	Score float64 `yaml:"score,omitempty"`
+}
+
 type ServerToolsConfig struct {
 	Enabled bool     `yaml:"enabled"`
 	Tools   []string `yaml:"tools"`
@@ -160,9 +230,33 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	if cfg.Batch.Dir == "" {
+		cfg.Batch.Dir = "./data/files"
+	}
+	if cfg.Batch.MaxUploadMB == 0 {
+		// OpenAI's limit for batch input files.
+		cfg.Batch.MaxUploadMB = 200
+	}
+	if cfg.Batch.Workers == 0 {
+		cfg.Batch.Workers = 4
+	}
+	if cfg.Jobs.Workers == 0 {
+		cfg.Jobs.Workers = 8
+	}
+
+	if cfg.Moderation.Mode == "" {
+		cfg.Moderation.Mode = "auto"
+	}
+	if cfg.Moderation.Threshold == 0 {
+		cfg.Moderation.Threshold = 0.5
+	}
+
 	if cfg.Defaults.RateLimit.RequestsPerMinute == 0 {
 		cfg.Defaults.RateLimit.RequestsPerMinute = 60
 	}
+	if cfg.Defaults.Quota.MaxAudioUploadMB == 0 {
+		cfg.Defaults.Quota.MaxAudioUploadMB = 25
+	}
 
 	cfg, err = ensureDefaults(cfg, path)
 	if err != nil {
@@ -231,6 +325,7 @@ func createDefaultConfig(path string) (*Config, error) {
 				MaxRequestsPerDay:     1000,
 				MaxInputTokens:        1000000,
 				MaxOutputTokens:       8192,
+				MaxAudioUploadMB:      25,
 			},
 		},
 		Database: DatabaseConfig{
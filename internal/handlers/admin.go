@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,6 +40,7 @@ func KnownProviderTypes() []string {
 		"azure-openai",
 		"ollama",
 		"lmstudio",
+		"llamacpp",
 		"vllm",
 		"openrouter",
 	}
@@ -295,10 +297,13 @@ func (h *AdminHandler) ShowClient(w http.ResponseWriter, r *http.Request) {
 		Title: client.Name,
 		User:  h.cfg.Admin.Username,
 		Data: map[string]interface{}{
-			"Client":     client,
-			"Stats":      clientStats,
-			"RecentLogs": recentLogs,
-			"Providers":  KnownProviderTypes(),
+			"Client":           client,
+			"Stats":            clientStats,
+			"RecentLogs":       recentLogs,
+			"Providers":        KnownProviderTypes(),
+			"SafetySettings":   clientSafetySettings(client),
+			"SafetyCategories": geminiSafetyCategories,
+			"SafetyThresholds": geminiSafetyThresholds,
 		},
 	})
 }
@@ -316,7 +321,17 @@ func (h *AdminHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
 	backendDefaultModel := r.Form.Get("backend_default_model")
 	systemPrompt := r.Form.Get("system_prompt")
 	toolMode := r.Form.Get("tool_mode")
+	toolArgsPolicy := r.Form.Get("tool_args_policy")
+	safetySettings := safetySettingsForm(r.Form)
 	fallbackModels := r.Form.Get("fallback_models")
+	contextPolicy := r.Form.Get("context_policy")
+	summaryModel := r.Form.Get("summary_model")
+	validateSchema := r.Form.Get("validate_schema") == "on"
+	// 0 retries is meaningful (validate only), which parseInt can't express.
+	schemaRetries, err := strconv.Atoi(r.Form.Get("schema_retries"))
+	if err != nil || schemaRetries < 0 {
+		schemaRetries = 2
+	}
 	serverTools := r.Form.Get("server_tools") == "on"
 	rateLimitMinute := parseInt(r.Form.Get("rate_limit_minute"), 60)
 	rateLimitHour := parseInt(r.Form.Get("rate_limit_hour"), 1000)
@@ -326,6 +341,9 @@ func (h *AdminHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
 	quotaRequests := parseInt(r.Form.Get("quota_requests"), 1000)
 	maxInputTokens := parseInt(r.Form.Get("max_input_tokens"), 1000000)
 	maxOutputTokens := parseInt(r.Form.Get("max_output_tokens"), 8192)
+	quotaAudioSeconds := parseInt(r.Form.Get("quota_audio_seconds"), 0)
+	maxAudioUploadMB := parseInt(r.Form.Get("max_audio_upload_mb"), 25)
+	quotaImages := parseInt(r.Form.Get("quota_images"), 0)
 	modelsList := r.Form.Get("models_list")
 
 	client, err := h.clientService.GetClientByID(id)
@@ -343,7 +361,13 @@ func (h *AdminHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
 	client.BackendDefaultModel = backendDefaultModel
 	client.SystemPrompt = systemPrompt
 	client.ToolMode = toolMode
+	client.ToolArgsPolicy = toolArgsPolicy
+	client.SafetySettings = safetySettings
 	client.FallbackModels = fallbackModels
+	client.ContextPolicy = contextPolicy
+	client.SummaryModel = summaryModel
+	client.ValidateSchema = validateSchema
+	client.SchemaRetries = schemaRetries
 	client.ServerTools = serverTools
 	client.RateLimitMinute = rateLimitMinute
 	client.RateLimitHour = rateLimitHour
@@ -353,6 +377,9 @@ func (h *AdminHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
 	client.QuotaRequestsDay = quotaRequests
 	client.MaxInputTokens = maxInputTokens
 	client.MaxOutputTokens = maxOutputTokens
+	client.QuotaAudioSecondsDay = quotaAudioSeconds
+	client.MaxAudioUploadMB = maxAudioUploadMB
+	client.QuotaImagesDay = quotaImages
 	if modelsList != "" {
 		client.BackendModels = modelsList
 	}
@@ -555,8 +582,8 @@ func (h *AdminHandler) GetAPISTats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"total_requests":%d,"total_input_tokens":%d,"total_output_tokens":%d,"active_clients":%d,"total_clients":%d,"error_rate":%.2f}`,
-		stats.TotalRequestsToday, stats.TotalInputTokensToday, stats.TotalOutputTokensToday, stats.ActiveClients, stats.TotalClients, stats.ErrorRate)
+	fmt.Fprintf(w, `{"total_requests":%d,"total_input_tokens":%d,"total_output_tokens":%d,"total_cache_creation_tokens":%d,"total_cache_read_tokens":%d,"active_clients":%d,"total_clients":%d,"error_rate":%.2f}`,
+		stats.TotalRequestsToday, stats.TotalInputTokensToday, stats.TotalOutputTokensToday, stats.TotalCacheCreationTokensToday, stats.TotalCacheReadTokensToday, stats.ActiveClients, stats.TotalClients, stats.ErrorRate)
 }
 
 func (h *AdminHandler) TestConnection(w http.ResponseWriter, r *http.Request) {
@@ -1619,6 +1646,20 @@ var adminTemplates = []byte(`
                             <input type="number" name="max_output_tokens" value="{{(index .Data "Client").MaxOutputTokens}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
                             <p class="text-gray-500 text-xs mt-1">0 = unlimited</p>
                         </div>
+                        <div>
+                            <label class="block text-gray-400 text-sm font-medium mb-2">Quota (audio seconds/day)</label>
+                            <input type="number" name="quota_audio_seconds" value="{{(index .Data "Client").QuotaAudioSecondsDay}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                            <p class="text-gray-500 text-xs mt-1">0 = unlimited</p>
+                        </div>
+                        <div>
+                            <label class="block text-gray-400 text-sm font-medium mb-2">Max audio upload (MB)</label>
+                            <input type="number" name="max_audio_upload_mb" value="{{(index .Data "Client").MaxAudioUploadMB}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                        </div>
+                        <div>
+                            <label class="block text-gray-400 text-sm font-medium mb-2">Quota (images/day)</label>
+                            <input type="number" name="quota_images" value="{{(index .Data "Client").QuotaImagesDay}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                            <p class="text-gray-500 text-xs mt-1">0 = unlimited</p>
+                        </div>
                     </div>
                     <div class="mb-6">
                         <label class="block text-gray-400 text-sm font-medium mb-2">Client Name</label>
@@ -1678,11 +1719,66 @@ var adminTemplates = []byte(`
                         </select>
                         <p class="text-gray-500 text-xs mt-1">Pass-through forwards tool_calls to the client (opencode) for execution.</p>
                     </div>
+                    <div class="mb-6">
+                        <label class="block text-gray-400 text-sm font-medium mb-2">Tool Arguments</label>
+                        <select name="tool_args_policy" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                            <option value="" {{if eq (index .Data "Client").ToolArgsPolicy ""}}selected{{end}}>Repair JSON syntax</option>
+                            <option value="validate" {{if eq (index .Data "Client").ToolArgsPolicy "validate"}}selected{{end}}>Repair and validate (log only)</option>
+                            <option value="strict" {{if eq (index .Data "Client").ToolArgsPolicy "strict"}}selected{{end}}>Strict (reject invalid arguments)</option>
+                            <option value="off" {{if eq (index .Data "Client").ToolArgsPolicy "off"}}selected{{end}}>Off (forward unchanged)</option>
+                        </select>
+                        <p class="text-gray-500 text-xs mt-1">Fixes trailing commas, single quotes, code fences and similar in tool call arguments, optionally checking them against the tool's parameters schema. Streamed pass-through tool calls are only repaired when validating, since they are then held back until complete.</p>
+                    </div>
+                    <div class="mb-6">
+                        <label class="block text-gray-400 text-sm font-medium mb-2">Gemini Safety Thresholds</label>
+                        {{$safety := index .Data "SafetySettings"}}{{$thresholds := index .Data "SafetyThresholds"}}
+                        <div class="grid grid-cols-2 gap-4">
+                            {{range index .Data "SafetyCategories"}}{{$category := .Category}}
+                            <div>
+                                <label class="block text-gray-500 text-xs mb-1">{{.Label}}</label>
+                                <select name="safety_{{.Category}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                                    <option value="">Default</option>
+                                    {{range $thresholds}}<option value="{{.}}" {{if eq (index $safety $category) .}}selected{{end}}>{{.}}</option>{{end}}
+                                </select>
+                            </div>
+                            {{end}}
+                        </div>
+                        <p class="text-gray-500 text-xs mt-1">Sent as safetySettings on requests to Gemini backends. Blocked responses finish with content_filter and are logged with the block reason.</p>
+                    </div>
                     <div class="mb-6">
                         <label class="block text-gray-400 text-sm font-medium mb-2">Fallback Models</label>
                         <input type="text" name="fallback_models" placeholder="claude-3-haiku,claude-3-sonnet" value="{{(index .Data "Client").FallbackModels}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
                         <p class="text-gray-500 text-xs mt-1">Comma-separated list of models to try if the primary model fails (rate limit, quota, server errors). Tried in order.</p>
                     </div>
+                    <div class="grid grid-cols-2 gap-4 mb-6">
+                        <div>
+                            <label class="block text-gray-400 text-sm font-medium mb-2">Context Policy</label>
+                            <select name="context_policy" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                                <option value="" {{if eq (index .Data "Client").ContextPolicy ""}}selected{{end}}>Off (forward unchanged)</option>
+                                <option value="reject" {{if eq (index .Data "Client").ContextPolicy "reject"}}selected{{end}}>Reject</option>
+                                <option value="truncate" {{if eq (index .Data "Client").ContextPolicy "truncate"}}selected{{end}}>Truncate oldest turns</option>
+                                <option value="summarize" {{if eq (index .Data "Client").ContextPolicy "summarize"}}selected{{end}}>Summarize oldest turns</option>
+                            </select>
+                        </div>
+                        <div>
+                            <label class="block text-gray-400 text-sm font-medium mb-2">Summary Model</label>
+                            <input type="text" name="summary_model" placeholder="Same as request" value="{{(index .Data "Client").SummaryModel}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                        </div>
+                        <p class="col-span-2 text-gray-500 text-xs">What to do when a chat request exceeds the model's context window. System messages and the latest turn are always kept.</p>
+                    </div>
+                    <div class="grid grid-cols-2 gap-4 mb-6">
+                        <div class="flex items-end pb-2">
+                            <label class="flex items-center text-gray-300">
+                                <input type="checkbox" name="validate_schema" {{if (index .Data "Client").ValidateSchema}}checked{{end}} class="w-5 h-5 rounded bg-gray-900 border-gray-600 text-blue-600 focus:ring-blue-500">
+                                <span class="ml-2">Validate Structured Output</span>
+                            </label>
+                        </div>
+                        <div>
+                            <label class="block text-gray-400 text-sm font-medium mb-2">Schema Retries</label>
+                            <input type="number" name="schema_retries" min="0" value="{{(index .Data "Client").SchemaRetries}}" class="w-full px-4 py-2 bg-gray-900 border border-gray-600 text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500">
+                        </div>
+                        <p class="col-span-2 text-gray-500 text-xs">Check responses to requests with a JSON response_format against the schema, and retry with the errors fed back to the model.</p>
+                    </div>
                     <div class="mb-6">
                         <label class="flex items-center text-gray-300">
                             <input type="checkbox" name="server_tools" {{if (index .Data "Client").ServerTools}}checked{{end}} class="w-5 h-5 rounded bg-gray-900 border-gray-600 text-blue-600 focus:ring-blue-500">
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// maxSpeechInput is OpenAI's limit on /audio/speech input, in characters.
+const maxSpeechInput = 4096
+
+// checkAudioQuota enforces the client's daily audio quota.
+func (h *OpenAIHandler) checkAudioQuota(client *models.Client) error {
+	if client.QuotaAudioSecondsDay <= 0 || h.statsService == nil {
+		return nil
+	}
+	if used := h.statsService.UsageToday(client.ID).TotalAudioSeconds; used >= float64(client.QuotaAudioSecondsDay) {
+		return fmt.Errorf("daily audio quota of %d seconds exhausted (%.0f used)", client.QuotaAudioSecondsDay, used)
+	}
+	return nil
+}
+
+func (h *OpenAIHandler) Transcriptions(w http.ResponseWriter, r *http.Request) {
+	h.transcribe(w, r, false)
+}
+
+func (h *OpenAIHandler) Translations(w http.ResponseWriter, r *http.Request) {
+	h.transcribe(w, r, true)
+}
+
+func (h *OpenAIHandler) transcribe(w http.ResponseWriter, r *http.Request, translate bool) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	// These routes are exempt from the global request size limit.
+	maxBytes := int64(client.MaxAudioUploadMB) << 20
+	if maxBytes <= 0 {
+		maxBytes = 25 << 20
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Audio file exceeds this client's limit of %d MB", maxBytes>>20), "invalid_request_error")
+			return
+		}
+		writeOpenAIError(w, http.StatusBadRequest, "Expected multipart/form-data: "+err.Error(), "invalid_request_error")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Missing file: "+err.Error(), "invalid_request_error")
+		return
+	}
+	audio, err := io.ReadAll(upload)
+	upload.Close()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Failed to read file", "invalid_request_error")
+		return
+	}
+
+	provider, err := h.resolveProvider(client)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Backend not configured: "+err.Error(), "invalid_request_error")
+		return
+	}
+	transcriber, ok := provider.(providers.Transcriber)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("The %s backend does not support audio transcription", provider.Name()), "invalid_request_error")
+		return
+	}
+	if err := h.checkAudioQuota(client); err != nil {
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error(), "rate_limit_error")
+		return
+	}
+
+	model := r.FormValue("model")
+	if model == "" {
+		model = "whisper-1"
+	}
+	fields := make(map[string][]string)
+	for name, values := range r.MultipartForm.Value {
+		if name != "model" {
+			fields[name] = values
+		}
+	}
+
+	if h.statsService != nil {
+		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
+	}
+
+	start := time.Now()
+	resp, err := transcriber.Transcribe(&providers.TranscriptionRequest{
+		Model: model, Filename: header.Filename, Audio: audio, Fields: fields, Translate: translate,
+	})
+	if err != nil {
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error()})
+		writeOpenAIError(w, http.StatusBadGateway, err.Error(), "api_error")
+		return
+	}
+
+	entry := &models.RequestLog{Model: model, StatusCode: resp.StatusCode}
+	if resp.StatusCode >= 400 {
+		entry.ErrorMessage = extractErrorMessage(resp.Body)
+	} else {
+		entry.AudioSeconds = transcriptDuration(resp.Body)
+		if entry.AudioSeconds == 0 {
+			entry.AudioSeconds = audioDuration(audio, strings.TrimPrefix(filepath.Ext(header.Filename), "."))
+		}
+	}
+	logRequest(h.geminiService, client, start, entry)
+
+	writeAudioResponse(w, resp)
+}
+
+// transcriptDuration reads the audio length a transcription response reports
+// (verbose_json's duration or usage.seconds), or 0 if it has none.
+func transcriptDuration(body []byte) float64 {
+	var resp struct {
+		Duration float64 `json:"duration"`
+		Usage    struct {
+			Type    string  `json:"type"`
+			Seconds float64 `json:"seconds"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return 0
+	}
+	if resp.Duration > 0 {
+		return resp.Duration
+	}
+	if resp.Usage.Type == "duration" {
+		return resp.Usage.Seconds
+	}
+	return 0
+}
+
+func (h *OpenAIHandler) Speech(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	var req struct {
+		Model          string   `json:"model"`
+		Input          string   `json:"input"`
+		Voice          string   `json:"voice"`
+		ResponseFormat string   `json:"response_format"`
+		Speed          *float64 `json:"speed"`
+		Instructions   string   `json:"instructions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid JSON in request body", "invalid_request_error")
+		return
+	}
+	if req.Input == "" || req.Voice == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "input and voice are required", "invalid_request_error")
+		return
+	}
+	if n := utf8.RuneCountInString(req.Input); n > maxSpeechInput {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("input is %d characters, the maximum is %d", n, maxSpeechInput), "invalid_request_error")
+		return
+	}
+	if req.Model == "" {
+		req.Model = "tts-1"
+	}
+
+	provider, err := h.resolveProvider(client)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Backend not configured: "+err.Error(), "invalid_request_error")
+		return
+	}
+	synthesizer, ok := provider.(providers.SpeechSynthesizer)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("The %s backend does not support speech", provider.Name()), "invalid_request_error")
+		return
+	}
+	if err := h.checkAudioQuota(client); err != nil {
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error(), "rate_limit_error")
+		return
+	}
+
+	if h.statsService != nil {
+		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
+	}
+
+	start := time.Now()
+	resp, err := synthesizer.Speech(&providers.SpeechRequest{
+		Model: req.Model, Input: req.Input, Voice: req.Voice, ResponseFormat: req.ResponseFormat,
+		Speed: req.Speed, Instructions: req.Instructions,
+	})
+	if err != nil {
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error()})
+		writeOpenAIError(w, http.StatusBadGateway, err.Error(), "api_error")
+		return
+	}
+
+	entry := &models.RequestLog{Model: req.Model, StatusCode: resp.StatusCode}
+	if resp.StatusCode >= 400 {
+		entry.ErrorMessage = extractErrorMessage(resp.Body)
+	} else {
+		format := req.ResponseFormat
+		if format == "" {
+			format = "mp3"
+		}
+		entry.AudioSeconds = audioDuration(resp.Body, format)
+		if entry.AudioSeconds == 0 {
+			// Formats that can't be measured: assume about 15 characters
+			// of speech per second.
+			entry.AudioSeconds = float64(utf8.RuneCountInString(req.Input)) / 15
+		}
+	}
+	logRequest(h.geminiService, client, start, entry)
+
+	writeAudioResponse(w, resp)
+}
+
+func writeAudioResponse(w http.ResponseWriter, resp *providers.AudioResponse) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
+// audioDuration returns the length in seconds of WAV, raw PCM (OpenAI's
+// 24 kHz 16-bit mono) or MP3 audio, or 0 for other formats. MP3 length is
+// estimated from the first frame's bitrate.
+func audioDuration(data []byte, format string) float64 {
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return wavDuration(data)
+	case format == "pcm":
+		return float64(len(data)) / (24000 * 2)
+	case format == "mp3" || format == "mpeg" || format == "mpga":
+		return mp3Duration(data)
+	}
+	return 0
+}
+
+func wavDuration(data []byte) float64 {
+	var byteRate uint32
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := binary.LittleEndian.Uint32(data[off+4 : off+8])
+		body := data[off+8:]
+		switch id {
+		case "fmt ":
+			if len(body) >= 12 {
+				byteRate = binary.LittleEndian.Uint32(body[8:12])
+			}
+		case "data":
+			if byteRate == 0 {
+				return 0
+			}
+			// Streamed WAVs leave the size unset.
+			if size == 0 || size == 0xFFFFFFFF || int(size) > len(body) {
+				size = uint32(len(body))
+			}
+			return float64(size) / float64(byteRate)
+		}
+		off += 8 + int(size) + int(size%2)
+	}
+	return 0
+}
+
+// mp3Bitrates are the Layer III bitrates in kbit/s by header index, for MPEG-1
+// and for MPEG-2/2.5.
+var mp3Bitrates = [2][16]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+func mp3Duration(data []byte) float64 {
+	off := 0
+	if len(data) >= 10 && string(data[0:3]) == "ID3" {
+		// The tag size is stored in 4 bytes of 7 bits each.
+		size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+		off = 10 + size
+	}
+	for ; off+4 <= len(data); off++ {
+		if data[off] != 0xFF || data[off+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := (data[off+1] >> 3) & 0x03 // 3 = MPEG-1
+		layer := (data[off+1] >> 1) & 0x03   // 1 = Layer III
+		index := data[off+2] >> 4
+		if version == 1 || layer != 1 {
+			continue
+		}
+		table := 1
+		if version == 3 {
+			table = 0
+		}
+		kbps := mp3Bitrates[table][index]
+		if kbps == 0 {
+			continue
+		}
+		return float64(len(data)-off) * 8 / float64(kbps*1000)
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// Requests run in the background (batch lines and async jobs) are stored as
+// chat completions request bodies and run without a client connection.
+
+// backgroundMaxAttempts bounds retries of a background request that hit a
+// rate limit or server error.
+const backgroundMaxAttempts = 5
+
+// buildStoredRequest turns a stored request body into a ChatRequest the same
+// way ChatCompletions does, including the client's system prompt, context
+// policy and token limits. Streaming is turned off.
+func (h *OpenAIHandler) buildStoredRequest(client *models.Client, provider providers.Provider, body []byte) (*providers.ChatRequest, error) {
+	var req OpenAIChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	req.Stream = false
+	req.StreamOptions = nil
+
+	chatReq := h.buildChatRequest(req, provider, client)
+	if len(chatReq.Messages) == 0 {
+		return nil, errors.New("No content in messages")
+	}
+	var err error
+	chatReq.ToolChoice, err = providers.ParseToolChoice(req.ToolChoice)
+	if err == nil {
+		err = providers.ValidateRequest(provider, chatReq)
+	}
+	if err == nil && choiceCount(chatReq) > 1 && !providers.SupportsChoiceCount(provider) {
+		err = fmt.Errorf("n > 1 is not supported in the background by the %s backend", provider.Name())
+	}
+	if err == nil {
+		_, err = fitContextWindow(h.geminiService, client, provider, chatReq)
+	}
+	if err == nil {
+		err = checkInputTokens(client, chatReq)
+	}
+	return chatReq, err
+}
+
+// completeWithRetry runs a background request, retrying rate limits and
+// server errors with backoff. It returns ctx's error if cancelled while
+// waiting to retry.
+func completeWithRetry(ctx context.Context, provider providers.Provider, chatReq *providers.ChatRequest) ([]byte, int, error) {
+	for attempt := 1; ; attempt++ {
+		respBody, statusCode, err := provider.ChatCompletion(chatReq)
+		retryable := err != nil || isRetryableError(statusCode, extractErrorMessage(respBody))
+		if !retryable || attempt == backgroundMaxAttempts {
+			return respBody, statusCode, err
+		}
+		backoff := min(time.Duration(1<<attempt)*time.Second, time.Minute)
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// completionResponse renders a successful upstream response as an OpenAI
+// chat.completion, filling in the log entry's usage and tool calls.
+func completionResponse(provider providers.Provider, chatReq *providers.ChatRequest, body []byte, entry *models.RequestLog) (*OpenAIChatResponse, error) {
+	choices, err := provider.ParseChoices(body)
+	if err != nil {
+		return nil, err
+	}
+	text, it, ot, _ := provider.ParseResponse(body)
+	details := providers.ParseUsageDetails(provider, body)
+	rt := details.ReasoningTokens
+	entry.TokensEstimated = estimateUsage(chatReq, text, &it, &ot)
+	entry.InputTokens, entry.OutputTokens, entry.ReasoningTokens = it, ot, rt
+	entry.CacheCreationTokens, entry.CacheReadTokens = details.CacheCreationTokens, details.CacheReadTokens
+
+	rendered := make([]map[string]interface{}, len(choices))
+	var toolNames []string
+	for i, c := range choices {
+		rendered[i] = withSafety(choiceJSON(c.Index, assistantMessage(c), c.FinishReason, c.Logprobs), c.Safety)
+		for _, tc := range c.ToolCalls {
+			toolNames = append(toolNames, tc.Name)
+		}
+	}
+	entry.HasTools = len(toolNames) > 0
+	entry.ToolNames = strings.Join(toolNames, ",")
+
+	return &OpenAIChatResponse{
+		ID:      "chatcmpl-" + randomID(12),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   chatReq.Model,
+		Choices: rendered,
+		Usage:   usageJSON(it, ot, rt),
+	}, nil
+}
@@ -0,0 +1,592 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// batchEndpoint is the only endpoint batches can target.
+const batchEndpoint = "/v1/chat/completions"
+
+// batchPollInterval is how often an upstream batch is checked.
+const batchPollInterval = 30 * time.Second
+
+// Progress of a local batch is saved every batchSaveEvery requests or
+// batchSaveInterval, whichever comes first, rather than after each one.
+const (
+	batchSaveEvery    = 100
+	batchSaveInterval = 5 * time.Second
+)
+
+// batchLine is one request of a batch input file.
+type batchLine struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// batchError is a validation error of an input file, reported in the batch's
+// errors list.
+type batchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// parseBatchInput reads and validates a batch input file.
+func parseBatchInput(data []byte, endpoint string) ([]batchLine, []batchError) {
+	var lines []batchLine
+	var errs []batchError
+	seen := make(map[string]bool)
+	for n, raw := range bytes.Split(data, []byte("\n")) {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		lineNo := n + 1
+
+		var line batchLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			errs = append(errs, batchError{Code: "invalid_json_line", Message: "Line is not valid JSON: " + err.Error(), Line: lineNo})
+			continue
+		}
+		var body OpenAIChatRequest
+		switch {
+		case line.CustomID == "":
+			errs = append(errs, batchError{Code: "missing_required_parameter", Message: "custom_id is required", Line: lineNo})
+		case seen[line.CustomID]:
+			errs = append(errs, batchError{Code: "duplicate_custom_id", Message: "custom_id " + line.CustomID + " is used more than once", Line: lineNo})
+		case line.Method != http.MethodPost:
+			errs = append(errs, batchError{Code: "invalid_method", Message: "method must be POST", Line: lineNo})
+		case line.URL != endpoint:
+			errs = append(errs, batchError{Code: "mismatched_endpoint", Message: "url must match the batch endpoint " + endpoint, Line: lineNo})
+		case json.Unmarshal(line.Body, &body) != nil:
+			errs = append(errs, batchError{Code: "invalid_request", Message: "body must be a chat completions request", Line: lineNo})
+		default:
+			seen[line.CustomID] = true
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 && len(errs) == 0 {
+		errs = append(errs, batchError{Code: "empty_file", Message: "The input file has no requests"})
+	}
+	return lines, errs
+}
+
+// batchRun is a batch being executed. The runner is the only writer of the
+// stored batch while it runs.
+type batchRun struct {
+	h        *BatchHandler
+	batch    *models.Batch
+	client   *models.Client
+	provider providers.Provider
+	lines    []batchLine
+
+	mu     sync.Mutex
+	output *os.File
+	errors *os.File
+	// done holds the custom IDs already written to the output or error file,
+	// so a resumed batch doesn't run them again.
+	done map[string]bool
+	// unsaved counts requests recorded since progress was last saved.
+	unsaved int
+	savedAt time.Time
+}
+
+func (h *BatchHandler) start(batch *models.Batch) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.mu.Lock()
+	h.running[batch.ID] = cancel
+	h.mu.Unlock()
+
+	go func() {
+		defer func() {
+			h.mu.Lock()
+			delete(h.running, batch.ID)
+			h.mu.Unlock()
+			cancel()
+		}()
+		h.run(ctx, batch)
+	}()
+}
+
+func (h *BatchHandler) run(ctx context.Context, batch *models.Batch) {
+	r := &batchRun{h: h, batch: batch, done: make(map[string]bool)}
+
+	client, err := h.chat.clientService.GetClientByID(batch.ClientID)
+	if err != nil || client == nil {
+		r.fail(batchError{Code: "client_not_found", Message: "The batch's client no longer exists"})
+		return
+	}
+	r.client = client
+
+	data, err := os.ReadFile(h.batchService.FilePath(batch.InputFileID))
+	if err != nil {
+		r.fail(batchError{Code: "file_not_found", Message: "The input file could not be read"})
+		return
+	}
+	lines, errs := parseBatchInput(data, batch.Endpoint)
+	if len(errs) > 0 {
+		r.fail(errs...)
+		return
+	}
+	r.lines = lines
+
+	r.provider, err = h.chat.resolveProvider(client)
+	if err != nil {
+		r.fail(batchError{Code: "backend_not_configured", Message: "Backend not configured: " + err.Error()})
+		return
+	}
+	if err := r.openOutputs(); err != nil {
+		log.Printf("[BATCH] %s: %v", batch.ID, err)
+		r.fail(batchError{Code: "internal_error", Message: "Failed to open output files"})
+		return
+	}
+
+	if batch.Status == "validating" {
+		now := time.Now()
+		batch.Status = "in_progress"
+		batch.InProgressAt = &now
+		batch.TotalRequests = len(lines)
+		r.save()
+	}
+
+	switch batch.Status {
+	case "cancelling":
+		if batch.NativeID == "" {
+			r.finalize("cancelled")
+			return
+		}
+	case "finalizing":
+		r.finalize("completed")
+		return
+	}
+
+	if batch.NativeID == "" && len(r.done) == 0 {
+		r.submitNative()
+	}
+	if batch.NativeID != "" {
+		r.finalize(r.pollNative(ctx))
+		return
+	}
+	r.finalize(r.runLocal(ctx))
+}
+
+func (r *batchRun) save() {
+	r.unsaved, r.savedAt = 0, time.Now()
+	if err := r.h.batchService.SaveBatch(r.batch); err != nil {
+		log.Printf("[BATCH] Failed to save batch %s: %v", r.batch.ID, err)
+	}
+}
+
+// fail ends a batch that can't run at all.
+func (r *batchRun) fail(errs ...batchError) {
+	data, _ := json.Marshal(errs)
+	now := time.Now()
+	r.batch.Status = "failed"
+	r.batch.FailedAt = &now
+	r.batch.Errors = string(data)
+	r.save()
+	log.Printf("[BATCH] Batch %s failed: %s", r.batch.ID, errs[0].Message)
+}
+
+// openOutputs opens the partial output and error files for appending and
+// loads the custom IDs they already hold. The request counts are rebuilt from
+// the files, as progress saved before a restart may lag behind them.
+func (r *batchRun) openOutputs() error {
+	var err error
+	for _, kind := range []string{"output", "errors"} {
+		path := r.h.batchService.PartialPath(r.batch.ID, kind)
+		written := 0
+		if data, readErr := os.ReadFile(path); readErr == nil {
+			for _, line := range bytes.Split(data, []byte("\n")) {
+				var result struct {
+					CustomID string `json:"custom_id"`
+				}
+				if json.Unmarshal(line, &result) == nil && result.CustomID != "" {
+					r.done[result.CustomID] = true
+					written++
+				}
+			}
+		}
+		if kind == "output" {
+			r.batch.CompletedRequests = written
+		} else {
+			r.batch.FailedRequests = written
+		}
+		f, openErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if openErr != nil {
+			err = fmt.Errorf("failed to open %s file: %w", kind, openErr)
+			continue
+		}
+		if kind == "output" {
+			r.output = f
+		} else {
+			r.errors = f
+		}
+	}
+	return err
+}
+
+func (r *batchRun) prepare(line batchLine) (*providers.ChatRequest, error) {
+	return r.h.chat.buildStoredRequest(r.client, r.provider, line.Body)
+}
+
+// record writes the outcome of one request to the output or error file and
+// logs it against the client. A request that got no upstream response has
+// statusCode 0 and errCode set.
+func (r *batchRun) record(line batchLine, chatReq *providers.ChatRequest, start time.Time, statusCode int, body []byte, errCode, errMsg string) {
+	entry := &models.RequestLog{Model: line.CustomID, StatusCode: statusCode, RequestBody: string(line.Body)}
+	if chatReq != nil {
+		entry.Model = chatReq.Model
+	}
+	result := map[string]interface{}{
+		"id":        "batch_req_" + randomID(24),
+		"custom_id": line.CustomID,
+		"response":  nil,
+		"error":     nil,
+	}
+
+	var resp *OpenAIChatResponse
+	if errMsg == "" && statusCode < 400 {
+		var err error
+		if resp, err = completionResponse(r.provider, chatReq, body, entry); err != nil {
+			errMsg = "Failed to parse upstream response: " + err.Error()
+		}
+	}
+
+	switch {
+	case errMsg != "" && statusCode == 0:
+		entry.StatusCode = http.StatusBadGateway
+		entry.ErrorMessage = errMsg
+		result["error"] = map[string]string{"code": errCode, "message": errMsg}
+	case errMsg != "" || statusCode >= 400:
+		if errMsg == "" {
+			errMsg = extractErrorMessage(body)
+		}
+		entry.ErrorMessage = errMsg
+		errBody := json.RawMessage(body)
+		if !json.Valid(body) {
+			errBody, _ = json.Marshal(map[string]interface{}{"error": map[string]string{"message": errMsg}})
+		}
+		result["response"] = map[string]interface{}{"status_code": statusCode, "request_id": "req-" + randomID(12), "body": errBody}
+		result["error"] = map[string]string{"code": "request_failed", "message": errMsg}
+	default:
+		result["response"] = map[string]interface{}{"status_code": statusCode, "request_id": "req-" + randomID(12), "body": resp}
+	}
+	logRequest(r.h.chat.geminiService, r.client, start, entry)
+
+	data, _ := json.Marshal(result)
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry.ErrorMessage == "" {
+		r.output.Write(data)
+		r.batch.CompletedRequests++
+	} else {
+		r.errors.Write(data)
+		r.batch.FailedRequests++
+	}
+	r.done[line.CustomID] = true
+	r.unsaved++
+	if r.unsaved >= batchSaveEvery || time.Since(r.savedAt) >= batchSaveInterval {
+		r.save()
+	}
+}
+
+// runLocal executes the batch through the client's backend, at most the
+// client's per-minute rate limit and the handler's worker count at a time.
+// Rate-limited and failed upstream calls are retried with backoff. It returns
+// the batch's final status.
+func (r *batchRun) runLocal(ctx context.Context) string {
+	var interval time.Duration
+	if r.client.RateLimitMinute > 0 {
+		interval = time.Minute / time.Duration(r.client.RateLimitMinute)
+	}
+
+	// Workers save the batch, so its fields aren't read while they run.
+	expiresAt := r.batch.ExpiresAt
+	var wg sync.WaitGroup
+	status := "completed"
+	next := time.Now()
+dispatch:
+	for _, line := range r.pending() {
+		if time.Now().After(expiresAt) {
+			status = "expired"
+			break
+		}
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-ctx.Done():
+				break dispatch
+			case <-time.After(wait):
+			}
+		}
+		next = time.Now().Add(interval)
+
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case r.h.workers <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(line batchLine) {
+			defer wg.Done()
+			defer func() { <-r.h.workers }()
+			r.execute(ctx, line)
+		}(line)
+	}
+
+	if ctx.Err() != nil {
+		r.markCancelling()
+		status = "cancelled"
+	}
+	wg.Wait()
+
+	if status == "expired" {
+		for _, line := range r.pending() {
+			r.record(line, nil, time.Now(), 0, nil, "batch_expired", "This request could not be executed before the completion window expired.")
+		}
+	}
+	return status
+}
+
+// pending returns the lines not yet written to the output or error file.
+// Workers update done as they finish, so it's read under the lock.
+func (r *batchRun) pending() []batchLine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var lines []batchLine
+	for _, line := range r.lines {
+		if !r.done[line.CustomID] {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// execute runs one request, retrying rate limits and server errors. A
+// request interrupted by cancellation is not recorded.
+func (r *batchRun) execute(ctx context.Context, line batchLine) {
+	start := time.Now()
+	chatReq, err := r.prepare(line)
+	if err != nil {
+		r.record(line, chatReq, start, http.StatusBadRequest, nil, "", err.Error())
+		return
+	}
+
+	respBody, statusCode, err := completeWithRetry(ctx, r.provider, chatReq)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		r.record(line, chatReq, start, 0, nil, "upstream_error", err.Error())
+		return
+	}
+	r.record(line, chatReq, start, statusCode, respBody, "", "")
+}
+
+func (r *batchRun) markCancelling() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.batch.Status != "cancelling" {
+		now := time.Now()
+		r.batch.Status = "cancelling"
+		r.batch.CancellingAt = &now
+		r.save()
+	}
+}
+
+// nativeID maps a line to the custom ID sent upstream, which must be short
+// and alphanumeric whatever the client used.
+func nativeID(index int) string {
+	return "req-" + strconv.Itoa(index)
+}
+
+// submitNative hands the batch to the provider's own batch API, if it has
+// one. Lines that fail to prepare are recorded as errors right away.
+func (r *batchRun) submitNative() {
+	submitter, ok := r.provider.(providers.BatchSubmitter)
+	if !ok {
+		return
+	}
+
+	var items []providers.BatchItem
+	var rejected []batchLine
+	var rejectedErrs []error
+	for i, line := range r.lines {
+		chatReq, err := r.prepare(line)
+		if err != nil {
+			rejected = append(rejected, line)
+			rejectedErrs = append(rejectedErrs, err)
+			continue
+		}
+		items = append(items, providers.BatchItem{CustomID: nativeID(i), Request: chatReq})
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	id, err := submitter.SubmitBatch(items)
+	if err != nil {
+		if !errors.Is(err, providers.ErrNativeBatchUnsupported) {
+			log.Printf("[BATCH] %s: native batch submission to %s failed, running locally: %v", r.batch.ID, r.provider.Name(), err)
+		}
+		return
+	}
+	log.Printf("[BATCH] %s: submitted %d requests to %s as batch %s", r.batch.ID, len(items), r.provider.Name(), id)
+
+	for i, line := range rejected {
+		r.record(line, nil, time.Now(), http.StatusBadRequest, nil, "", rejectedErrs[i].Error())
+	}
+	r.mu.Lock()
+	r.batch.NativeID = id
+	r.save()
+	r.mu.Unlock()
+}
+
+// pollNative waits for an upstream batch to end and records its results,
+// cancelling it upstream if the batch is cancelled here. It returns the
+// batch's final status.
+func (r *batchRun) pollNative(ctx context.Context) string {
+	submitter := r.provider.(providers.BatchSubmitter)
+	id := r.batch.NativeID
+	// Until the upstream batch ends, only lines rejected before submission
+	// have been written.
+	rejected := len(r.done)
+
+	cancelled := r.batch.Status == "cancelling"
+	if cancelled {
+		if err := submitter.CancelBatch(id); err != nil {
+			log.Printf("[BATCH] %s: failed to cancel upstream batch %s: %v", r.batch.ID, id, err)
+		}
+	}
+
+	ticker := time.NewTicker(batchPollInterval)
+	defer ticker.Stop()
+	cancelCh := ctx.Done()
+	for {
+		status, err := submitter.PollBatch(id)
+		if err != nil {
+			log.Printf("[BATCH] %s: failed to poll upstream batch %s: %v", r.batch.ID, id, err)
+		} else if status.Done {
+			r.mu.Lock()
+			r.batch.CompletedRequests, r.batch.FailedRequests = 0, rejected
+			r.mu.Unlock()
+			r.recordNative(status.Results)
+			break
+		} else {
+			r.mu.Lock()
+			r.batch.CompletedRequests = status.Completed
+			r.batch.FailedRequests = rejected + status.Failed
+			r.save()
+			r.mu.Unlock()
+		}
+
+		select {
+		case <-cancelCh:
+			// Keep polling: the upstream returns what finished before the
+			// cancellation took effect.
+			cancelCh = nil
+			cancelled = true
+			r.markCancelling()
+			if err := submitter.CancelBatch(id); err != nil {
+				log.Printf("[BATCH] %s: failed to cancel upstream batch %s: %v", r.batch.ID, id, err)
+			}
+		case <-ticker.C:
+		}
+	}
+
+	if cancelled {
+		return "cancelled"
+	}
+	if time.Now().After(r.batch.ExpiresAt) {
+		return "expired"
+	}
+	return "completed"
+}
+
+// recordNative records an upstream batch's results against the input lines.
+func (r *batchRun) recordNative(results []providers.BatchResult) {
+	byID := make(map[string]int, len(r.lines))
+	for i := range r.lines {
+		byID[nativeID(i)] = i
+	}
+	for _, res := range results {
+		i, ok := byID[res.CustomID]
+		if !ok || r.done[r.lines[i].CustomID] {
+			continue
+		}
+		line := r.lines[i]
+		chatReq, _ := r.prepare(line)
+		if res.StatusCode == 0 {
+			r.record(line, chatReq, time.Now(), 0, nil, "upstream_error", res.Error)
+			continue
+		}
+		r.record(line, chatReq, time.Now(), res.StatusCode, res.Body, "", res.Error)
+	}
+}
+
+// finalize turns the partial files into the batch's output and error files
+// and sets its final status.
+func (r *batchRun) finalize(status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.batch.Status = "finalizing"
+	r.batch.FinalizingAt = &now
+	r.save()
+
+	for _, f := range []*os.File{r.output, r.errors} {
+		if f != nil {
+			f.Close()
+		}
+	}
+	for _, kind := range []string{"output", "errors"} {
+		path := r.h.batchService.PartialPath(r.batch.ID, kind)
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			os.Remove(path)
+			continue
+		}
+		name := r.batch.ID + "_" + strings.TrimSuffix(kind, "s") + ".jsonl"
+		file, err := r.h.batchService.AdoptFile(r.client.ID, name, "batch_output", path)
+		if err != nil {
+			log.Printf("[BATCH] %s: failed to store %s file: %v", r.batch.ID, kind, err)
+			continue
+		}
+		if kind == "output" {
+			r.batch.OutputFileID = file.ID
+		} else {
+			r.batch.ErrorFileID = file.ID
+		}
+	}
+
+	now = time.Now()
+	r.batch.Status = status
+	switch status {
+	case "completed":
+		r.batch.CompletedAt = &now
+	case "cancelled":
+		r.batch.CancelledAt = &now
+	case "expired":
+		r.batch.ExpiredAt = &now
+	}
+	r.save()
+	log.Printf("[BATCH] Batch %s %s: %d completed, %d failed", r.batch.ID, status, r.batch.CompletedRequests, r.batch.FailedRequests)
+}
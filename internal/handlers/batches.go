@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// BatchHandler serves the OpenAI-compatible /v1/files and /v1/batches APIs.
+// Batches run in the background: on the provider's own batch API where it
+// has one, otherwise request by request through the client's backend.
+type BatchHandler struct {
+	batchService *services.BatchService
+	chat         *OpenAIHandler
+	// maxUpload is the largest file accepted by /v1/files, in bytes.
+	maxUpload int64
+	// workers bounds the batch requests in flight across all batches.
+	workers chan struct{}
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+func NewBatchHandler(batchService *services.BatchService, chat *OpenAIHandler, maxUploadMB, workers int) *BatchHandler {
+	return &BatchHandler{
+		batchService: batchService,
+		chat:         chat,
+		maxUpload:    int64(maxUploadMB) << 20,
+		workers:      make(chan struct{}, max(workers, 1)),
+		running:      make(map[string]context.CancelFunc),
+	}
+}
+
+func (h *BatchHandler) RegisterRoutes(r chi.Router) {
+	r.Post("/v1/files", h.UploadFile)
+	r.Get("/v1/files", h.ListFiles)
+	r.Get("/v1/files/{id}", h.GetFile)
+	r.Get("/v1/files/{id}/content", h.GetFileContent)
+	r.Delete("/v1/files/{id}", h.DeleteFile)
+
+	r.Post("/v1/batches", h.CreateBatch)
+	r.Get("/v1/batches", h.ListBatches)
+	r.Get("/v1/batches/{id}", h.GetBatch)
+	r.Post("/v1/batches/{id}/cancel", h.CancelBatch)
+}
+
+// Resume restarts batches left unfinished by a previous run of the gateway.
+func (h *BatchHandler) Resume() {
+	batches, err := h.batchService.UnfinishedBatches()
+	if err != nil {
+		log.Printf("[BATCH] Failed to load unfinished batches: %v", err)
+		return
+	}
+	for i := range batches {
+		log.Printf("[BATCH] Resuming batch %s (%s)", batches[i].ID, batches[i].Status)
+		h.start(&batches[i])
+	}
+}
+
+func fileJSON(f *models.File) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         f.ID,
+		"object":     "file",
+		"bytes":      f.Bytes,
+		"created_at": f.CreatedAt.Unix(),
+		"filename":   f.Filename,
+		"purpose":    f.Purpose,
+		"status":     "processed",
+	}
+}
+
+func (h *BatchHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	// This route is exempt from the global request size limit.
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds the upload limit of %d MB", h.maxUpload>>20), "invalid_request_error")
+			return
+		}
+		writeOpenAIError(w, http.StatusBadRequest, "Expected multipart/form-data: "+err.Error(), "invalid_request_error")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	purpose := r.FormValue("purpose")
+	if purpose != "batch" {
+		writeOpenAIError(w, http.StatusBadRequest, "purpose must be \"batch\"", "invalid_request_error")
+		return
+	}
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Missing file: "+err.Error(), "invalid_request_error")
+		return
+	}
+	defer upload.Close()
+
+	file, err := h.batchService.CreateFile(client.ID, header.Filename, purpose, upload)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fileJSON(file))
+}
+
+func (h *BatchHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	files, err := h.batchService.ListFiles(client.ID, r.URL.Query().Get("purpose"))
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	data := make([]map[string]interface{}, len(files))
+	for i := range files {
+		data[i] = fileJSON(&files[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data, "has_more": false})
+}
+
+// clientFile looks up the file named in the URL, writing a 404 if the client
+// has no such file.
+func (h *BatchHandler) clientFile(w http.ResponseWriter, r *http.Request) *models.File {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return nil
+	}
+	id := chi.URLParam(r, "id")
+	file, err := h.batchService.GetFile(client.ID, id)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return nil
+	}
+	if file == nil {
+		writeOpenAIError(w, http.StatusNotFound, "No such file: "+id, "invalid_request_error")
+		return nil
+	}
+	return file
+}
+
+func (h *BatchHandler) GetFile(w http.ResponseWriter, r *http.Request) {
+	if file := h.clientFile(w, r); file != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fileJSON(file))
+	}
+}
+
+func (h *BatchHandler) GetFileContent(w http.ResponseWriter, r *http.Request) {
+	file := h.clientFile(w, r)
+	if file == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+file.Filename+"\"")
+	http.ServeFile(w, r, h.batchService.FilePath(file.ID))
+}
+
+func (h *BatchHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	file := h.clientFile(w, r)
+	if file == nil {
+		return
+	}
+	if err := h.batchService.DeleteFile(file); err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": file.ID, "object": "file", "deleted": true})
+}
+
+func unixOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+func stringOrNil(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func batchJSON(b *models.Batch) map[string]interface{} {
+	var errors interface{}
+	if b.Errors != "" {
+		errors = map[string]interface{}{"object": "list", "data": json.RawMessage(b.Errors)}
+	}
+	var metadata interface{}
+	if b.Metadata != "" {
+		metadata = json.RawMessage(b.Metadata)
+	}
+	return map[string]interface{}{
+		"id":                b.ID,
+		"object":            "batch",
+		"endpoint":          b.Endpoint,
+		"errors":            errors,
+		"input_file_id":     b.InputFileID,
+		"completion_window": b.CompletionWindow,
+		"status":            b.Status,
+		"output_file_id":    stringOrNil(b.OutputFileID),
+		"error_file_id":     stringOrNil(b.ErrorFileID),
+		"created_at":        b.CreatedAt.Unix(),
+		"in_progress_at":    unixOrNil(b.InProgressAt),
+		"expires_at":        b.ExpiresAt.Unix(),
+		"expired_at":        unixOrNil(b.ExpiredAt),
+		"finalizing_at":     unixOrNil(b.FinalizingAt),
+		"completed_at":      unixOrNil(b.CompletedAt),
+		"failed_at":         unixOrNil(b.FailedAt),
+		"cancelling_at":     unixOrNil(b.CancellingAt),
+		"cancelled_at":      unixOrNil(b.CancelledAt),
+		"request_counts": map[string]int{
+			"total":     b.TotalRequests,
+			"completed": b.CompletedRequests,
+			"failed":    b.FailedRequests,
+		},
+		"metadata": metadata,
+	}
+}
+
+func (h *BatchHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	var req struct {
+		InputFileID      string          `json:"input_file_id"`
+		Endpoint         string          `json:"endpoint"`
+		CompletionWindow string          `json:"completion_window"`
+		Metadata         json.RawMessage `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid JSON in request body", "invalid_request_error")
+		return
+	}
+	if req.Endpoint != batchEndpoint {
+		writeOpenAIError(w, http.StatusBadRequest, "endpoint must be "+batchEndpoint, "invalid_request_error")
+		return
+	}
+	if req.CompletionWindow != "24h" {
+		writeOpenAIError(w, http.StatusBadRequest, "completion_window must be 24h", "invalid_request_error")
+		return
+	}
+	file, err := h.batchService.GetFile(client.ID, req.InputFileID)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	if file == nil || file.Purpose != "batch" {
+		writeOpenAIError(w, http.StatusBadRequest, "input_file_id must be a file uploaded with purpose \"batch\"", "invalid_request_error")
+		return
+	}
+
+	batch := &models.Batch{
+		ClientID:         client.ID,
+		Endpoint:         req.Endpoint,
+		InputFileID:      file.ID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           "validating",
+		ExpiresAt:        time.Now().Add(24 * time.Hour),
+	}
+	if len(req.Metadata) > 0 && string(req.Metadata) != "null" {
+		batch.Metadata = string(req.Metadata)
+	}
+	if err := h.batchService.CreateBatch(batch); err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+
+	response := batchJSON(batch)
+	h.start(batch)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *BatchHandler) ListBatches(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	// One extra row tells whether there is another page.
+	batches, err := h.batchService.ListBatches(client.ID, r.URL.Query().Get("after"), limit+1)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+
+	data := make([]map[string]interface{}, len(batches))
+	for i := range batches {
+		data[i] = batchJSON(&batches[i])
+	}
+	response := map[string]interface{}{"object": "list", "data": data, "has_more": hasMore, "first_id": nil, "last_id": nil}
+	if len(batches) > 0 {
+		response["first_id"] = batches[0].ID
+		response["last_id"] = batches[len(batches)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// clientBatch looks up the batch named in the URL, writing a 404 if the
+// client has no such batch.
+func (h *BatchHandler) clientBatch(w http.ResponseWriter, r *http.Request) *models.Batch {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return nil
+	}
+	id := chi.URLParam(r, "id")
+	batch, err := h.batchService.GetBatch(client.ID, id)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return nil
+	}
+	if batch == nil {
+		writeOpenAIError(w, http.StatusNotFound, "No such batch: "+id, "invalid_request_error")
+		return nil
+	}
+	return batch
+}
+
+func (h *BatchHandler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	if batch := h.clientBatch(w, r); batch != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batchJSON(batch))
+	}
+}
+
+// CancelBatch stops a batch. Requests already finished are kept and written
+// to the output files; the batch moves to cancelled once in-flight requests
+// (or the upstream batch) wind down.
+func (h *BatchHandler) CancelBatch(w http.ResponseWriter, r *http.Request) {
+	batch := h.clientBatch(w, r)
+	if batch == nil {
+		return
+	}
+
+	switch batch.Status {
+	case "validating", "in_progress", "finalizing", "cancelling":
+	default:
+		writeOpenAIError(w, http.StatusConflict, "Cannot cancel a batch with status "+batch.Status, "invalid_request_error")
+		return
+	}
+
+	h.mu.Lock()
+	cancel, running := h.running[batch.ID]
+	h.mu.Unlock()
+
+	now := time.Now()
+	if running {
+		// The runner owns the stored batch while it runs and records the
+		// cancellation itself.
+		cancel()
+		if batch.Status != "cancelling" {
+			batch.Status = "cancelling"
+			batch.CancellingAt = &now
+		}
+	} else {
+		batch.Status = "cancelled"
+		batch.CancellingAt = &now
+		batch.CancelledAt = &now
+		if err := h.batchService.SaveBatch(batch); err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batchJSON(batch))
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// maxChoices caps n. Providers without native support get one upstream call
+// per choice, so this also bounds the fan-out.
+const maxChoices = 16
+
+// choiceCount returns the number of choices requested, at least 1.
+func choiceCount(req *providers.ChatRequest) int {
+	if req.N == nil || *req.N < 1 {
+		return 1
+	}
+	return *req.N
+}
+
+// validateChoiceCount checks n against the gateway limits. Gateway-mode tool
+// execution continues a single conversation, and schema validation retries
+// one, so neither can serve several choices.
+func validateChoiceCount(req *providers.ChatRequest, client *models.Client) error {
+	n := choiceCount(req)
+	if n > maxChoices {
+		return fmt.Errorf("n must be at most %d", maxChoices)
+	}
+	if n > 1 && client.ToolMode != "pass-through" && len(req.Tools) > 0 {
+		return fmt.Errorf("n > 1 is not supported with gateway-mode tool execution")
+	}
+	if _, wantsJSON := providers.ResponseSchema(req.ResponseFormat); n > 1 && client.ValidateSchema && wantsJSON {
+		return fmt.Errorf("n > 1 is not supported with schema validation")
+	}
+	return nil
+}
+
+// choiceRequests returns the upstream requests needed for a multi-choice
+// request: the request itself when the provider honours n natively, otherwise
+// one copy per choice with n unset.
+func choiceRequests(provider providers.Provider, chatReq *providers.ChatRequest) []*providers.ChatRequest {
+	if providers.SupportsChoiceCount(provider) {
+		return []*providers.ChatRequest{chatReq}
+	}
+	single := *chatReq
+	single.N = nil
+	reqs := make([]*providers.ChatRequest, choiceCount(chatReq))
+	for i := range reqs {
+		reqs[i] = &single
+	}
+	return reqs
+}
+
+// choiceResult is the upstream response to one request of a fan-out.
+type choiceResult struct {
+	body       []byte
+	statusCode int
+	err        error
+}
+
+// completeChoices sends the requests from choiceRequests concurrently.
+func completeChoices(provider providers.Provider, reqs []*providers.ChatRequest) []choiceResult {
+	results := make([]choiceResult, len(reqs))
+	var wg sync.WaitGroup
+	for i, cr := range reqs {
+		wg.Add(1)
+		go func(i int, cr *providers.ChatRequest) {
+			defer wg.Done()
+			body, statusCode, err := provider.ChatCompletion(cr)
+			results[i] = choiceResult{body, statusCode, err}
+		}(i, cr)
+	}
+	wg.Wait()
+	return results
+}
+
+// openChoiceStreams starts the streams for the requests from choiceRequests
+// concurrently.
+func openChoiceStreams(provider providers.Provider, reqs []*providers.ChatRequest) ([]*http.Response, []error) {
+	resps := make([]*http.Response, len(reqs))
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for i, cr := range reqs {
+		wg.Add(1)
+		go func(i int, cr *providers.ChatRequest) {
+			defer wg.Done()
+			resps[i], errs[i] = provider.ChatCompletionStream(cr)
+		}(i, cr)
+	}
+	wg.Wait()
+	return resps, errs
+}
+
+// mergeChoiceStreams reads the streams opened by openChoiceStreams into one
+// channel, which is closed once all of them end. With fan-out every stream is
+// choice 0 of its own response, so events are re-indexed by the stream they
+// came from. A stream's read error arrives as an error event.
+func mergeChoiceStreams(provider providers.Provider, resps []*http.Response) <-chan providers.StreamEvent {
+	events := make(chan providers.StreamEvent)
+	var readers sync.WaitGroup
+	for i, resp := range resps {
+		readers.Add(1)
+		go func(i int, stream *providers.EventStream) {
+			defer readers.Done()
+			defer stream.Close()
+			for stream.Next() {
+				ev := stream.Event()
+				if len(resps) > 1 {
+					ev.Choice = i
+				}
+				events <- ev
+			}
+			if err := stream.Err(); err != nil {
+				events <- providers.StreamEvent{Type: providers.StreamEventError, Choice: i, Err: err}
+			}
+		}(i, provider.StreamEvents(resp))
+	}
+	go func() {
+		readers.Wait()
+		close(events)
+	}()
+	return events
+}
+
+// estimateChoiceUsage is estimateUsage for a request fanned out over calls
+// upstream requests, each of which was sent the whole prompt.
+func estimateChoiceUsage(chatReq *providers.ChatRequest, calls int, output string, it, ot *int) bool {
+	promptEstimated := *it == 0
+	estimated := estimateUsage(chatReq, output, it, ot)
+	if promptEstimated {
+		*it *= calls
+	}
+	return estimated
+}
+
+// tryMultiChoiceRequest serves a non-streaming request with n > 1. Usage is
+// summed across all upstream calls; any failed call fails the whole request.
+func (h *OpenAIHandler) tryMultiChoiceRequest(w http.ResponseWriter, client *models.Client, req OpenAIChatRequest, provider providers.Provider, chatReq *providers.ChatRequest, requestBody string) error {
+	start := time.Now()
+	reqs := choiceRequests(provider, chatReq)
+	results := completeChoices(provider, reqs)
+	latencyMs := int(time.Since(start).Milliseconds())
+
+	for _, res := range results {
+		if res.err != nil {
+			if isRetryableError(502, res.err.Error()) {
+				return res.err
+			}
+			writeOpenAIError(w, http.StatusBadGateway, "Upstream request failed: "+res.err.Error(), "api_error")
+			if h.statsService != nil {
+				h.statsService.DecrementRequestsInProgress()
+			}
+			return nil
+		}
+		if res.statusCode >= 400 {
+			errMsg := extractErrorMessage(res.body)
+			if isRetryableError(res.statusCode, errMsg) {
+				return fmt.Errorf("status %d: %s", res.statusCode, errMsg)
+			}
+			writeOpenAIError(w, mapUpstreamStatusToHTTP(res.statusCode), errMsg, "api_error")
+			if h.statsService != nil {
+				h.statsService.DecrementRequestsInProgress()
+			}
+			return nil
+		}
+	}
+
+	var choices []map[string]interface{}
+	var toolNames []string
+	var output strings.Builder
+	var safety *providers.SafetyFeedback
+	var it, ot, rt, cacheWrite, cacheRead int
+	for i, res := range results {
+		text, in, out, _ := provider.ParseResponse(res.body)
+		output.WriteString(text)
+		it += in
+		ot += out
+		details := providers.ParseUsageDetails(provider, res.body)
+		rt += details.ReasoningTokens
+		cacheWrite += details.CacheCreationTokens
+		cacheRead += details.CacheReadTokens
+
+		parsed, err := provider.ParseChoices(res.body)
+		if err != nil {
+			log.Printf("[CHAT] Failed to parse choices from %s: %v", provider.Name(), err)
+			continue
+		}
+		if safety == nil {
+			safety = firstSafetyBlock(parsed)
+		}
+		for _, c := range parsed {
+			if len(reqs) > 1 {
+				c.Index = i
+			}
+			// A choice can't be rejected on its own, so strict problems are
+			// only logged here.
+			fixToolArguments(client, chatReq.Tools, c.ToolCalls)
+			for _, tc := range c.ToolCalls {
+				toolNames = append(toolNames, tc.Name)
+			}
+			choices = append(choices, withSafety(choiceJSON(c.Index, assistantMessage(c), c.FinishReason, c.Logprobs), c.Safety))
+		}
+	}
+
+	estimated := estimateChoiceUsage(chatReq, len(reqs), output.String(), &it, &ot)
+	h.geminiService.LogRequestEntry(&models.RequestLog{
+		ClientID: client.ID, Model: chatReq.Model, StatusCode: http.StatusOK,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, LatencyMs: latencyMs,
+		ErrorMessage: safetyBlockMessage(client, chatReq.Model, safety), RequestBody: requestBody, HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","),
+		TokensEstimated: estimated, CacheCreationTokens: cacheWrite, CacheReadTokens: cacheRead,
+	})
+	RecordRequest(client.ID, chatReq.Model, fmt.Sprintf("%d", http.StatusOK), it, ot, latencyMs)
+	RecordCacheTokens(client.ID, chatReq.Model, cacheWrite, cacheRead)
+	if h.statsService != nil {
+		h.statsService.DecrementRequestsInProgress()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OpenAIChatResponse{
+		ID:      "chatcmpl-" + randomID(12),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: choices,
+		Usage:   usageJSON(it, ot, rt),
+	})
+
+	if client.BackendModels == "" {
+		h.updateClientModels(client, provider)
+	}
+	return nil
+}
+
+// tryMultiChoiceStream serves a streaming request with n > 1. Events from all
+// upstream streams are merged into one SSE stream, each chunk carrying its
+// choice index. Tool calls are always forwarded to the client.
+func (h *OpenAIHandler) tryMultiChoiceStream(w http.ResponseWriter, client *models.Client, req OpenAIChatRequest, provider providers.Provider, chatReq *providers.ChatRequest, requestBody string) error {
+	start := time.Now()
+	n := choiceCount(chatReq)
+	reqs := choiceRequests(provider, chatReq)
+
+	resps, errs := openChoiceStreams(provider, reqs)
+
+	closeAll := func() {
+		for _, resp := range resps {
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			closeAll()
+			if isRetryableError(502, err.Error()) {
+				return err
+			}
+			writeOpenAIError(w, http.StatusBadGateway, "Upstream request failed: "+err.Error(), "api_error")
+			if h.statsService != nil {
+				h.statsService.DecrementRequestsInProgress()
+			}
+			return nil
+		}
+		if resps[i].StatusCode >= 400 {
+			body, _ := io.ReadAll(resps[i].Body)
+			closeAll()
+			errMsg := extractErrorMessage(body)
+			if isRetryableError(resps[i].StatusCode, errMsg) {
+				return fmt.Errorf("status %d: %s", resps[i].StatusCode, errMsg)
+			}
+			writeOpenAIError(w, mapUpstreamStatusToHTTP(resps[i].StatusCode), errMsg, "api_error")
+			if h.statsService != nil {
+				h.statsService.DecrementRequestsInProgress()
+			}
+			return nil
+		}
+	}
+
+	events := mergeChoiceStreams(provider, resps)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	responseID := "chatcmpl-" + randomID(12)
+	created := time.Now().Unix()
+	w.WriteHeader(http.StatusOK)
+
+	flusher := w.(http.Flusher)
+	for i := 0; i < n; i++ {
+		sendSSEChoiceChunk(w, flusher, responseID, req.Model, created, i, map[string]interface{}{"role": "assistant", "content": ""}, nil)
+	}
+
+	finishReasons := make([]string, n)
+	safety := make([]*providers.SafetyFeedback, n)
+	calls := make([]*toolCallAccumulator, n)
+	inputTokens := make(map[int]int)
+	outputTokens := make(map[int]int)
+	reasoningTokens := make(map[int]int)
+	cacheWrites := make(map[int]int)
+	cacheReads := make(map[int]int)
+	var output strings.Builder
+	var streamErr string
+
+	for ev := range events {
+		if ev.Choice < 0 || ev.Choice >= n {
+			continue
+		}
+		switch ev.Type {
+		case providers.StreamEventText:
+			output.WriteString(ev.Text)
+			sendSSEContentChunk(w, flusher, responseID, req.Model, created, ev.Choice, ev.Text, ev.Logprobs)
+		case providers.StreamEventReasoning:
+			sendSSEChoiceChunk(w, flusher, responseID, req.Model, created, ev.Choice, map[string]interface{}{"reasoning_content": ev.Text}, nil)
+		case providers.StreamEventThinkingBlock:
+			sendSSEChoiceChunk(w, flusher, responseID, req.Model, created, ev.Choice, map[string]interface{}{"thinking_blocks": []providers.ThinkingBlock{*ev.ThinkingBlock}}, nil)
+		case providers.StreamEventToolCall:
+			if calls[ev.Choice] == nil {
+				calls[ev.Choice] = newToolCallAccumulator()
+			}
+			delta := calls[ev.Choice].add(ev.ToolCall)
+			sendSSEChoiceChunk(w, flusher, responseID, req.Model, created, ev.Choice, map[string]interface{}{"tool_calls": []map[string]interface{}{delta}}, nil)
+		case providers.StreamEventUsage:
+			if ev.InputTokens > 0 {
+				inputTokens[ev.Choice] = ev.InputTokens
+			}
+			if ev.OutputTokens > 0 {
+				outputTokens[ev.Choice] = ev.OutputTokens
+			}
+			if ev.ReasoningTokens > 0 {
+				reasoningTokens[ev.Choice] = ev.ReasoningTokens
+			}
+			if ev.CacheCreationTokens > 0 || ev.CacheReadTokens > 0 {
+				cacheWrites[ev.Choice], cacheReads[ev.Choice] = ev.CacheCreationTokens, ev.CacheReadTokens
+			}
+		case providers.StreamEventFinish:
+			finishReasons[ev.Choice] = ev.FinishReason
+			if ev.Safety != nil {
+				safety[ev.Choice] = ev.Safety
+			}
+		case providers.StreamEventError:
+			if streamErr == "" {
+				streamErr = ev.Err.Error()
+			}
+		}
+	}
+
+	if streamErr != "" {
+		log.Printf("[CHAT] Stream from %s ended with error: %s", provider.Name(), streamErr)
+		sendSSEError(w, flusher, "Upstream stream failed: "+streamErr, "api_error")
+	}
+
+	var toolNames []string
+	var blocked *providers.SafetyFeedback
+	for i := 0; i < n; i++ {
+		reason := finishReasons[i]
+		if calls[i] != nil {
+			for _, tc := range calls[i].list() {
+				toolNames = append(toolNames, tc.Name)
+			}
+			reason = "tool_calls"
+		} else if reason == "" {
+			reason = "stop"
+		}
+		if blocked == nil {
+			blocked = safety[i]
+		}
+		sendSSEChoice(w, flusher, responseID, req.Model, created, withSafety(map[string]interface{}{"index": i, "delta": map[string]interface{}{}, "finish_reason": reason}, safety[i]))
+	}
+
+	var it, ot, rt, cacheWrite, cacheRead int
+	for _, v := range inputTokens {
+		it += v
+	}
+	for _, v := range outputTokens {
+		ot += v
+	}
+	for _, v := range reasoningTokens {
+		rt += v
+	}
+	for i := range cacheWrites {
+		cacheWrite += cacheWrites[i]
+		cacheRead += cacheReads[i]
+	}
+	estimated := estimateChoiceUsage(chatReq, len(reqs), output.String(), &it, &ot)
+	sendSSEUsage(w, flusher, responseID, req.Model, created, it, ot, rt)
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+
+	latencyMs := int(time.Since(start).Milliseconds())
+	h.geminiService.LogRequestEntry(&models.RequestLog{
+		ClientID: client.ID, Model: chatReq.Model, StatusCode: http.StatusOK,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, LatencyMs: latencyMs,
+		ErrorMessage: streamErr + safetyBlockMessage(client, chatReq.Model, blocked), RequestBody: requestBody, IsStreaming: true,
+		HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","), TokensEstimated: estimated,
+		CacheCreationTokens: cacheWrite, CacheReadTokens: cacheRead,
+	})
+	RecordRequest(client.ID, chatReq.Model, fmt.Sprintf("%d", http.StatusOK), it, ot, latencyMs)
+	RecordCacheTokens(client.ID, chatReq.Model, cacheWrite, cacheRead)
+	if h.statsService != nil {
+		h.statsService.DecrementRequestsInProgress()
+	}
+	if client.BackendModels == "" {
+		h.updateClientModels(client, provider)
+	}
+	return nil
+}
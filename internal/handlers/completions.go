@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+	"ai-gateway/internal/sse"
+	"ai-gateway/internal/tokenizer"
+)
+
+const continuePrompt = "Continue the text the user sends. Reply with only the continuation, starting exactly " +
+	"where the text ends. Don't repeat the text, and don't add commentary or code fences."
+
+const fillInMiddlePrompt = "Fill in the gap in the text the user sends. The text before the gap is in <prefix> " +
+	"and the text after it is in <suffix>. Reply with only the text that goes in the gap, so that prefix, " +
+	"reply and suffix read as one. Don't repeat the prefix or suffix, and don't add commentary or code fences."
+
+// CompletionRequest is OpenAI's legacy /v1/completions body. Backends with a
+// native endpoint get the body as sent, with the model filled in; the fields
+// here are what the gateway reads to check limits and to emulate the request
+// through chat.
+type CompletionRequest struct {
+	Model            string          `json:"model"`
+	Prompt           json.RawMessage `json:"prompt"`
+	Suffix           string          `json:"suffix,omitempty"`
+	MaxTokens        *int            `json:"max_tokens,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	N                *int            `json:"n,omitempty"`
+	Stop             StopSequences   `json:"stop,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	Echo             bool            `json:"echo,omitempty"`
+	Logprobs         *int            `json:"logprobs,omitempty"`
+	Stream           bool            `json:"stream,omitempty"`
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`
+	User             string          `json:"user,omitempty"`
+}
+
+// completionPrompts reads the prompt, a string or an array of strings.
+// Token-array prompts aren't supported as the gateway couldn't count or
+// emulate them.
+func completionPrompts(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return []string{""}, nil
+	}
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil || len(many) == 0 {
+		return nil, fmt.Errorf("prompt must be a string or an array of strings")
+	}
+	return many, nil
+}
+
+func (h *OpenAIHandler) Completions(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Failed to read request body", "invalid_request_error")
+		return
+	}
+	var req CompletionRequest
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid JSON in request body", "invalid_request_error")
+		return
+	}
+	json.Unmarshal(body, &fields)
+	prompts, err := completionPrompts(req.Prompt)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+	if req.N != nil && *req.N > maxChoices {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("n must be at most %d", maxChoices), "invalid_request_error")
+		return
+	}
+
+	provider, err := h.resolveProvider(client)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Backend not configured: "+err.Error(), "invalid_request_error")
+		return
+	}
+	if req.Model == "" {
+		req.Model = clientDefaultModel(client, provider)
+	}
+
+	inputTokens := countCompletionTokens(req.Model, prompts, req.Suffix)
+	if client.MaxInputTokens > 0 && inputTokens > client.MaxInputTokens {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("input is %d tokens, which exceeds this client's limit of %d", inputTokens, client.MaxInputTokens), "invalid_request_error")
+		return
+	}
+
+	if h.statsService != nil {
+		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
+	}
+
+	if completer, ok := provider.(providers.TextCompleter); ok {
+		fields["model"], _ = json.Marshal(req.Model)
+		if req.Stream {
+			// Ask for usage to log it; the chunk is dropped again unless the
+			// client asked too.
+			fields["stream_options"] = json.RawMessage(`{"include_usage":true}`)
+		}
+		upstreamBody, _ := json.Marshal(fields)
+
+		start := time.Now()
+		resp, err := completer.ForwardCompletion(upstreamBody)
+		if err == nil {
+			h.forwardCompletion(w, client, &req, resp, inputTokens, start, string(body))
+			return
+		}
+		if !errors.Is(err, providers.ErrCompletionsUnsupported) {
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: string(body), IsStreaming: req.Stream})
+			writeOpenAIError(w, http.StatusBadGateway, "Upstream request failed: "+err.Error(), "api_error")
+			return
+		}
+	}
+
+	h.emulateCompletion(w, client, provider, &req, prompts, string(body))
+}
+
+// countCompletionTokens counts the prompts and suffix.
+func countCompletionTokens(model string, prompts []string, suffix string) int {
+	n, _ := tokenizer.Count(model, suffix)
+	for _, p := range prompts {
+		c, _ := tokenizer.Count(model, p)
+		n += c
+	}
+	return n
+}
+
+// completionUsage is the usage and generated text of a completions response
+// or stream chunk.
+type completionUsage struct {
+	Choices []struct {
+		Text string `json:"text"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// forwardCompletion relays a native backend's response, logging its usage.
+// Counts the backend doesn't report are estimated.
+func (h *OpenAIHandler) forwardCompletion(w http.ResponseWriter, client *models.Client, req *CompletionRequest, resp *http.Response, inputTokens int, start time.Time, requestBody string) {
+	defer resp.Body.Close()
+	entry := &models.RequestLog{Model: req.Model, StatusCode: resp.StatusCode, RequestBody: requestBody, IsStreaming: req.Stream}
+
+	if resp.StatusCode >= 400 || !req.Stream {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			entry.StatusCode = http.StatusBadGateway
+			entry.ErrorMessage = err.Error()
+			logRequest(h.geminiService, client, start, entry)
+			writeOpenAIError(w, http.StatusBadGateway, "Failed to read upstream response: "+err.Error(), "api_error")
+			return
+		}
+		if resp.StatusCode >= 400 {
+			entry.ErrorMessage = extractErrorMessage(respBody)
+		} else {
+			var parsed completionUsage
+			json.Unmarshal(respBody, &parsed)
+			var output strings.Builder
+			for _, c := range parsed.Choices {
+				output.WriteString(c.Text)
+			}
+			if parsed.Usage != nil {
+				entry.InputTokens, entry.OutputTokens = parsed.Usage.PromptTokens, parsed.Usage.CompletionTokens
+			}
+			entry.TokensEstimated = estimateCompletionUsage(req.Model, inputTokens, output.String(), entry)
+		}
+		logRequest(h.geminiService, client, start, entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		w.Write(respBody)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher := w.(http.Flusher)
+
+	wantUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	var output strings.Builder
+	dec := sse.NewDecoder(resp.Body, 0)
+	for {
+		ev, err := dec.Next()
+		if err != nil {
+			if err != io.EOF {
+				entry.ErrorMessage = err.Error()
+			}
+			break
+		}
+		if !bytes.Equal(ev.Data, []byte("[DONE]")) {
+			var chunk completionUsage
+			json.Unmarshal(ev.Data, &chunk)
+			for _, c := range chunk.Choices {
+				output.WriteString(c.Text)
+			}
+			if chunk.Usage != nil {
+				entry.InputTokens, entry.OutputTokens = chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens
+				if len(chunk.Choices) == 0 && !wantUsage {
+					continue
+				}
+			}
+		}
+		fmt.Fprintf(w, "data: %s\n\n", ev.Data)
+		flusher.Flush()
+	}
+
+	if entry.ErrorMessage != "" {
+		log.Printf("[COMPLETIONS] Stream for client %s ended with error: %s", client.Name, entry.ErrorMessage)
+	}
+	entry.TokensEstimated = estimateCompletionUsage(req.Model, inputTokens, output.String(), entry)
+	logRequest(h.geminiService, client, start, entry)
+}
+
+// estimateCompletionUsage fills in the token counts an upstream didn't
+// report, returning true if either was estimated.
+func estimateCompletionUsage(model string, inputTokens int, output string, entry *models.RequestLog) bool {
+	estimated := false
+	if entry.InputTokens == 0 {
+		entry.InputTokens = inputTokens
+		estimated = true
+	}
+	if entry.OutputTokens == 0 && output != "" {
+		entry.OutputTokens, _ = tokenizer.Count(model, output)
+		estimated = true
+	}
+	return estimated
+}
+
+// completionChatRequest turns a completion into a chat request asking the
+// model to continue the prompt, or to fill the gap before the suffix. The
+// client's system prompt isn't applied, as it isn't on native backends.
+func completionChatRequest(req *CompletionRequest, prompt string) *providers.ChatRequest {
+	messages := []providers.ChatMessage{
+		{Role: "system", Content: continuePrompt},
+		{Role: "user", Content: prompt},
+	}
+	if req.Suffix != "" {
+		messages = []providers.ChatMessage{
+			{Role: "system", Content: fillInMiddlePrompt},
+			{Role: "user", Content: "<prefix>" + prompt + "</prefix>\n<suffix>" + req.Suffix + "</suffix>"},
+		}
+	}
+	maxTokens := req.MaxTokens
+	if maxTokens == nil {
+		// The legacy endpoint defaults to 16 tokens.
+		n := 16
+		maxTokens = &n
+	}
+	return &providers.ChatRequest{
+		Model:            req.Model,
+		Messages:         messages,
+		MaxTokens:        maxTokens,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		Stop:             req.Stop,
+		Seed:             req.Seed,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		N:                req.N,
+		User:             req.User,
+	}
+}
+
+// completionChoice renders a text_completion choice.
+func completionChoice(index int, text string, finishReason interface{}) map[string]interface{} {
+	return map[string]interface{}{"text": text, "index": index, "logprobs": nil, "finish_reason": finishReason}
+}
+
+// emulateCompletion serves a completion from a backend without the legacy
+// endpoint through its chat API, one prompt per request.
+func (h *OpenAIHandler) emulateCompletion(w http.ResponseWriter, client *models.Client, provider providers.Provider, req *CompletionRequest, prompts []string, requestBody string) {
+	switch {
+	case len(prompts) > 1:
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("The %s backend takes one prompt per request", provider.Name()), "invalid_request_error")
+		return
+	case req.Logprobs != nil:
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("logprobs is not supported for completions on the %s backend", provider.Name()), "invalid_request_error")
+		return
+	case req.Stream && req.N != nil && *req.N > 1:
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Streaming with n > 1 is not supported for completions on the %s backend", provider.Name()), "invalid_request_error")
+		return
+	}
+
+	chatReq := completionChatRequest(req, prompts[0])
+	chatReq.SafetySettings = clientSafetySettings(client)
+	if err := providers.ValidateRequest(provider, chatReq); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+	echo := ""
+	if req.Echo {
+		echo = prompts[0]
+	}
+	id := "cmpl-" + randomID(24)
+	created := time.Now().Unix()
+
+	if req.Stream {
+		h.emulateCompletionStream(w, client, provider, req, chatReq, echo, id, created, requestBody)
+		return
+	}
+
+	start := time.Now()
+	var choices []map[string]interface{}
+	var output strings.Builder
+	var safety *providers.SafetyFeedback
+	var it, ot int
+	reqs := choiceRequests(provider, chatReq)
+	for _, cr := range reqs {
+		respBody, statusCode, err := provider.ChatCompletion(cr)
+		if err != nil {
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: requestBody})
+			writeOpenAIError(w, http.StatusBadGateway, "Upstream request failed: "+err.Error(), "api_error")
+			return
+		}
+		if statusCode >= 400 {
+			errMsg := extractErrorMessage(respBody)
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: statusCode, ErrorMessage: errMsg, RequestBody: requestBody})
+			writeOpenAIError(w, mapUpstreamStatusToHTTP(statusCode), errMsg, "api_error")
+			return
+		}
+		_, in, out, _ := provider.ParseResponse(respBody)
+		it += in
+		ot += out
+		parsed, err := provider.ParseChoices(respBody)
+		if err != nil {
+			log.Printf("[COMPLETIONS] Failed to parse choices from %s: %v", provider.Name(), err)
+			continue
+		}
+		if safety == nil {
+			safety = firstSafetyBlock(parsed)
+		}
+		for _, c := range parsed {
+			output.WriteString(c.Text)
+			choices = append(choices, withSafety(completionChoice(len(choices), echo+c.Text, c.FinishReason), c.Safety))
+		}
+	}
+
+	estimated := estimateChoiceUsage(chatReq, len(reqs), output.String(), &it, &ot)
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: req.Model, StatusCode: http.StatusOK, InputTokens: it, OutputTokens: ot,
+		TokensEstimated: estimated, RequestBody: requestBody, ErrorMessage: safetyBlockMessage(client, req.Model, safety),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      id,
+		"object":  "text_completion",
+		"created": created,
+		"model":   req.Model,
+		"choices": choices,
+		"usage":   usageJSON(it, ot, 0),
+	})
+}
+
+func (h *OpenAIHandler) emulateCompletionStream(w http.ResponseWriter, client *models.Client, provider providers.Provider, req *CompletionRequest, chatReq *providers.ChatRequest, echo, id string, created int64, requestBody string) {
+	start := time.Now()
+	chatReq.Stream = true
+	chatReq.StreamOptions = &providers.StreamOptions{IncludeUsage: true}
+
+	resp, err := provider.ChatCompletionStream(chatReq)
+	if err != nil {
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: requestBody, IsStreaming: true})
+		writeOpenAIError(w, http.StatusBadGateway, "Upstream request failed: "+err.Error(), "api_error")
+		return
+	}
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		errMsg := extractErrorMessage(respBody)
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: resp.StatusCode, ErrorMessage: errMsg, RequestBody: requestBody, IsStreaming: true})
+		writeOpenAIError(w, mapUpstreamStatusToHTTP(resp.StatusCode), errMsg, "api_error")
+		return
+	}
+
+	stream := provider.StreamEvents(resp)
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher := w.(http.Flusher)
+	send := func(chunk map[string]interface{}) {
+		chunk["id"] = id
+		chunk["object"] = "text_completion"
+		chunk["created"] = created
+		chunk["model"] = req.Model
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	sendText := func(text string, finishReason interface{}) {
+		send(map[string]interface{}{"choices": []map[string]interface{}{completionChoice(0, text, finishReason)}})
+	}
+
+	if echo != "" {
+		sendText(echo, nil)
+	}
+	finishReason := "stop"
+	var safety *providers.SafetyFeedback
+	var it, ot int
+	var output strings.Builder
+	var streamErr string
+	for stream.Next() {
+		ev := stream.Event()
+		switch ev.Type {
+		case providers.StreamEventText:
+			if ev.Text != "" {
+				output.WriteString(ev.Text)
+				sendText(ev.Text, nil)
+			}
+		case providers.StreamEventUsage:
+			if ev.InputTokens > 0 {
+				it = ev.InputTokens
+			}
+			if ev.OutputTokens > 0 {
+				ot = ev.OutputTokens
+			}
+		case providers.StreamEventFinish:
+			finishReason = ev.FinishReason
+			if ev.Safety != nil {
+				safety = ev.Safety
+			}
+		case providers.StreamEventError:
+			streamErr = ev.Err.Error()
+		}
+	}
+	if err := stream.Err(); err != nil && streamErr == "" {
+		streamErr = err.Error()
+	}
+
+	if streamErr != "" {
+		log.Printf("[COMPLETIONS] Stream from %s ended with error: %s", provider.Name(), streamErr)
+		sendSSEError(w, flusher, "Upstream stream failed: "+streamErr, "api_error")
+	} else {
+		send(map[string]interface{}{"choices": []map[string]interface{}{withSafety(completionChoice(0, "", finishReason), safety)}})
+	}
+	estimated := estimateUsage(chatReq, output.String(), &it, &ot)
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		send(map[string]interface{}{"choices": []interface{}{}, "usage": usageJSON(it, ot, 0)})
+	}
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: req.Model, StatusCode: resp.StatusCode, InputTokens: it, OutputTokens: ot, TokensEstimated: estimated,
+		ErrorMessage: streamErr + safetyBlockMessage(client, req.Model, safety), RequestBody: requestBody, IsStreaming: true,
+	})
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+	"ai-gateway/internal/services"
+	"ai-gateway/internal/tokenizer"
+)
+
+// Client context policies, see models.Client.ContextPolicy. Any other
+// non-empty policy, including "reject", fails requests that don't fit.
+const (
+	contextPolicyTruncate  = "truncate"
+	contextPolicySummarize = "summarize"
+)
+
+// summaryMaxTokens bounds the summary that replaces dropped turns; that much
+// room is kept free for it when choosing what to drop.
+const summaryMaxTokens = 1024
+
+const summaryPrompt = "Summarize the conversation below so the summary can replace it as context for " +
+	"the rest of the conversation. Keep facts, decisions, names, numbers, tool results and open " +
+	"questions. Be concise and don't add anything that isn't in the conversation."
+
+// contextLengthError is returned when a request can't be made to fit the
+// model's context window.
+type contextLengthError struct {
+	window, tokens int
+}
+
+func (e *contextLengthError) Error() string {
+	return fmt.Sprintf("This model's maximum context length is %d tokens, but the request needs about %d tokens. "+
+		"Shorten the messages or reduce max_tokens.", e.window, e.tokens)
+}
+
+// fitContextWindow applies the client's context policy when chatReq doesn't
+// fit its model's context window, rewriting chatReq.Messages for truncate and
+// summarize. It returns a description of what was done for the
+// X-Context-Action header, or "" if nothing was.
+func fitContextWindow(geminiService *services.GeminiService, client *models.Client, provider providers.Provider, chatReq *providers.ChatRequest) (string, error) {
+	if client.ContextPolicy == "" {
+		return "", nil
+	}
+	window := tokenizer.ContextWindow(chatReq.Model)
+	if window <= 0 {
+		return "", nil
+	}
+
+	total, exact := countPromptTokens(chatReq)
+	reserved := requestedOutputTokens(chatReq)
+	budget := window - reserved
+	if !exact {
+		// Estimated counts can be a few percent low; leave some headroom.
+		budget -= budget / 20
+	}
+	if total <= budget {
+		return "", nil
+	}
+
+	switch client.ContextPolicy {
+	case contextPolicyTruncate:
+		drop, remaining := dropOldestTurns(chatReq, total, budget)
+		if remaining > budget {
+			return "", &contextLengthError{window: window, tokens: remaining + reserved}
+		}
+		chatReq.Messages = removeMessages(chatReq.Messages, drop, nil)
+		return fmt.Sprintf("truncated; dropped=%d", len(drop)), nil
+
+	case contextPolicySummarize:
+		drop, remaining := dropOldestTurns(chatReq, total, budget-summaryMaxTokens)
+		if remaining > budget {
+			return "", &contextLengthError{window: window, tokens: remaining + reserved}
+		}
+		if remaining > budget-summaryMaxTokens {
+			// The kept turns leave no room for a summary.
+			chatReq.Messages = removeMessages(chatReq.Messages, drop, nil)
+			return fmt.Sprintf("truncated; dropped=%d", len(drop)), nil
+		}
+		summary, err := summarizeTurns(geminiService, client, provider, chatReq, drop)
+		if err != nil {
+			log.Printf("[CONTEXT] Summary for client %s failed, truncating instead: %v", client.Name, err)
+			chatReq.Messages = removeMessages(chatReq.Messages, drop, nil)
+			return fmt.Sprintf("truncated; dropped=%d", len(drop)), nil
+		}
+		chatReq.Messages = removeMessages(chatReq.Messages, drop, &providers.ChatMessage{
+			Role:    "system",
+			Content: "Summary of the earlier conversation:\n" + summary,
+		})
+		return fmt.Sprintf("summarized; replaced=%d", len(drop)), nil
+	}
+
+	return "", &contextLengthError{window: window, tokens: total + reserved}
+}
+
+// requestedOutputTokens is the room the request asks to keep for output.
+// Without an explicit limit nothing is reserved, as upstreams only reject
+// when the prompt itself is too long.
+func requestedOutputTokens(chatReq *providers.ChatRequest) int {
+	switch {
+	case chatReq.MaxCompletionTokens != nil:
+		return *chatReq.MaxCompletionTokens
+	case chatReq.MaxTokens != nil:
+		return *chatReq.MaxTokens
+	}
+	return 0
+}
+
+// dropOldestTurns picks the oldest non-system messages to remove until the
+// prompt fits in budget tokens, returning their indexes and the prompt size
+// without them. The final turn (the last message, with the tool call its
+// results answer) is always kept, tool results are dropped with the call
+// before them, and the remaining conversation starts with a user message.
+func dropOldestTurns(chatReq *providers.ChatRequest, total, budget int) (map[int]bool, int) {
+	messages := chatReq.Messages
+	texts := messageTexts(messages)
+
+	protected := len(messages) - 1
+	for protected > 0 && messages[protected].Role == "tool" {
+		protected--
+	}
+
+	drop := make(map[int]bool)
+	for i := 0; i < protected; i++ {
+		if isSystemRole(messages[i].Role) {
+			continue
+		}
+		if total <= budget && messages[i].Role == "user" {
+			break
+		}
+		drop[i] = true
+		n, _ := tokenizer.CountMessage(chatReq.Model, texts[i])
+		total -= n
+	}
+	return drop, total
+}
+
+func isSystemRole(role string) bool {
+	return role == "system" || role == "developer"
+}
+
+// removeMessages returns messages without the dropped indexes, with
+// replacement (if any) in place of the first dropped message.
+func removeMessages(messages []providers.ChatMessage, drop map[int]bool, replacement *providers.ChatMessage) []providers.ChatMessage {
+	kept := make([]providers.ChatMessage, 0, len(messages)-len(drop)+1)
+	for i, m := range messages {
+		if !drop[i] {
+			kept = append(kept, m)
+			continue
+		}
+		if replacement != nil {
+			kept = append(kept, *replacement)
+			replacement = nil
+		}
+	}
+	return kept
+}
+
+// summarizeTurns asks the client's summary model for a summary of the
+// dropped messages. The call is logged against the client like any other
+// request so it counts towards its quotas.
+func summarizeTurns(geminiService *services.GeminiService, client *models.Client, provider providers.Provider, chatReq *providers.ChatRequest, drop map[int]bool) (string, error) {
+	var transcript strings.Builder
+	for i, m := range chatReq.Messages {
+		if !drop[i] {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", m.Role, m.Content)
+		for _, tc := range m.ToolCalls {
+			fmt.Fprintf(&transcript, "%s called %s(%s)\n", m.Role, tc.Name, tc.Arguments)
+		}
+	}
+
+	model := client.SummaryModel
+	if model == "" {
+		model = chatReq.Model
+	}
+	maxTokens := summaryMaxTokens
+	summaryReq := &providers.ChatRequest{
+		Model: model,
+		Messages: []providers.ChatMessage{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: transcript.String()},
+		},
+		MaxTokens: &maxTokens,
+	}
+
+	start := time.Now()
+	respBody, statusCode, err := provider.ChatCompletion(summaryReq)
+	if err != nil {
+		logRequest(geminiService, client, start, &models.RequestLog{Model: model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error()})
+		return "", fmt.Errorf("failed to call summary model: %w", err)
+	}
+	if statusCode >= 400 {
+		errMsg := extractErrorMessage(respBody)
+		logRequest(geminiService, client, start, &models.RequestLog{Model: model, StatusCode: statusCode, ErrorMessage: errMsg})
+		return "", fmt.Errorf("summary model returned status %d: %s", statusCode, errMsg)
+	}
+
+	text, it, ot, _ := provider.ParseResponse(respBody)
+	estimated := estimateUsage(summaryReq, text, &it, &ot)
+	logRequest(geminiService, client, start, &models.RequestLog{
+		Model: model, StatusCode: statusCode, InputTokens: it, OutputTokens: ot, TokensEstimated: estimated,
+	})
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("summary model returned no text")
+	}
+	return text, nil
+}
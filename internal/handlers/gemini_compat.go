@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// Gemini-format requests for backends that don't speak the Gemini API are
+// translated into a providers.ChatRequest, and the result is rendered back as
+// a GenerateContentResponse, so Google GenAI SDK clients work with any backend.
+
+type geminiRequest struct {
+	Contents          []geminiContent `json:"contents"`
+	SystemInstruction *geminiContent  `json:"systemInstruction"`
+	Tools             []struct {
+		FunctionDeclarations []providers.ToolFunction `json:"functionDeclarations"`
+	} `json:"tools"`
+	ToolConfig *struct {
+		FunctionCallingConfig struct {
+			Mode                 string   `json:"mode"`
+			AllowedFunctionNames []string `json:"allowedFunctionNames"`
+		} `json:"functionCallingConfig"`
+	} `json:"toolConfig"`
+	GenerationConfig struct {
+		Temperature      *float64 `json:"temperature"`
+		TopP             *float64 `json:"topP"`
+		MaxOutputTokens  *int     `json:"maxOutputTokens"`
+		StopSequences    []string `json:"stopSequences"`
+		CandidateCount   *int     `json:"candidateCount"`
+		Seed             *int64   `json:"seed"`
+		PresencePenalty  *float64 `json:"presencePenalty"`
+		FrequencyPenalty *float64 `json:"frequencyPenalty"`
+		ResponseMimeType string   `json:"responseMimeType"`
+		ResponseSchema   any      `json:"responseSchema"`
+		ResponseLogprobs bool     `json:"responseLogprobs"`
+		Logprobs         *int     `json:"logprobs"`
+		ThinkingConfig   *struct {
+			ThinkingBudget *int `json:"thinkingBudget"`
+		} `json:"thinkingConfig"`
+	} `json:"generationConfig"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text         string `json:"text"`
+	Thought      bool   `json:"thought"`
+	FunctionCall *struct {
+		ID   string          `json:"id"`
+		Name string          `json:"name"`
+		Args json.RawMessage `json:"args"`
+	} `json:"functionCall"`
+	FunctionResponse *struct {
+		ID       string          `json:"id"`
+		Name     string          `json:"name"`
+		Response json.RawMessage `json:"response"`
+	} `json:"functionResponse"`
+	InlineData *json.RawMessage `json:"inlineData"`
+	FileData   *json.RawMessage `json:"fileData"`
+}
+
+// geminiToChatRequest translates a GenerateContentRequest body. Function calls
+// get generated ids, and function responses are matched to the oldest
+// unanswered call of the same name unless they carry an id.
+func geminiToChatRequest(body []byte, model string, client *models.Client) (*providers.ChatRequest, error) {
+	var req geminiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	var messages []providers.ChatMessage
+	system := client.SystemPrompt
+	if req.SystemInstruction != nil {
+		text, _ := geminiPartsText(req.SystemInstruction.Parts)
+		if system != "" && text != "" {
+			system += "\n\n"
+		}
+		system += text
+	}
+	if system != "" {
+		messages = append(messages, providers.ChatMessage{Role: "system", Content: system})
+	}
+
+	var calls toolCallMatcher
+	for _, content := range req.Contents {
+		text, err := geminiPartsText(content.Parts)
+		if err != nil {
+			return nil, err
+		}
+
+		if content.Role == "model" {
+			msg := providers.ChatMessage{Role: "assistant", Content: text}
+			for _, part := range content.Parts {
+				if part.FunctionCall == nil {
+					continue
+				}
+				id := part.FunctionCall.ID
+				if id == "" {
+					id = "call_" + randomID(24)
+				}
+				args := "{}"
+				if len(part.FunctionCall.Args) > 0 && string(part.FunctionCall.Args) != "null" {
+					args = string(part.FunctionCall.Args)
+				}
+				tc := providers.ToolCall{ID: id, Name: part.FunctionCall.Name, Arguments: args}
+				msg.ToolCalls = append(msg.ToolCalls, tc)
+				calls.add(tc)
+			}
+			messages = append(messages, msg)
+			continue
+		}
+
+		for _, part := range content.Parts {
+			fr := part.FunctionResponse
+			if fr == nil {
+				continue
+			}
+			id := fr.ID
+			if id == "" {
+				var ok bool
+				if id, ok = calls.match(fr.Name); !ok {
+					return nil, fmt.Errorf("functionResponse for %q has no matching functionCall", fr.Name)
+				}
+			}
+			messages = append(messages, providers.ChatMessage{Role: "tool", ToolCallID: id, Content: string(fr.Response)})
+		}
+		if text != "" {
+			messages = append(messages, providers.ChatMessage{Role: "user", Content: text})
+		}
+	}
+
+	chatReq := &providers.ChatRequest{
+		Model:            model,
+		Messages:         messages,
+		MaxTokens:        req.GenerationConfig.MaxOutputTokens,
+		Temperature:      req.GenerationConfig.Temperature,
+		TopP:             req.GenerationConfig.TopP,
+		Stop:             req.GenerationConfig.StopSequences,
+		Seed:             req.GenerationConfig.Seed,
+		PresencePenalty:  req.GenerationConfig.PresencePenalty,
+		FrequencyPenalty: req.GenerationConfig.FrequencyPenalty,
+		N:                req.GenerationConfig.CandidateCount,
+		Logprobs:         req.GenerationConfig.ResponseLogprobs,
+		TopLogprobs:      req.GenerationConfig.Logprobs,
+	}
+	if tc := req.GenerationConfig.ThinkingConfig; tc != nil {
+		chatReq.ThinkingBudget = tc.ThinkingBudget
+	}
+
+	if req.GenerationConfig.ResponseMimeType == "application/json" {
+		if req.GenerationConfig.ResponseSchema != nil {
+			chatReq.ResponseFormat = map[string]interface{}{
+				"type":        "json_schema",
+				"json_schema": map[string]interface{}{"name": "response", "schema": lowerSchemaTypes(req.GenerationConfig.ResponseSchema)},
+			}
+		} else {
+			chatReq.ResponseFormat = map[string]interface{}{"type": "json_object"}
+		}
+	}
+
+	for _, t := range req.Tools {
+		for i := range t.FunctionDeclarations {
+			fn := &t.FunctionDeclarations[i]
+			fn.Parameters = lowerSchemaTypes(fn.Parameters)
+			chatReq.Tools = append(chatReq.Tools, providers.Tool{Type: "function", Function: fn})
+		}
+	}
+	if req.ToolConfig != nil {
+		chatReq.ToolChoice = geminiToolChoice(req.ToolConfig.FunctionCallingConfig.Mode, req.ToolConfig.FunctionCallingConfig.AllowedFunctionNames, chatReq)
+	}
+
+	return chatReq, nil
+}
+
+// lowerSchemaTypes rewrites Gemini's upper-case schema types (OBJECT, STRING)
+// to the JSON Schema spelling other backends validate against.
+func lowerSchemaTypes(schema any) any {
+	switch v := schema.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if t, ok := val.(string); ok && k == "type" {
+				v[k] = strings.ToLower(t)
+			} else {
+				v[k] = lowerSchemaTypes(val)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = lowerSchemaTypes(v[i])
+		}
+	}
+	return schema
+}
+
+// geminiPartsText concatenates the text parts of a turn, skipping thoughts.
+// Media parts can't be carried by ChatMessage and are rejected.
+func geminiPartsText(parts []geminiPart) (string, error) {
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.InlineData != nil || part.FileData != nil {
+			return "", fmt.Errorf("inlineData and fileData parts are only supported with a Gemini backend")
+		}
+		if !part.Thought {
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String(), nil
+}
+
+// geminiToolChoice maps a functionCallingConfig. ANY with several allowed
+// names narrows the declared tools, since OpenAI can only force one function.
+func geminiToolChoice(mode string, allowed []string, chatReq *providers.ChatRequest) *providers.ToolChoice {
+	switch strings.ToUpper(mode) {
+	case "NONE":
+		return &providers.ToolChoice{Mode: providers.ToolChoiceNone}
+	case "ANY":
+		if len(allowed) == 1 {
+			return &providers.ToolChoice{Mode: providers.ToolChoiceFunction, Function: allowed[0]}
+		}
+		if len(allowed) > 1 {
+			tools := chatReq.Tools[:0]
+			for _, t := range chatReq.Tools {
+				for _, name := range allowed {
+					if t.Function != nil && t.Function.Name == name {
+						tools = append(tools, t)
+						break
+					}
+				}
+			}
+			chatReq.Tools = tools
+		}
+		return &providers.ToolChoice{Mode: providers.ToolChoiceRequired}
+	case "AUTO":
+		return &providers.ToolChoice{Mode: providers.ToolChoiceAuto}
+	}
+	return nil
+}
+
+// geminiCandidateJSON renders a parsed choice as a Gemini candidate.
+func geminiCandidateJSON(c providers.Choice) map[string]interface{} {
+	parts := []map[string]interface{}{}
+	if c.Reasoning != "" {
+		parts = append(parts, map[string]interface{}{"text": c.Reasoning, "thought": true})
+	}
+	if c.Text != "" {
+		parts = append(parts, map[string]interface{}{"text": c.Text})
+	}
+	parts = append(parts, geminiFunctionCallParts(c.ToolCalls)...)
+	candidate := geminiCandidateChunk(c.Index, parts)
+	candidate["finishReason"] = geminiFinishReason(c.FinishReason)
+	return candidate
+}
+
+func geminiCandidateChunk(index int, parts []map[string]interface{}) map[string]interface{} {
+	if parts == nil {
+		parts = []map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"index":   index,
+		"content": map[string]interface{}{"role": "model", "parts": parts},
+	}
+}
+
+// geminiStreamChunk wraps a single part streamed for the candidate at index.
+func geminiStreamChunk(index int, part map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"candidates": []map[string]interface{}{geminiCandidateChunk(index, []map[string]interface{}{part})},
+	}
+}
+
+func geminiFunctionCallParts(calls []providers.ToolCall) []map[string]interface{} {
+	parts := make([]map[string]interface{}, 0, len(calls))
+	for _, tc := range calls {
+		args := providers.ToolArgumentsObject(tc.Arguments)
+		parts = append(parts, map[string]interface{}{
+			"functionCall": map[string]interface{}{"id": tc.ID, "name": tc.Name, "args": args},
+		})
+	}
+	return parts
+}
+
+// geminiFinishReason maps an OpenAI finish_reason to Gemini's enum. Gemini
+// reports STOP for turns that end in function calls.
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// geminiUsageJSON renders usageMetadata. Gemini counts thoughts separately
+// from candidate tokens, while providers report them inside output tokens.
+func geminiUsageJSON(it, ot, rt int) map[string]interface{} {
+	usage := map[string]interface{}{
+		"promptTokenCount":     it,
+		"candidatesTokenCount": ot - rt,
+		"totalTokenCount":      it + ot,
+	}
+	if rt > 0 {
+		usage["thoughtsTokenCount"] = rt
+	}
+	return usage
+}
+
+// geminiStatus maps an HTTP status to the google.rpc status name Gemini
+// clients expect in error bodies.
+func geminiStatus(statusCode int) string {
+	switch {
+	case statusCode == http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case statusCode == http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case statusCode == http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case statusCode == http.StatusNotFound:
+		return "NOT_FOUND"
+	case statusCode == http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case statusCode >= 500 && statusCode != http.StatusInternalServerError:
+		return "UNAVAILABLE"
+	default:
+		return "INTERNAL"
+	}
+}
+
+func writeGeminiError(w http.ResponseWriter, statusCode int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(APIError{Err: APIErrorBody{Message: message, Code: code, Status: geminiStatus(statusCode)}})
+}
+
+// geminiStreamWriter writes streamGenerateContent chunks either as SSE
+// (alt=sse, what the GenAI SDKs request) or as the default JSON array.
+type geminiStreamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+	started bool
+}
+
+func newGeminiStreamWriter(w http.ResponseWriter, sse bool) *geminiStreamWriter {
+	flusher, _ := w.(http.Flusher)
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	return &geminiStreamWriter{w: w, flusher: flusher, sse: sse}
+}
+
+// writeRaw sends one already-encoded GenerateContentResponse chunk.
+func (s *geminiStreamWriter) writeRaw(data []byte) {
+	switch {
+	case s.sse:
+		fmt.Fprintf(s.w, "data: %s\r\n\r\n", data)
+	case !s.started:
+		fmt.Fprintf(s.w, "[%s", data)
+	default:
+		fmt.Fprintf(s.w, ",\r\n%s", data)
+	}
+	s.started = true
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+func (s *geminiStreamWriter) write(chunk interface{}) {
+	data, _ := json.Marshal(chunk)
+	s.writeRaw(data)
+}
+
+// close terminates the JSON array; SSE streams need no trailer.
+func (s *geminiStreamWriter) close() {
+	if s.sse {
+		return
+	}
+	if !s.started {
+		s.w.Write([]byte("["))
+	}
+	s.w.Write([]byte("]"))
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
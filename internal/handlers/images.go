@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+const (
+	// maxImagesPerRequest is OpenAI's limit on n.
+	maxImagesPerRequest = 10
+	// maxImageUpload bounds an edit request's images and mask together.
+	maxImageUpload = 50 << 20
+)
+
+// ImageRequest is the body of /v1/images/generations, and the form fields of
+// /v1/images/edits.
+type ImageRequest struct {
+	Model          string `json:"model,omitempty"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	Style          string `json:"style,omitempty"`
+	Background     string `json:"background,omitempty"`
+	OutputFormat   string `json:"output_format,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+// checkImageQuota enforces the client's daily image quota for a request of n
+// images.
+func (h *OpenAIHandler) checkImageQuota(client *models.Client, n int) error {
+	if client.QuotaImagesDay <= 0 || h.statsService == nil {
+		return nil
+	}
+	if used := h.statsService.UsageToday(client.ID).TotalImages; used+n > client.QuotaImagesDay {
+		return fmt.Errorf("daily image quota of %d exceeded (%d used, %d requested)", client.QuotaImagesDay, used, n)
+	}
+	return nil
+}
+
+func (h *OpenAIHandler) ImageGenerations(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	var req ImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid JSON in request body", "invalid_request_error")
+		return
+	}
+	h.images(w, client, &req, nil, nil)
+}
+
+func (h *OpenAIHandler) ImageEdits(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	// This route is exempt from the global request size limit.
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Images exceed the limit of %d MB", maxImageUpload>>20), "invalid_request_error")
+			return
+		}
+		writeOpenAIError(w, http.StatusBadRequest, "Expected multipart/form-data: "+err.Error(), "invalid_request_error")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	req := ImageRequest{
+		Model:          r.FormValue("model"),
+		Prompt:         r.FormValue("prompt"),
+		Size:           r.FormValue("size"),
+		Quality:        r.FormValue("quality"),
+		Background:     r.FormValue("background"),
+		OutputFormat:   r.FormValue("output_format"),
+		ResponseFormat: r.FormValue("response_format"),
+	}
+	if n := r.FormValue("n"); n != "" {
+		var err error
+		if req.N, err = strconv.Atoi(n); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "n must be an integer", "invalid_request_error")
+			return
+		}
+	}
+
+	headers := slices.Concat(r.MultipartForm.File["image"], r.MultipartForm.File["image[]"])
+	if len(headers) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "Missing image", "invalid_request_error")
+		return
+	}
+	images := make([]providers.ImageFile, len(headers))
+	for i, fh := range headers {
+		img, err := readImageFile(fh)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "Failed to read image: "+err.Error(), "invalid_request_error")
+			return
+		}
+		images[i] = *img
+	}
+	var mask *providers.ImageFile
+	if fhs := r.MultipartForm.File["mask"]; len(fhs) > 0 {
+		var err error
+		if mask, err = readImageFile(fhs[0]); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "Failed to read mask: "+err.Error(), "invalid_request_error")
+			return
+		}
+	}
+	h.images(w, client, &req, images, mask)
+}
+
+func readImageFile(fh *multipart.FileHeader) (*providers.ImageFile, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return &providers.ImageFile{Filename: fh.Filename, Data: data}, nil
+}
+
+// images runs a generation, or an edit when images are given. Only the
+// request's options are logged, never the images.
+func (h *OpenAIHandler) images(w http.ResponseWriter, client *models.Client, req *ImageRequest, images []providers.ImageFile, mask *providers.ImageFile) {
+	if req.Prompt == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "prompt is required", "invalid_request_error")
+		return
+	}
+	if req.N == 0 {
+		req.N = 1
+	}
+	if req.N < 1 || req.N > maxImagesPerRequest {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("n must be between 1 and %d", maxImagesPerRequest), "invalid_request_error")
+		return
+	}
+
+	provider, err := h.resolveProvider(client)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Backend not configured: "+err.Error(), "invalid_request_error")
+		return
+	}
+	generator, ok := provider.(providers.ImageGenerator)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("The %s backend does not support image generation", provider.Name()), "invalid_request_error")
+		return
+	}
+	if err := h.checkImageQuota(client, req.N); err != nil {
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error(), "rate_limit_error")
+		return
+	}
+
+	if h.statsService != nil {
+		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
+	}
+
+	imageReq := &providers.ImageRequest{
+		Model: req.Model, Prompt: req.Prompt, N: req.N, Size: req.Size, Quality: req.Quality, Style: req.Style,
+		Background: req.Background, OutputFormat: req.OutputFormat, ResponseFormat: req.ResponseFormat,
+		Images: images, Mask: mask,
+	}
+	requestBody, _ := json.Marshal(req)
+
+	start := time.Now()
+	var resp *providers.ImageResponse
+	var statusCode int
+	if len(images) > 0 {
+		resp, statusCode, err = generator.EditImages(imageReq)
+	} else {
+		resp, statusCode, err = generator.GenerateImages(imageReq)
+	}
+	if err != nil {
+		if statusCode == 0 {
+			statusCode = http.StatusBadGateway
+		}
+		logRequest(h.geminiService, client, start, &models.RequestLog{
+			Model: req.Model, StatusCode: statusCode, ErrorMessage: err.Error(), RequestBody: string(requestBody),
+		})
+		writeOpenAIError(w, mapUpstreamStatusToHTTP(statusCode), err.Error(), "api_error")
+		return
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = req.Model
+	}
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: model, StatusCode: statusCode, InputTokens: resp.InputTokens, OutputTokens: resp.OutputTokens,
+		ImageCount: len(resp.Images), RequestBody: string(requestBody),
+	})
+
+	data := make([]map[string]string, len(resp.Images))
+	for i, img := range resp.Images {
+		item := map[string]string{}
+		if img.B64JSON != "" {
+			item["b64_json"] = img.B64JSON
+		}
+		if img.URL != "" {
+			item["url"] = img.URL
+		}
+		if img.RevisedPrompt != "" {
+			item["revised_prompt"] = img.RevisedPrompt
+		}
+		data[i] = item
+	}
+	out := map[string]interface{}{"created": resp.Created, "data": data}
+	if resp.InputTokens > 0 || resp.OutputTokens > 0 {
+		out["usage"] = map[string]int{
+			"input_tokens":  resp.InputTokens,
+			"output_tokens": resp.OutputTokens,
+			"total_tokens":  resp.InputTokens + resp.OutputTokens,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
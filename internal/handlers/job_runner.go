@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// jobPollInterval is how often an upstream job is checked.
+const jobPollInterval = 5 * time.Second
+
+// webhookAttempts bounds deliveries of a finished job to its webhook.
+const webhookAttempts = 3
+
+// newWebhookClient returns the client webhooks are delivered with. Unless
+// allowPrivate is set it refuses to connect to loopback, private and
+// link-local addresses, so API clients can't use webhooks to reach the
+// gateway's own network. The check runs on the dialed address, after DNS
+// resolution, so it also covers redirects and hostnames that resolve to
+// such addresses.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+// publicIP reports whether ip is a globally routable unicast address.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// jobOutcome is the upstream response to a job's request.
+type jobOutcome struct {
+	body       []byte
+	statusCode int
+	err        error
+}
+
+func (h *JobHandler) start(job *models.Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &runningJob{cancel: cancel, done: make(chan struct{})}
+	h.mu.Lock()
+	h.running[job.ID] = run
+	h.mu.Unlock()
+
+	go func() {
+		defer func() {
+			h.mu.Lock()
+			delete(h.running, job.ID)
+			h.mu.Unlock()
+			cancel()
+		}()
+		if h.acquire(ctx, job) {
+			h.run(ctx, job)
+			<-h.workers
+		}
+		close(run.done)
+		if job.WebhookURL != "" {
+			h.notify(job)
+		}
+	}()
+}
+
+// acquire waits for a free worker slot. A job cancelled while it waits is
+// finished as cancelled and never runs.
+func (h *JobHandler) acquire(ctx context.Context, job *models.Job) bool {
+	select {
+	case h.workers <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		h.finish(job, "cancelled", 0, "")
+		return false
+	}
+}
+
+func (h *JobHandler) save(job *models.Job) {
+	if err := h.jobService.SaveJob(job); err != nil {
+		log.Printf("[JOB] Failed to save job %s: %v", job.ID, err)
+	}
+}
+
+// finish stores a job's final state.
+func (h *JobHandler) finish(job *models.Job, status string, statusCode int, errMsg string) {
+	now := time.Now()
+	job.Status = status
+	job.StatusCode = statusCode
+	job.ErrorMessage = errMsg
+	if status == "cancelled" {
+		job.CancelledAt = &now
+	} else {
+		job.CompletedAt = &now
+	}
+	h.save(job)
+	log.Printf("[JOB] Job %s %s", job.ID, status)
+}
+
+func (h *JobHandler) run(ctx context.Context, job *models.Job) {
+	client, err := h.chat.clientService.GetClientByID(job.ClientID)
+	if err != nil || client == nil {
+		h.finish(job, "failed", 0, "The job's client no longer exists")
+		return
+	}
+	provider, err := h.chat.resolveProvider(client)
+	if err != nil {
+		h.finish(job, "failed", http.StatusServiceUnavailable, "Backend not configured: "+err.Error())
+		return
+	}
+
+	start := time.Now()
+	chatReq, err := h.chat.buildStoredRequest(client, provider, []byte(job.Request))
+	if err != nil {
+		h.finish(job, "failed", http.StatusBadRequest, err.Error())
+		return
+	}
+	if job.Status == "queued" {
+		job.Status = "in_progress"
+		job.StartedAt = &start
+		h.save(job)
+	}
+
+	outcomes := make(chan jobOutcome, 1)
+	if runner, ok := provider.(providers.AsyncRunner); ok && h.submitNative(job, runner, chatReq) {
+		go func() { outcomes <- h.pollNative(ctx, job, runner) }()
+	} else {
+		go func() {
+			body, statusCode, err := completeWithRetry(ctx, provider, chatReq)
+			outcomes <- jobOutcome{body, statusCode, err}
+		}()
+	}
+
+	entry := &models.RequestLog{Model: chatReq.Model, RequestBody: job.Request}
+	var outcome jobOutcome
+	select {
+	case outcome = <-outcomes:
+	case <-ctx.Done():
+		h.finish(job, "cancelled", 0, "")
+		if job.NativeID == "" {
+			// The backend call can't be interrupted; log its usage when it
+			// returns.
+			go func() {
+				if o := <-outcomes; o.err == nil {
+					h.logOutcome(entry, provider, chatReq, o)
+					logRequest(h.chat.geminiService, client, start, entry)
+				}
+			}()
+		}
+		return
+	}
+	if errors.Is(outcome.err, context.Canceled) {
+		h.finish(job, "cancelled", 0, "")
+		return
+	}
+
+	resp := h.logOutcome(entry, provider, chatReq, outcome)
+	logRequest(h.chat.geminiService, client, start, entry)
+	if entry.ErrorMessage != "" {
+		h.finish(job, "failed", entry.StatusCode, entry.ErrorMessage)
+		return
+	}
+	result, _ := json.Marshal(resp)
+	job.Result = string(result)
+	h.finish(job, "completed", outcome.statusCode, "")
+}
+
+// logOutcome fills in the request log entry for an upstream response and
+// returns the rendered chat completion, or nil if the request failed.
+func (h *JobHandler) logOutcome(entry *models.RequestLog, provider providers.Provider, chatReq *providers.ChatRequest, o jobOutcome) *OpenAIChatResponse {
+	entry.StatusCode = o.statusCode
+	switch {
+	case o.err != nil:
+		entry.StatusCode = http.StatusBadGateway
+		entry.ErrorMessage = o.err.Error()
+	case o.statusCode >= 400:
+		entry.ErrorMessage = extractErrorMessage(o.body)
+	default:
+		resp, err := completionResponse(provider, chatReq, o.body, entry)
+		if err != nil {
+			entry.StatusCode = http.StatusBadGateway
+			entry.ErrorMessage = "Failed to parse upstream response: " + err.Error()
+		}
+		return resp
+	}
+	return nil
+}
+
+// submitNative queues the request on the provider's job API, or picks up the
+// upstream job of a resumed run. It reports whether the job runs upstream.
+func (h *JobHandler) submitNative(job *models.Job, runner providers.AsyncRunner, chatReq *providers.ChatRequest) bool {
+	if job.NativeID != "" {
+		return true
+	}
+	id, err := runner.SubmitAsync(chatReq)
+	if err != nil {
+		if !errors.Is(err, providers.ErrAsyncUnsupported) {
+			log.Printf("[JOB] %s: upstream job submission failed, calling the backend directly: %v", job.ID, err)
+		}
+		return false
+	}
+	log.Printf("[JOB] %s: queued upstream as %s", job.ID, id)
+	job.NativeID = id
+	h.save(job)
+	return true
+}
+
+// pollNative waits for an upstream job to end, cancelling it upstream if ctx
+// is cancelled first.
+func (h *JobHandler) pollNative(ctx context.Context, job *models.Job, runner providers.AsyncRunner) jobOutcome {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		result, err := runner.PollAsync(job.NativeID)
+		if err != nil {
+			log.Printf("[JOB] %s: failed to poll upstream job %s: %v", job.ID, job.NativeID, err)
+		} else if result.Done {
+			if result.Error != "" {
+				return jobOutcome{err: errors.New(result.Error)}
+			}
+			return jobOutcome{body: result.Body, statusCode: result.StatusCode}
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := runner.CancelRequest(job.NativeID); err != nil {
+				log.Printf("[JOB] %s: failed to cancel upstream job %s: %v", job.ID, job.NativeID, err)
+			}
+			return jobOutcome{err: ctx.Err()}
+		case <-ticker.C:
+		}
+	}
+}
+
+// notify POSTs a finished job to its webhook, retrying failed deliveries.
+func (h *JobHandler) notify(job *models.Job) {
+	body, _ := json.Marshal(jobJSON(job))
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err := h.deliverWebhook(job.WebhookURL, body)
+		if err == nil {
+			return
+		}
+		log.Printf("[JOB] %s: webhook delivery attempt %d failed: %v", job.ID, attempt, err)
+		if attempt < webhookAttempts {
+			time.Sleep(time.Duration(attempt*attempt) * 5 * time.Second)
+		}
+	}
+}
+
+func (h *JobHandler) deliverWebhook(url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ai-gateway/webhook")
+
+	resp, err := h.webhook.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// JobHandler serves asynchronous chat completions, for requests that can wait
+// in a backend queue longer than a connection stays open: POST /v1/jobs
+// returns a job at once, the request runs in the background (on the
+// provider's job queue where it has one), and the client polls the job or
+// has it POSTed to a webhook when it ends.
+type JobHandler struct {
+	jobService *services.JobService
+	chat       *OpenAIHandler
+	// workers bounds the jobs run at once.
+	workers chan struct{}
+	webhook *http.Client
+	// allowPrivate lets webhooks reach non-public addresses.
+	allowPrivate bool
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+}
+
+// runningJob is a job's background run: cancel stops it, done is closed once
+// the run has stored the job's final state.
+type runningJob struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewJobHandler(jobService *services.JobService, chat *OpenAIHandler, workers int, allowPrivateWebhooks bool) *JobHandler {
+	return &JobHandler{
+		jobService:   jobService,
+		chat:         chat,
+		workers:      make(chan struct{}, max(workers, 1)),
+		webhook:      newWebhookClient(allowPrivateWebhooks),
+		allowPrivate: allowPrivateWebhooks,
+		running:      make(map[string]*runningJob),
+	}
+}
+
+func (h *JobHandler) RegisterRoutes(r chi.Router) {
+	r.Post("/v1/jobs", h.CreateJob)
+	r.Get("/v1/jobs", h.ListJobs)
+	r.Get("/v1/jobs/{id}", h.GetJob)
+	r.Post("/v1/jobs/{id}/cancel", h.CancelJob)
+}
+
+// Resume restarts jobs left unfinished by a previous run of the gateway.
+func (h *JobHandler) Resume() {
+	jobs, err := h.jobService.UnfinishedJobs()
+	if err != nil {
+		log.Printf("[JOB] Failed to load unfinished jobs: %v", err)
+		return
+	}
+	for i := range jobs {
+		log.Printf("[JOB] Resuming job %s (%s)", jobs[i].ID, jobs[i].Status)
+		h.start(&jobs[i])
+	}
+}
+
+func jobJSON(j *models.Job) map[string]interface{} {
+	var result interface{}
+	if j.Result != "" {
+		result = json.RawMessage(j.Result)
+	}
+	var jobErr interface{}
+	if j.ErrorMessage != "" {
+		jobErr = map[string]interface{}{"message": j.ErrorMessage, "status_code": j.StatusCode}
+	}
+	return map[string]interface{}{
+		"id":           j.ID,
+		"object":       "job",
+		"status":       j.Status,
+		"model":        j.Model,
+		"created_at":   j.CreatedAt.Unix(),
+		"started_at":   unixOrNil(j.StartedAt),
+		"completed_at": unixOrNil(j.CompletedAt),
+		"cancelled_at": unixOrNil(j.CancelledAt),
+		"webhook_url":  stringOrNil(j.WebhookURL),
+		"result":       result,
+		"error":        jobErr,
+	}
+}
+
+func (h *JobHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	var req struct {
+		OpenAIChatRequest
+		WebhookURL string `json:"webhook_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid JSON in request body", "invalid_request_error")
+		return
+	}
+	if req.Stream {
+		writeOpenAIError(w, http.StatusBadRequest, "stream is not supported for jobs", "invalid_request_error")
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "No content in messages", "invalid_request_error")
+		return
+	}
+	if req.WebhookURL != "" {
+		u, err := url.Parse(req.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeOpenAIError(w, http.StatusBadRequest, "webhook_url must be an http or https URL", "invalid_request_error")
+			return
+		}
+		if ip := net.ParseIP(u.Hostname()); ip != nil && !h.allowPrivate && !publicIP(ip) {
+			writeOpenAIError(w, http.StatusBadRequest, "webhook_url must not point to a private address", "invalid_request_error")
+			return
+		}
+	}
+
+	body, _ := json.Marshal(req.OpenAIChatRequest)
+	job := &models.Job{
+		ClientID:   client.ID,
+		Status:     "queued",
+		Model:      req.Model,
+		Request:    string(body),
+		WebhookURL: req.WebhookURL,
+	}
+	if err := h.jobService.CreateJob(job); err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+
+	response := jobJSON(job)
+	h.start(job)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	// One extra row tells whether there is another page.
+	jobs, err := h.jobService.ListJobs(client.ID, r.URL.Query().Get("after"), limit+1)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	hasMore := len(jobs) > limit
+	if hasMore {
+		jobs = jobs[:limit]
+	}
+
+	data := make([]map[string]interface{}, len(jobs))
+	for i := range jobs {
+		data[i] = jobJSON(&jobs[i])
+	}
+	response := map[string]interface{}{"object": "list", "data": data, "has_more": hasMore, "first_id": nil, "last_id": nil}
+	if len(jobs) > 0 {
+		response["first_id"] = jobs[0].ID
+		response["last_id"] = jobs[len(jobs)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// clientJob looks up the job named in the URL, writing a 404 if the client
+// has no such job.
+func (h *JobHandler) clientJob(w http.ResponseWriter, r *http.Request) *models.Job {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return nil
+	}
+	id := chi.URLParam(r, "id")
+	job, err := h.jobService.GetJob(client.ID, id)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return nil
+	}
+	if job == nil {
+		writeOpenAIError(w, http.StatusNotFound, "No such job: "+id, "invalid_request_error")
+		return nil
+	}
+	return job
+}
+
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	if job := h.clientJob(w, r); job != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobJSON(job))
+	}
+}
+
+// CancelJob stops a queued or running job, cancelling it upstream when it
+// runs on the provider's job queue.
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	job := h.clientJob(w, r)
+	if job == nil {
+		return
+	}
+	if job.Status != "queued" && job.Status != "in_progress" {
+		writeOpenAIError(w, http.StatusConflict, "Cannot cancel a job with status "+job.Status, "invalid_request_error")
+		return
+	}
+
+	h.mu.Lock()
+	run := h.running[job.ID]
+	h.mu.Unlock()
+
+	if run != nil {
+		// The run owns the stored job and records the cancellation itself.
+		run.cancel()
+		select {
+		case <-run.done:
+		case <-time.After(10 * time.Second):
+		}
+		if updated, err := h.jobService.GetJob(job.ClientID, job.ID); err == nil && updated != nil {
+			job = updated
+		}
+	} else {
+		now := time.Now()
+		job.Status = "cancelled"
+		job.CancelledAt = &now
+		if err := h.jobService.SaveJob(job); err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, err.Error(), "api_error")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobJSON(job))
+}
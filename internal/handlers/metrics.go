@@ -43,6 +43,54 @@ var (
 		[]string{"client_id", "model"},
 	)
 
+	cacheTokensTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ai_gateway_cache_tokens_total",
+			Help: "Total number of input tokens written to (creation) or read from (read) the upstream prompt cache",
+		},
+		[]string{"client_id", "model", "type"},
+	)
+
+	audioSecondsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ai_gateway_audio_seconds_total",
+			Help: "Total seconds of transcribed and synthesized audio",
+		},
+		[]string{"client_id", "model"},
+	)
+
+	imagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ai_gateway_images_total",
+			Help: "Total number of generated and edited images",
+		},
+		[]string{"client_id", "model"},
+	)
+
+	moderationFlaggedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ai_gateway_moderation_flagged_total",
+			Help: "Total number of moderation inputs flagged, by category",
+		},
+		[]string{"client_id", "category"},
+	)
+
+	safetyBlocksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ai_gateway_safety_blocks_total",
+			Help: "Total number of prompts and responses blocked by upstream safety filters, by block reason",
+		},
+		[]string{"client_id", "model", "reason"},
+	)
+
+	toolArgumentRepairsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ai_gateway_tool_argument_repairs_total",
+			Help: "Total number of repairs applied to malformed tool call arguments, by repair",
+		},
+		[]string{"client_id", "repair"},
+	)
+
 	requestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "ai_gateway_request_duration_seconds",
@@ -79,6 +127,24 @@ func init() {
 	if err := prometheus.Register(outputTokensTotal); err != nil {
 		log.Printf("[METRICS] Failed to register outputTokensTotal: %v", err)
 	}
+	if err := prometheus.Register(cacheTokensTotal); err != nil {
+		log.Printf("[METRICS] Failed to register cacheTokensTotal: %v", err)
+	}
+	if err := prometheus.Register(audioSecondsTotal); err != nil {
+		log.Printf("[METRICS] Failed to register audioSecondsTotal: %v", err)
+	}
+	if err := prometheus.Register(imagesTotal); err != nil {
+		log.Printf("[METRICS] Failed to register imagesTotal: %v", err)
+	}
+	if err := prometheus.Register(moderationFlaggedTotal); err != nil {
+		log.Printf("[METRICS] Failed to register moderationFlaggedTotal: %v", err)
+	}
+	if err := prometheus.Register(safetyBlocksTotal); err != nil {
+		log.Printf("[METRICS] Failed to register safetyBlocksTotal: %v", err)
+	}
+	if err := prometheus.Register(toolArgumentRepairsTotal); err != nil {
+		log.Printf("[METRICS] Failed to register toolArgumentRepairsTotal: %v", err)
+	}
 	if err := prometheus.Register(requestDuration); err != nil {
 		log.Printf("[METRICS] Failed to register requestDuration: %v", err)
 	}
@@ -141,6 +207,36 @@ func RecordRequest(clientID, model, status string, inputTokens, outputTokens int
 	requestDuration.WithLabelValues(clientID, model).Observe(float64(latencyMs) / 1000)
 }
 
+// RecordCacheTokens counts prompt cache usage reported by the upstream.
+func RecordCacheTokens(clientID, model string, creationTokens, readTokens int) {
+	if creationTokens > 0 {
+		cacheTokensTotal.WithLabelValues(clientID, model, "creation").Add(float64(creationTokens))
+	}
+	if readTokens > 0 {
+		cacheTokensTotal.WithLabelValues(clientID, model, "read").Add(float64(readTokens))
+	}
+}
+
+func RecordAudioSeconds(clientID, model string, seconds float64) {
+	audioSecondsTotal.WithLabelValues(clientID, model).Add(seconds)
+}
+
+func RecordImages(clientID, model string, count int) {
+	imagesTotal.WithLabelValues(clientID, model).Add(float64(count))
+}
+
+func RecordModerationFlagged(clientID, category string) {
+	moderationFlaggedTotal.WithLabelValues(clientID, category).Inc()
+}
+
+func RecordSafetyBlock(clientID, model, reason string) {
+	safetyBlocksTotal.WithLabelValues(clientID, model, reason).Inc()
+}
+
+func RecordToolArgumentRepair(clientID, repair string) {
+	toolArgumentRepairsTotal.WithLabelValues(clientID, repair).Inc()
+}
+
 func RecordUpstreamError(clientID, model, provider string) {
 	upstreamErrors.WithLabelValues(clientID, model, provider).Inc()
 }
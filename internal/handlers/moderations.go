@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+	"ai-gateway/internal/services"
+	"ai-gateway/internal/tokenizer"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// localModerationModel is the model name reported for results from the
+// gateway's own rules.
+const localModerationModel = "gateway-rules"
+
+// ModerationHandler serves /v1/moderations from the client's backend or the
+// configured local rules.
+type ModerationHandler struct {
+	moderation *services.ModerationService
+	chat       *OpenAIHandler
+}
+
+func NewModerationHandler(moderation *services.ModerationService, chat *OpenAIHandler) *ModerationHandler {
+	return &ModerationHandler{moderation: moderation, chat: chat}
+}
+
+func (h *ModerationHandler) RegisterRoutes(r chi.Router) {
+	r.Post("/v1/moderations", h.Moderations)
+}
+
+type ModerationRequest struct {
+	Model string          `json:"model,omitempty"`
+	Input json.RawMessage `json:"input"`
+}
+
+// moderationInputs reads the input as the texts classified separately: each
+// string of a string array, or the text parts of a content part array joined
+// as one input. Image parts are left to upstream models.
+func moderationInputs(raw json.RawMessage) ([]string, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return []string{s}, nil
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil && len(list) > 0 {
+		return list, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(raw, &parts) == nil && len(parts) > 0 {
+		var texts []string
+		for _, p := range parts {
+			if p.Type == "text" {
+				texts = append(texts, p.Text)
+			}
+		}
+		return []string{strings.Join(texts, "\n")}, nil
+	}
+	return nil, errors.New("input must be a string, an array of strings or an array of content parts")
+}
+
+func (h *ModerationHandler) Moderations(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	var req ModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid JSON in request body", "invalid_request_error")
+		return
+	}
+	if len(req.Input) == 0 || string(req.Input) == "null" {
+		writeOpenAIError(w, http.StatusBadRequest, "input is required", "invalid_request_error")
+		return
+	}
+	texts, err := moderationInputs(req.Input)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+	requestBody, _ := json.Marshal(req)
+
+	if h.chat.statsService != nil {
+		h.chat.statsService.IncrementRequestsInProgress()
+		defer h.chat.statsService.DecrementRequestsInProgress()
+	}
+
+	start := time.Now()
+	var resp *providers.ModerationResponse
+	statusCode := http.StatusOK
+	mode := h.moderation.Mode()
+	if mode != "local" {
+		provider, err := h.chat.resolveProvider(client)
+		if err != nil && mode == "upstream" {
+			writeOpenAIError(w, http.StatusBadRequest, "Backend not configured: "+err.Error(), "invalid_request_error")
+			return
+		}
+		moderator, ok := provider.(providers.Moderator)
+		if !ok && mode == "upstream" {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("The %s backend does not support moderation", provider.Name()), "invalid_request_error")
+			return
+		}
+		if ok {
+			resp, statusCode, err = moderator.Moderate(req.Model, req.Input)
+			switch {
+			case err == nil:
+			case mode == "auto" && (errors.Is(err, providers.ErrModerationUnsupported) || statusCode == http.StatusNotFound):
+				// The backend has no moderation endpoint; use the rules.
+				statusCode = http.StatusOK
+			case errors.Is(err, providers.ErrModerationUnsupported):
+				writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("The %s backend does not support moderation", provider.Name()), "invalid_request_error")
+				return
+			default:
+				if statusCode == 0 {
+					statusCode = http.StatusBadGateway
+				}
+				logRequest(h.chat.geminiService, client, start, &models.RequestLog{
+					Model: req.Model, StatusCode: statusCode, ErrorMessage: err.Error(), RequestBody: string(requestBody),
+				})
+				writeOpenAIError(w, mapUpstreamStatusToHTTP(statusCode), err.Error(), "api_error")
+				return
+			}
+		}
+	}
+
+	var matched []string
+	if resp == nil {
+		resp, matched = h.checkLocal(texts)
+	}
+	if resp.Model == "" {
+		resp.Model = req.Model
+	}
+
+	inputTokens := 0
+	for _, text := range texts {
+		n, _ := tokenizer.Count(resp.Model, text)
+		inputTokens += n
+	}
+	logRequest(h.chat.geminiService, client, start, &models.RequestLog{
+		Model: resp.Model, StatusCode: statusCode, InputTokens: inputTokens, TokensEstimated: true,
+		RequestBody: string(requestBody),
+	})
+
+	var flagged []string
+	for _, result := range resp.Results {
+		for category, hit := range result.Categories {
+			if hit {
+				RecordModerationFlagged(client.ID, category)
+				flagged = append(flagged, category)
+			}
+		}
+	}
+	if len(flagged) > 0 {
+		slices.Sort(flagged)
+		flagged = slices.Compact(flagged)
+		if len(matched) > 0 {
+			log.Printf("[MODERATION] Client %s flagged by %s for %s (rules: %s)", client.Name, resp.Model, strings.Join(flagged, ", "), strings.Join(matched, ", "))
+		} else {
+			log.Printf("[MODERATION] Client %s flagged by %s for %s", client.Name, resp.Model, strings.Join(flagged, ", "))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkLocal classifies each text with the local rules, returning the names
+// of the rules that matched.
+func (h *ModerationHandler) checkLocal(texts []string) (*providers.ModerationResponse, []string) {
+	resp := &providers.ModerationResponse{
+		ID:      "modr-" + randomID(24),
+		Model:   localModerationModel,
+		Results: make([]providers.ModerationResult, len(texts)),
+	}
+	var matched []string
+	for i, text := range texts {
+		check := h.moderation.Check(text)
+		applied := make(map[string][]string, len(check.Categories))
+		for category := range check.Categories {
+			applied[category] = []string{"text"}
+		}
+		resp.Results[i] = providers.ModerationResult{
+			Flagged:                   check.Flagged,
+			Categories:                check.Categories,
+			CategoryScores:            check.Scores,
+			CategoryAppliedInputTypes: applied,
+		}
+		for _, name := range check.Rules {
+			if !slices.Contains(matched, name) {
+				matched = append(matched, name)
+			}
+		}
+	}
+	return resp, matched
+}
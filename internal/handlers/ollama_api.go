@@ -0,0 +1,661 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+	"ai-gateway/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ollamaAPIVersion is reported by /api/version. Some clients gate features on
+// it, so it tracks an Ollama release whose API the facade covers.
+const ollamaAPIVersion = "0.9.0"
+
+// OllamaHandler serves the Ollama REST API on top of the client's configured
+// provider, so tools that only speak Ollama can use any backend.
+type OllamaHandler struct {
+	geminiService *services.GeminiService
+	statsService  *services.StatsService
+	registry      *providers.Registry
+}
+
+func NewOllamaHandler(geminiService *services.GeminiService, statsService *services.StatsService, registry *providers.Registry) *OllamaHandler {
+	return &OllamaHandler{geminiService: geminiService, statsService: statsService, registry: registry}
+}
+
+func (h *OllamaHandler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Recovery)
+
+		r.Post("/api/chat", h.Chat)
+		r.Post("/api/generate", h.Generate)
+		r.Get("/api/tags", h.Tags)
+		r.Post("/api/show", h.Show)
+		r.Post("/api/embed", h.Embed)
+		r.Get("/api/version", h.Version)
+	})
+}
+
+type ollamaOptions struct {
+	Temperature      *float64 `json:"temperature"`
+	TopP             *float64 `json:"top_p"`
+	NumPredict       *int     `json:"num_predict"`
+	Stop             []string `json:"stop"`
+	Seed             *int64   `json:"seed"`
+	PresencePenalty  *float64 `json:"presence_penalty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty"`
+}
+
+type ollamaMessage struct {
+	Role      string   `json:"role"`
+	Content   string   `json:"content"`
+	Images    []string `json:"images"`
+	ToolName  string   `json:"tool_name"`
+	ToolCalls []struct {
+		Function struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+type ollamaChatRequest struct {
+	Model    string                   `json:"model"`
+	Messages []ollamaMessage          `json:"messages"`
+	Tools    []map[string]interface{} `json:"tools"`
+	Format   json.RawMessage          `json:"format"`
+	Options  ollamaOptions            `json:"options"`
+	Stream   *bool                    `json:"stream"`
+	Think    json.RawMessage          `json:"think"`
+}
+
+type ollamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	Suffix  string          `json:"suffix"`
+	System  string          `json:"system"`
+	Images  []string        `json:"images"`
+	Format  json.RawMessage `json:"format"`
+	Options ollamaOptions   `json:"options"`
+	Stream  *bool           `json:"stream"`
+	Think   json.RawMessage `json:"think"`
+}
+
+func writeOllamaError(w http.ResponseWriter, statusCode int, errMsg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": errMsg})
+}
+
+// begin authenticates the request, resolves the client's provider and reads
+// the body. It writes the error response itself and returns ok=false on failure.
+func (h *OllamaHandler) begin(w http.ResponseWriter, r *http.Request) (client *models.Client, provider providers.Provider, body []byte, ok bool) {
+	client = middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOllamaError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, nil, nil, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "failed to read request body")
+		return nil, nil, nil, false
+	}
+
+	provider, err = resolveClientProvider(client, h.registry, h.geminiService.GetConfig())
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "backend not configured: "+err.Error())
+		return nil, nil, nil, false
+	}
+	return client, provider, body, true
+}
+
+func (h *OllamaHandler) Chat(w http.ResponseWriter, r *http.Request) {
+	client, provider, body, ok := h.begin(w, r)
+	if !ok {
+		return
+	}
+
+	var req ollamaChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid JSON in request body")
+		return
+	}
+
+	messages := make([]providers.ChatMessage, 0, len(req.Messages)+1)
+	if client.SystemPrompt != "" {
+		messages = append(messages, providers.ChatMessage{Role: "system", Content: client.SystemPrompt})
+	}
+	var calls toolCallMatcher
+	for _, msg := range req.Messages {
+		if len(msg.Images) > 0 {
+			writeOllamaError(w, http.StatusBadRequest, "images are not supported through the gateway")
+			return
+		}
+		switch msg.Role {
+		case "tool":
+			id, ok := calls.match(msg.ToolName)
+			if !ok {
+				writeOllamaError(w, http.StatusBadRequest, "tool message has no matching tool call")
+				return
+			}
+			messages = append(messages, providers.ChatMessage{Role: "tool", ToolCallID: id, Content: msg.Content})
+		case "assistant":
+			out := providers.ChatMessage{Role: "assistant", Content: msg.Content}
+			for _, tc := range msg.ToolCalls {
+				args := "{}"
+				if len(tc.Function.Arguments) > 0 && string(tc.Function.Arguments) != "null" {
+					args = string(tc.Function.Arguments)
+				}
+				call := providers.ToolCall{ID: "call_" + randomID(24), Name: tc.Function.Name, Arguments: args}
+				out.ToolCalls = append(out.ToolCalls, call)
+				calls.add(call)
+			}
+			messages = append(messages, out)
+		default:
+			messages = append(messages, providers.ChatMessage{Role: msg.Role, Content: msg.Content})
+		}
+	}
+
+	chatReq := ollamaChatRequestBase(client, provider, req.Model, req.Options, req.Format, req.Think)
+	chatReq.Messages = messages
+	chatReq.Tools = convertTools(req.Tools)
+
+	h.serve(w, client, provider, chatReq, body, req.Stream == nil || *req.Stream, false)
+}
+
+func (h *OllamaHandler) Generate(w http.ResponseWriter, r *http.Request) {
+	client, provider, body, ok := h.begin(w, r)
+	if !ok {
+		return
+	}
+
+	var req ollamaGenerateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid JSON in request body")
+		return
+	}
+	if len(req.Images) > 0 {
+		writeOllamaError(w, http.StatusBadRequest, "images are not supported through the gateway")
+		return
+	}
+	if req.Suffix != "" {
+		writeOllamaError(w, http.StatusBadRequest, "suffix is not supported through the gateway")
+		return
+	}
+
+	// An empty prompt is how Ollama clients preload a model; there is
+	// nothing to load here, so answer done straight away.
+	if req.Prompt == "" {
+		model := req.Model
+		if model == "" {
+			model = clientDefaultModel(client, provider)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": model, "created_at": time.Now().UTC().Format(time.RFC3339Nano),
+			"response": "", "done": true, "done_reason": "load",
+		})
+		return
+	}
+
+	var messages []providers.ChatMessage
+	system := client.SystemPrompt
+	if req.System != "" {
+		if system != "" {
+			system += "\n\n"
+		}
+		system += req.System
+	}
+	if system != "" {
+		messages = append(messages, providers.ChatMessage{Role: "system", Content: system})
+	}
+	messages = append(messages, providers.ChatMessage{Role: "user", Content: req.Prompt})
+
+	chatReq := ollamaChatRequestBase(client, provider, req.Model, req.Options, req.Format, req.Think)
+	chatReq.Messages = messages
+
+	h.serve(w, client, provider, chatReq, body, req.Stream == nil || *req.Stream, true)
+}
+
+// ollamaChatRequestBase maps the fields /api/chat and /api/generate share.
+func ollamaChatRequestBase(client *models.Client, provider providers.Provider, model string, opts ollamaOptions, format, think json.RawMessage) *providers.ChatRequest {
+	if model == "" {
+		model = clientDefaultModel(client, provider)
+	}
+	chatReq := &providers.ChatRequest{
+		Model:            model,
+		MaxTokens:        opts.NumPredict,
+		Temperature:      opts.Temperature,
+		TopP:             opts.TopP,
+		Stop:             opts.Stop,
+		Seed:             opts.Seed,
+		PresencePenalty:  opts.PresencePenalty,
+		FrequencyPenalty: opts.FrequencyPenalty,
+		SafetySettings:   clientSafetySettings(client),
+	}
+	if chatReq.MaxTokens != nil && *chatReq.MaxTokens < 0 {
+		// -1 means unlimited in Ollama.
+		chatReq.MaxTokens = nil
+	}
+
+	// format is "json" or a JSON schema object.
+	var formatName string
+	if json.Unmarshal(format, &formatName) == nil {
+		if formatName == "json" {
+			chatReq.ResponseFormat = map[string]interface{}{"type": "json_object"}
+		}
+	} else {
+		var schema map[string]interface{}
+		if json.Unmarshal(format, &schema) == nil {
+			chatReq.ResponseFormat = map[string]interface{}{
+				"type":        "json_schema",
+				"json_schema": map[string]interface{}{"name": "response", "schema": schema},
+			}
+		}
+	}
+
+	// think is a bool, or an effort level on newer Ollama versions.
+	var thinkOn bool
+	var thinkLevel string
+	if json.Unmarshal(think, &thinkOn) == nil && thinkOn {
+		chatReq.ReasoningEffort = "medium"
+	} else if json.Unmarshal(think, &thinkLevel) == nil {
+		chatReq.ReasoningEffort = thinkLevel
+	}
+	return chatReq
+}
+
+// serve runs a translated request and renders the result in the Ollama
+// format: a message for /api/chat, a response string for /api/generate.
+func (h *OllamaHandler) serve(w http.ResponseWriter, client *models.Client, provider providers.Provider, chatReq *providers.ChatRequest, body []byte, stream, generate bool) {
+	err := providers.ValidateRequest(provider, chatReq)
+	var contextAction string
+	if err == nil {
+		contextAction, err = fitContextWindow(h.geminiService, client, provider, chatReq)
+	}
+	if err == nil {
+		err = checkInputTokens(client, chatReq)
+	}
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if contextAction != "" {
+		w.Header().Set("X-Context-Action", contextAction)
+	}
+
+	if h.statsService != nil {
+		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
+	}
+
+	if stream {
+		h.serveStream(w, client, provider, chatReq, body, generate)
+		return
+	}
+
+	start := time.Now()
+	respBody, statusCode, err := provider.ChatCompletion(chatReq)
+	if err != nil {
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: string(body)})
+		writeOllamaError(w, http.StatusBadGateway, "upstream request failed: "+err.Error())
+		return
+	}
+	if statusCode >= 400 {
+		errMsg := extractErrorMessage(respBody)
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: statusCode, ErrorMessage: errMsg, RequestBody: string(body)})
+		writeOllamaError(w, mapUpstreamStatusToHTTP(statusCode), errMsg)
+		return
+	}
+
+	first := firstChoice(provider, respBody)
+	text, it, ot, _ := provider.ParseResponse(respBody)
+	details := providers.ParseUsageDetails(provider, respBody)
+	rt := details.ReasoningTokens
+	if first.Text != "" {
+		text = first.Text
+	}
+	ensureToolCallIDs(first.ToolCalls)
+	var toolNames []string
+	for _, tc := range first.ToolCalls {
+		toolNames = append(toolNames, tc.Name)
+	}
+	estimated := estimateUsage(chatReq, text, &it, &ot)
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: chatReq.Model, StatusCode: statusCode,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, TokensEstimated: estimated,
+		ErrorMessage: safetyBlockMessage(client, chatReq.Model, first.Safety), RequestBody: string(body), HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","),
+		CacheCreationTokens: details.CacheCreationTokens, CacheReadTokens: details.CacheReadTokens,
+	})
+
+	resp := ollamaChunk(chatReq.Model, generate, text, first.Reasoning, first.ToolCalls)
+	ollamaDone(resp, first.FinishReason, it, ot, start)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveStream streams NDJSON chunks. Tool calls are sent whole in the final
+// chunk, as Ollama never splits a call across chunks.
+func (h *OllamaHandler) serveStream(w http.ResponseWriter, client *models.Client, provider providers.Provider, chatReq *providers.ChatRequest, body []byte, generate bool) {
+	start := time.Now()
+	chatReq.Stream = true
+	chatReq.StreamOptions = &providers.StreamOptions{IncludeUsage: true}
+
+	resp, err := provider.ChatCompletionStream(chatReq)
+	if err != nil {
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: string(body), IsStreaming: true})
+		writeOllamaError(w, http.StatusBadGateway, "upstream request failed: "+err.Error())
+		return
+	}
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		errMsg := extractErrorMessage(respBody)
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: resp.StatusCode, ErrorMessage: errMsg, RequestBody: string(body), IsStreaming: true})
+		writeOllamaError(w, mapUpstreamStatusToHTTP(resp.StatusCode), errMsg)
+		return
+	}
+
+	stream := provider.StreamEvents(resp)
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	send := func(v interface{}) {
+		enc.Encode(v)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	calls := newToolCallAccumulator()
+	finishReason := "stop"
+	var safety *providers.SafetyFeedback
+	var it, ot, rt, cacheWrite, cacheRead int
+	var output strings.Builder
+	var streamErr string
+	for stream.Next() {
+		ev := stream.Event()
+		if ev.Choice != 0 {
+			continue
+		}
+		switch ev.Type {
+		case providers.StreamEventText:
+			if ev.Text != "" {
+				output.WriteString(ev.Text)
+				send(ollamaChunk(chatReq.Model, generate, ev.Text, "", nil))
+			}
+		case providers.StreamEventReasoning:
+			send(ollamaChunk(chatReq.Model, generate, "", ev.Text, nil))
+		case providers.StreamEventToolCall:
+			calls.add(ev.ToolCall)
+		case providers.StreamEventUsage:
+			if ev.InputTokens > 0 {
+				it = ev.InputTokens
+			}
+			if ev.OutputTokens > 0 {
+				ot = ev.OutputTokens
+			}
+			if ev.ReasoningTokens > 0 {
+				rt = ev.ReasoningTokens
+			}
+			if ev.CacheCreationTokens > 0 || ev.CacheReadTokens > 0 {
+				cacheWrite, cacheRead = ev.CacheCreationTokens, ev.CacheReadTokens
+			}
+		case providers.StreamEventFinish:
+			finishReason = ev.FinishReason
+			if ev.Safety != nil {
+				safety = ev.Safety
+			}
+		case providers.StreamEventError:
+			streamErr = ev.Err.Error()
+		}
+	}
+	if err := stream.Err(); err != nil && streamErr == "" {
+		streamErr = err.Error()
+	}
+
+	if streamErr != "" {
+		log.Printf("[OLLAMA] Stream from %s ended with error: %s", provider.Name(), streamErr)
+		send(map[string]string{"error": "upstream stream failed: " + streamErr})
+	}
+
+	estimated := estimateUsage(chatReq, output.String(), &it, &ot)
+	toolCalls := calls.list()
+	var toolNames []string
+	for _, tc := range toolCalls {
+		toolNames = append(toolNames, tc.Name)
+	}
+	final := ollamaChunk(chatReq.Model, generate, "", "", toolCalls)
+	ollamaDone(final, finishReason, it, ot, start)
+	send(final)
+
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: chatReq.Model, StatusCode: resp.StatusCode,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, TokensEstimated: estimated,
+		ErrorMessage: streamErr + safetyBlockMessage(client, chatReq.Model, safety), RequestBody: string(body), IsStreaming: true,
+		HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","),
+		CacheCreationTokens: cacheWrite, CacheReadTokens: cacheRead,
+	})
+}
+
+// ollamaChunk renders one response object. Tool calls only exist on chat.
+func ollamaChunk(model string, generate bool, text, thinking string, toolCalls []providers.ToolCall) map[string]interface{} {
+	chunk := map[string]interface{}{
+		"model":      model,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       false,
+	}
+	if generate {
+		chunk["response"] = text
+		if thinking != "" {
+			chunk["thinking"] = thinking
+		}
+		return chunk
+	}
+
+	message := map[string]interface{}{"role": "assistant", "content": text}
+	if thinking != "" {
+		message["thinking"] = thinking
+	}
+	if len(toolCalls) > 0 {
+		list := make([]map[string]interface{}, len(toolCalls))
+		for i, tc := range toolCalls {
+			args := providers.ToolArgumentsObject(tc.Arguments)
+			list[i] = map[string]interface{}{"function": map[string]interface{}{"name": tc.Name, "arguments": args}}
+		}
+		message["tool_calls"] = list
+	}
+	chunk["message"] = message
+	return chunk
+}
+
+// ollamaDone turns a chunk into the final one, carrying Ollama's timing and
+// token count fields. Durations are nanoseconds.
+func ollamaDone(chunk map[string]interface{}, finishReason string, it, ot int, start time.Time) {
+	doneReason := "stop"
+	if finishReason == "length" {
+		doneReason = "length"
+	}
+	elapsed := time.Since(start).Nanoseconds()
+	chunk["done"] = true
+	chunk["done_reason"] = doneReason
+	chunk["total_duration"] = elapsed
+	chunk["load_duration"] = 0
+	chunk["prompt_eval_count"] = it
+	chunk["eval_count"] = ot
+	chunk["eval_duration"] = elapsed
+}
+
+// ollamaModelNames lists the models a client can use: the backend's model
+// list fetched for the client, or else the provider's configured models.
+func ollamaModelNames(client *models.Client, provider providers.Provider) []string {
+	var names []string
+	if client.BackendModels != "" {
+		json.Unmarshal([]byte(client.BackendModels), &names)
+	}
+	if len(names) == 0 {
+		names = append(names, provider.Models()...)
+	}
+	if len(names) == 0 {
+		if m := clientDefaultModel(client, provider); m != "" {
+			names = append(names, m)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ollamaModelDetails(provider providers.Provider) map[string]interface{} {
+	return map[string]interface{}{
+		"format":             "gateway",
+		"family":             provider.Name(),
+		"families":           []string{provider.Name()},
+		"parameter_size":     "",
+		"quantization_level": "",
+	}
+}
+
+func (h *OllamaHandler) Tags(w http.ResponseWriter, r *http.Request) {
+	client, provider, _, ok := h.begin(w, r)
+	if !ok {
+		return
+	}
+
+	modified := time.Now().UTC().Format(time.RFC3339)
+	names := ollamaModelNames(client, provider)
+	list := make([]map[string]interface{}, len(names))
+	for i, name := range names {
+		list[i] = map[string]interface{}{
+			"name":        name,
+			"model":       name,
+			"modified_at": modified,
+			"size":        0,
+			"digest":      "",
+			"details":     ollamaModelDetails(provider),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"models": list})
+}
+
+func (h *OllamaHandler) Show(w http.ResponseWriter, r *http.Request) {
+	_, provider, body, ok := h.begin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	json.Unmarshal(body, &req)
+	if req.Model == "" {
+		req.Model = req.Name
+	}
+	if req.Model == "" {
+		writeOllamaError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	capabilities := []string{"completion", "tools"}
+	if _, ok := provider.(providers.Embedder); ok {
+		capabilities = append(capabilities, "embedding")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"modelfile":    "",
+		"parameters":   "",
+		"template":     "",
+		"details":      ollamaModelDetails(provider),
+		"model_info":   map[string]interface{}{"general.architecture": provider.Name()},
+		"capabilities": capabilities,
+		"modified_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (h *OllamaHandler) Embed(w http.ResponseWriter, r *http.Request) {
+	client, provider, body, ok := h.begin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Model      string          `json:"model"`
+		Input      json.RawMessage `json:"input"`
+		Dimensions *int            `json:"dimensions"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid JSON in request body")
+		return
+	}
+
+	var input []string
+	var single string
+	if json.Unmarshal(req.Input, &single) == nil {
+		input = []string{single}
+	} else if err := json.Unmarshal(req.Input, &input); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "input must be a string or an array of strings")
+		return
+	}
+	if len(input) == 0 {
+		writeOllamaError(w, http.StatusBadRequest, "input is required")
+		return
+	}
+
+	embedder, ok := provider.(providers.Embedder)
+	if !ok {
+		writeOllamaError(w, http.StatusBadRequest, fmt.Sprintf("the %s backend does not support embeddings", provider.Name()))
+		return
+	}
+	if req.Model == "" {
+		req.Model = clientDefaultModel(client, provider)
+	}
+
+	if h.statsService != nil {
+		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
+	}
+
+	start := time.Now()
+	resp, statusCode, err := embedder.Embed(&providers.EmbedRequest{Model: req.Model, Input: input, Dimensions: req.Dimensions})
+	if err != nil {
+		if statusCode == 0 {
+			statusCode = http.StatusBadGateway
+		}
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: statusCode, ErrorMessage: err.Error(), RequestBody: string(body)})
+		writeOllamaError(w, mapUpstreamStatusToHTTP(statusCode), err.Error())
+		return
+	}
+	logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: statusCode, InputTokens: resp.InputTokens, RequestBody: string(body)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":             req.Model,
+		"embeddings":        resp.Embeddings,
+		"total_duration":    time.Since(start).Nanoseconds(),
+		"load_duration":     0,
+		"prompt_eval_count": resp.InputTokens,
+	})
+}
+
+func (h *OllamaHandler) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"version": ollamaAPIVersion})
+}
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"bufio"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -9,7 +8,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"ai-gateway/internal/config"
@@ -17,6 +18,7 @@ import (
 	"ai-gateway/internal/models"
 	"ai-gateway/internal/providers"
 	"ai-gateway/internal/services"
+	"ai-gateway/internal/tokenizer"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -38,8 +40,16 @@ func (h *OpenAIHandler) RegisterRoutes(r chi.Router) {
 		r.Use(middleware.Recovery)
 
 		r.Post("/v1/chat/completions", h.ChatCompletions)
+		r.Post("/v1/completions", h.Completions)
 		r.Post("/v1/messages", h.ChatCompletions)
 		r.Post("/v1/messages/count_tokens", h.CountTokens)
+		r.Post("/v1/rerank", h.Rerank)
+		r.Post("/v2/rerank", h.Rerank)
+		r.Post("/v1/audio/transcriptions", h.Transcriptions)
+		r.Post("/v1/audio/translations", h.Translations)
+		r.Post("/v1/audio/speech", h.Speech)
+		r.Post("/v1/images/generations", h.ImageGenerations)
+		r.Post("/v1/images/edits", h.ImageEdits)
 		r.Post("/chat/completions", h.ChatCompletions)
 		r.Get("/v1/models", h.ListModels)
 		r.Get("/v1/models/{model}", h.GetModel)
@@ -49,12 +59,51 @@ func (h *OpenAIHandler) RegisterRoutes(r chi.Router) {
 type OpenAIChatRequest struct {
 	Model          string                   `json:"model"`
 	Messages       []map[string]interface{} `json:"messages"`
-	MaxTokens      int                      `json:"max_tokens,omitempty"`
-	Temperature    float64                  `json:"temperature,omitempty"`
 	Stream         bool                     `json:"stream,omitempty"`
 	Tools          []map[string]interface{} `json:"tools,omitempty"`
 	ResponseFormat any                      `json:"response_format,omitempty"`
 	StreamOptions  *StreamOptions           `json:"stream_options,omitempty"`
+	// ToolChoice is "auto", "none", "required" or {"type":"function","function":{"name":...}}.
+	ToolChoice        any   `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool `json:"parallel_tool_calls,omitempty"`
+
+	MaxTokens           *int               `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int               `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64           `json:"temperature,omitempty"`
+	TopP                *float64           `json:"top_p,omitempty"`
+	Stop                StopSequences      `json:"stop,omitempty"`
+	Seed                *int64             `json:"seed,omitempty"`
+	PresencePenalty     *float64           `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64           `json:"frequency_penalty,omitempty"`
+	N                   *int               `json:"n,omitempty"`
+	LogitBias           map[string]float64 `json:"logit_bias,omitempty"`
+	User                string             `json:"user,omitempty"`
+	ReasoningEffort     string             `json:"reasoning_effort,omitempty"`
+	// ThinkingBudget is a gateway extension: an explicit reasoning token budget.
+	ThinkingBudget *int `json:"thinking_budget,omitempty"`
+
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs *int `json:"top_logprobs,omitempty"`
+}
+
+// StopSequences accepts OpenAI's stop field, which is either a single string
+// or an array of strings.
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		if one != "" {
+			*s = StopSequences{one}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = many
+	return nil
 }
 
 type StreamOptions struct {
@@ -68,6 +117,8 @@ type OpenAIChatResponse struct {
 	Model   string                   `json:"model"`
 	Choices []map[string]interface{} `json:"choices"`
 	Usage   map[string]interface{}   `json:"usage"`
+	// SchemaValidation is set when the client has schema validation enabled.
+	SchemaValidation *SchemaValidation `json:"schema_validation,omitempty"`
 }
 
 type OpenAIModelsResponse struct {
@@ -112,6 +163,13 @@ func mapUpstreamStatusToHTTP(geminiStatus int) int {
 }
 
 func (h *OpenAIHandler) resolveProvider(client *models.Client) (providers.Provider, error) {
+	return resolveClientProvider(client, h.registry, h.geminiService.GetConfig())
+}
+
+// resolveClientProvider returns the provider serving a client: a dedicated
+// instance when the client has its own backend key or URL, otherwise the
+// shared one from the registry.
+func resolveClientProvider(client *models.Client, registry *providers.Registry, gatewayCfg *config.Config) (providers.Provider, error) {
 	backend := client.Backend
 	if backend == "" {
 		backend = "gemini"
@@ -126,19 +184,19 @@ func (h *OpenAIHandler) resolveProvider(client *models.Client) (providers.Provid
 			TimeoutSeconds: 120,
 		}
 		if cfg.APIKey == "" {
-			if globalP := h.geminiService.GetConfig().GetProvider(backend); globalP != nil {
+			if globalP := gatewayCfg.GetProvider(backend); globalP != nil {
 				cfg.APIKey = globalP.APIKey
 			}
 		}
 		if cfg.DefaultModel == "" {
-			if globalP := h.geminiService.GetConfig().GetProvider(backend); globalP != nil {
+			if globalP := gatewayCfg.GetProvider(backend); globalP != nil {
 				cfg.DefaultModel = globalP.DefaultModel
 			}
 		}
 		return providers.BuildSingleProvider(backend, cfg)
 	}
 
-	return h.registry.Get(backend)
+	return registry.Get(backend)
 }
 
 func (h *OpenAIHandler) updateClientModels(client *models.Client, provider providers.Provider) {
@@ -205,6 +263,33 @@ func (h *OpenAIHandler) ChatCompletions(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	chatReq.ToolChoice, err = providers.ParseToolChoice(req.ToolChoice)
+	if err == nil {
+		err = providers.ValidateRequest(provider, chatReq)
+	}
+	if err == nil {
+		err = validateChoiceCount(chatReq, client)
+	}
+	// The context policy runs only on otherwise valid requests, since
+	// summarizing costs an upstream call.
+	var contextAction string
+	if err == nil {
+		contextAction, err = fitContextWindow(h.geminiService, client, provider, chatReq)
+	}
+	if err == nil {
+		err = checkInputTokens(client, chatReq)
+	}
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		if h.statsService != nil {
+			h.statsService.DecrementRequestsInProgress()
+		}
+		return
+	}
+	if contextAction != "" {
+		w.Header().Set("X-Context-Action", contextAction)
+	}
+
 	fallbackModels := parseFallbackModels(client.FallbackModels)
 
 	if req.Stream {
@@ -237,14 +322,12 @@ func isRetryableError(statusCode int, errMsg string) bool {
 	return strings.Contains(lowerErr, "rate limit") || strings.Contains(lowerErr, "quota") || strings.Contains(lowerErr, "too many requests")
 }
 
+// buildChatRequest translates an OpenAI request into a ChatRequest, adding the
+// client's system prompt and server tools.
 func (h *OpenAIHandler) buildChatRequest(req OpenAIChatRequest, provider providers.Provider, client *models.Client) *providers.ChatRequest {
 	model := req.Model
 	if model == "" {
-		if client.BackendDefaultModel != "" {
-			model = client.BackendDefaultModel
-		} else {
-			model = provider.DefaultModel()
-		}
+		model = clientDefaultModel(client, provider)
 	}
 
 	messages := make([]providers.ChatMessage, 0, len(req.Messages)+1)
@@ -254,11 +337,12 @@ func (h *OpenAIHandler) buildChatRequest(req OpenAIChatRequest, provider provide
 
 	for _, msg := range req.Messages {
 		role, _ := msg["role"].(string)
-		content, _ := msg["content"].(string)
+		content := contentText(msg["content"])
 		toolCallID, _ := msg["tool_call_id"].(string)
+		cache := cacheControl(msg)
 
 		if role == "tool" {
-			messages = append(messages, providers.ChatMessage{Role: role, Content: content, ToolCallID: toolCallID})
+			messages = append(messages, providers.ChatMessage{Role: role, Content: content, ToolCallID: toolCallID, CacheControl: cache})
 			continue
 		}
 
@@ -275,23 +359,38 @@ func (h *OpenAIHandler) buildChatRequest(req OpenAIChatRequest, provider provide
 					}
 				}
 			}
-			messages = append(messages, providers.ChatMessage{Role: role, Content: content, ToolCalls: toolCalls})
+			messages = append(messages, providers.ChatMessage{Role: role, Content: content, ToolCalls: toolCalls, ThinkingBlocks: thinkingBlocks(msg), CacheControl: cache})
 			continue
 		}
 
 		if content != "" || role == "assistant" {
-			messages = append(messages, providers.ChatMessage{Role: role, Content: content})
+			messages = append(messages, providers.ChatMessage{Role: role, Content: content, ThinkingBlocks: thinkingBlocks(msg), CacheControl: cache})
 		}
 	}
 
-	return &providers.ChatRequest{
-		Model:          model,
-		Messages:       messages,
-		MaxTokens:      req.MaxTokens,
-		Temperature:    req.Temperature,
-		Stream:         req.Stream,
-		Tools:          h.mergeTools(req.Tools, client.ServerTools),
-		ResponseFormat: req.ResponseFormat,
+	chatReq := &providers.ChatRequest{
+		Model:               model,
+		Messages:            messages,
+		MaxTokens:           req.MaxTokens,
+		MaxCompletionTokens: req.MaxCompletionTokens,
+		Temperature:         req.Temperature,
+		TopP:                req.TopP,
+		Stop:                req.Stop,
+		Seed:                req.Seed,
+		PresencePenalty:     req.PresencePenalty,
+		FrequencyPenalty:    req.FrequencyPenalty,
+		N:                   req.N,
+		LogitBias:           req.LogitBias,
+		User:                req.User,
+		ReasoningEffort:     req.ReasoningEffort,
+		ThinkingBudget:      req.ThinkingBudget,
+		Logprobs:            req.Logprobs,
+		TopLogprobs:         req.TopLogprobs,
+		Stream:              req.Stream,
+		Tools:               h.mergeTools(req.Tools, client.ServerTools),
+		ResponseFormat:      req.ResponseFormat,
+		ParallelToolCalls:   req.ParallelToolCalls,
+		SafetySettings:      clientSafetySettings(client),
 		StreamOptions: func() *providers.StreamOptions {
 			if req.StreamOptions != nil {
 				return &providers.StreamOptions{IncludeUsage: req.StreamOptions.IncludeUsage}
@@ -299,6 +398,16 @@ func (h *OpenAIHandler) buildChatRequest(req OpenAIChatRequest, provider provide
 			return nil
 		}(),
 	}
+
+	return chatReq
+}
+
+// clientDefaultModel is the model used when a request doesn't name one.
+func clientDefaultModel(client *models.Client, provider providers.Provider) string {
+	if client.BackendDefaultModel != "" {
+		return client.BackendDefaultModel
+	}
+	return provider.DefaultModel()
 }
 
 func convertTools(tools []map[string]interface{}) []providers.Tool {
@@ -307,7 +416,7 @@ func convertTools(tools []map[string]interface{}) []providers.Tool {
 	}
 	result := make([]providers.Tool, len(tools))
 	for i, t := range tools {
-		result[i] = providers.Tool{Type: "function"}
+		result[i] = providers.Tool{Type: "function", CacheControl: cacheControl(t)}
 		if fn, ok := t["function"].(map[string]interface{}); ok {
 			result[i].Function = &providers.ToolFunction{
 				Name:        getString(fn, "name"),
@@ -340,6 +449,46 @@ func (h *OpenAIHandler) mergeTools(clientTools []map[string]interface{}, serverT
 	return tools
 }
 
+// cacheControl returns the prompt caching breakpoint a client set on a
+// message or tool, either on it directly or, as Anthropic clients do, on one
+// of its content parts; nil if there is none.
+func cacheControl(m map[string]interface{}) *providers.CacheControl {
+	if cc, ok := m["cache_control"].(map[string]interface{}); ok {
+		control := &providers.CacheControl{Type: getString(cc, "type"), TTL: getString(cc, "ttl")}
+		if control.Type == "" {
+			control.Type = "ephemeral"
+		}
+		return control
+	}
+	parts, _ := m["content"].([]interface{})
+	for i := len(parts) - 1; i >= 0; i-- {
+		if part, ok := parts[i].(map[string]interface{}); ok {
+			if control := cacheControl(part); control != nil {
+				return control
+			}
+		}
+	}
+	return nil
+}
+
+// thinkingBlocks reads the signed thinking blocks of an assistant message, as
+// the gateway returns them in thinking_blocks, so clients can send them back.
+func thinkingBlocks(m map[string]interface{}) []providers.ThinkingBlock {
+	raw, _ := m["thinking_blocks"].([]interface{})
+	var blocks []providers.ThinkingBlock
+	for _, r := range raw {
+		if b, ok := r.(map[string]interface{}); ok {
+			blocks = append(blocks, providers.ThinkingBlock{
+				Type:      getString(b, "type"),
+				Thinking:  getString(b, "thinking"),
+				Signature: getString(b, "signature"),
+				Data:      getString(b, "data"),
+			})
+		}
+	}
+	return blocks
+}
+
 func getString(m map[string]interface{}, key string) string {
 	if v, ok := m[key].(string); ok {
 		return v
@@ -364,6 +513,10 @@ func (h *OpenAIHandler) handleNonStreamingRequestWithFallback(w http.ResponseWri
 }
 
 func (h *OpenAIHandler) tryNonStreamingRequest(w http.ResponseWriter, client *models.Client, req OpenAIChatRequest, provider providers.Provider, chatReq *providers.ChatRequest, requestBody string) error {
+	if choiceCount(chatReq) > 1 {
+		return h.tryMultiChoiceRequest(w, client, req, provider, chatReq, requestBody)
+	}
+
 	start := time.Now()
 	maxToolIterations := 5
 	var toolNames []string
@@ -394,45 +547,79 @@ func (h *OpenAIHandler) tryNonStreamingRequest(w http.ResponseWriter, client *mo
 		return nil
 	}
 
+	// Gateway-mode iterations drop the tools, but their schemas are still
+	// needed to check later calls' arguments.
+	tools := chatReq.Tools
 	for iteration := 0; iteration < maxToolIterations; iteration++ {
 		toolCalls, err := provider.ParseToolCalls(respBody)
 		if err != nil || len(toolCalls) == 0 {
 			break
 		}
+		ensureToolCallIDs(toolCalls)
+		problems := fixToolArguments(client, tools, toolCalls)
+		for _, tc := range toolCalls {
+			toolNames = append(toolNames, tc.Name)
+		}
 
 		if client.ToolMode == "pass-through" {
-			toolCallsResp := make([]map[string]interface{}, len(toolCalls))
-			for i, tc := range toolCalls {
-				toolCallsResp[i] = map[string]interface{}{
-					"id":   tc.ID,
-					"type": "function",
-					"function": map[string]interface{}{
-						"name":      tc.Name,
-						"arguments": tc.Arguments,
-					},
-				}
+			text, it, ot, _ := provider.ParseResponse(respBody)
+			details := providers.ParseUsageDetails(provider, respBody)
+			rt := details.ReasoningTokens
+			output := messageTexts([]providers.ChatMessage{{Content: text, ToolCalls: toolCalls}})[0]
+			estimated := estimateUsage(chatReq, output, &it, &ot)
+			rejected := rejectedToolCalls(toolCalls, problems)
+			if rejected != "" {
+				statusCode = http.StatusBadGateway
+			}
+			h.geminiService.LogRequestEntry(&models.RequestLog{
+				ClientID: client.ID, Model: chatReq.Model, StatusCode: statusCode,
+				InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, LatencyMs: latencyMs,
+				ErrorMessage: rejected, RequestBody: requestBody, HasTools: true, ToolNames: strings.Join(toolNames, ","),
+				TokensEstimated: estimated, CacheCreationTokens: details.CacheCreationTokens, CacheReadTokens: details.CacheReadTokens,
+			})
+			RecordRequest(client.ID, chatReq.Model, fmt.Sprintf("%d", statusCode), it, ot, latencyMs)
+			RecordCacheTokens(client.ID, chatReq.Model, details.CacheCreationTokens, details.CacheReadTokens)
+			if h.statsService != nil {
+				h.statsService.DecrementRequestsInProgress()
+			}
+			if rejected != "" {
+				writeOpenAIError(w, http.StatusBadGateway, rejected, "api_error")
+				return nil
+			}
+
+			message := map[string]interface{}{"role": "assistant", "tool_calls": toolCallsJSON(toolCalls)}
+			if text != "" {
+				message["content"] = text
+			} else {
+				message["content"] = nil
+			}
+			first := firstChoice(provider, respBody)
+			if first.Reasoning != "" {
+				message["reasoning_content"] = first.Reasoning
+			}
+			if len(first.ThinkingBlocks) > 0 {
+				message["thinking_blocks"] = first.ThinkingBlocks
 			}
+			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(OpenAIChatResponse{
 				ID:      "chatcmpl-" + randomID(12),
 				Object:  "chat.completion",
 				Created: time.Now().Unix(),
 				Model:   req.Model,
-				Choices: []map[string]interface{}{{"index": 0, "message": map[string]interface{}{"role": "assistant", "tool_calls": toolCallsResp}, "finish_reason": "tool_calls"}},
-				Usage:   map[string]interface{}{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0},
+				Choices: []map[string]interface{}{choiceJSON(0, message, "tool_calls", first.Logprobs)},
+				Usage:   usageJSON(it, ot, rt),
 			})
 			return nil
 		}
 
-		for _, tc := range toolCalls {
-			toolNames = append(toolNames, tc.Name)
-			chatReq.Messages = append(chatReq.Messages, providers.ChatMessage{Role: "assistant", ToolCalls: []providers.ToolCall{tc}})
-			var args map[string]interface{}
-			json.Unmarshal([]byte(tc.Arguments), &args)
-			result, _ := h.toolService.Execute(tc.Name, args)
-			chatReq.Messages = append(chatReq.Messages, providers.ChatMessage{Role: "tool", ToolCallID: tc.ID, Content: result})
-		}
+		// Backends with extended thinking need the turn's signed thinking back.
+		thinking := firstChoice(provider, respBody).ThinkingBlocks
+		chatReq.Messages = append(chatReq.Messages, providers.ChatMessage{Role: "assistant", ToolCalls: toolCalls, ThinkingBlocks: thinking})
+		chatReq.Messages = append(chatReq.Messages, h.executeToolCalls(toolCalls, problems)...)
 
 		chatReq.Tools = nil
+		chatReq.ToolChoice = nil
+		chatReq.ParallelToolCalls = nil
 		respBody, statusCode, _ = provider.ChatCompletion(chatReq)
 		if statusCode >= 400 {
 			break
@@ -440,20 +627,53 @@ func (h *OpenAIHandler) tryNonStreamingRequest(w http.ResponseWriter, client *mo
 	}
 
 	text, it, ot, _ := provider.ParseResponse(respBody)
-	h.geminiService.LogRequest(client.ID, chatReq.Model, statusCode, it, ot, latencyMs, "", requestBody, false, len(toolNames) > 0, strings.Join(toolNames, ","))
+	details := providers.ParseUsageDetails(provider, respBody)
+	rt := details.ReasoningTokens
+	estimated := estimateUsage(chatReq, text, &it, &ot)
+	first := firstChoice(provider, respBody)
+	var validation *SchemaValidation
+	// A filtered response has nothing to validate; retrying would only be
+	// filtered again.
+	if client.ValidateSchema && statusCode < 400 && first.FinishReason != "content_filter" {
+		respBody, text, validation = h.enforceSchema(client, provider, chatReq, respBody, text, &it, &ot, &details)
+		rt = details.ReasoningTokens
+		first = firstChoice(provider, respBody)
+		latencyMs = int(time.Since(start).Milliseconds())
+	}
+	h.geminiService.LogRequestEntry(&models.RequestLog{
+		ClientID: client.ID, Model: chatReq.Model, StatusCode: statusCode,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, LatencyMs: latencyMs,
+		ErrorMessage: safetyBlockMessage(client, chatReq.Model, first.Safety) + schemaErrorMessage(validation), RequestBody: requestBody,
+		HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","), TokensEstimated: estimated,
+		CacheCreationTokens: details.CacheCreationTokens, CacheReadTokens: details.CacheReadTokens,
+	})
 	RecordRequest(client.ID, chatReq.Model, fmt.Sprintf("%d", statusCode), it, ot, latencyMs)
+	RecordCacheTokens(client.ID, chatReq.Model, details.CacheCreationTokens, details.CacheReadTokens)
 	if h.statsService != nil {
 		h.statsService.DecrementRequestsInProgress()
 	}
 
+	message := map[string]interface{}{"role": "assistant", "content": text}
+	if first.Reasoning != "" {
+		message["reasoning_content"] = first.Reasoning
+	}
+	if len(first.ThinkingBlocks) > 0 {
+		message["thinking_blocks"] = first.ThinkingBlocks
+	}
+	finishReason := first.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(OpenAIChatResponse{
 		ID:      "chatcmpl-" + randomID(12),
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
 		Model:   req.Model,
-		Choices: []map[string]interface{}{{"index": 0, "message": map[string]interface{}{"role": "assistant", "content": text}, "finish_reason": "stop"}},
-		Usage:   map[string]interface{}{"prompt_tokens": it, "completion_tokens": ot, "total_tokens": it + ot},
+		Choices: []map[string]interface{}{withSafety(choiceJSON(0, message, finishReason, first.Logprobs), first.Safety)},
+		Usage:   usageJSON(it, ot, rt),
+
+		SchemaValidation: validation,
 	})
 
 	if client.BackendModels == "" {
@@ -483,6 +703,10 @@ func (h *OpenAIHandler) handleStreamingRequestWithFallback(w http.ResponseWriter
 }
 
 func (h *OpenAIHandler) tryStreamingRequest(w http.ResponseWriter, r *http.Request, client *models.Client, req OpenAIChatRequest, provider providers.Provider, chatReq *providers.ChatRequest, requestBody string) error {
+	if choiceCount(chatReq) > 1 {
+		return h.tryMultiChoiceStream(w, client, req, provider, chatReq, requestBody)
+	}
+
 	start := time.Now()
 	var toolNames []string
 
@@ -497,10 +721,10 @@ func (h *OpenAIHandler) tryStreamingRequest(w http.ResponseWriter, r *http.Reque
 		}
 		return nil
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		errMsg := extractErrorMessage(body)
 		if isRetryableError(resp.StatusCode, errMsg) {
 			return fmt.Errorf("status %d: %s", resp.StatusCode, errMsg)
@@ -512,6 +736,9 @@ func (h *OpenAIHandler) tryStreamingRequest(w http.ResponseWriter, r *http.Reque
 		return nil
 	}
 
+	stream := provider.StreamEvents(resp)
+	defer func() { stream.Close() }()
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -522,116 +749,141 @@ func (h *OpenAIHandler) tryStreamingRequest(w http.ResponseWriter, r *http.Reque
 	flusher := w.(http.Flusher)
 	sendSSEChunk(w, flusher, responseID, req.Model, created, map[string]interface{}{"role": "assistant", "content": ""}, nil)
 
-	scanner := bufio.NewScanner(resp.Body)
-	prefix := provider.StreamDataPrefix()
-	var it, ot int
+	statusCode := resp.StatusCode
+	finishReason := "stop"
+	var safety *providers.SafetyFeedback
+	var it, ot, rt, cacheWrite, cacheRead int
 	var totalText strings.Builder
-
+	var streamErr string
+
+	passThrough := client.ToolMode == "pass-through"
+	// Arguments can only be checked once complete, so pass-through calls are
+	// buffered and sent whole when the client asked for validation. Otherwise
+	// they stream as they arrive, unrepaired.
+	bufferCalls := passThrough && (client.ToolArgsPolicy == "validate" || client.ToolArgsPolicy == "strict")
+	tools := chatReq.Tools
 	maxToolIterations := 5
-toolLoop:
 	for iteration := 0; iteration < maxToolIterations; iteration++ {
-		var toolCallID, toolCallName, toolCallArgs string
-		var hasToolCall bool
-
-		for scanner.Scan() {
-			line := scanner.Text()
-			if !strings.HasPrefix(line, prefix) {
-				continue
-			}
-			jsonData := strings.TrimPrefix(line, prefix)
-			if jsonData == "" || jsonData == "[DONE]" {
-				continue
-			}
-
-			var chunk map[string]interface{}
-			json.Unmarshal([]byte(jsonData), &chunk)
-
-			finishReason := ""
-			if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
-				if choice, ok := choices[0].(map[string]interface{}); ok {
-					finishReason, _ = choice["finish_reason"].(string)
+		calls := newToolCallAccumulator()
+		var thinking []providers.ThinkingBlock
+
+		for stream.Next() {
+			ev := stream.Event()
+			switch ev.Type {
+			case providers.StreamEventText:
+				totalText.WriteString(ev.Text)
+				sendSSEContentChunk(w, flusher, responseID, req.Model, created, 0, ev.Text, ev.Logprobs)
+			case providers.StreamEventReasoning:
+				sendSSEChunk(w, flusher, responseID, req.Model, created, map[string]interface{}{"reasoning_content": ev.Text}, nil)
+			case providers.StreamEventThinkingBlock:
+				thinking = append(thinking, *ev.ThinkingBlock)
+				sendSSEChunk(w, flusher, responseID, req.Model, created, map[string]interface{}{"thinking_blocks": []providers.ThinkingBlock{*ev.ThinkingBlock}}, nil)
+			case providers.StreamEventToolCall:
+				delta := calls.add(ev.ToolCall)
+				if passThrough && !bufferCalls {
+					sendSSEChunk(w, flusher, responseID, req.Model, created, map[string]interface{}{"tool_calls": []map[string]interface{}{delta}}, nil)
 				}
-			} else if doneReason, ok := chunk["done_reason"].(string); ok {
-				finishReason = doneReason
-			} else if done, ok := chunk["done"].(bool); ok && done {
-				finishReason = "stop"
-			}
-
-			tcInterface, fr := provider.ParseStreamToolCall([]byte(jsonData))
-			if fr != "" && finishReason == "" {
-				finishReason = fr
-			}
-			if tcInterface != nil {
-				hasToolCall = true
-				if tc, ok := tcInterface.(*providers.StreamToolCall); ok {
-					if tc.ID != "" { toolCallID = tc.ID }
-					if tc.Name != "" { toolCallName = tc.Name }
-					toolCallArgs += tc.Arguments
+			case providers.StreamEventUsage:
+				if ev.InputTokens > 0 {
+					it = ev.InputTokens
 				}
+				if ev.OutputTokens > 0 {
+					ot = ev.OutputTokens
+				}
+				if ev.ReasoningTokens > 0 {
+					rt = ev.ReasoningTokens
+				}
+				if ev.CacheCreationTokens > 0 || ev.CacheReadTokens > 0 {
+					cacheWrite, cacheRead = ev.CacheCreationTokens, ev.CacheReadTokens
+				}
+			case providers.StreamEventFinish:
+				finishReason = ev.FinishReason
+				if ev.Safety != nil {
+					safety = ev.Safety
+				}
+			case providers.StreamEventError:
+				streamErr = ev.Err.Error()
 			}
+		}
+		if err := stream.Err(); err != nil && streamErr == "" {
+			streamErr = err.Error()
+		}
 
-			if finishReason == "tool_calls" || (finishReason == "stop" && hasToolCall) {
-				toolNames = append(toolNames, toolCallName)
-				break
-			}
+		toolCalls := calls.list()
+		if len(toolCalls) == 0 || streamErr != "" {
+			break
+		}
+		problems := fixToolArguments(client, tools, toolCalls)
+		for _, tc := range toolCalls {
+			toolNames = append(toolNames, tc.Name)
+		}
 
-			text, cit, cot := provider.ParseStreamChunk([]byte(jsonData))
-			if cit > 0 { it = cit }
-			if cot > 0 { ot = cot }
-			if text != "" {
-				totalText.WriteString(text)
-				sendSSEChunk(w, flusher, responseID, req.Model, created, map[string]interface{}{"content": text}, nil)
-			}
-			if finishReason == "stop" || finishReason == "length" {
-				break
+		if passThrough {
+			finishReason = "tool_calls"
+			if rejected := rejectedToolCalls(toolCalls, problems); rejected != "" {
+				streamErr = rejected
+			} else if bufferCalls {
+				for i, tc := range toolCalls {
+					sendSSEChunk(w, flusher, responseID, req.Model, created, map[string]interface{}{"tool_calls": []map[string]interface{}{{
+						"index": i, "id": tc.ID, "type": "function",
+						"function": map[string]interface{}{"name": tc.Name, "arguments": tc.Arguments},
+					}}}, nil)
+				}
 			}
+			break
 		}
 
-		if !hasToolCall {
-			break toolLoop
+		chatReq.Messages = append(chatReq.Messages, providers.ChatMessage{Role: "assistant", ToolCalls: toolCalls, ThinkingBlocks: thinking})
+		chatReq.Messages = append(chatReq.Messages, h.executeToolCalls(toolCalls, problems)...)
+		chatReq.Tools = nil
+		chatReq.ToolChoice = nil
+		chatReq.ParallelToolCalls = nil
+		finishReason = "stop"
+
+		stream.Close()
+		resp, err = provider.ChatCompletionStream(chatReq)
+		if err != nil {
+			streamErr = err.Error()
+			break
 		}
-
-		if client.ToolMode == "pass-through" {
-			toolCallsChunk := map[string]interface{}{"tool_calls": []map[string]interface{}{{"id": toolCallID, "index": 0, "type": "function", "function": map[string]interface{}{"name": toolCallName, "arguments": toolCallArgs}}}}
-			sendSSEChunk(w, flusher, responseID, req.Model, created, toolCallsChunk, "tool_calls")
-			fmt.Fprintf(w, "data: [DONE]\n\n")
-			flusher.Flush()
-			return nil
+		statusCode = resp.StatusCode
+		stream = provider.StreamEvents(resp)
+		if resp.StatusCode >= 400 {
+			body, _ := io.ReadAll(resp.Body)
+			streamErr = extractErrorMessage(body)
+			break
 		}
+	}
 
-		chatReq.Messages = append(chatReq.Messages, providers.ChatMessage{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: toolCallID, Name: toolCallName, Arguments: toolCallArgs}}})
-		var args map[string]interface{}
-		json.Unmarshal([]byte(toolCallArgs), &args)
-		result, _ := h.toolService.Execute(toolCallName, args)
-		chatReq.Messages = append(chatReq.Messages, providers.ChatMessage{Role: "tool", ToolCallID: toolCallID, Content: result})
-		chatReq.Tools = nil
-		resp.Body.Close()
-		resp, _ = provider.ChatCompletionStream(chatReq)
-		scanner = bufio.NewScanner(resp.Body)
+	if streamErr != "" {
+		log.Printf("[CHAT] Stream from %s ended with error: %s", provider.Name(), streamErr)
+		sendSSEError(w, flusher, "Upstream stream failed: "+streamErr, "api_error")
 	}
 
-	if ot == 0 && totalText.Len() > 0 { ot = totalText.Len() / 4 }
-	sendSSEChunk(w, flusher, responseID, req.Model, created, map[string]interface{}{}, "stop")
-	// Send usage info in a separate chunk for OpenAI compatibility
-	usageChunk := map[string]interface{}{
-		"id":      responseID,
-		"object":  "chat.completion.chunk",
-		"created": created,
-		"model":   req.Model,
-		"choices": []interface{}{},
-		"usage": map[string]interface{}{
-			"prompt_tokens":     it,
-			"completion_tokens": ot,
-			"total_tokens":      it + ot,
-		},
+	var validation *SchemaValidation
+	if schema, wantsJSON := providers.ResponseSchema(chatReq.ResponseFormat); client.ValidateSchema && wantsJSON && streamErr == "" && safety == nil && finishReason != "tool_calls" {
+		_, errs := validateStructured(schema, totalText.String())
+		validation = &SchemaValidation{Validated: len(errs) == 0, Attempts: 1, Errors: errs}
+	}
+
+	estimated := estimateUsage(chatReq, totalText.String(), &it, &ot)
+	sendSSEChoice(w, flusher, responseID, req.Model, created, withSafety(map[string]interface{}{"index": 0, "delta": map[string]interface{}{}, "finish_reason": finishReason}, safety))
+	if validation != nil {
+		sendSSESchemaValidation(w, flusher, responseID, req.Model, created, validation)
 	}
-	usageData, _ := json.Marshal(usageChunk)
-	fmt.Fprintf(w, "data: %s\n\n", usageData)
+	sendSSEUsage(w, flusher, responseID, req.Model, created, it, ot, rt)
 	fmt.Fprintf(w, "data: [DONE]\n\n")
 	flusher.Flush()
 
-	h.geminiService.LogRequest(client.ID, chatReq.Model, resp.StatusCode, it, ot, int(time.Since(start).Milliseconds()), "", requestBody, true, len(toolNames) > 0, strings.Join(toolNames, ","))
-	RecordRequest(client.ID, chatReq.Model, fmt.Sprintf("%d", resp.StatusCode), it, ot, int(time.Since(start).Milliseconds()))
+	h.geminiService.LogRequestEntry(&models.RequestLog{
+		ClientID: client.ID, Model: chatReq.Model, StatusCode: statusCode,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, LatencyMs: int(time.Since(start).Milliseconds()),
+		ErrorMessage: streamErr + safetyBlockMessage(client, chatReq.Model, safety) + schemaErrorMessage(validation), RequestBody: requestBody, IsStreaming: true,
+		HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","), TokensEstimated: estimated,
+		CacheCreationTokens: cacheWrite, CacheReadTokens: cacheRead,
+	})
+	RecordRequest(client.ID, chatReq.Model, fmt.Sprintf("%d", statusCode), it, ot, int(time.Since(start).Milliseconds()))
+	RecordCacheTokens(client.ID, chatReq.Model, cacheWrite, cacheRead)
 	if h.statsService != nil {
 		h.statsService.DecrementRequestsInProgress()
 	}
@@ -641,29 +893,254 @@ toolLoop:
 	return nil
 }
 
+// toolCallAccumulator assembles streamed tool call fragments into complete
+// calls, keyed by the index the upstream assigned to each call.
+type toolCallAccumulator struct {
+	calls   map[int]*providers.ToolCall
+	indexes []int
+}
+
+func newToolCallAccumulator() *toolCallAccumulator {
+	return &toolCallAccumulator{calls: make(map[int]*providers.ToolCall)}
+}
+
+// add merges a fragment and returns it as an OpenAI tool_calls delta entry.
+// The first fragment of a call carries its id, type and name.
+func (a *toolCallAccumulator) add(frag *providers.StreamToolCall) map[string]interface{} {
+	fn := map[string]interface{}{"arguments": frag.Arguments}
+	delta := map[string]interface{}{"index": frag.Index, "function": fn}
+
+	tc, ok := a.calls[frag.Index]
+	if !ok {
+		tc = &providers.ToolCall{ID: frag.ID}
+		if tc.ID == "" {
+			tc.ID = "call_" + randomID(24)
+		}
+		a.calls[frag.Index] = tc
+		a.indexes = append(a.indexes, frag.Index)
+		delta["id"] = tc.ID
+		delta["type"] = "function"
+	}
+	if frag.Name != "" {
+		tc.Name = frag.Name
+		fn["name"] = frag.Name
+	}
+	tc.Arguments += frag.Arguments
+	return delta
+}
+
+// list returns the assembled calls ordered by index.
+func (a *toolCallAccumulator) list() []providers.ToolCall {
+	sort.Ints(a.indexes)
+	calls := make([]providers.ToolCall, len(a.indexes))
+	for i, idx := range a.indexes {
+		calls[i] = *a.calls[idx]
+	}
+	return calls
+}
+
+// ensureToolCallIDs assigns ids to calls from backends that don't provide
+// them (Gemini, Ollama), so clients can reference them in tool results.
+func ensureToolCallIDs(calls []providers.ToolCall) {
+	for i := range calls {
+		if calls[i].ID == "" {
+			calls[i].ID = "call_" + randomID(24)
+		}
+	}
+}
+
+// toolCallMatcher pairs tool results with earlier calls for APIs whose tool
+// results carry no call id (Gemini, Ollama). A result takes the oldest
+// unanswered call with its function name, or the oldest unanswered call when
+// the result names no function.
+type toolCallMatcher struct {
+	pending []providers.ToolCall
+}
+
+func (m *toolCallMatcher) add(tc providers.ToolCall) {
+	m.pending = append(m.pending, tc)
+}
+
+func (m *toolCallMatcher) match(name string) (string, bool) {
+	for i, tc := range m.pending {
+		if name == "" || tc.Name == name {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			return tc.ID, true
+		}
+	}
+	return "", false
+}
+
+// toolCallsJSON renders tool calls in the OpenAI message format.
+func toolCallsJSON(calls []providers.ToolCall) []map[string]interface{} {
+	result := make([]map[string]interface{}, len(calls))
+	for i, tc := range calls {
+		result[i] = map[string]interface{}{
+			"id":   tc.ID,
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      tc.Name,
+				"arguments": tc.Arguments,
+			},
+		}
+	}
+	return result
+}
+
+// executeToolCalls runs gateway-mode tool calls concurrently and returns the
+// tool result messages in the same order as the calls. Calls whose arguments
+// were rejected (a non-empty problem) are not run; the problem is returned to
+// the model instead.
+func (h *OpenAIHandler) executeToolCalls(calls []providers.ToolCall, problems []string) []providers.ChatMessage {
+	results := make([]providers.ChatMessage, len(calls))
+	var wg sync.WaitGroup
+	for i, tc := range calls {
+		if problems[i] != "" {
+			results[i] = providers.ChatMessage{Role: "tool", ToolCallID: tc.ID, Content: toolArgsFeedback(tc, problems[i])}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, tc providers.ToolCall) {
+			defer wg.Done()
+			var args map[string]interface{}
+			json.Unmarshal([]byte(tc.Arguments), &args)
+			result, _ := h.toolService.Execute(tc.Name, args)
+			results[i] = providers.ChatMessage{Role: "tool", ToolCallID: tc.ID, Content: result}
+		}(i, tc)
+	}
+	wg.Wait()
+	return results
+}
+
 func sendSSEChunk(w http.ResponseWriter, flusher http.Flusher, id, model string, created int64, delta map[string]interface{}, finishReason interface{}) {
+	sendSSEChoiceChunk(w, flusher, id, model, created, 0, delta, finishReason)
+}
+
+// sendSSEChoiceChunk sends a delta for the choice at index.
+func sendSSEChoiceChunk(w http.ResponseWriter, flusher http.Flusher, id, model string, created int64, index int, delta map[string]interface{}, finishReason interface{}) {
+	sendSSEChoice(w, flusher, id, model, created, map[string]interface{}{"index": index, "delta": delta, "finish_reason": finishReason})
+}
+
+// sendSSEContentChunk sends a content delta along with its logprobs, if any.
+func sendSSEContentChunk(w http.ResponseWriter, flusher http.Flusher, id, model string, created int64, index int, text string, logprobs json.RawMessage) {
+	choice := map[string]interface{}{"index": index, "delta": map[string]interface{}{"content": text}, "finish_reason": nil}
+	if logprobs != nil {
+		choice["logprobs"] = logprobs
+	}
+	sendSSEChoice(w, flusher, id, model, created, choice)
+}
+
+func sendSSEChoice(w http.ResponseWriter, flusher http.Flusher, id, model string, created int64, choice map[string]interface{}) {
 	chunk := map[string]interface{}{
 		"id":      id,
 		"object":  "chat.completion.chunk",
 		"created": created,
 		"model":   model,
-		"choices": []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		"choices": []map[string]interface{}{choice},
 	}
 	data, _ := json.Marshal(chunk)
 	fmt.Fprintf(w, "data: %s\n\n", data)
 	flusher.Flush()
 }
 
+// sendSSEUsage sends usage info in a separate chunk with no choices, as
+// OpenAI does for stream_options.include_usage.
+func sendSSEUsage(w http.ResponseWriter, flusher http.Flusher, id, model string, created int64, it, ot, rt int) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   model,
+		"choices": []interface{}{},
+		"usage":   usageJSON(it, ot, rt),
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	flusher.Flush()
+}
+
+// usageJSON renders an OpenAI usage object. Reasoning tokens are part of the
+// completion tokens and are broken out in completion_tokens_details.
+func usageJSON(it, ot, rt int) map[string]interface{} {
+	usage := map[string]interface{}{
+		"prompt_tokens":     it,
+		"completion_tokens": ot,
+		"total_tokens":      it + ot,
+	}
+	if rt > 0 {
+		usage["completion_tokens_details"] = map[string]interface{}{"reasoning_tokens": rt}
+	}
+	return usage
+}
+
+// firstChoice returns the parsed first choice of a single-choice response, or
+// a zero Choice if it cannot be parsed.
+func firstChoice(provider providers.Provider, body []byte) providers.Choice {
+	choices, err := provider.ParseChoices(body)
+	if err != nil || len(choices) == 0 {
+		return providers.Choice{}
+	}
+	return choices[0]
+}
+
+// assistantMessage renders a parsed choice as a chat.completion message,
+// with reasoning and tool calls when present.
+func assistantMessage(c providers.Choice) map[string]interface{} {
+	message := map[string]interface{}{"role": "assistant", "content": c.Text}
+	if c.Reasoning != "" {
+		message["reasoning_content"] = c.Reasoning
+	}
+	if len(c.ThinkingBlocks) > 0 {
+		message["thinking_blocks"] = c.ThinkingBlocks
+	}
+	if len(c.ToolCalls) > 0 {
+		ensureToolCallIDs(c.ToolCalls)
+		message["tool_calls"] = toolCallsJSON(c.ToolCalls)
+		if c.Text == "" {
+			message["content"] = nil
+		}
+	}
+	return message
+}
+
+// choiceJSON renders a chat.completion choice, including logprobs when present.
+func choiceJSON(index int, message map[string]interface{}, finishReason string, logprobs json.RawMessage) map[string]interface{} {
+	choice := map[string]interface{}{"index": index, "message": message, "finish_reason": finishReason}
+	if logprobs != nil {
+		choice["logprobs"] = logprobs
+	}
+	return choice
+}
+
+// sendSSEError reports a failure after the stream has started, when a status
+// code can no longer be sent. OpenAI SDKs raise on a data chunk with an error.
+func sendSSEError(w http.ResponseWriter, flusher http.Flusher, errMsg, errType string) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": errMsg,
+			"type":    errType,
+			"code":    nil,
+		},
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	flusher.Flush()
+}
+
 func extractErrorMessage(body []byte) string {
 	var errObj map[string]interface{}
 	if err := json.Unmarshal(body, &errObj); err != nil {
 		return "Upstream API error"
 	}
 	if e, ok := errObj["error"].(map[string]interface{}); ok {
-		if msg, ok := e["message"].(string); ok { return msg }
+		if msg, ok := e["message"].(string); ok {
+			return msg
+		}
+	}
+	if msg, ok := errObj["error"].(string); ok {
+		return msg
+	}
+	if msg, ok := errObj["message"].(string); ok {
+		return msg
 	}
-	if msg, ok := errObj["error"].(string); ok { return msg }
-	if msg, ok := errObj["message"].(string); ok { return msg }
 	return "Upstream API error"
 }
 
@@ -693,13 +1170,75 @@ func (h *OpenAIHandler) ListModels(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(OpenAIModelsResponse{Object: "list", Data: allModels})
 }
 
+// CountTokens counts a prompt with the tokenizer for its model. It accepts an
+// Anthropic count_tokens body (system, messages, tools) or a bare prompt, and
+// reports whether the count came from the model's own vocabulary.
 func (h *OpenAIHandler) CountTokens(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Prompt string `json:"prompt"`
+		Model    string                   `json:"model"`
+		Prompt   string                   `json:"prompt"`
+		System   any                      `json:"system"`
+		Messages []map[string]interface{} `json:"messages"`
+		Tools    []map[string]interface{} `json:"tools"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid request body: "+err.Error(), "invalid_request_error")
+		return
+	}
+
+	var n int
+	exact := true
+	if req.Messages == nil && req.System == nil {
+		n, exact = tokenizer.Count(req.Model, req.Prompt)
+	} else {
+		var texts []string
+		if system := contentText(req.System); system != "" {
+			texts = append(texts, system)
+		}
+		for _, msg := range req.Messages {
+			texts = append(texts, contentText(msg["content"]))
+		}
+		if len(req.Tools) > 0 {
+			tools, _ := json.Marshal(req.Tools)
+			texts = append(texts, string(tools))
+		}
+		n, exact = tokenizer.CountMessages(req.Model, texts)
 	}
-	json.NewDecoder(r.Body).Decode(&req)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": len(req.Prompt) / 4})
+	json.NewEncoder(w).Encode(map[string]interface{}{"input_tokens": n, "tokens": n, "estimated": !exact})
+}
+
+// contentText flattens message content for counting: a plain string, or a
+// list of blocks whose text, tool input and tool results are concatenated.
+func contentText(content any) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var text strings.Builder
+		for _, item := range c {
+			block, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if t, ok := block["text"].(string); ok {
+				text.WriteString(t)
+			}
+			if name, ok := block["name"].(string); ok {
+				text.WriteString(name)
+			}
+			if input, ok := block["input"]; ok {
+				args, _ := json.Marshal(input)
+				text.Write(args)
+			}
+			if inner, ok := block["content"]; ok {
+				text.WriteString(contentText(inner))
+			}
+		}
+		return text.String()
+	}
+	return ""
 }
 
 func (h *OpenAIHandler) GetModel(w http.ResponseWriter, r *http.Request) {
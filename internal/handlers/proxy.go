@@ -4,13 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"ai-gateway/internal/middleware"
 	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
 	"ai-gateway/internal/services"
+	"ai-gateway/internal/sse"
+	"ai-gateway/internal/tokenizer"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -18,10 +23,11 @@ import (
 type ProxyHandler struct {
 	geminiService *services.GeminiService
 	statsService  *services.StatsService
+	registry      *providers.Registry
 }
 
-func NewProxyHandler(geminiService *services.GeminiService, statsService *services.StatsService) *ProxyHandler {
-	return &ProxyHandler{geminiService: geminiService, statsService: statsService}
+func NewProxyHandler(geminiService *services.GeminiService, statsService *services.StatsService, registry *providers.Registry) *ProxyHandler {
+	return &ProxyHandler{geminiService: geminiService, statsService: statsService, registry: registry}
 }
 
 func (h *ProxyHandler) RegisterRoutes(r chi.Router) {
@@ -40,6 +46,17 @@ func (h *ProxyHandler) RegisterRoutes(r chi.Router) {
 }
 
 func (h *ProxyHandler) GenerateContent(w http.ResponseWriter, r *http.Request) {
+	h.serveGenerateContent(w, r, false)
+}
+
+func (h *ProxyHandler) StreamGenerateContent(w http.ResponseWriter, r *http.Request) {
+	h.serveGenerateContent(w, r, true)
+}
+
+// serveGenerateContent routes a Gemini-format request to the client's backend.
+// Gemini backends receive the body unchanged; any other backend gets it
+// translated to a ChatRequest, with the result translated back.
+func (h *ProxyHandler) serveGenerateContent(w http.ResponseWriter, r *http.Request, stream bool) {
 	client := middleware.GetClientFromContext(r.Context())
 	if client == nil {
 		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
@@ -59,37 +76,107 @@ func (h *ProxyHandler) GenerateContent(w http.ResponseWriter, r *http.Request) {
 
 	if h.statsService != nil {
 		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
 	}
 
-	if err := h.enforceRequestLimits(client, body); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Error-Code", "REQUEST_LIMIT_EXCEEDED")
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		if h.statsService != nil {
-			h.statsService.DecrementRequestsInProgress()
+	body = h.capOutputTokens(client, body)
+
+	provider, err := resolveClientProvider(client, h.registry, h.geminiService.GetConfig())
+	if err != nil {
+		writeGeminiError(w, http.StatusBadRequest, "BACKEND_NOT_CONFIGURED", "Backend not configured: "+err.Error())
+		return
+	}
+
+	if fwd, ok := provider.(providers.GeminiForwarder); ok {
+		// Translated requests are checked once the context policy has
+		// fitted them.
+		if err := h.enforceRequestLimits(client, model, body); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Error-Code", "REQUEST_LIMIT_EXCEEDED")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = providers.WithGeminiSafetySettings(body, clientSafetySettings(client))
+		if stream {
+			h.forwardStream(w, r, client, fwd, model, body)
+		} else {
+			h.forward(w, client, fwd, model, body)
 		}
 		return
 	}
 
-	body = h.capOutputTokens(client, body)
+	chatReq, err := geminiToChatRequest(body, servedModel(client, provider, model), client)
+	if err == nil {
+		err = providers.ValidateRequest(provider, chatReq)
+	}
+	if err == nil && choiceCount(chatReq) > maxChoices {
+		err = fmt.Errorf("candidateCount must be at most %d", maxChoices)
+	}
+	var contextAction string
+	if err == nil {
+		contextAction, err = fitContextWindow(h.geminiService, client, provider, chatReq)
+	}
+	if err != nil {
+		writeGeminiError(w, http.StatusBadRequest, "INVALID_REQUEST", err.Error())
+		return
+	}
+	if err := checkInputTokens(client, chatReq); err != nil {
+		w.Header().Set("X-Error-Code", "REQUEST_LIMIT_EXCEEDED")
+		writeGeminiError(w, http.StatusBadRequest, "MAX_INPUT_TOKENS_EXCEEDED", err.Error())
+		return
+	}
+	if contextAction != "" {
+		w.Header().Set("X-Context-Action", contextAction)
+	}
 
-	start := time.Now()
-	respBody, statusCode, err := h.geminiService.ForwardRequest(model, body)
-	latencyMs := int(time.Since(start).Milliseconds())
+	if stream {
+		h.translateStream(w, r, client, provider, chatReq, body)
+	} else {
+		h.translate(w, client, provider, chatReq, body)
+	}
+}
 
-	inputTokens, outputTokens, _ := services.ParseGeminiResponse(respBody)
+// servedModel returns model if the client's backend serves it, and otherwise
+// the client's default model: Gemini SDKs always name a Gemini model in the
+// URL, which other backends don't know.
+func servedModel(client *models.Client, provider providers.Provider, model string) string {
+	var served []string
+	if client.BackendModels != "" {
+		json.Unmarshal([]byte(client.BackendModels), &served)
+	}
+	served = append(served, provider.Models()...)
+	for _, m := range served {
+		if m == model {
+			return model
+		}
+	}
+	if def := clientDefaultModel(client, provider); def != "" {
+		return def
+	}
+	return model
+}
+
+// forward proxies a generateContent call to a native Gemini backend.
+func (h *ProxyHandler) forward(w http.ResponseWriter, client *models.Client, fwd providers.GeminiForwarder, model string, body []byte) {
+	start := time.Now()
+	resp, err := fwd.ForwardGemini(model, body, false)
+	if err != nil {
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: string(body)})
+		writeGeminiError(w, http.StatusBadGateway, "UPSTREAM_ERROR", "Upstream request failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
 
+	respBody, err := io.ReadAll(resp.Body)
 	errMsg := ""
 	if err != nil {
 		errMsg = err.Error()
 	}
-
-	h.geminiService.LogRequest(client.ID, model, statusCode, inputTokens, outputTokens, latencyMs, errMsg, string(body), false, false, "")
-	RecordRequest(client.ID, model, fmt.Sprintf("%d", statusCode), inputTokens, outputTokens, latencyMs)
-
-	if h.statsService != nil {
-		h.statsService.DecrementRequestsInProgress()
+	inputTokens, outputTokens, _ := services.ParseGeminiResponse(respBody)
+	if resp.StatusCode < 400 {
+		errMsg += safetyBlockMessage(client, model, providers.GeminiSafetyFeedback(respBody))
 	}
+	logRequest(h.geminiService, client, start, &models.RequestLog{Model: model, StatusCode: resp.StatusCode, InputTokens: inputTokens, OutputTokens: outputTokens, ErrorMessage: errMsg, RequestBody: string(body)})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-RateLimit-Limit-Minute", string(rune(client.RateLimitMinute)))
@@ -98,14 +185,301 @@ func (h *ProxyHandler) GenerateContent(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-TokenLimit-Input", string(rune(client.MaxInputTokens)))
 	w.Header().Set("X-TokenLimit-Output", string(rune(client.MaxOutputTokens)))
 
-	w.WriteHeader(statusCode)
-	if respBody != nil {
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+// forwardStream proxies streamGenerateContent to a native Gemini backend. The
+// upstream is always read as SSE so usage can be picked out of each chunk;
+// the client gets SSE or a JSON array depending on its alt parameter.
+func (h *ProxyHandler) forwardStream(w http.ResponseWriter, r *http.Request, client *models.Client, fwd providers.GeminiForwarder, model string, body []byte) {
+	start := time.Now()
+	resp, err := fwd.ForwardGemini(model, body, true)
+	if err != nil {
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: string(body), IsStreaming: true})
+		writeGeminiError(w, http.StatusBadGateway, "UPSTREAM_ERROR", "Upstream request failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: model, StatusCode: resp.StatusCode, ErrorMessage: extractErrorMessage(respBody), RequestBody: string(body), IsStreaming: true})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
 		w.Write(respBody)
+		return
+	}
+
+	out := newGeminiStreamWriter(w, r.URL.Query().Get("alt") == "sse")
+	dec := sse.NewDecoder(resp.Body, 0)
+	var inputTokens, outputTokens int
+	var safety *providers.SafetyFeedback
+	errMsg := ""
+	for {
+		ev, err := dec.Next()
+		if err != nil {
+			if err != io.EOF {
+				errMsg = err.Error()
+			}
+			break
+		}
+		if it, ot, _ := services.ParseGeminiResponse(ev.Data); it > 0 || ot > 0 {
+			inputTokens, outputTokens = it, ot
+		}
+		if safety == nil {
+			safety = providers.GeminiSafetyFeedback(ev.Data)
+		}
+		out.writeRaw(ev.Data)
+	}
+	out.close()
+
+	if errMsg != "" {
+		log.Printf("[GEMINI] Stream for client %s ended with error: %s", client.Name, errMsg)
+	}
+	errMsg += safetyBlockMessage(client, model, safety)
+	logRequest(h.geminiService, client, start, &models.RequestLog{Model: model, StatusCode: resp.StatusCode, InputTokens: inputTokens, OutputTokens: outputTokens, ErrorMessage: errMsg, RequestBody: string(body), IsStreaming: true})
+}
+
+// translate serves a generateContent call from a non-Gemini backend. A
+// candidateCount the backend can't honour is served with one call per
+// candidate, as for n on /v1/chat/completions; usage is summed across calls.
+func (h *ProxyHandler) translate(w http.ResponseWriter, client *models.Client, provider providers.Provider, chatReq *providers.ChatRequest, body []byte) {
+	start := time.Now()
+	reqs := choiceRequests(provider, chatReq)
+	results := completeChoices(provider, reqs)
+	for _, res := range results {
+		if res.err != nil {
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: http.StatusBadGateway, ErrorMessage: res.err.Error(), RequestBody: string(body)})
+			writeGeminiError(w, http.StatusBadGateway, "UPSTREAM_ERROR", "Upstream request failed: "+res.err.Error())
+			return
+		}
+		if res.statusCode >= 400 {
+			errMsg := extractErrorMessage(res.body)
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: res.statusCode, ErrorMessage: errMsg, RequestBody: string(body)})
+			writeGeminiError(w, mapUpstreamStatusToHTTP(res.statusCode), "UPSTREAM_ERROR", errMsg)
+			return
+		}
+	}
+
+	var candidates []map[string]interface{}
+	var toolNames []string
+	var output strings.Builder
+	var it, ot, rt, cacheWrite, cacheRead int
+	for i, res := range results {
+		choices, err := provider.ParseChoices(res.body)
+		if err != nil {
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: string(body)})
+			writeGeminiError(w, http.StatusBadGateway, "UPSTREAM_ERROR", "Failed to parse upstream response: "+err.Error())
+			return
+		}
+		_, in, out, _ := provider.ParseResponse(res.body)
+		it += in
+		ot += out
+		details := providers.ParseUsageDetails(provider, res.body)
+		rt += details.ReasoningTokens
+		cacheWrite += details.CacheCreationTokens
+		cacheRead += details.CacheReadTokens
+
+		for _, c := range choices {
+			if len(reqs) > 1 {
+				c.Index = i
+			}
+			ensureToolCallIDs(c.ToolCalls)
+			candidates = append(candidates, geminiCandidateJSON(c))
+			output.WriteString(c.Text)
+			for _, tc := range c.ToolCalls {
+				toolNames = append(toolNames, tc.Name)
+			}
+		}
+	}
+	estimated := estimateChoiceUsage(chatReq, len(reqs), output.String(), &it, &ot)
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: chatReq.Model, StatusCode: http.StatusOK,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, TokensEstimated: estimated,
+		RequestBody: string(body), HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","),
+		CacheCreationTokens: cacheWrite, CacheReadTokens: cacheRead,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"candidates":    candidates,
+		"usageMetadata": geminiUsageJSON(it, ot, rt),
+		"modelVersion":  chatReq.Model,
+	})
+}
+
+// translateStream serves streamGenerateContent from a non-Gemini backend.
+// Text and thoughts are streamed as they arrive; function calls are sent
+// whole in the final chunk along with finish reasons and usage, since Gemini
+// never splits a functionCall part across chunks. Candidates fanned out over
+// several upstream streams are merged as they arrive.
+func (h *ProxyHandler) translateStream(w http.ResponseWriter, r *http.Request, client *models.Client, provider providers.Provider, chatReq *providers.ChatRequest, body []byte) {
+	start := time.Now()
+	chatReq.Stream = true
+	chatReq.StreamOptions = &providers.StreamOptions{IncludeUsage: true}
+
+	resps, errs := openChoiceStreams(provider, choiceRequests(provider, chatReq))
+	closeAll := func() {
+		for _, resp := range resps {
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			closeAll()
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: http.StatusBadGateway, ErrorMessage: err.Error(), RequestBody: string(body), IsStreaming: true})
+			writeGeminiError(w, http.StatusBadGateway, "UPSTREAM_ERROR", "Upstream request failed: "+err.Error())
+			return
+		}
+		if resps[i].StatusCode >= 400 {
+			respBody, _ := io.ReadAll(resps[i].Body)
+			closeAll()
+			errMsg := extractErrorMessage(respBody)
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: chatReq.Model, StatusCode: resps[i].StatusCode, ErrorMessage: errMsg, RequestBody: string(body), IsStreaming: true})
+			writeGeminiError(w, mapUpstreamStatusToHTTP(resps[i].StatusCode), "UPSTREAM_ERROR", errMsg)
+			return
+		}
+	}
+
+	out := newGeminiStreamWriter(w, r.URL.Query().Get("alt") == "sse")
+	calls := make(map[int]*toolCallAccumulator)
+	finish := make(map[int]string)
+	var indexes []int
+	seen := func(index int) {
+		if _, ok := finish[index]; !ok {
+			finish[index] = "stop"
+			indexes = append(indexes, index)
+		}
+	}
+
+	inputTokens := make(map[int]int)
+	outputTokens := make(map[int]int)
+	reasoningTokens := make(map[int]int)
+	cacheWrites := make(map[int]int)
+	cacheReads := make(map[int]int)
+	var output strings.Builder
+	var streamErr string
+	for ev := range mergeChoiceStreams(provider, resps) {
+		switch ev.Type {
+		case providers.StreamEventText:
+			seen(ev.Choice)
+			if ev.Text != "" {
+				output.WriteString(ev.Text)
+				out.write(geminiStreamChunk(ev.Choice, map[string]interface{}{"text": ev.Text}))
+			}
+		case providers.StreamEventReasoning:
+			seen(ev.Choice)
+			out.write(geminiStreamChunk(ev.Choice, map[string]interface{}{"text": ev.Text, "thought": true}))
+		case providers.StreamEventToolCall:
+			seen(ev.Choice)
+			if calls[ev.Choice] == nil {
+				calls[ev.Choice] = newToolCallAccumulator()
+			}
+			calls[ev.Choice].add(ev.ToolCall)
+		case providers.StreamEventUsage:
+			// Usage is per upstream stream; with native candidates there is
+			// one stream and every event carries the totals.
+			if ev.InputTokens > 0 {
+				inputTokens[ev.Choice] = ev.InputTokens
+			}
+			if ev.OutputTokens > 0 {
+				outputTokens[ev.Choice] = ev.OutputTokens
+			}
+			if ev.ReasoningTokens > 0 {
+				reasoningTokens[ev.Choice] = ev.ReasoningTokens
+			}
+			if ev.CacheCreationTokens > 0 || ev.CacheReadTokens > 0 {
+				cacheWrites[ev.Choice], cacheReads[ev.Choice] = ev.CacheCreationTokens, ev.CacheReadTokens
+			}
+		case providers.StreamEventFinish:
+			seen(ev.Choice)
+			finish[ev.Choice] = ev.FinishReason
+		case providers.StreamEventError:
+			if streamErr == "" {
+				streamErr = ev.Err.Error()
+			}
+		}
+	}
+
+	if streamErr != "" {
+		log.Printf("[GEMINI] Stream from %s ended with error: %s", provider.Name(), streamErr)
+		out.write(APIError{Err: APIErrorBody{Message: "Upstream stream failed: " + streamErr, Code: "UPSTREAM_ERROR", Status: geminiStatus(http.StatusBadGateway)}})
+	}
+
+	var it, ot, rt, cacheWrite, cacheRead int
+	for _, v := range inputTokens {
+		it += v
+	}
+	for _, v := range outputTokens {
+		ot += v
+	}
+	for _, v := range reasoningTokens {
+		rt += v
+	}
+	for i := range cacheWrites {
+		cacheWrite += cacheWrites[i]
+		cacheRead += cacheReads[i]
+	}
+	estimated := estimateChoiceUsage(chatReq, len(resps), output.String(), &it, &ot)
+	if len(indexes) == 0 {
+		seen(0)
+	}
+	sort.Ints(indexes)
+
+	var toolNames []string
+	candidates := make([]map[string]interface{}, len(indexes))
+	for i, idx := range indexes {
+		var parts []map[string]interface{}
+		if acc := calls[idx]; acc != nil {
+			list := acc.list()
+			for _, tc := range list {
+				toolNames = append(toolNames, tc.Name)
+			}
+			parts = geminiFunctionCallParts(list)
+		}
+		candidates[i] = geminiCandidateChunk(idx, parts)
+		candidates[i]["finishReason"] = geminiFinishReason(finish[idx])
+	}
+	out.write(map[string]interface{}{
+		"candidates":    candidates,
+		"usageMetadata": geminiUsageJSON(it, ot, rt),
+		"modelVersion":  chatReq.Model,
+	})
+	out.close()
+
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: chatReq.Model, StatusCode: http.StatusOK,
+		InputTokens: it, OutputTokens: ot, ReasoningTokens: rt, TokensEstimated: estimated,
+		ErrorMessage: streamErr, RequestBody: string(body), IsStreaming: true,
+		HasTools: len(toolNames) > 0, ToolNames: strings.Join(toolNames, ","),
+		CacheCreationTokens: cacheWrite, CacheReadTokens: cacheRead,
+	})
+}
+
+// logRequest completes a request log entry with the client and latency,
+// stores it and records its metrics.
+func logRequest(geminiService *services.GeminiService, client *models.Client, start time.Time, entry *models.RequestLog) {
+	entry.ClientID = client.ID
+	entry.LatencyMs = int(time.Since(start).Milliseconds())
+	geminiService.LogRequestEntry(entry)
+	RecordRequest(client.ID, entry.Model, fmt.Sprintf("%d", entry.StatusCode), entry.InputTokens, entry.OutputTokens, entry.LatencyMs)
+	RecordCacheTokens(client.ID, entry.Model, entry.CacheCreationTokens, entry.CacheReadTokens)
+	if entry.AudioSeconds > 0 {
+		RecordAudioSeconds(client.ID, entry.Model, entry.AudioSeconds)
+	}
+	if entry.ImageCount > 0 {
+		RecordImages(client.ID, entry.Model, entry.ImageCount)
 	}
 }
 
-func (h *ProxyHandler) enforceRequestLimits(client *models.Client, body []byte) error {
-	inputTokens := estimateInputTokens(string(body))
+func (h *ProxyHandler) enforceRequestLimits(client *models.Client, model string, body []byte) error {
+	if client.MaxInputTokens <= 0 {
+		return nil
+	}
+	inputTokens := countGeminiInputTokens(model, body)
 
 	if client.MaxInputTokens > 0 && inputTokens > client.MaxInputTokens {
 		return &APIError{
@@ -148,88 +522,41 @@ func (h *ProxyHandler) capOutputTokens(client *models.Client, body []byte) []byt
 	return newBody
 }
 
-func estimateInputTokens(text string) int {
-	return len(text) / 4
-}
-
-func (h *ProxyHandler) StreamGenerateContent(w http.ResponseWriter, r *http.Request) {
-	client := middleware.GetClientFromContext(r.Context())
-	if client == nil {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	model := chi.URLParam(r, "model")
-	if model == "" {
-		model = "gemini-flash-lite-latest"
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
-		return
-	}
-
-	if h.statsService != nil {
-		h.statsService.IncrementRequestsInProgress()
-	}
-
-	if err := h.enforceRequestLimits(client, body); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		if h.statsService != nil {
-			h.statsService.DecrementRequestsInProgress()
-		}
-		return
-	}
-
-	body = h.capOutputTokens(client, body)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Transfer-Encoding", "chunked")
-
-	baseURL := h.geminiService.GetBaseURL()
-	url := baseURL + "/models/" + model + ":streamGenerateContent?key="
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(body)))
-	if err != nil {
-		http.Error(w, `{"error": "Failed to create request"}`, http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	clientHTTP := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-
-	resp, err := clientHTTP.Do(req)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to forward request"}`, http.StatusBadGateway)
-		return
+// countGeminiInputTokens counts the text, function calls and tool
+// declarations of a generateContent body. Bodies that don't parse are counted
+// whole.
+func countGeminiInputTokens(model string, body []byte) int {
+	var req geminiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		n, _ := tokenizer.Count(model, string(body))
+		return n
 	}
-	defer resp.Body.Close()
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, `{"error": "Streaming not supported"}`, http.StatusInternalServerError)
-		return
+	contents := req.Contents
+	if req.SystemInstruction != nil {
+		contents = append(contents, *req.SystemInstruction)
 	}
-
-	buffer := make([]byte, 1024)
-	for {
-		n, err := resp.Body.Read(buffer)
-		if n > 0 {
-			w.Write(buffer[:n])
-			flusher.Flush()
-		}
-		if err != nil {
-			break
+	var texts []string
+	for _, c := range contents {
+		var text strings.Builder
+		for _, p := range c.Parts {
+			text.WriteString(p.Text)
+			if p.FunctionCall != nil {
+				text.WriteString(p.FunctionCall.Name)
+				text.Write(p.FunctionCall.Args)
+			}
+			if p.FunctionResponse != nil {
+				text.Write(p.FunctionResponse.Response)
+			}
 		}
+		texts = append(texts, text.String())
 	}
-
-	if h.statsService != nil {
-		h.statsService.DecrementRequestsInProgress()
+	if len(req.Tools) > 0 {
+		tools, _ := json.Marshal(req.Tools)
+		texts = append(texts, string(tools))
 	}
+	n, _ := tokenizer.CountMessages(model, texts)
+	return n
 }
 
 func (h *ProxyHandler) ListModels(w http.ResponseWriter, r *http.Request) {
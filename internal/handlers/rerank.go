@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ai-gateway/internal/middleware"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+	"ai-gateway/internal/tokenizer"
+)
+
+// RerankRequest is the Cohere/Jina rerank schema. Documents are strings or
+// {"text": ...} objects.
+type RerankRequest struct {
+	Model           string            `json:"model"`
+	Query           string            `json:"query"`
+	Documents       []json.RawMessage `json:"documents"`
+	TopN            int               `json:"top_n,omitempty"`
+	ReturnDocuments bool              `json:"return_documents,omitempty"`
+}
+
+// rerankDocuments reads the request's documents as text.
+func rerankDocuments(raw []json.RawMessage) ([]string, error) {
+	docs := make([]string, len(raw))
+	for i, d := range raw {
+		if json.Unmarshal(d, &docs[i]) == nil {
+			continue
+		}
+		var obj struct {
+			Text *string `json:"text"`
+		}
+		if json.Unmarshal(d, &obj) != nil || obj.Text == nil {
+			return nil, fmt.Errorf("documents[%d] must be a string or an object with text", i)
+		}
+		docs[i] = *obj.Text
+	}
+	return docs, nil
+}
+
+// countRerankTokens counts rerank input: a cross-encoder reads the query
+// once per document.
+func countRerankTokens(model, query string, docs []string) int {
+	q, _ := tokenizer.Count(model, query)
+	n := q * len(docs)
+	for _, d := range docs {
+		c, _ := tokenizer.Count(model, d)
+		n += c
+	}
+	return n
+}
+
+func (h *OpenAIHandler) Rerank(w http.ResponseWriter, r *http.Request) {
+	client := middleware.GetClientFromContext(r.Context())
+	if client == nil {
+		writeOpenAIError(w, http.StatusUnauthorized, "Unauthorized", "authentication_error")
+		return
+	}
+
+	var req RerankRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Invalid JSON in request body", "invalid_request_error")
+		return
+	}
+	if req.Query == "" || len(req.Documents) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "query and documents are required", "invalid_request_error")
+		return
+	}
+	docs, err := rerankDocuments(req.Documents)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	provider, err := h.resolveProvider(client)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Backend not configured: "+err.Error(), "invalid_request_error")
+		return
+	}
+	reranker, ok := provider.(providers.Reranker)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("The %s backend does not support reranking", provider.Name()), "invalid_request_error")
+		return
+	}
+	if req.Model == "" {
+		req.Model = clientDefaultModel(client, provider)
+	}
+
+	estimated := countRerankTokens(req.Model, req.Query, docs)
+	if client.MaxInputTokens > 0 && estimated > client.MaxInputTokens {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("input is %d tokens, which exceeds this client's limit of %d", estimated, client.MaxInputTokens), "invalid_request_error")
+		return
+	}
+
+	if h.statsService != nil {
+		h.statsService.IncrementRequestsInProgress()
+		defer h.statsService.DecrementRequestsInProgress()
+	}
+
+	start := time.Now()
+	resp, statusCode, err := reranker.Rerank(&providers.RerankRequest{Model: req.Model, Query: req.Query, Documents: docs, TopN: req.TopN})
+	if err != nil {
+		// An error with a success status is a response that couldn't be used.
+		if statusCode < 400 {
+			statusCode = http.StatusBadGateway
+		}
+		logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: statusCode, ErrorMessage: err.Error()})
+		writeOpenAIError(w, mapUpstreamStatusToHTTP(statusCode), err.Error(), "api_error")
+		return
+	}
+	for _, res := range resp.Results {
+		if res.Index < 0 || res.Index >= len(docs) {
+			msg := fmt.Sprintf("upstream returned result index %d for %d documents", res.Index, len(docs))
+			logRequest(h.geminiService, client, start, &models.RequestLog{Model: req.Model, StatusCode: http.StatusBadGateway, ErrorMessage: msg})
+			writeOpenAIError(w, http.StatusBadGateway, msg, "api_error")
+			return
+		}
+	}
+
+	inputTokens := resp.InputTokens
+	if inputTokens == 0 {
+		inputTokens = estimated
+	}
+	logRequest(h.geminiService, client, start, &models.RequestLog{
+		Model: req.Model, StatusCode: statusCode, InputTokens: inputTokens, TokensEstimated: resp.InputTokens == 0,
+	})
+
+	results := make([]map[string]interface{}, len(resp.Results))
+	for i, res := range resp.Results {
+		results[i] = map[string]interface{}{"index": res.Index, "relevance_score": res.RelevanceScore}
+		if req.ReturnDocuments {
+			results[i]["document"] = map[string]string{"text": docs[res.Index]}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      "rerank-" + randomID(12),
+		"model":   req.Model,
+		"results": results,
+		"usage":   map[string]int{"total_tokens": inputTokens},
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// geminiSafetyCategories are the harm categories a client can set Gemini
+// thresholds for, with their labels in the admin UI.
+var geminiSafetyCategories = []struct {
+	Category string
+	Label    string
+}{
+	{"HARM_CATEGORY_HARASSMENT", "Harassment"},
+	{"HARM_CATEGORY_HATE_SPEECH", "Hate speech"},
+	{"HARM_CATEGORY_SEXUALLY_EXPLICIT", "Sexually explicit"},
+	{"HARM_CATEGORY_DANGEROUS_CONTENT", "Dangerous content"},
+	{"HARM_CATEGORY_CIVIC_INTEGRITY", "Civic integrity"},
+}
+
+// geminiSafetyThresholds are the block thresholds Gemini accepts.
+var geminiSafetyThresholds = []string{"BLOCK_NONE", "BLOCK_ONLY_HIGH", "BLOCK_MEDIUM_AND_ABOVE", "BLOCK_LOW_AND_ABOVE", "OFF"}
+
+// clientSafetySettings returns the client's Gemini thresholds by harm
+// category, or nil if none are set.
+func clientSafetySettings(client *models.Client) map[string]string {
+	if client.SafetySettings == "" {
+		return nil
+	}
+	var settings map[string]string
+	if err := json.Unmarshal([]byte(client.SafetySettings), &settings); err != nil {
+		log.Printf("[SAFETY] Ignoring invalid safety settings of client %s: %v", client.Name, err)
+		return nil
+	}
+	return settings
+}
+
+// safetySettingsForm reads the admin form's safety_<CATEGORY> selects into
+// the JSON stored on the client. Categories left on the default are omitted;
+// "" means no settings.
+func safetySettingsForm(form url.Values) string {
+	settings := make(map[string]string)
+	for _, c := range geminiSafetyCategories {
+		if threshold := form.Get("safety_" + c.Category); slices.Contains(geminiSafetyThresholds, threshold) {
+			settings[c.Category] = threshold
+		}
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(settings)
+	return string(data)
+}
+
+// safetyBlockMessage logs and counts a response stopped by the backend's
+// safety filters and returns the message stored in its request log; "" if
+// safety is nil.
+func safetyBlockMessage(client *models.Client, model string, safety *providers.SafetyFeedback) string {
+	if safety == nil {
+		return ""
+	}
+	what := "Response"
+	if safety.PromptBlocked {
+		what = "Prompt"
+	}
+	log.Printf("[SAFETY] %s blocked for client %s on %s: %s", what, client.Name, model, safety.BlockReason)
+	RecordSafetyBlock(client.ID, model, safety.BlockReason)
+	return fmt.Sprintf("%s blocked by safety filters (%s)", what, safety.BlockReason)
+}
+
+// firstSafetyBlock returns the feedback of the first choice stopped by
+// safety filters, or nil.
+func firstSafetyBlock(choices []providers.Choice) *providers.SafetyFeedback {
+	for _, c := range choices {
+		if c.Safety != nil {
+			return c.Safety
+		}
+	}
+	return nil
+}
+
+// withSafety adds a choice's safety feedback to its rendered JSON as the
+// "safety" extension field.
+func withSafety(choice map[string]interface{}, safety *providers.SafetyFeedback) map[string]interface{} {
+	if safety != nil {
+		choice["safety"] = safety
+	}
+	return choice
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"ai-gateway/internal/jsonschema"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// SchemaValidation is a gateway extension on chat responses to requests with
+// a JSON response_format, for clients with schema validation enabled: whether
+// the content passed, after how many upstream attempts, and the violations
+// of the last attempt if it did not.
+type SchemaValidation struct {
+	Validated bool     `json:"validated"`
+	Attempts  int      `json:"attempts"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// validateStructured checks a response's content against a json_schema
+// response_format, or only for a JSON value when schema is nil. Content
+// wrapped in a markdown code fence is unwrapped; the returned content is what
+// was validated.
+func validateStructured(schema map[string]interface{}, content string) (string, []string) {
+	content = stripCodeFence(content)
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return content, []string{"response is not valid JSON: " + err.Error()}
+	}
+	if schema == nil {
+		return content, nil
+	}
+	return content, jsonschema.Validate(schema, value)
+}
+
+// stripCodeFence removes a markdown code fence around s, as models without a
+// native JSON mode often add one.
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") || len(s) < 6 {
+		return s
+	}
+	s = strings.TrimSuffix(s[3:], "```")
+	// Drop the info string (```json).
+	if i := strings.IndexByte(s, '\n'); i >= 0 && !strings.ContainsAny(s[:i], "{[\"") {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
+
+// schemaFeedback is the message sent back to the model after a response
+// fails validation.
+func schemaFeedback(errs []string) string {
+	return "Your response did not match the required JSON format:\n- " + strings.Join(errs, "\n- ") +
+		"\nReply again with only the corrected JSON."
+}
+
+// enforceSchema validates a non-streamed response to a request with a JSON
+// response_format and, while it fails and the client's retries allow, asks
+// again with the errors fed back. It returns the response body and content to
+// send, with the retries' usage added to it, ot and details, or a nil
+// validation when the request doesn't ask for JSON.
+func (h *OpenAIHandler) enforceSchema(client *models.Client, provider providers.Provider, chatReq *providers.ChatRequest, respBody []byte, content string, it, ot *int, details *providers.UsageDetails) ([]byte, string, *SchemaValidation) {
+	schema, wantsJSON := providers.ResponseSchema(chatReq.ResponseFormat)
+	if !wantsJSON {
+		return respBody, content, nil
+	}
+
+	validation := &SchemaValidation{Attempts: 1}
+	messages := chatReq.Messages
+	for {
+		validated, errs := validateStructured(schema, content)
+		if len(errs) == 0 {
+			validation.Validated = true
+			validation.Errors = nil
+			return respBody, validated, validation
+		}
+		validation.Errors = errs
+		if validation.Attempts > client.SchemaRetries {
+			log.Printf("[SCHEMA] Response for client %s failed validation after %d attempts: %s", client.Name, validation.Attempts, strings.Join(errs, "; "))
+			return respBody, content, validation
+		}
+
+		retry := *chatReq
+		retry.Messages = append(slices.Clip(messages),
+			providers.ChatMessage{Role: "assistant", Content: content},
+			providers.ChatMessage{Role: "user", Content: schemaFeedback(errs)},
+		)
+		body, statusCode, err := provider.ChatCompletion(&retry)
+		if err == nil && statusCode >= 400 {
+			err = fmt.Errorf("status %d: %s", statusCode, extractErrorMessage(body))
+		}
+		if err != nil {
+			log.Printf("[SCHEMA] Retry for client %s failed: %v", client.Name, err)
+			return respBody, content, validation
+		}
+		validation.Attempts++
+		messages = retry.Messages
+		respBody = body
+
+		var rit, rot int
+		content, rit, rot, _ = provider.ParseResponse(body)
+		estimateUsage(&retry, content, &rit, &rot)
+		*it += rit
+		*ot += rot
+		retryDetails := providers.ParseUsageDetails(provider, body)
+		details.ReasoningTokens += retryDetails.ReasoningTokens
+		details.CacheCreationTokens += retryDetails.CacheCreationTokens
+		details.CacheReadTokens += retryDetails.CacheReadTokens
+	}
+}
+
+// sendSSESchemaValidation reports the validation of a streamed response in a
+// chunk with no choices before the usage chunk. Streams are validated once
+// they complete and can't be retried.
+func sendSSESchemaValidation(w http.ResponseWriter, flusher http.Flusher, id, model string, created int64, validation *SchemaValidation) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":                id,
+		"object":            "chat.completion.chunk",
+		"created":           created,
+		"model":             model,
+		"choices":           []interface{}{},
+		"schema_validation": validation,
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	flusher.Flush()
+}
+
+// schemaErrorMessage is logged with a request whose response failed
+// validation.
+func schemaErrorMessage(validation *SchemaValidation) string {
+	if validation == nil || validation.Validated {
+		return ""
+	}
+	return "response failed schema validation: " + strings.Join(validation.Errors, "; ")
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+	"ai-gateway/internal/tokenizer"
+)
+
+// messageTexts returns the text the tokenizer counts for each message: its
+// content plus the names and arguments of any tool calls.
+func messageTexts(messages []providers.ChatMessage) []string {
+	texts := make([]string, len(messages))
+	for i, m := range messages {
+		text := m.Content
+		for _, tc := range m.ToolCalls {
+			text += tc.Name + tc.Arguments
+		}
+		texts[i] = text
+	}
+	return texts
+}
+
+// countPromptTokens counts the prompt of chatReq, including tool definitions,
+// with the tokenizer for its model.
+func countPromptTokens(chatReq *providers.ChatRequest) (int, bool) {
+	texts := messageTexts(chatReq.Messages)
+	if len(chatReq.Tools) > 0 {
+		tools, _ := json.Marshal(chatReq.Tools)
+		texts = append(texts, string(tools))
+	}
+	return tokenizer.CountMessages(chatReq.Model, texts)
+}
+
+// checkInputTokens enforces the client's MaxInputTokens before the request is
+// sent upstream.
+func checkInputTokens(client *models.Client, chatReq *providers.ChatRequest) error {
+	if client.MaxInputTokens <= 0 {
+		return nil
+	}
+	if n, _ := countPromptTokens(chatReq); n > client.MaxInputTokens {
+		return fmt.Errorf("input is %d tokens, which exceeds this client's limit of %d", n, client.MaxInputTokens)
+	}
+	return nil
+}
+
+// estimateUsage fills in the token counts an upstream didn't report, from
+// the prompt and the generated output. It returns true if either count was
+// estimated.
+func estimateUsage(chatReq *providers.ChatRequest, output string, it, ot *int) bool {
+	estimated := false
+	if *it == 0 {
+		*it, _ = countPromptTokens(chatReq)
+		estimated = true
+	}
+	if *ot == 0 && output != "" {
+		*ot, _ = tokenizer.Count(chatReq.Model, output)
+		estimated = true
+	}
+	return estimated
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"ai-gateway/internal/jsonrepair"
+	"ai-gateway/internal/jsonschema"
+	"ai-gateway/internal/models"
+	"ai-gateway/internal/providers"
+)
+
+// fixToolArguments applies the client's ToolArgsPolicy to tool calls from the
+// model, replacing malformed arguments with their repaired JSON in place and
+// logging each repair. It returns, per call, why the arguments were rejected
+// under the "strict" policy, or "" when they are usable; other policies never
+// reject.
+func fixToolArguments(client *models.Client, tools []providers.Tool, calls []providers.ToolCall) []string {
+	problems := make([]string, len(calls))
+	policy := client.ToolArgsPolicy
+	if policy == "off" {
+		return problems
+	}
+
+	for i := range calls {
+		tc := &calls[i]
+		repaired, repairs, err := jsonrepair.Repair(tc.Arguments)
+		if len(repairs) > 0 && err == nil {
+			log.Printf("[TOOLS] Repaired arguments of %s for client %s: %s", tc.Name, client.Name, strings.Join(repairs, ", "))
+			for _, repair := range repairs {
+				RecordToolArgumentRepair(client.ID, repair)
+			}
+			tc.Arguments = repaired
+		}
+		if err != nil {
+			log.Printf("[TOOLS] Unrepairable arguments of %s for client %s: %q", tc.Name, client.Name, truncateArgs(tc.Arguments))
+			if policy == "strict" {
+				problems[i] = "arguments are not valid JSON"
+			}
+			continue
+		}
+		if policy != "validate" && policy != "strict" {
+			continue
+		}
+
+		schema := toolParameters(tools, tc.Name)
+		if schema == nil {
+			continue
+		}
+		if errs := jsonschema.ValidateJSON(schema, []byte(tc.Arguments)); len(errs) > 0 {
+			log.Printf("[TOOLS] Arguments of %s for client %s do not match its parameters: %s", tc.Name, client.Name, strings.Join(errs, "; "))
+			if policy == "strict" {
+				problems[i] = strings.Join(errs, "; ")
+			}
+		}
+	}
+	return problems
+}
+
+// toolParameters returns the parameters schema of the named tool, or nil.
+func toolParameters(tools []providers.Tool, name string) interface{} {
+	for _, t := range tools {
+		if t.Function != nil && t.Function.Name == name {
+			return t.Function.Parameters
+		}
+	}
+	return nil
+}
+
+// rejectedToolCalls describes the calls fixToolArguments rejected, for an
+// error returned to the client; "" when none were.
+func rejectedToolCalls(calls []providers.ToolCall, problems []string) string {
+	var parts []string
+	for i, p := range problems {
+		if p != "" {
+			parts = append(parts, fmt.Sprintf("%s: %s", calls[i].Name, p))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Model produced invalid tool call arguments (" + strings.Join(parts, "; ") + ")"
+}
+
+// toolArgsFeedback is the tool result sent back to the model in gateway mode
+// instead of running a call with rejected arguments.
+func toolArgsFeedback(tc providers.ToolCall, problem string) string {
+	return fmt.Sprintf("Error: invalid arguments for %s: %s. Call the tool again with arguments that are valid JSON matching its parameters.", tc.Name, problem)
+}
+
+// truncateArgs shortens arguments for logging.
+func truncateArgs(s string) string {
+	if len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
@@ -0,0 +1,275 @@
+// Package jsonrepair fixes the almost-JSON that language models, local ones
+// especially, emit for tool call arguments: markdown code fences, prose
+// around the object, single-quoted strings, unquoted keys, trailing commas,
+// comments, Python literals, raw newlines in strings, double-encoded objects
+// and output truncated before its closing brackets.
+package jsonrepair
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrUnrepairable is returned when the input can't be turned into valid JSON.
+var ErrUnrepairable = errors.New("not valid JSON and could not be repaired")
+
+// Repair returns s as valid JSON along with a description of each repair
+// applied, in the order found. Valid JSON is returned unchanged with no
+// repairs; empty input becomes {}. On failure s is returned with
+// ErrUnrepairable.
+func Repair(s string) (string, []string, error) {
+	if json.Valid([]byte(s)) {
+		if inner, ok := doubleEncoded(s); ok {
+			return inner, []string{"decoded double-encoded JSON"}, nil
+		}
+		return s, nil, nil
+	}
+
+	r := &repairer{}
+	t := strings.TrimSpace(s)
+	if t == "" {
+		return "{}", []string{"replaced empty arguments with {}"}, nil
+	}
+	if unfenced, ok := stripFence(t); ok {
+		t = unfenced
+		r.note("removed markdown code fence")
+	}
+	if i := strings.IndexAny(t, "{["); i > 0 {
+		t = t[i:]
+		r.note("removed text before JSON")
+	} else if i < 0 {
+		return s, nil, ErrUnrepairable
+	}
+
+	out := r.rewrite(t)
+	if !json.Valid([]byte(out)) {
+		return s, r.repairs, ErrUnrepairable
+	}
+	return out, r.repairs, nil
+}
+
+// doubleEncoded reports whether s is a JSON string holding a JSON object or
+// array, and returns that.
+func doubleEncoded(s string) (string, bool) {
+	var inner string
+	if json.Unmarshal([]byte(s), &inner) != nil {
+		return "", false
+	}
+	inner = strings.TrimSpace(inner)
+	if (strings.HasPrefix(inner, "{") || strings.HasPrefix(inner, "[")) && json.Valid([]byte(inner)) {
+		return inner, true
+	}
+	return "", false
+}
+
+// stripFence removes a markdown code fence, with or without a language tag,
+// around s.
+func stripFence(s string) (string, bool) {
+	if !strings.HasPrefix(s, "```") {
+		return s, false
+	}
+	s = strings.TrimPrefix(s, "```")
+	if i := strings.IndexByte(s, '\n'); i >= 0 && !strings.ContainsAny(s[:i], "{[") {
+		s = s[i+1:]
+	}
+	if i := strings.LastIndex(s, "```"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), true
+}
+
+type repairer struct {
+	repairs []string
+}
+
+// note records a repair once.
+func (r *repairer) note(repair string) {
+	for _, existing := range r.repairs {
+		if existing == repair {
+			return
+		}
+	}
+	r.repairs = append(r.repairs, repair)
+}
+
+// rewrite re-emits t token by token as strict JSON.
+func (r *repairer) rewrite(t string) string {
+	out := make([]byte, 0, len(t)+8)
+	var stack []byte
+
+	for i := 0; i < len(t); {
+		c := t[i]
+		switch {
+		case c == '"' || c == '\'':
+			str, n, closed, escaped := readString(t[i:], c)
+			if c == '\'' {
+				r.note("converted single-quoted strings")
+			}
+			if escaped {
+				r.note("escaped control characters in strings")
+			}
+			if !closed {
+				r.note("closed unterminated string")
+			}
+			out = append(out, str...)
+			i += n
+
+		case c == '{' || c == '[':
+			stack = append(stack, c)
+			out = append(out, c)
+			i++
+
+		case c == '}' || c == ']':
+			if trimmed, ok := trimTrailingComma(out); ok {
+				out = trimmed
+				r.note("removed trailing commas")
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			out = append(out, c)
+			i++
+			if len(stack) == 0 {
+				if strings.TrimSpace(t[i:]) != "" {
+					r.note("removed text after JSON")
+				}
+				return string(out)
+			}
+
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(t) && strings.IndexByte("0123456789.eE+-", t[j]) >= 0 {
+				j++
+			}
+			out = append(out, t[i:j]...)
+			i = j
+
+		case c == '/' && i+1 < len(t) && (t[i+1] == '/' || t[i+1] == '*'):
+			i += skipComment(t[i:])
+			r.note("removed comments")
+
+		case isIdentStart(c):
+			j := i
+			for j < len(t) && isIdentPart(t[j]) {
+				j++
+			}
+			word := t[i:j]
+			switch word {
+			case "true", "false", "null":
+				out = append(out, word...)
+			case "True", "False":
+				out = append(out, strings.ToLower(word)...)
+				r.note("converted Python literals")
+			case "None":
+				out = append(out, "null"...)
+				r.note("converted Python literals")
+			default:
+				// An unquoted key, or a bare word value.
+				quoted, _ := json.Marshal(word)
+				out = append(out, quoted...)
+				if k := strings.TrimLeft(t[j:], " \t\r\n"); strings.HasPrefix(k, ":") {
+					r.note("quoted object keys")
+				} else {
+					r.note("quoted bare words")
+				}
+			}
+			i = j
+
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+
+	if trimmed, ok := trimTrailingComma(out); ok {
+		out = trimmed
+		r.note("removed trailing commas")
+	}
+	if len(stack) > 0 {
+		r.note("closed unterminated brackets")
+		for k := len(stack) - 1; k >= 0; k-- {
+			if stack[k] == '{' {
+				out = append(out, '}')
+			} else {
+				out = append(out, ']')
+			}
+		}
+	}
+	return string(out)
+}
+
+// readString reads a string starting with quote at s[0] and returns it as a
+// double-quoted JSON string, the bytes consumed, whether it was terminated
+// and whether raw control characters had to be escaped.
+func readString(s string, quote byte) (string, int, bool, bool) {
+	var b strings.Builder
+	b.WriteByte('"')
+	escaped := false
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] == '\'' {
+				// \' is not a JSON escape.
+				b.WriteByte('\'')
+			} else {
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		case c == quote:
+			b.WriteByte('"')
+			return b.String(), i + 1, true, escaped
+		case c == '"':
+			b.WriteString(`\"`)
+		case c == '\n':
+			b.WriteString(`\n`)
+			escaped = true
+		case c == '\r':
+			b.WriteString(`\r`)
+			escaped = true
+		case c == '\t':
+			b.WriteString(`\t`)
+			escaped = true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String(), len(s), false, escaped
+}
+
+// trimTrailingComma removes a comma that is the last non-space byte of out.
+func trimTrailingComma(out []byte) ([]byte, bool) {
+	i := len(out) - 1
+	for i >= 0 && (out[i] == ' ' || out[i] == '\t' || out[i] == '\n' || out[i] == '\r') {
+		i--
+	}
+	if i >= 0 && out[i] == ',' {
+		return append(out[:i], out[i+1:]...), true
+	}
+	return out, false
+}
+
+// skipComment returns the length of the // or /* */ comment at the start of s.
+func skipComment(s string) int {
+	if s[1] == '/' {
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			return i
+		}
+		return len(s)
+	}
+	if i := strings.Index(s[2:], "*/"); i >= 0 {
+		return i + 4
+	}
+	return len(s)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '-' || c == '.'
+}
@@ -0,0 +1,65 @@
+package jsonrepair
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		repairs []string
+	}{
+		{"valid", `{"a": 1}`, `{"a": 1}`, nil},
+		{"empty", "  ", `{}`, []string{"replaced empty arguments with {}"}},
+		{"double encoded", `"{\"a\": 1}"`, `{"a": 1}`, []string{"decoded double-encoded JSON"}},
+		{"fence", "```json\n{\"a\": 1}\n```", `{"a": 1}`, []string{"removed markdown code fence"}},
+		{"fence without tag", "```\n{\"a\": 1}\n```", `{"a": 1}`, []string{"removed markdown code fence"}},
+		{"fence on one line", "```{\"a\": 1}```", `{"a": 1}`, []string{"removed markdown code fence"}},
+		{"surrounding text", `Here you go: {"a": 1} hope that helps`, `{"a": 1}`,
+			[]string{"removed text before JSON", "removed text after JSON"}},
+		{"trailing commas", `{"a": [1, 2,], "b": 3,}`, `{"a": [1, 2], "b": 3}`, []string{"removed trailing commas"}},
+		{"single quotes", `{'a': 'it\'s "x"'}`, `{"a": "it's \"x\""}`, []string{"converted single-quoted strings"}},
+		{"python literals", `{"a": True, "b": False, "c": None}`, `{"a": true, "b": false, "c": null}`,
+			[]string{"converted Python literals"}},
+		{"unquoted keys", `{a: 1, b_2: "x"}`, `{"a": 1, "b_2": "x"}`, []string{"quoted object keys"}},
+		{"bare words", `{"unit": celsius}`, `{"unit": "celsius"}`, []string{"quoted bare words"}},
+		{"comments", "{\"a\": 1, // one\n\"b\": /* two */ 2}", "{\"a\": 1, \n\"b\":  2}", []string{"removed comments"}},
+		{"raw newline", "{\"a\": \"x\ny\"}", `{"a": "x\ny"}`, []string{"escaped control characters in strings"}},
+		{"unterminated object", `{"a": {"b": [1, 2`, `{"a": {"b": [1, 2]}}`, []string{"closed unterminated brackets"}},
+		{"unterminated string", `{"a": "hel`, `{"a": "hel"}`,
+			[]string{"closed unterminated string", "closed unterminated brackets"}},
+		{"unterminated after comma", `{"a": 1,`, `{"a": 1}`,
+			[]string{"removed trailing commas", "closed unterminated brackets"}},
+		{"combined", "```python\n{'city': 'Paris', 'metric': True,}\n```", `{"city": "Paris", "metric": true}`,
+			[]string{"removed markdown code fence", "converted single-quoted strings", "converted Python literals", "removed trailing commas"}},
+	}
+	for _, tt := range tests {
+		got, repairs, err := Repair(tt.in)
+		if err != nil {
+			t.Errorf("%s: Repair(%q) failed: %v", tt.name, tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Repair(%q) = %q; want %q", tt.name, tt.in, got, tt.want)
+		}
+		if !reflect.DeepEqual(repairs, tt.repairs) {
+			t.Errorf("%s: Repair(%q) repairs = %q; want %q", tt.name, tt.in, repairs, tt.repairs)
+		}
+	}
+}
+
+func TestRepairUnrepairable(t *testing.T) {
+	for _, in := range []string{"no json here", `{"a" 1}`, `{"a": 1 2}`} {
+		got, _, err := Repair(in)
+		if !errors.Is(err, ErrUnrepairable) {
+			t.Errorf("Repair(%q) = %q, %v; want ErrUnrepairable", in, got, err)
+		}
+		if got != in {
+			t.Errorf("Repair(%q) returned %q; want the input unchanged", in, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"slices"
 	"time"
 )
 
@@ -77,10 +78,14 @@ func Recovery(next http.Handler) http.Handler {
 	})
 }
 
-func MaxRequestSize(maxSize int64) func(http.Handler) http.Handler {
+// MaxRequestSize limits request bodies to maxSize bytes. Paths in exempt
+// (uploads with a per-client limit) must limit their bodies themselves.
+func MaxRequestSize(maxSize int64, exempt ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+			if !slices.Contains(exempt, r.URL.Path) {
+				r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -30,6 +30,32 @@ type Client struct {
 	// - "pass-through" (default): gateway forwards tool_calls to client, client executes
 	// - "gateway": gateway attempts to execute tools internally
 	ToolMode string `gorm:"type:varchar(20);default:'pass-through'" json:"tool_mode"`
+	// ContextPolicy decides what happens when a chat request doesn't fit the
+	// model's context window:
+	// - "" (default): forward unchanged and let the upstream reject it
+	// - "reject": fail with context_length_exceeded before dispatch
+	// - "truncate": drop the oldest non-system turns
+	// - "summarize": replace the oldest non-system turns with a summary
+	ContextPolicy string `gorm:"type:varchar(20)" json:"context_policy,omitempty"`
+	// SummaryModel writes summaries for the "summarize" policy; empty uses the request's model
+	SummaryModel string `gorm:"type:varchar(200)" json:"summary_model,omitempty"`
+	// ValidateSchema checks responses to chat requests with a JSON response_format
+	// against the schema (or for valid JSON), retrying up to SchemaRetries times
+	// with the errors fed back to the model
+	ValidateSchema bool `gorm:"default:false" json:"validate_schema"`
+	SchemaRetries  int  `gorm:"default:2" json:"schema_retries"`
+	// ToolArgsPolicy decides how malformed tool call arguments are handled:
+	// - "" (default): repair the JSON syntax and log each repair (streamed
+	//   pass-through calls are forwarded as they arrive, unrepaired)
+	// - "off": leave arguments as the model produced them
+	// - "validate": repair, then log violations of the tool's parameters schema
+	// - "strict": repair, then reject arguments that are unrepairable or
+	//   violate the schema
+	ToolArgsPolicy string `gorm:"type:varchar(20)" json:"tool_args_policy,omitempty"`
+	// SafetySettings is a JSON object of Gemini harm categories to block
+	// thresholds (e.g. {"HARM_CATEGORY_HARASSMENT":"BLOCK_ONLY_HIGH"}), sent as
+	// safetySettings on every Gemini request; unset categories use Gemini's default
+	SafetySettings string `gorm:"type:text" json:"safety_settings,omitempty"`
 	// ServerTools enables server-provided tools in addition to client-provided ones
 	ServerTools          bool `gorm:"default:false" json:"server_tools"`
 	RateLimitMinute      int  `gorm:"default:60" json:"rate_limit_minute"`
@@ -40,6 +66,12 @@ type Client struct {
 	QuotaRequestsDay     int  `gorm:"default:1000" json:"quota_requests_day"`
 	MaxInputTokens       int  `gorm:"default:1000000" json:"max_input_tokens"`
 	MaxOutputTokens      int  `gorm:"default:8192" json:"max_output_tokens"`
+	// QuotaAudioSecondsDay limits transcribed plus synthesized audio per day; 0 = unlimited
+	QuotaAudioSecondsDay int `gorm:"default:0" json:"quota_audio_seconds_day"`
+	// MaxAudioUploadMB limits audio files sent for transcription
+	MaxAudioUploadMB int `gorm:"default:25" json:"max_audio_upload_mb"`
+	// QuotaImagesDay limits generated and edited images per day; 0 = unlimited
+	QuotaImagesDay int `gorm:"default:0" json:"quota_images_day"`
 	// LastSeen tracks the last time this client made a request (used for "active" status)
 	LastSeen  time.Time `json:"last_seen"`
 	CreatedAt time.Time `json:"created_at"`
@@ -47,19 +79,98 @@ type Client struct {
 }
 
 type RequestLog struct {
-	ID           int64     `gorm:"primaryKey;autoIncrement" json:"id"`
-	ClientID     string    `gorm:"type:varchar(36);index" json:"client_id"`
-	Model        string    `gorm:"type:varchar(100)" json:"model"`
-	StatusCode   int       `json:"status_code"`
-	InputTokens  int       `gorm:"default:0" json:"input_tokens"`
-	OutputTokens int       `gorm:"default:0" json:"output_tokens"`
-	LatencyMs    int       `json:"latency_ms"`
-	ErrorMessage string    `gorm:"type:text" json:"error_message"`
-	RequestBody  string    `gorm:"type:text" json:"request_body"`
-	IsStreaming  bool      `gorm:"default:false" json:"is_streaming"`
-	HasTools     bool      `gorm:"default:false" json:"has_tools"`
-	ToolNames    string    `gorm:"type:varchar(500)" json:"tool_names"`
-	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+	ID              int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	ClientID        string `gorm:"type:varchar(36);index" json:"client_id"`
+	Model           string `gorm:"type:varchar(100)" json:"model"`
+	StatusCode      int    `json:"status_code"`
+	InputTokens     int    `gorm:"default:0" json:"input_tokens"`
+	OutputTokens    int    `gorm:"default:0" json:"output_tokens"`
+	ReasoningTokens int    `gorm:"default:0" json:"reasoning_tokens"`
+	LatencyMs       int    `json:"latency_ms"`
+	ErrorMessage    string `gorm:"type:text" json:"error_message"`
+	RequestBody     string `gorm:"type:text" json:"request_body"`
+	IsStreaming     bool   `gorm:"default:false" json:"is_streaming"`
+	HasTools        bool   `gorm:"default:false" json:"has_tools"`
+	ToolNames       string `gorm:"type:varchar(500)" json:"tool_names"`
+	TokensEstimated bool   `gorm:"default:false" json:"tokens_estimated"`
+	// AudioSeconds is the length of transcribed or synthesized audio
+	AudioSeconds float64 `gorm:"default:0" json:"audio_seconds"`
+	// ImageCount is the number of images generated or edited
+	ImageCount int `gorm:"default:0" json:"image_count"`
+	// CacheCreationTokens and CacheReadTokens are input tokens written to and
+	// read from the provider's prompt cache, not included in InputTokens
+	CacheCreationTokens int       `gorm:"default:0" json:"cache_creation_tokens"`
+	CacheReadTokens     int       `gorm:"default:0" json:"cache_read_tokens"`
+	CreatedAt           time.Time `gorm:"index" json:"created_at"`
+}
+
+// File is a file uploaded through /v1/files or written by a batch. The
+// content lives on disk in the batch directory under the file's ID.
+type File struct {
+	ID        string    `gorm:"primaryKey;type:varchar(40)" json:"id"`
+	ClientID  string    `gorm:"type:varchar(36);index" json:"client_id"`
+	Filename  string    `gorm:"type:varchar(255)" json:"filename"`
+	Purpose   string    `gorm:"type:varchar(30)" json:"purpose"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Batch is an OpenAI-style batch job: the requests in an input file, run in
+// the background with results written to output and error files.
+type Batch struct {
+	ID               string `gorm:"primaryKey;type:varchar(40)" json:"id"`
+	ClientID         string `gorm:"type:varchar(36);index" json:"client_id"`
+	Endpoint         string `gorm:"type:varchar(100)" json:"endpoint"`
+	InputFileID      string `gorm:"type:varchar(40)" json:"input_file_id"`
+	OutputFileID     string `gorm:"type:varchar(40)" json:"output_file_id"`
+	ErrorFileID      string `gorm:"type:varchar(40)" json:"error_file_id"`
+	CompletionWindow string `gorm:"type:varchar(10)" json:"completion_window"`
+	// Status is validating, in_progress, finalizing, completed, failed,
+	// expired, cancelling or cancelled
+	Status string `gorm:"type:varchar(20);index" json:"status"`
+	// Metadata is the client's metadata object, as JSON
+	Metadata string `gorm:"type:text" json:"metadata"`
+	// Errors is a JSON array of {code, message, line} validation errors
+	Errors            string `gorm:"type:text" json:"errors"`
+	TotalRequests     int    `json:"total_requests"`
+	CompletedRequests int    `json:"completed_requests"`
+	FailedRequests    int    `json:"failed_requests"`
+	// NativeID is the upstream batch ID when the provider's own batch API
+	// runs the job; empty when the gateway runs the requests itself
+	NativeID     string     `gorm:"type:varchar(100)" json:"native_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	InProgressAt *time.Time `json:"in_progress_at"`
+	FinalizingAt *time.Time `json:"finalizing_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	FailedAt     *time.Time `json:"failed_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	ExpiredAt    *time.Time `json:"expired_at"`
+	CancellingAt *time.Time `json:"cancelling_at"`
+	CancelledAt  *time.Time `json:"cancelled_at"`
+}
+
+// Job is an asynchronous chat completion: submitted, run in the background
+// and polled for (or delivered to a webhook) by the client.
+type Job struct {
+	ID       string `gorm:"primaryKey;type:varchar(40)" json:"id"`
+	ClientID string `gorm:"type:varchar(36);index" json:"client_id"`
+	// Status is queued, in_progress, completed, failed or cancelled
+	Status string `gorm:"type:varchar(20);index" json:"status"`
+	Model  string `gorm:"type:varchar(100)" json:"model"`
+	// Request is the chat completions request body
+	Request    string `gorm:"type:text" json:"request"`
+	WebhookURL string `gorm:"type:varchar(500)" json:"webhook_url"`
+	// NativeID is the upstream job ID when the provider's queue API runs the
+	// request; empty when the gateway calls the backend itself
+	NativeID string `gorm:"type:varchar(100)" json:"native_id,omitempty"`
+	// Result is the chat.completion response, as JSON
+	Result       string     `gorm:"type:text" json:"result"`
+	StatusCode   int        `json:"status_code"`
+	ErrorMessage string     `gorm:"type:text" json:"error_message"`
+	CreatedAt    time.Time  `json:"created_at"`
+	StartedAt    *time.Time `json:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	CancelledAt  *time.Time `json:"cancelled_at"`
 }
 
 type DailyUsage struct {
@@ -69,6 +180,11 @@ type DailyUsage struct {
 	TotalRequests     int       `gorm:"default:0" json:"total_requests"`
 	TotalInputTokens  int       `gorm:"default:0" json:"total_input_tokens"`
 	TotalOutputTokens int       `gorm:"default:0" json:"total_output_tokens"`
+	TotalAudioSeconds float64   `gorm:"default:0" json:"total_audio_seconds"`
+	TotalImages       int       `gorm:"default:0" json:"total_images"`
+	// Prompt cache usage, on top of TotalInputTokens
+	TotalCacheCreationTokens int `gorm:"default:0" json:"total_cache_creation_tokens"`
+	TotalCacheReadTokens     int `gorm:"default:0" json:"total_cache_read_tokens"`
 }
 
 type AdminSession struct {
@@ -85,6 +201,9 @@ type Stats struct {
 	ActiveClients          int64   `json:"active_clients"`
 	TotalClients           int64   `json:"total_clients"`
 	ErrorRate              float64 `json:"error_rate"`
+	// Prompt cache usage today, on top of TotalInputTokensToday
+	TotalCacheCreationTokensToday int64 `json:"total_cache_creation_tokens_today"`
+	TotalCacheReadTokensToday     int64 `json:"total_cache_read_tokens_today"`
 }
 
 type ClientStats struct {
@@ -98,6 +217,10 @@ type ClientStats struct {
 	OutputTokensLimit int     `json:"output_tokens_limit"`
 	MaxInputTokens    int     `json:"max_input_tokens"`
 	MaxOutputTokens   int     `json:"max_output_tokens"`
+	AudioSecondsToday float64 `json:"audio_seconds_today"`
+	AudioSecondsLimit int     `json:"audio_seconds_limit"`
+	ImagesToday       int     `json:"images_today"`
+	ImagesLimit       int     `json:"images_limit"`
 	ErrorRate         float64 `json:"error_rate"`
 }
 
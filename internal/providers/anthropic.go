@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"ai-gateway/internal/config"
@@ -66,6 +68,124 @@ func (p *AnthropicProvider) ChatCompletionStream(req *ChatRequest) (*http.Respon
 	return client.Do(httpReq)
 }
 
+// SubmitBatch starts a Message Batch with one Messages request per item.
+func (p *AnthropicProvider) SubmitBatch(items []BatchItem) (string, error) {
+	requests := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		requests[i] = map[string]interface{}{
+			"custom_id": item.CustomID,
+			"params":    json.RawMessage(p.buildRequestBody(item.Request, false)),
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"requests": requests})
+
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+"/messages/batches", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	respBody, err := doBatchRequest(client, httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to create batch: %w", err)
+	}
+	var batch struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(respBody, &batch)
+	return batch.ID, nil
+}
+
+func (p *AnthropicProvider) PollBatch(id string) (*BatchStatus, error) {
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+
+	httpReq, err := http.NewRequest("GET", p.cfg.BaseURL+"/messages/batches/"+id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	body, err := doBatchRequest(client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	var batch struct {
+		ProcessingStatus string `json:"processing_status"`
+		ResultsURL       string `json:"results_url"`
+		RequestCounts    struct {
+			Succeeded int `json:"succeeded"`
+			Errored   int `json:"errored"`
+			Canceled  int `json:"canceled"`
+			Expired   int `json:"expired"`
+		} `json:"request_counts"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("failed to parse batch: %w", err)
+	}
+
+	counts := batch.RequestCounts
+	status := &BatchStatus{Completed: counts.Succeeded, Failed: counts.Errored + counts.Canceled + counts.Expired}
+	if batch.ProcessingStatus != "ended" || batch.ResultsURL == "" {
+		return status, nil
+	}
+	status.Done = true
+
+	httpReq, err = http.NewRequest("GET", batch.ResultsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	content, err := doBatchRequest(client, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download batch results: %w", err)
+	}
+	for _, line := range jsonLines(content) {
+		var result struct {
+			CustomID string `json:"custom_id"`
+			Result   struct {
+				Type    string          `json:"type"`
+				Message json.RawMessage `json:"message"`
+				Error   struct {
+					Error struct {
+						Message string `json:"message"`
+					} `json:"error"`
+				} `json:"error"`
+			} `json:"result"`
+		}
+		if json.Unmarshal(line, &result) != nil {
+			continue
+		}
+		r := BatchResult{CustomID: result.CustomID}
+		switch result.Result.Type {
+		case "succeeded":
+			r.StatusCode = http.StatusOK
+			r.Body = result.Result.Message
+		case "errored":
+			r.StatusCode = http.StatusBadRequest
+			r.Error = result.Result.Error.Error.Message
+		default:
+			r.Error = "request " + result.Result.Type
+		}
+		status.Results = append(status.Results, r)
+	}
+	return status, nil
+}
+
+func (p *AnthropicProvider) CancelBatch(id string) error {
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+"/messages/batches/"+id+"/cancel", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	_, err = doBatchRequest(client, httpReq)
+	return err
+}
+
 func (p *AnthropicProvider) setHeaders(req *http.Request) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("anthropic-version", "2023-06-01")
@@ -74,25 +194,140 @@ func (p *AnthropicProvider) setHeaders(req *http.Request) {
 	}
 }
 
+// anthropicMessages renders normalized turns as Anthropic messages with
+// content blocks: assistant tool calls as tool_use blocks, tool results as
+// tool_result blocks, which lead their user message as the API requires.
+// Thinking blocks are replayed only when thinking is enabled; otherwise the
+// API has no use for them.
+func anthropicMessages(turns []turn, thinking bool) []map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(turns))
+	for _, t := range turns {
+		var results, blocks []map[string]interface{}
+		for _, part := range t.Parts {
+			var block map[string]interface{}
+			switch {
+			case part.Thinking != nil:
+				if thinking {
+					// Thinking blocks cannot carry a cache breakpoint.
+					blocks = append(blocks, anthropicThinkingBlock(part.Thinking))
+				}
+				continue
+			case part.ToolCall != nil:
+				block = map[string]interface{}{
+					"type":  "tool_use",
+					"id":    part.ToolCall.ID,
+					"name":  part.ToolCall.Name,
+					"input": ToolArgumentsObject(part.ToolCall.Arguments),
+				}
+				blocks = append(blocks, block)
+			case part.Result != nil:
+				block = map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": part.Result.CallID,
+					"content":     part.Result.Content,
+				}
+				results = append(results, block)
+			default:
+				block = map[string]interface{}{"type": "text", "text": part.Text}
+				blocks = append(blocks, block)
+			}
+			if part.CacheControl != nil {
+				block["cache_control"] = part.CacheControl
+			}
+		}
+		messages = append(messages, map[string]interface{}{"role": t.Role, "content": append(results, blocks...)})
+	}
+	return messages
+}
+
+// anthropicThinkingBlock renders a thinking block exactly as Anthropic sent it.
+func anthropicThinkingBlock(tb *ThinkingBlock) map[string]interface{} {
+	if tb.Type == "redacted_thinking" {
+		return map[string]interface{}{"type": "redacted_thinking", "data": tb.Data}
+	}
+	return map[string]interface{}{"type": "thinking", "thinking": tb.Thinking, "signature": tb.Signature}
+}
+
+// anthropicSystem renders system parts as text blocks, keeping the client's
+// cache breakpoints.
+func anthropicSystem(parts []turnPart) []map[string]interface{} {
+	blocks := make([]map[string]interface{}, len(parts))
+	for i, part := range parts {
+		blocks[i] = map[string]interface{}{"type": "text", "text": part.Text}
+		if part.CacheControl != nil {
+			blocks[i]["cache_control"] = part.CacheControl
+		}
+	}
+	return blocks
+}
+
+// anthropicMaxBreakpoints is the most cache_control blocks Anthropic accepts
+// in one request.
+const anthropicMaxBreakpoints = 4
+
+// setCacheBreakpoints marks the auto blocks as ephemeral cache breakpoints as
+// far as Anthropic's limit allows next to those the client set, then drops the
+// client's earliest breakpoints while there are still too many, since later
+// ones cover more of the prompt. blocks are all blocks of the request in
+// prompt order (tools, system, messages).
+func setCacheBreakpoints(blocks, auto []map[string]interface{}) {
+	count := 0
+	// Auto breakpoints precede the client's, and a 1h breakpoint may not
+	// follow a 5m one.
+	control := &CacheControl{Type: "ephemeral"}
+	for _, b := range blocks {
+		if cc, ok := b["cache_control"].(*CacheControl); ok {
+			count++
+			if cc.TTL == "1h" {
+				control.TTL = "1h"
+			}
+		}
+	}
+	for _, b := range auto {
+		if count < anthropicMaxBreakpoints && b["cache_control"] == nil {
+			b["cache_control"] = control
+			count++
+		}
+	}
+	for _, b := range blocks {
+		if count <= anthropicMaxBreakpoints {
+			break
+		}
+		if b["cache_control"] != nil {
+			delete(b, "cache_control")
+			count--
+		}
+	}
+}
+
 func (p *AnthropicProvider) buildRequestBody(req *ChatRequest, stream bool) []byte {
 	model := req.Model
 	if model == "" {
 		model = p.cfg.DefaultModel
 	}
 
-	// Anthropic separates system message from the messages array
-	var system string
-	messages := make([]map[string]interface{}, 0)
-	for _, m := range req.Messages {
-		if m.Role == "system" {
-			system = m.Content
-			continue
-		}
-		msg := map[string]interface{}{"role": m.Role, "content": m.Content}
-		if m.Role == "tool" && m.ToolCallID != "" {
-			msg["tool_use_id"] = m.ToolCallID
-		}
-		messages = append(messages, msg)
+	budget, thinking := req.ReasoningBudget()
+	thinking = thinking && budget > 0
+
+	// Anthropic separates the system prompt from the messages array
+	systemParts, turns := normalizeMessages(req.Messages)
+	system := anthropicSystem(systemParts)
+	messages := anthropicMessages(turns, thinking)
+
+	// Without a native JSON mode, an object schema is enforced by forcing a
+	// tool whose input is the answer, unless the request brings its own tools
+	// or uses extended thinking (which can't force a tool); otherwise JSON is
+	// asked for in the system prompt.
+	schema, wantsJSON := ResponseSchema(req.ResponseFormat)
+	forceJSONTool := schema != nil && schema["type"] == "object" && len(req.Tools) == 0 && !thinking
+	// The gateway's system breakpoint goes before the JSON instruction, so
+	// requests with different response formats still share the cache.
+	var auto []map[string]interface{}
+	if p.cfg.PromptCaching != "off" && len(system) > 0 {
+		auto = append(auto, system[len(system)-1])
+	}
+	if wantsJSON && !forceJSONTool {
+		system = append(system, map[string]interface{}{"type": "text", "text": jsonInstruction(schema)})
 	}
 
 	body := map[string]interface{}{
@@ -101,17 +336,37 @@ func (p *AnthropicProvider) buildRequestBody(req *ChatRequest, stream bool) []by
 		"max_tokens": 4096,
 	}
 
-	if system != "" {
+	if len(system) > 0 {
 		body["system"] = system
 	}
-	if req.MaxTokens > 0 {
-		body["max_tokens"] = req.MaxTokens
+	if limit := req.OutputTokenLimit(); limit > 0 {
+		body["max_tokens"] = limit
+	}
+	// Extended thinking fixes sampling: ValidateRequest rejects a non-default
+	// temperature or top_p, and the defaults are left out.
+	if req.Temperature != nil && !thinking {
+		body["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil && !thinking {
+		body["top_p"] = *req.TopP
+	}
+	if len(req.Stop) > 0 {
+		body["stop_sequences"] = req.Stop
+	}
+	if req.User != "" {
+		body["metadata"] = map[string]interface{}{"user_id": req.User}
 	}
-	if req.Temperature > 0 {
-		body["temperature"] = req.Temperature
+	if thinking {
+		budget = max(budget, anthropicMinThinkingBudget)
+		body["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": budget}
+		// max_tokens must exceed the budget; keep the requested answer allowance on top.
+		if maxTokens := body["max_tokens"].(int); maxTokens <= budget {
+			body["max_tokens"] = budget + maxTokens
+		}
 	}
+	var tools []map[string]interface{}
 	if len(req.Tools) > 0 {
-		tools := make([]map[string]interface{}, len(req.Tools))
+		tools = make([]map[string]interface{}, len(req.Tools))
 		for i, tool := range req.Tools {
 			if tool.Function != nil {
 				tools[i] = map[string]interface{}{
@@ -119,10 +374,37 @@ func (p *AnthropicProvider) buildRequestBody(req *ChatRequest, stream bool) []by
 					"description":  tool.Function.Description,
 					"input_schema": tool.Function.Parameters,
 				}
+				if tool.CacheControl != nil {
+					tools[i]["cache_control"] = tool.CacheControl
+				}
 			}
 		}
 		body["tools"] = tools
+		if tc := anthropicToolChoice(req.ToolChoice, req.ParallelToolCalls, thinking); tc != nil {
+			body["tool_choice"] = tc
+		}
+	}
+	if forceJSONTool {
+		tools = []map[string]interface{}{{
+			"name":         jsonResponseTool,
+			"description":  "Respond with the final answer as this tool's input.",
+			"input_schema": schema,
+		}}
+		body["tools"] = tools
+		body["tool_choice"] = map[string]interface{}{"type": "tool", "name": jsonResponseTool}
 	}
+
+	// Tool definitions come first in the cached prefix; a breakpoint after
+	// them keeps them cached when the system prompt changes.
+	blocks := append([]map[string]interface{}{}, tools...)
+	if p.cfg.PromptCaching != "off" && len(tools) > 0 && tools[len(tools)-1] != nil {
+		auto = append(auto, tools[len(tools)-1])
+	}
+	blocks = append(blocks, system...)
+	for _, m := range messages {
+		blocks = append(blocks, m["content"].([]map[string]interface{})...)
+	}
+	setCacheBreakpoints(blocks, auto)
 	if stream {
 		body["stream"] = true
 	}
@@ -131,6 +413,70 @@ func (p *AnthropicProvider) buildRequestBody(req *ChatRequest, stream bool) []by
 	return data
 }
 
+// anthropicMinThinkingBudget is the smallest budget_tokens Anthropic accepts.
+const anthropicMinThinkingBudget = 1024
+
+// anthropicToolChoice maps an OpenAI tool_choice onto Anthropic's
+// {"type":"auto"|"any"|"tool"|"none"} object. parallel_tool_calls=false becomes
+// disable_parallel_tool_use, which Anthropic only carries inside tool_choice.
+// Extended thinking cannot force a tool, so with thinking enabled a forced
+// choice, which ValidateRequest rejects from clients, falls back to auto.
+func anthropicToolChoice(choice *ToolChoice, parallel *bool, thinking bool) map[string]interface{} {
+	if choice == nil && parallel == nil {
+		return nil
+	}
+	tc := map[string]interface{}{"type": "auto"}
+	if choice != nil {
+		switch {
+		case choice.Mode == ToolChoiceNone:
+			return map[string]interface{}{"type": "none"}
+		case thinking && (choice.Mode == ToolChoiceRequired || choice.Mode == ToolChoiceFunction):
+			log.Printf("[anthropic] tool_choice %q cannot be combined with extended thinking, using auto", choice.Mode)
+		case choice.Mode == ToolChoiceRequired:
+			tc["type"] = "any"
+		case choice.Mode == ToolChoiceFunction:
+			tc["type"] = "tool"
+			tc["name"] = choice.Function
+		}
+	}
+	if parallel != nil && !*parallel {
+		tc["disable_parallel_tool_use"] = true
+	}
+	return tc
+}
+
+// ValidateRequest rejects sampling options the Messages API has no equivalent
+// for. Penalties of exactly 0 are no-ops and accepted; seed is best-effort in
+// OpenAI's API too, so it is dropped rather than rejected. n > 1 is fanned out
+// by the gateway.
+func (p *AnthropicProvider) ValidateRequest(req *ChatRequest) error {
+	if req.PresencePenalty != nil && *req.PresencePenalty != 0 {
+		return unsupportedParam(p, "presence_penalty")
+	}
+	if req.FrequencyPenalty != nil && *req.FrequencyPenalty != 0 {
+		return unsupportedParam(p, "frequency_penalty")
+	}
+	if req.Logprobs {
+		return unsupportedParam(p, "logprobs")
+	}
+	if len(req.LogitBias) > 0 {
+		return unsupportedParam(p, "logit_bias")
+	}
+	if budget, ok := req.ReasoningBudget(); ok && budget > 0 {
+		// Extended thinking fixes sampling and cannot be combined with a forced tool.
+		if req.Temperature != nil && *req.Temperature != 1 {
+			return fmt.Errorf("temperature cannot be combined with extended thinking on the %s provider", p.Name())
+		}
+		if req.TopP != nil {
+			return fmt.Errorf("top_p cannot be combined with extended thinking on the %s provider", p.Name())
+		}
+		if tc := req.ToolChoice; tc != nil && (tc.Mode == ToolChoiceRequired || tc.Mode == ToolChoiceFunction) {
+			return fmt.Errorf("tool_choice %q cannot be combined with extended thinking on the %s provider", tc.Mode, p.Name())
+		}
+	}
+	return nil
+}
+
 func (p *AnthropicProvider) ParseResponse(body []byte) (string, int, int, error) {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(body, &resp); err != nil {
@@ -138,12 +484,7 @@ func (p *AnthropicProvider) ParseResponse(body []byte) (string, int, int, error)
 	}
 
 	// Anthropic response: {"content": [{"type":"text","text":"..."}], "usage": {...}}
-	text := ""
-	if content, ok := resp["content"].([]interface{}); ok && len(content) > 0 {
-		if block, ok := content[0].(map[string]interface{}); ok {
-			text, _ = block["text"].(string)
-		}
-	}
+	text := anthropicAnswerText(resp)
 
 	inputTokens, outputTokens := 0, 0
 	if usage, ok := resp["usage"].(map[string]interface{}); ok {
@@ -158,38 +499,164 @@ func (p *AnthropicProvider) ParseResponse(body []byte) (string, int, int, error)
 	return text, inputTokens, outputTokens, nil
 }
 
-func (p *AnthropicProvider) ParseStreamChunk(data []byte) (string, int, int) {
-	var event map[string]interface{}
-	if err := json.Unmarshal(data, &event); err != nil {
-		return "", 0, 0
-	}
+func (p *AnthropicProvider) StreamEvents(resp *http.Response) *EventStream {
+	return newSSEStream(resp.Body, newAnthropicStreamDecoder())
+}
 
-	text := ""
-	eventType, _ := event["type"].(string)
+type anthropicStreamUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
 
-	switch eventType {
-	case "content_block_delta":
-		if delta, ok := event["delta"].(map[string]interface{}); ok {
-			text, _ = delta["text"].(string)
+// newAnthropicStreamDecoder decodes Messages API stream events. Anthropic numbers
+// content blocks across text and tool_use, so tool_use blocks are renumbered to
+// contiguous tool call indices. Thinking blocks are collected with their
+// signature and yielded whole when they end.
+func newAnthropicStreamDecoder() streamDecoder {
+	toolIndex := make(map[int]int)
+	var thinking strings.Builder
+	thinkingBlocks := make(map[int]*ThinkingBlock)
+	// jsonBlock is the index of an emulated JSON response's tool_use block,
+	// streamed as text; -1 if there is none.
+	jsonBlock := -1
+
+	return func(eventName string, data []byte) []StreamEvent {
+		var event struct {
+			Type    string `json:"type"`
+			Index   int    `json:"index"`
+			Message struct {
+				Usage *anthropicStreamUsage `json:"usage"`
+			} `json:"message"`
+			ContentBlock struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+				Name string `json:"name"`
+				Data string `json:"data"`
+			} `json:"content_block"`
+			Delta struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				Thinking    string `json:"thinking"`
+				Signature   string `json:"signature"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage *anthropicStreamUsage `json:"usage"`
+			Error json.RawMessage       `json:"error"`
 		}
-	case "message_delta":
-		// Final chunk may contain usage
-	}
-
-	inputTokens, outputTokens := 0, 0
-	if usage, ok := event["usage"].(map[string]interface{}); ok {
-		if pt, ok := usage["input_tokens"].(float64); ok {
-			inputTokens = int(pt)
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil
 		}
-		if ct, ok := usage["output_tokens"].(float64); ok {
-			outputTokens = int(ct)
+		if event.Type == "" {
+			event.Type = eventName
 		}
-	}
 
-	return text, inputTokens, outputTokens
+		switch event.Type {
+		case "message_start":
+			if u := event.Message.Usage; u != nil {
+				return []StreamEvent{{
+					Type:                StreamEventUsage,
+					InputTokens:         u.InputTokens,
+					OutputTokens:        u.OutputTokens,
+					CacheCreationTokens: u.CacheCreationInputTokens,
+					CacheReadTokens:     u.CacheReadInputTokens,
+				}}
+			}
+		case "content_block_start":
+			switch event.ContentBlock.Type {
+			case "thinking":
+				thinkingBlocks[event.Index] = &ThinkingBlock{Type: "thinking"}
+				return nil
+			case "redacted_thinking":
+				thinkingBlocks[event.Index] = &ThinkingBlock{Type: "redacted_thinking", Data: event.ContentBlock.Data}
+				return nil
+			}
+			if event.ContentBlock.Type == "tool_use" && event.ContentBlock.Name == jsonResponseTool {
+				jsonBlock = event.Index
+				return nil
+			}
+			if event.ContentBlock.Type == "tool_use" {
+				idx := len(toolIndex)
+				toolIndex[event.Index] = idx
+				return []StreamEvent{{Type: StreamEventToolCall, ToolCall: &StreamToolCall{
+					ID:    event.ContentBlock.ID,
+					Name:  event.ContentBlock.Name,
+					Index: idx,
+				}}}
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				if event.Delta.Text != "" {
+					return []StreamEvent{{Type: StreamEventText, Text: event.Delta.Text}}
+				}
+			case "thinking_delta":
+				if event.Delta.Thinking != "" {
+					thinking.WriteString(event.Delta.Thinking)
+					if tb := thinkingBlocks[event.Index]; tb != nil {
+						tb.Thinking += event.Delta.Thinking
+					}
+					return []StreamEvent{{Type: StreamEventReasoning, Text: event.Delta.Thinking}}
+				}
+			case "signature_delta":
+				if tb := thinkingBlocks[event.Index]; tb != nil {
+					tb.Signature += event.Delta.Signature
+				}
+			case "input_json_delta":
+				if event.Index == jsonBlock && event.Delta.PartialJSON != "" {
+					return []StreamEvent{{Type: StreamEventText, Text: event.Delta.PartialJSON}}
+				}
+				if idx, ok := toolIndex[event.Index]; ok && event.Delta.PartialJSON != "" {
+					return []StreamEvent{{Type: StreamEventToolCall, ToolCall: &StreamToolCall{
+						Arguments: event.Delta.PartialJSON,
+						Index:     idx,
+					}}}
+				}
+			}
+		case "content_block_stop":
+			if tb := thinkingBlocks[event.Index]; tb != nil {
+				delete(thinkingBlocks, event.Index)
+				return []StreamEvent{{Type: StreamEventThinkingBlock, ThinkingBlock: tb}}
+			}
+		case "message_delta":
+			var events []StreamEvent
+			if u := event.Usage; u != nil {
+				events = append(events, StreamEvent{
+					Type:            StreamEventUsage,
+					InputTokens:     u.InputTokens,
+					OutputTokens:    u.OutputTokens,
+					ReasoningTokens: estimateReasoningTokens(thinking.String()),
+				})
+			}
+			if reason := event.Delta.StopReason; reason != "" {
+				if reason == "tool_use" && jsonBlock >= 0 && len(toolIndex) == 0 {
+					reason = "end_turn"
+				}
+				events = append(events, StreamEvent{Type: StreamEventFinish, FinishReason: mapAnthropicStopReason(reason)})
+			}
+			return events
+		case "error":
+			if ev, ok := streamErrorEvent(event.Error); ok {
+				return []StreamEvent{ev}
+			}
+		}
+		return nil
+	}
 }
 
-func (p *AnthropicProvider) StreamDataPrefix() string { return "data: " }
+// mapAnthropicStopReason converts an Anthropic stop_reason to an OpenAI finish_reason.
+func mapAnthropicStopReason(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
 
 func (p *AnthropicProvider) Models() []string     { return p.cfg.AllowedModels }
 func (p *AnthropicProvider) DefaultModel() string { return p.cfg.DefaultModel }
@@ -233,6 +700,91 @@ func (p *AnthropicProvider) FetchModels() ([]string, error) {
 	}, nil
 }
 
+// anthropicBlockText concatenates the field of every content block of the given
+// type. Thinking blocks precede text blocks, so the answer is not content[0].
+func anthropicBlockText(resp map[string]interface{}, blockType, field string) string {
+	content, _ := resp["content"].([]interface{})
+	var text strings.Builder
+	for _, raw := range content {
+		if block, ok := raw.(map[string]interface{}); ok && block["type"] == blockType {
+			t, _ := block[field].(string)
+			text.WriteString(t)
+		}
+	}
+	return text.String()
+}
+
+// anthropicThinkingBlocks returns the response's thinking and
+// redacted_thinking blocks, in order.
+func anthropicThinkingBlocks(resp map[string]interface{}) []ThinkingBlock {
+	content, _ := resp["content"].([]interface{})
+	var blocks []ThinkingBlock
+	for _, raw := range content {
+		block, _ := raw.(map[string]interface{})
+		switch block["type"] {
+		case "thinking":
+			thinking, _ := block["thinking"].(string)
+			signature, _ := block["signature"].(string)
+			blocks = append(blocks, ThinkingBlock{Type: "thinking", Thinking: thinking, Signature: signature})
+		case "redacted_thinking":
+			data, _ := block["data"].(string)
+			blocks = append(blocks, ThinkingBlock{Type: "redacted_thinking", Data: data})
+		}
+	}
+	return blocks
+}
+
+// anthropicAnswerText is the response text: the input of an emulated JSON
+// response's tool_use block if there is one, else the text blocks.
+func anthropicAnswerText(resp map[string]interface{}) string {
+	content, _ := resp["content"].([]interface{})
+	for _, raw := range content {
+		if block, ok := raw.(map[string]interface{}); ok && block["type"] == "tool_use" && block["name"] == jsonResponseTool {
+			data, _ := json.Marshal(block["input"])
+			return string(data)
+		}
+	}
+	return anthropicBlockText(resp, "text", "text")
+}
+
+func (p *AnthropicProvider) ParseChoices(body []byte) ([]Choice, error) {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	toolCalls, _ := p.ParseToolCalls(body)
+	stopReason, _ := resp["stop_reason"].(string)
+	if stopReason == "tool_use" && len(toolCalls) == 0 {
+		// Only the emulated JSON response tool was called.
+		stopReason = "end_turn"
+	}
+	return []Choice{{
+		Text:           anthropicAnswerText(resp),
+		Reasoning:      anthropicBlockText(resp, "thinking", "thinking"),
+		ThinkingBlocks: anthropicThinkingBlocks(resp),
+		ToolCalls:      toolCalls,
+		FinishReason:   mapAnthropicStopReason(stopReason),
+	}}, nil
+}
+
+// ParseUsageDetails estimates reasoning tokens from the thinking text, since
+// Anthropic counts thinking in output_tokens without breaking it out, and
+// reads prompt cache usage.
+func (p *AnthropicProvider) ParseUsageDetails(body []byte) UsageDetails {
+	var resp map[string]interface{}
+	json.Unmarshal(body, &resp)
+	details := UsageDetails{ReasoningTokens: estimateReasoningTokens(anthropicBlockText(resp, "thinking", "thinking"))}
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		if n, ok := usage["cache_creation_input_tokens"].(float64); ok {
+			details.CacheCreationTokens = int(n)
+		}
+		if n, ok := usage["cache_read_input_tokens"].(float64); ok {
+			details.CacheReadTokens = int(n)
+		}
+	}
+	return details
+}
+
 func (p *AnthropicProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(body, &resp); err != nil {
@@ -251,7 +803,7 @@ func (p *AnthropicProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 			continue
 		}
 
-		if block["type"] == "tool_use" {
+		if block["type"] == "tool_use" && block["name"] != jsonResponseTool {
 			id, _ := block["id"].(string)
 			name, _ := block["name"].(string)
 			input, _ := block["input"].(map[string]interface{})
@@ -267,7 +819,3 @@ func (p *AnthropicProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 
 	return toolCalls, nil
 }
-
-func (p *AnthropicProvider) ParseStreamToolCall(data []byte) (interface{}, string) {
-	return nil, ""
-}
@@ -0,0 +1,35 @@
+package providers
+
+import (
+	"errors"
+)
+
+// ErrAsyncUnsupported is returned by SubmitAsync and CancelRequest when a
+// provider instance has no job queue API. Callers run the request themselves
+// instead.
+var ErrAsyncUnsupported = errors.New("asynchronous jobs not supported by this backend")
+
+// AsyncRunner is implemented by providers with a queue-based job API (RunPod
+// serverless), where a request is submitted and its result polled for rather
+// than holding a connection open while it waits for a worker.
+type AsyncRunner interface {
+	// SubmitAsync queues a non-streaming request and returns the upstream
+	// job id.
+	SubmitAsync(req *ChatRequest) (string, error)
+	// PollAsync reports an upstream job's state, with its result once done.
+	PollAsync(id string) (*AsyncResult, error)
+	// CancelRequest cancels a queued or running upstream job.
+	CancelRequest(id string) error
+}
+
+// AsyncResult is the state of an upstream job. Once Done, either StatusCode
+// and Body hold the provider's native chat response, or Error says why there
+// is none.
+type AsyncResult struct {
+	Done bool
+	// Status is the upstream's own job status, for logging.
+	Status     string
+	StatusCode int
+	Body       []byte
+	Error      string
+}
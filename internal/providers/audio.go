@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// Transcriber is implemented by providers with OpenAI's
+// /audio/transcriptions and /audio/translations endpoints (OpenAI, vLLM, and
+// local whisper servers such as faster-whisper-server or whisper.cpp's).
+type Transcriber interface {
+	Transcribe(req *TranscriptionRequest) (*AudioResponse, error)
+}
+
+// SpeechSynthesizer is implemented by providers with OpenAI's /audio/speech
+// endpoint.
+type SpeechSynthesizer interface {
+	Speech(req *SpeechRequest) (*AudioResponse, error)
+}
+
+type TranscriptionRequest struct {
+	Model    string
+	Filename string
+	Audio    []byte
+	// Fields are the other form fields (language, prompt, response_format,
+	// temperature, ...), forwarded as sent.
+	Fields map[string][]string
+	// Translate asks for an English translation instead of a transcript.
+	Translate bool
+}
+
+type SpeechRequest struct {
+	Model          string
+	Input          string
+	Voice          string
+	ResponseFormat string
+	Speed          *float64
+	Instructions   string
+}
+
+// AudioResponse is an upstream audio response, passed through as is: a
+// transcript in the requested format, synthesized audio, or an error body.
+type AudioResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// transcriptionBody encodes a transcription request as multipart form data,
+// returning its content type.
+func transcriptionBody(req *TranscriptionRequest) (string, []byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("model", req.Model); err != nil {
+		return "", nil, err
+	}
+	for name, values := range req.Fields {
+		for _, v := range values {
+			if err := mw.WriteField(name, v); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	part, err := mw.CreateFormFile("file", req.Filename)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := part.Write(req.Audio); err != nil {
+		return "", nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+	return mw.FormDataContentType(), buf.Bytes(), nil
+}
+
+func speechBody(req *SpeechRequest) []byte {
+	body := map[string]interface{}{
+		"model": req.Model,
+		"input": req.Input,
+		"voice": req.Voice,
+	}
+	if req.ResponseFormat != "" {
+		body["response_format"] = req.ResponseFormat
+	}
+	if req.Speed != nil {
+		body["speed"] = *req.Speed
+	}
+	if req.Instructions != "" {
+		body["instructions"] = req.Instructions
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+func doAudioRequest(client *http.Client, httpReq *http.Request) (*AudioResponse, error) {
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return &AudioResponse{StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: body}, nil
+}
+
+func audioPath(translate bool) string {
+	if translate {
+		return "/audio/translations"
+	}
+	return "/audio/transcriptions"
+}
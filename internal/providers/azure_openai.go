@@ -69,6 +69,25 @@ func (p *AzureOpenAIProvider) ChatCompletion(req *ChatRequest) ([]byte, int, err
 	return respBody, resp.StatusCode, nil
 }
 
+// Embed calls the embeddings endpoint of the deployment named by the model.
+func (p *AzureOpenAIProvider) Embed(req *EmbedRequest) (*EmbedResponse, int, error) {
+	model := req.Model
+	if model == "" {
+		model = p.cfg.DefaultModel
+	}
+
+	url := fmt.Sprintf("%s/openai/deployments/%s/embeddings?api-version=%s", p.cfg.BaseURL, model, p.apiVersion)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(openAIEmbedBody(req)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doEmbedRequest(client, httpReq, parseOpenAIEmbeddings)
+}
+
 func (p *AzureOpenAIProvider) ChatCompletionStream(req *ChatRequest) (*http.Response, error) {
 	model := req.Model
 	if model == "" {
@@ -97,9 +116,27 @@ func (p *AzureOpenAIProvider) setHeaders(req *http.Request) {
 }
 
 func (p *AzureOpenAIProvider) buildRequestBody(req *ChatRequest, stream bool) []byte {
-	messages := make([]map[string]string, len(req.Messages))
+	messages := make([]map[string]interface{}, len(req.Messages))
 	for i, m := range req.Messages {
-		messages[i] = map[string]string{"role": m.Role, "content": m.Content}
+		msg := map[string]interface{}{"role": m.Role, "content": m.Content}
+		if m.Role == "tool" && m.ToolCallID != "" {
+			msg["tool_call_id"] = m.ToolCallID
+		}
+		if m.Role == "assistant" && len(m.ToolCalls) > 0 {
+			toolCalls := make([]map[string]interface{}, len(m.ToolCalls))
+			for j, tc := range m.ToolCalls {
+				toolCalls[j] = map[string]interface{}{
+					"id":   tc.ID,
+					"type": "function",
+					"function": map[string]interface{}{
+						"name":      tc.Name,
+						"arguments": tc.Arguments,
+					},
+				}
+			}
+			msg["tool_calls"] = toolCalls
+		}
+		messages[i] = msg
 	}
 
 	// Azure does not accept the model field in the body (it's in the URL path)
@@ -108,11 +145,15 @@ func (p *AzureOpenAIProvider) buildRequestBody(req *ChatRequest, stream bool) []
 		"stream":   stream,
 	}
 
-	if req.MaxTokens > 0 {
-		body["max_tokens"] = req.MaxTokens
+	applyOpenAISampling(body, req)
+	if len(req.Tools) > 0 {
+		body["tools"] = req.Tools
 	}
-	if req.Temperature > 0 {
-		body["temperature"] = req.Temperature
+	if req.ToolChoice != nil {
+		body["tool_choice"] = req.ToolChoice.OpenAI()
+	}
+	if req.ParallelToolCalls != nil {
+		body["parallel_tool_calls"] = *req.ParallelToolCalls
 	}
 	if req.ResponseFormat != nil {
 		body["response_format"] = req.ResponseFormat
@@ -150,36 +191,10 @@ func (p *AzureOpenAIProvider) ParseResponse(body []byte) (string, int, int, erro
 	return text, inputTokens, outputTokens, nil
 }
 
-func (p *AzureOpenAIProvider) ParseStreamChunk(data []byte) (string, int, int) {
-	var chunk map[string]interface{}
-	if err := json.Unmarshal(data, &chunk); err != nil {
-		return "", 0, 0
-	}
-
-	text := ""
-	if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
-		if choice, ok := choices[0].(map[string]interface{}); ok {
-			if delta, ok := choice["delta"].(map[string]interface{}); ok {
-				text, _ = delta["content"].(string)
-			}
-		}
-	}
-
-	inputTokens, outputTokens := 0, 0
-	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
-		if pt, ok := usage["prompt_tokens"].(float64); ok {
-			inputTokens = int(pt)
-		}
-		if ct, ok := usage["completion_tokens"].(float64); ok {
-			outputTokens = int(ct)
-		}
-	}
-
-	return text, inputTokens, outputTokens
+func (p *AzureOpenAIProvider) StreamEvents(resp *http.Response) *EventStream {
+	return newSSEStream(resp.Body, decodeOpenAIStreamChunk)
 }
 
-func (p *AzureOpenAIProvider) StreamDataPrefix() string { return "data: " }
-
 func (p *AzureOpenAIProvider) Models() []string     { return p.cfg.AllowedModels }
 func (p *AzureOpenAIProvider) DefaultModel() string { return p.cfg.DefaultModel }
 
@@ -254,6 +269,16 @@ func (p *AzureOpenAIProvider) FetchModels() ([]string, error) {
 	return models, nil
 }
 
+func (p *AzureOpenAIProvider) ParseChoices(body []byte) ([]Choice, error) {
+	return parseOpenAIChoices(body)
+}
+
+func (p *AzureOpenAIProvider) SupportsChoiceCount() bool { return true }
+
+func (p *AzureOpenAIProvider) ParseUsageDetails(body []byte) UsageDetails {
+	return parseOpenAIUsageDetails(body)
+}
+
 func (p *AzureOpenAIProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(body, &resp); err != nil {
@@ -305,7 +330,3 @@ func (p *AzureOpenAIProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 
 	return toolCalls, nil
 }
-
-func (p *AzureOpenAIProvider) ParseStreamToolCall(data []byte) (interface{}, string) {
-	return nil, ""
-}
@@ -0,0 +1,84 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrNativeBatchUnsupported is returned by SubmitBatch when a provider
+// instance has no batch API (e.g. an OpenAI-format backend other than
+// OpenAI itself). Callers run the requests themselves instead.
+var ErrNativeBatchUnsupported = errors.New("native batch API not supported by this backend")
+
+// BatchSubmitter is implemented by providers with an asynchronous batch API,
+// which runs requests at a discount within a completion window.
+type BatchSubmitter interface {
+	// SubmitBatch starts an upstream batch and returns its id.
+	SubmitBatch(items []BatchItem) (string, error)
+	// PollBatch reports an upstream batch's progress, with its results once
+	// it has ended.
+	PollBatch(id string) (*BatchStatus, error)
+	// CancelBatch asks the upstream to stop a batch. Requests already done
+	// are still returned by PollBatch.
+	CancelBatch(id string) error
+}
+
+// BatchItem is one request of a batch. CustomID must match
+// ^[a-zA-Z0-9_-]{1,64}$, the strictest upstream format.
+type BatchItem struct {
+	CustomID string
+	Request  *ChatRequest
+}
+
+type BatchStatus struct {
+	Done      bool
+	Completed int
+	Failed    int
+	// Results are set once Done, in no particular order.
+	Results []BatchResult
+}
+
+// BatchResult is the outcome of one item. Body is the provider's native
+// response, readable with its ParseChoices and ParseResponse.
+type BatchResult struct {
+	CustomID   string
+	StatusCode int
+	Body       []byte
+	Error      string
+}
+
+// doBatchRequest sends a batch or job API call and returns the body of a
+// successful response. Upstream error bodies are returned in the error.
+func doBatchRequest(client *http.Client, httpReq *http.Request) ([]byte, error) {
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("batch request failed with status %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// jsonLines splits a JSONL body into its non-empty lines.
+func jsonLines(data []byte) [][]byte {
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,30 @@
+package providers
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrCompletionsUnsupported is returned by ForwardCompletion when a provider
+// instance's backend has no legacy /completions endpoint. Callers emulate
+// the request through chat instead.
+var ErrCompletionsUnsupported = errors.New("legacy completions not supported by this backend")
+
+// TextCompleter is implemented by providers whose backends may serve OpenAI's
+// legacy /completions endpoint (OpenAI, vLLM, llama.cpp, Ollama, LM Studio
+// and most other OpenAI-format servers), including fill-in-the-middle
+// requests with a suffix.
+type TextCompleter interface {
+	// ForwardCompletion sends an OpenAI /completions body as is and returns
+	// the upstream response, streamed when the body asks for it.
+	ForwardCompletion(body []byte) (*http.Response, error)
+}
+
+// completionUnsupported lists the OpenAI-format providers known to lack
+// /completions. Mistral's FIM endpoint returns chat completions, so it is
+// emulated like the others.
+var completionUnsupported = map[string]bool{
+	"mistral":    true,
+	"perplexity": true,
+	"cohere":     true,
+}
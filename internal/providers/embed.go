@@ -0,0 +1,99 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"ai-gateway/internal/tokenizer"
+)
+
+// Embedder is implemented by providers with an embeddings endpoint.
+type Embedder interface {
+	// Embed returns one vector per input, in input order, along with the
+	// upstream HTTP status code.
+	Embed(req *EmbedRequest) (*EmbedResponse, int, error)
+}
+
+type EmbedRequest struct {
+	Model string
+	Input []string
+	// Dimensions truncates the vectors on models that support it.
+	Dimensions *int
+}
+
+type EmbedResponse struct {
+	Model       string
+	Embeddings  [][]float64
+	InputTokens int
+}
+
+// doEmbedRequest sends an embeddings request and decodes a successful body
+// with parse. Upstream error bodies are returned in the error.
+func doEmbedRequest(client *http.Client, httpReq *http.Request, parse func([]byte) (*EmbedResponse, error)) (*EmbedResponse, int, error) {
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, resp.StatusCode, fmt.Errorf("embeddings request failed: %s", body)
+	}
+
+	out, err := parse(body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse embeddings: %w", err)
+	}
+	return out, resp.StatusCode, nil
+}
+
+func openAIEmbedBody(req *EmbedRequest) []byte {
+	body := map[string]interface{}{"model": req.Model, "input": req.Input}
+	if req.Dimensions != nil {
+		body["dimensions"] = *req.Dimensions
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+func parseOpenAIEmbeddings(body []byte) (*EmbedResponse, error) {
+	var resp struct {
+		Model string `json:"model"`
+		Data  []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	out := &EmbedResponse{Model: resp.Model, Embeddings: make([][]float64, len(resp.Data)), InputTokens: resp.Usage.PromptTokens}
+	for i, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(out.Embeddings) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		out.Embeddings[d.Index] = resp.Data[i].Embedding
+	}
+	return out, nil
+}
+
+// estimateEmbedTokens counts embedding input for upstreams that don't report
+// usage.
+func estimateEmbedTokens(model string, input []string) int {
+	n := 0
+	for _, s := range input {
+		c, _ := tokenizer.Count(model, s)
+		n += c
+	}
+	return n
+}
@@ -2,10 +2,15 @@ package providers
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"ai-gateway/internal/config"
@@ -79,6 +84,186 @@ func (p *GeminiProvider) ChatCompletion(req *ChatRequest) ([]byte, int, error) {
 	return respBody, resp.StatusCode, nil
 }
 
+// Embed uses batchEmbedContents so all inputs go in one call. Gemini doesn't
+// report token counts for embeddings, so they are estimated.
+func (p *GeminiProvider) Embed(req *EmbedRequest) (*EmbedResponse, int, error) {
+	model := req.Model
+	if model == "" {
+		model = p.cfg.DefaultModel
+	}
+
+	requests := make([]map[string]interface{}, len(req.Input))
+	for i, text := range req.Input {
+		r := map[string]interface{}{
+			"model":   "models/" + model,
+			"content": map[string]interface{}{"parts": []map[string]interface{}{{"text": text}}},
+		}
+		if req.Dimensions != nil {
+			r["outputDimensionality"] = *req.Dimensions
+		}
+		requests[i] = r
+	}
+	data, _ := json.Marshal(map[string]interface{}{"requests": requests})
+
+	url := fmt.Sprintf("%s/models/%s:batchEmbedContents?key=%s", p.cfg.BaseURL, model, p.cfg.APIKey)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doEmbedRequest(client, httpReq, func(body []byte) (*EmbedResponse, error) {
+		var resp struct {
+			Embeddings []struct {
+				Values []float64 `json:"values"`
+			} `json:"embeddings"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		out := &EmbedResponse{Model: model, Embeddings: make([][]float64, len(resp.Embeddings)), InputTokens: estimateEmbedTokens(model, req.Input)}
+		for i, e := range resp.Embeddings {
+			out.Embeddings[i] = e.Values
+		}
+		return out, nil
+	})
+}
+
+// geminiImageModel is used for image requests that don't name a model; the
+// provider's default model is usually a text-only one.
+const geminiImageModel = "gemini-2.5-flash-image"
+
+// geminiAspectRatios are the aspect ratios Gemini image models accept.
+var geminiAspectRatios = []string{"1:1", "2:3", "3:2", "3:4", "4:3", "4:5", "5:4", "9:16", "16:9", "21:9"}
+
+// geminiAspectRatio maps an OpenAI size ("1536x1024") to the closest aspect
+// ratio Gemini accepts, or "" if size isn't WIDTHxHEIGHT.
+func geminiAspectRatio(size string) string {
+	var w, h float64
+	if _, err := fmt.Sscanf(size, "%gx%g", &w, &h); err != nil || w <= 0 || h <= 0 {
+		return ""
+	}
+	best, bestDiff := "", 0.0
+	for _, ratio := range geminiAspectRatios {
+		var rw, rh float64
+		fmt.Sscanf(ratio, "%g:%g", &rw, &rh)
+		diff := math.Abs(math.Log(w/h) - math.Log(rw/rh))
+		if best == "" || diff < bestDiff {
+			best, bestDiff = ratio, diff
+		}
+	}
+	return best
+}
+
+func (p *GeminiProvider) GenerateImages(req *ImageRequest) (*ImageResponse, int, error) {
+	return p.generateImages(req)
+}
+
+// EditImages sends the input images along with the prompt. Gemini has no
+// masks, so requests with one are rejected.
+func (p *GeminiProvider) EditImages(req *ImageRequest) (*ImageResponse, int, error) {
+	if req.Mask != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("image masks are not supported by Gemini image models")
+	}
+	return p.generateImages(req)
+}
+
+// generateImages calls generateContent once per requested image, as image
+// models don't support candidateCount.
+func (p *GeminiProvider) generateImages(req *ImageRequest) (*ImageResponse, int, error) {
+	model := req.Model
+	if model == "" {
+		model = geminiImageModel
+	}
+
+	parts := []map[string]interface{}{{"text": req.Prompt}}
+	for _, img := range req.Images {
+		parts = append(parts, map[string]interface{}{
+			"inlineData": map[string]string{
+				"mimeType": http.DetectContentType(img.Data),
+				"data":     base64.StdEncoding.EncodeToString(img.Data),
+			},
+		})
+	}
+	genConfig := map[string]interface{}{"responseModalities": []string{"TEXT", "IMAGE"}}
+	if ratio := geminiAspectRatio(req.Size); ratio != "" {
+		genConfig["imageConfig"] = map[string]string{"aspectRatio": ratio}
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"contents":         []map[string]interface{}{{"role": "user", "parts": parts}},
+		"generationConfig": genConfig,
+	})
+
+	n := req.N
+	if n <= 0 {
+		n = 1
+	}
+	out := &ImageResponse{Model: model, Created: time.Now().Unix()}
+	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", p.cfg.BaseURL, model, p.cfg.APIKey)
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	for i := 0; i < n; i++ {
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to send request: %w", err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+		}
+		if resp.StatusCode >= 400 {
+			return nil, resp.StatusCode, fmt.Errorf("image request failed: %s", respBody)
+		}
+
+		var parsed struct {
+			Candidates []struct {
+				Content struct {
+					Parts []struct {
+						InlineData *struct {
+							Data string `json:"data"`
+						} `json:"inlineData"`
+					} `json:"parts"`
+				} `json:"content"`
+				FinishReason string `json:"finishReason"`
+			} `json:"candidates"`
+			UsageMetadata geminiUsage `json:"usageMetadata"`
+		}
+		if err := json.Unmarshal(respBody, &parsed); err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("failed to parse image response: %w", err)
+		}
+		out.InputTokens += parsed.UsageMetadata.PromptTokenCount
+		out.OutputTokens += parsed.UsageMetadata.CandidatesTokenCount + parsed.UsageMetadata.ThoughtsTokenCount
+
+		image, reason := "", ""
+		for _, c := range parsed.Candidates {
+			for _, part := range c.Content.Parts {
+				if part.InlineData != nil && image == "" {
+					image = part.InlineData.Data
+				}
+			}
+			reason = c.FinishReason
+		}
+		if image == "" {
+			if reason != "" && reason != "STOP" {
+				// Blocked by safety filters or similar: the prompt's fault.
+				return nil, http.StatusBadRequest, fmt.Errorf("no image generated (finish reason %s)", reason)
+			}
+			return nil, http.StatusBadGateway, fmt.Errorf("no image in Gemini response")
+		}
+		out.Images = append(out.Images, GeneratedImage{B64JSON: image})
+	}
+	return out, http.StatusOK, nil
+}
+
 func (p *GeminiProvider) ChatCompletionStream(req *ChatRequest) (*http.Response, error) {
 	model := req.Model
 	if model == "" {
@@ -99,34 +284,36 @@ func (p *GeminiProvider) ChatCompletionStream(req *ChatRequest) (*http.Response,
 	return client.Do(httpReq)
 }
 
-func (p *GeminiProvider) buildRequestBody(req *ChatRequest) []byte {
-	contents := make([]map[string]interface{}, 0)
-	var systemInstruction *map[string]interface{}
+// ForwardGemini sends a native Gemini request body unchanged. A model outside
+// AllowedModels falls back to the default model, as for the global Gemini key.
+func (p *GeminiProvider) ForwardGemini(model string, body []byte, stream bool) (*http.Response, error) {
+	if len(p.cfg.AllowedModels) > 0 && !slices.Contains(p.cfg.AllowedModels, model) && p.cfg.DefaultModel != "" {
+		model = p.cfg.DefaultModel
+	}
 
-	for _, msg := range req.Messages {
-		if msg.Content == "" {
-			continue
-		}
-		if msg.Role == "system" {
-			si := map[string]interface{}{
-				"parts": []map[string]interface{}{{"text": msg.Content}},
-			}
-			systemInstruction = &si
-			continue
-		}
-		role := "user"
-		if msg.Role == "assistant" {
-			role = "model"
-		}
-		contents = append(contents, map[string]interface{}{
-			"role":  role,
-			"parts": []map[string]interface{}{{"text": msg.Content}},
-		})
+	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", p.cfg.BaseURL, model, p.cfg.APIKey)
+	if stream {
+		url = fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse&key=%s", p.cfg.BaseURL, model, p.cfg.APIKey)
 	}
 
-	geminiReq := map[string]interface{}{"contents": contents}
-	if systemInstruction != nil {
-		geminiReq["systemInstruction"] = *systemInstruction
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return client.Do(httpReq)
+}
+
+func (p *GeminiProvider) buildRequestBody(req *ChatRequest) []byte {
+	parts, turns := normalizeMessages(req.Messages)
+	geminiReq := map[string]interface{}{"contents": geminiContents(turns)}
+	if system := systemText(parts); system != "" {
+		geminiReq["systemInstruction"] = map[string]interface{}{
+			"parts": []map[string]interface{}{{"text": system}},
+		}
 	}
 
 	if len(req.Tools) > 0 {
@@ -145,14 +332,49 @@ func (p *GeminiProvider) buildRequestBody(req *ChatRequest) []byte {
 			}
 		}
 		geminiReq["tools"] = tools
+		if tc := req.ToolChoice; tc != nil {
+			geminiReq["toolConfig"] = map[string]interface{}{
+				"functionCallingConfig": geminiFunctionCallingConfig(tc),
+			}
+		}
 	}
 
 	genConfig := map[string]interface{}{}
-	if req.MaxTokens > 0 {
-		genConfig["maxOutputTokens"] = req.MaxTokens
+	if limit := req.OutputTokenLimit(); limit > 0 {
+		genConfig["maxOutputTokens"] = limit
+	}
+	if req.Temperature != nil {
+		genConfig["temperature"] = *req.Temperature
 	}
-	if req.Temperature > 0 {
-		genConfig["temperature"] = req.Temperature
+	if req.TopP != nil {
+		genConfig["topP"] = *req.TopP
+	}
+	if len(req.Stop) > 0 {
+		genConfig["stopSequences"] = req.Stop
+	}
+	if req.Seed != nil {
+		genConfig["seed"] = *req.Seed
+	}
+	if req.PresencePenalty != nil {
+		genConfig["presencePenalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		genConfig["frequencyPenalty"] = *req.FrequencyPenalty
+	}
+	if req.N != nil {
+		genConfig["candidateCount"] = *req.N
+	}
+	if req.Logprobs {
+		genConfig["responseLogprobs"] = true
+		if req.TopLogprobs != nil {
+			genConfig["logprobs"] = *req.TopLogprobs
+		}
+	}
+	if budget, ok := req.ReasoningBudget(); ok {
+		genConfig["thinkingConfig"] = map[string]interface{}{
+			"thinkingBudget":  budget,
+			"includeThoughts": budget > 0,
+		}
 	}
 	if req.ResponseFormat != nil {
 		convertResponseFormat(req.ResponseFormat, genConfig)
@@ -160,11 +382,122 @@ func (p *GeminiProvider) buildRequestBody(req *ChatRequest) []byte {
 	if len(genConfig) > 0 {
 		geminiReq["generationConfig"] = genConfig
 	}
+	if len(req.SafetySettings) > 0 {
+		geminiReq["safetySettings"] = geminiSafetySettings(nil, req.SafetySettings)
+	}
 
 	data, _ := json.Marshal(geminiReq)
 	return data
 }
 
+// geminiSafetySettings returns the safetySettings entries of a request with
+// the thresholds in settings applied: they replace entries for the same
+// category and the others are kept. Entries are sorted by category.
+func geminiSafetySettings(entries []map[string]interface{}, settings map[string]string) []map[string]interface{} {
+	var merged []map[string]interface{}
+	for _, e := range entries {
+		if category, _ := e["category"].(string); settings[category] == "" {
+			merged = append(merged, e)
+		}
+	}
+	for category, threshold := range settings {
+		if threshold != "" {
+			merged = append(merged, map[string]interface{}{"category": category, "threshold": threshold})
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		a, _ := merged[i]["category"].(string)
+		b, _ := merged[j]["category"].(string)
+		return a < b
+	})
+	return merged
+}
+
+// WithGeminiSafetySettings applies a client's thresholds to a native Gemini
+// request body being forwarded, overriding the request's own settings for
+// the same categories. The body is returned unchanged if it isn't a JSON
+// object or there is nothing to apply.
+func WithGeminiSafetySettings(body []byte, settings map[string]string) []byte {
+	if len(settings) == 0 {
+		return body
+	}
+	var req map[string]json.RawMessage
+	if json.Unmarshal(body, &req) != nil {
+		return body
+	}
+	var entries []map[string]interface{}
+	json.Unmarshal(req["safetySettings"], &entries)
+	req["safetySettings"], _ = json.Marshal(geminiSafetySettings(entries, settings))
+	data, err := json.Marshal(req)
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// geminiContents renders normalized turns as Gemini contents: assistant tool
+// calls as functionCall parts of model turns, tool results as
+// functionResponse parts of user turns.
+func geminiContents(turns []turn) []map[string]interface{} {
+	contents := make([]map[string]interface{}, 0, len(turns))
+	for _, t := range turns {
+		role := "user"
+		if t.Role == "assistant" {
+			role = "model"
+		}
+		parts := make([]map[string]interface{}, 0, len(t.Parts))
+		for _, part := range t.Parts {
+			switch {
+			case part.Thinking != nil:
+				// Another backend's signed thinking means nothing to Gemini.
+				continue
+			case part.ToolCall != nil:
+				parts = append(parts, map[string]interface{}{"functionCall": map[string]interface{}{
+					"name": part.ToolCall.Name,
+					"args": ToolArgumentsObject(part.ToolCall.Arguments),
+				}})
+			case part.Result != nil:
+				parts = append(parts, map[string]interface{}{"functionResponse": map[string]interface{}{
+					"name":     part.Result.Name,
+					"response": toolResultObject(part.Result.Content),
+				}})
+			default:
+				parts = append(parts, map[string]interface{}{"text": part.Text})
+			}
+		}
+		contents = append(contents, map[string]interface{}{"role": role, "parts": parts})
+	}
+	return contents
+}
+
+// geminiFunctionCallingConfig maps an OpenAI tool_choice onto Gemini's
+// functionCallingConfig. "required" and a named function both use mode ANY; the
+// latter restricts allowedFunctionNames to that one function.
+func geminiFunctionCallingConfig(tc *ToolChoice) map[string]interface{} {
+	switch tc.Mode {
+	case ToolChoiceNone:
+		return map[string]interface{}{"mode": "NONE"}
+	case ToolChoiceRequired:
+		return map[string]interface{}{"mode": "ANY"}
+	case ToolChoiceFunction:
+		return map[string]interface{}{"mode": "ANY", "allowedFunctionNames": []string{tc.Function}}
+	}
+	return map[string]interface{}{"mode": "AUTO"}
+}
+
+// ValidateRequest rejects options generateContent has no equivalent for:
+// parallel_tool_calls=false (no switch to limit a turn to one function call)
+// and logit_bias. The user field is informational and simply not forwarded.
+func (p *GeminiProvider) ValidateRequest(req *ChatRequest) error {
+	if req.ParallelToolCalls != nil && !*req.ParallelToolCalls && len(req.Tools) > 0 {
+		return unsupportedParam(p, "parallel_tool_calls=false")
+	}
+	if len(req.LogitBias) > 0 {
+		return unsupportedParam(p, "logit_bias")
+	}
+	return nil
+}
+
 func (p *GeminiProvider) ParseResponse(body []byte) (string, int, int, error) {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(body, &resp); err != nil {
@@ -176,18 +509,281 @@ func (p *GeminiProvider) ParseResponse(body []byte) (string, int, int, error) {
 	return text, inputTokens, outputTokens, nil
 }
 
-func (p *GeminiProvider) ParseStreamChunk(data []byte) (string, int, int) {
-	var chunk map[string]interface{}
-	if err := json.Unmarshal(data, &chunk); err != nil {
-		return "", 0, 0
+func (p *GeminiProvider) StreamEvents(resp *http.Response) *EventStream {
+	return newSSEStream(resp.Body, newGeminiStreamDecoder())
+}
+
+// newGeminiStreamDecoder decodes streamGenerateContent chunks. Gemini sends each
+// functionCall whole, so every call becomes one tool call event with the next index.
+func newGeminiStreamDecoder() streamDecoder {
+	// Running tool call count per candidate, used both as the call index and
+	// to pick the finish reason.
+	toolCalls := make(map[int]int)
+
+	return func(_ string, data []byte) []StreamEvent {
+		var chunk struct {
+			Candidates     []geminiCandidate     `json:"candidates"`
+			PromptFeedback *geminiPromptFeedback `json:"promptFeedback"`
+			UsageMetadata  *geminiUsage          `json:"usageMetadata"`
+			Error          json.RawMessage       `json:"error"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil
+		}
+
+		var events []StreamEvent
+		if ev, ok := streamErrorEvent(chunk.Error); ok {
+			return append(events, ev)
+		}
+		if safety := chunk.PromptFeedback.blocked(); safety != nil && len(chunk.Candidates) == 0 {
+			events = append(events, StreamEvent{Type: StreamEventFinish, FinishReason: "content_filter", Safety: safety})
+		}
+
+		for _, candidate := range chunk.Candidates {
+			idx := candidate.Index
+			logprobs := candidate.LogprobsResult.openAI()
+			for _, part := range candidate.Content.Parts {
+				if part.FunctionCall != nil {
+					events = append(events, StreamEvent{Type: StreamEventToolCall, Choice: idx, ToolCall: &StreamToolCall{
+						Name:      part.FunctionCall.Name,
+						Arguments: geminiArgs(part.FunctionCall.Args),
+						Index:     toolCalls[idx],
+					}})
+					toolCalls[idx]++
+					continue
+				}
+				if part.Text == "" {
+					continue
+				}
+				if part.Thought {
+					events = append(events, StreamEvent{Type: StreamEventReasoning, Choice: idx, Text: part.Text})
+				} else {
+					// A chunk's logprobs cover all of its text; attach them once.
+					events = append(events, StreamEvent{Type: StreamEventText, Choice: idx, Text: part.Text, Logprobs: logprobs})
+					logprobs = nil
+				}
+			}
+			if logprobs != nil {
+				events = append(events, StreamEvent{Type: StreamEventText, Choice: idx, Logprobs: logprobs})
+			}
+			if candidate.FinishReason != "" {
+				events = append(events, StreamEvent{
+					Type:         StreamEventFinish,
+					Choice:       idx,
+					FinishReason: mapGeminiFinishReason(candidate.FinishReason, toolCalls[idx] > 0),
+					Safety:       candidate.safety(),
+				})
+			}
+		}
+
+		if u := chunk.UsageMetadata; u != nil {
+			events = append(events, StreamEvent{
+				Type:            StreamEventUsage,
+				InputTokens:     u.PromptTokenCount,
+				OutputTokens:    u.CandidatesTokenCount + u.ThoughtsTokenCount,
+				ReasoningTokens: u.ThoughtsTokenCount,
+			})
+		}
+
+		return events
+	}
+}
+
+// geminiCandidate is one candidate of a generateContent response or stream chunk.
+type geminiCandidate struct {
+	Index   int `json:"index"`
+	Content struct {
+		Parts []struct {
+			Text string `json:"text"`
+			// Thought marks a thought summary, sent when includeThoughts is set.
+			Thought      bool `json:"thought"`
+			FunctionCall *struct {
+				Name string          `json:"name"`
+				Args json.RawMessage `json:"args"`
+			} `json:"functionCall"`
+		} `json:"parts"`
+	} `json:"content"`
+	FinishReason   string                `json:"finishReason"`
+	LogprobsResult *geminiLogprobsResult `json:"logprobsResult"`
+	SafetyRatings  json.RawMessage       `json:"safetyRatings"`
+}
+
+// safety returns why the candidate was filtered, or nil if it wasn't.
+func (c *geminiCandidate) safety() *SafetyFeedback {
+	if !geminiFiltered(c.FinishReason) {
+		return nil
+	}
+	return &SafetyFeedback{BlockReason: c.FinishReason, Ratings: c.SafetyRatings}
+}
+
+// geminiPromptFeedback is sent instead of candidates when the prompt itself
+// was blocked.
+type geminiPromptFeedback struct {
+	BlockReason   string          `json:"blockReason"`
+	SafetyRatings json.RawMessage `json:"safetyRatings"`
+}
+
+// blocked returns why the prompt was blocked, or nil if it wasn't.
+func (f *geminiPromptFeedback) blocked() *SafetyFeedback {
+	if f == nil || f.BlockReason == "" {
+		return nil
 	}
+	return &SafetyFeedback{BlockReason: f.BlockReason, Ratings: f.SafetyRatings, PromptBlocked: true}
+}
+
+// GeminiSafetyFeedback reports why a native Gemini response or stream chunk
+// was blocked: the prompt feedback, or the first filtered candidate. It
+// returns nil if nothing was blocked.
+func GeminiSafetyFeedback(body []byte) *SafetyFeedback {
+	var resp struct {
+		Candidates     []geminiCandidate     `json:"candidates"`
+		PromptFeedback *geminiPromptFeedback `json:"promptFeedback"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return nil
+	}
+	if safety := resp.PromptFeedback.blocked(); safety != nil {
+		return safety
+	}
+	for i := range resp.Candidates {
+		if safety := resp.Candidates[i].safety(); safety != nil {
+			return safety
+		}
+	}
+	return nil
+}
+
+// geminiLogprobsResult holds per-position log probabilities, sent when
+// responseLogprobs is set.
+type geminiLogprobsResult struct {
+	TopCandidates []struct {
+		Candidates []geminiLogprob `json:"candidates"`
+	} `json:"topCandidates"`
+	ChosenCandidates []geminiLogprob `json:"chosenCandidates"`
+}
+
+type geminiLogprob struct {
+	Token          string  `json:"token"`
+	LogProbability float64 `json:"logProbability"`
+}
+
+// openAI converts the result into an OpenAI logprobs object.
+func (r *geminiLogprobsResult) openAI() json.RawMessage {
+	if r == nil || len(r.ChosenCandidates) == 0 {
+		return nil
+	}
+	entry := func(lp geminiLogprob) map[string]interface{} {
+		b := []byte(lp.Token)
+		bytes := make([]int, len(b))
+		for i, c := range b {
+			bytes[i] = int(c)
+		}
+		return map[string]interface{}{"token": lp.Token, "logprob": lp.LogProbability, "bytes": bytes}
+	}
+	content := make([]map[string]interface{}, len(r.ChosenCandidates))
+	for i, chosen := range r.ChosenCandidates {
+		e := entry(chosen)
+		top := []map[string]interface{}{}
+		if i < len(r.TopCandidates) {
+			for _, alt := range r.TopCandidates[i].Candidates {
+				top = append(top, entry(alt))
+			}
+		}
+		e["top_logprobs"] = top
+		content[i] = e
+	}
+	data, _ := json.Marshal(map[string]interface{}{"content": content})
+	return data
+}
 
-	text := extractNestedText(chunk, "candidates", "content", "parts", "text")
-	inputTokens, outputTokens := extractGeminiUsage(chunk)
-	return text, inputTokens, outputTokens
+// geminiUsage is the usageMetadata object. candidatesTokenCount excludes
+// thinking, which Gemini bills as output and reports in thoughtsTokenCount.
+type geminiUsage struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
 }
 
-func (p *GeminiProvider) StreamDataPrefix() string { return "data: " }
+// geminiArgs renders functionCall args as a JSON object string, defaulting to {}.
+func geminiArgs(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "{}"
+	}
+	return string(raw)
+}
+
+func (p *GeminiProvider) ParseChoices(body []byte) ([]Choice, error) {
+	var resp struct {
+		Candidates     []geminiCandidate     `json:"candidates"`
+		PromptFeedback *geminiPromptFeedback `json:"promptFeedback"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if safety := resp.PromptFeedback.blocked(); safety != nil && len(resp.Candidates) == 0 {
+		return []Choice{{FinishReason: "content_filter", Safety: safety}}, nil
+	}
+
+	choices := make([]Choice, len(resp.Candidates))
+	for i, c := range resp.Candidates {
+		var text, reasoning strings.Builder
+		choice := Choice{Index: c.Index}
+		for _, part := range c.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				choice.ToolCalls = append(choice.ToolCalls, ToolCall{Name: part.FunctionCall.Name, Arguments: geminiArgs(part.FunctionCall.Args)})
+			case part.Thought:
+				reasoning.WriteString(part.Text)
+			default:
+				text.WriteString(part.Text)
+			}
+		}
+		choice.Text = text.String()
+		choice.Reasoning = reasoning.String()
+		choice.Logprobs = c.LogprobsResult.openAI()
+		choice.FinishReason = mapGeminiFinishReason(c.FinishReason, len(choice.ToolCalls) > 0)
+		choice.Safety = c.safety()
+		choices[i] = choice
+	}
+	sort.Slice(choices, func(i, j int) bool { return choices[i].Index < choices[j].Index })
+	return choices, nil
+}
+
+func (p *GeminiProvider) SupportsChoiceCount() bool { return true }
+
+func (p *GeminiProvider) ParseUsageDetails(body []byte) UsageDetails {
+	var resp struct {
+		UsageMetadata geminiUsage `json:"usageMetadata"`
+	}
+	json.Unmarshal(body, &resp)
+	return UsageDetails{ReasoningTokens: resp.UsageMetadata.ThoughtsTokenCount}
+}
+
+// mapGeminiFinishReason converts a Gemini finishReason to an OpenAI finish_reason.
+func mapGeminiFinishReason(reason string, hasToolCalls bool) string {
+	switch {
+	case reason == "MAX_TOKENS":
+		return "length"
+	case geminiFiltered(reason):
+		return "content_filter"
+	default:
+		if hasToolCalls {
+			return "tool_calls"
+		}
+		return "stop"
+	}
+}
+
+// geminiFiltered reports whether a finishReason means the output was stopped
+// by safety, recitation or content policy filters.
+func geminiFiltered(reason string) bool {
+	switch reason {
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII",
+		"IMAGE_SAFETY", "IMAGE_PROHIBITED_CONTENT", "IMAGE_RECITATION":
+		return true
+	}
+	return false
+}
 
 func (p *GeminiProvider) Models() []string     { return p.cfg.AllowedModels }
 func (p *GeminiProvider) DefaultModel() string { return p.cfg.DefaultModel }
@@ -262,12 +858,21 @@ func extractNestedText(resp map[string]interface{}, keys ...string) string {
 	if !ok || len(parts) == 0 {
 		return ""
 	}
-	part, ok := parts[0].(map[string]interface{})
-	if !ok {
-		return ""
+	// Thought parts come first when includeThoughts is set; they are
+	// reasoning, not answer text.
+	var text strings.Builder
+	for _, raw := range parts {
+		part, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if thought, _ := part["thought"].(bool); thought {
+			continue
+		}
+		t, _ := part["text"].(string)
+		text.WriteString(t)
 	}
-	text, _ := part["text"].(string)
-	return text
+	return text.String()
 }
 
 func extractGeminiUsage(resp map[string]interface{}) (int, int) {
@@ -283,6 +888,9 @@ func extractGeminiUsage(resp map[string]interface{}) (int, int) {
 	if ct, ok := usage["candidatesTokenCount"].(float64); ok {
 		outputTokens = int(ct)
 	}
+	if tt, ok := usage["thoughtsTokenCount"].(float64); ok {
+		outputTokens += int(tt)
+	}
 	return inputTokens, outputTokens
 }
 
@@ -337,7 +945,3 @@ func (p *GeminiProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 
 	return toolCalls, nil
 }
-
-func (p *GeminiProvider) ParseStreamToolCall(data []byte) (interface{}, string) {
-	return nil, ""
-}
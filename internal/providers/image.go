@@ -0,0 +1,192 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ImageGenerator is implemented by providers that can create images: OpenAI's
+// /images endpoints (OpenAI and local OpenAI-format servers such as
+// stable-diffusion shims) and Gemini's image models.
+type ImageGenerator interface {
+	// GenerateImages creates images from req.Prompt, returning them along
+	// with the upstream HTTP status code.
+	GenerateImages(req *ImageRequest) (*ImageResponse, int, error)
+	// EditImages creates images from req.Prompt and req.Images.
+	EditImages(req *ImageRequest) (*ImageResponse, int, error)
+}
+
+type ImageRequest struct {
+	// Model is empty to use the backend's default image model.
+	Model  string
+	Prompt string
+	N      int
+	// Size, Quality, Style, Background, OutputFormat and ResponseFormat are
+	// OpenAI's options, forwarded when set. Gemini only honours Size, as an
+	// aspect ratio, and always returns b64_json.
+	Size           string
+	Quality        string
+	Style          string
+	Background     string
+	OutputFormat   string
+	ResponseFormat string
+	// Images and Mask are the inputs of an edit.
+	Images []ImageFile
+	Mask   *ImageFile
+}
+
+type ImageFile struct {
+	Filename string
+	Data     []byte
+}
+
+type ImageResponse struct {
+	Model   string
+	Created int64
+	Images  []GeneratedImage
+	// InputTokens and OutputTokens are the upstream's usage, or 0 if it
+	// reports none (only token-priced models do).
+	InputTokens  int
+	OutputTokens int
+}
+
+// GeneratedImage holds either the base64 image data or a URL to it.
+type GeneratedImage struct {
+	B64JSON       string
+	URL           string
+	RevisedPrompt string
+}
+
+// imageOptions are the request's optional fields in OpenAI's format.
+func imageOptions(req *ImageRequest) map[string]string {
+	fields := map[string]string{
+		"model":           req.Model,
+		"size":            req.Size,
+		"quality":         req.Quality,
+		"style":           req.Style,
+		"background":      req.Background,
+		"output_format":   req.OutputFormat,
+		"response_format": req.ResponseFormat,
+	}
+	for k, v := range fields {
+		if v == "" {
+			delete(fields, k)
+		}
+	}
+	return fields
+}
+
+func imageGenerationBody(req *ImageRequest) []byte {
+	body := map[string]interface{}{"prompt": req.Prompt}
+	for k, v := range imageOptions(req) {
+		body[k] = v
+	}
+	if req.N > 0 {
+		body["n"] = req.N
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+// imageEditBody encodes an edit as multipart form data, returning its content
+// type. Several images are sent as image[], as OpenAI's gpt-image models
+// expect.
+func imageEditBody(req *ImageRequest) (string, []byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("prompt", req.Prompt); err != nil {
+		return "", nil, err
+	}
+	for k, v := range imageOptions(req) {
+		if err := mw.WriteField(k, v); err != nil {
+			return "", nil, err
+		}
+	}
+	if req.N > 0 {
+		if err := mw.WriteField("n", strconv.Itoa(req.N)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	field := "image"
+	if len(req.Images) > 1 {
+		field = "image[]"
+	}
+	writeFile := func(name string, f ImageFile) error {
+		part, err := mw.CreateFormFile(name, f.Filename)
+		if err != nil {
+			return err
+		}
+		_, err = part.Write(f.Data)
+		return err
+	}
+	for _, img := range req.Images {
+		if err := writeFile(field, img); err != nil {
+			return "", nil, err
+		}
+	}
+	if req.Mask != nil {
+		if err := writeFile("mask", *req.Mask); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+	return mw.FormDataContentType(), buf.Bytes(), nil
+}
+
+// doImageRequest sends a request to an OpenAI-format /images endpoint and
+// decodes a successful body. Upstream error bodies are returned in the error.
+func doImageRequest(client *http.Client, httpReq *http.Request, model string) (*ImageResponse, int, error) {
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, resp.StatusCode, fmt.Errorf("image request failed: %s", body)
+	}
+
+	var parsed struct {
+		Created int64 `json:"created"`
+		Data    []struct {
+			B64JSON       string `json:"b64_json"`
+			URL           string `json:"url"`
+			RevisedPrompt string `json:"revised_prompt"`
+		} `json:"data"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse image response: %w", err)
+	}
+
+	out := &ImageResponse{
+		Model:        model,
+		Created:      parsed.Created,
+		Images:       make([]GeneratedImage, len(parsed.Data)),
+		InputTokens:  parsed.Usage.InputTokens,
+		OutputTokens: parsed.Usage.OutputTokens,
+	}
+	if out.Created == 0 {
+		out.Created = time.Now().Unix()
+	}
+	for i, d := range parsed.Data {
+		out.Images[i] = GeneratedImage{B64JSON: d.B64JSON, URL: d.URL, RevisedPrompt: d.RevisedPrompt}
+	}
+	return out, resp.StatusCode, nil
+}
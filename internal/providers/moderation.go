@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrModerationUnsupported is returned by Moderate when a provider instance's
+// backend has no moderation endpoint.
+var ErrModerationUnsupported = errors.New("moderation not supported by this backend")
+
+// Moderator is implemented by providers whose backends may serve OpenAI's
+// /moderations endpoint.
+type Moderator interface {
+	// Moderate classifies the input, a string, an array of strings or an
+	// array of text and image_url parts, and returns the upstream status code.
+	Moderate(model string, input json.RawMessage) (*ModerationResponse, int, error)
+}
+
+type ModerationResponse struct {
+	ID      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []ModerationResult `json:"results"`
+}
+
+// ModerationResult is one input's classification in OpenAI's schema.
+type ModerationResult struct {
+	Flagged                   bool                `json:"flagged"`
+	Categories                map[string]bool     `json:"categories"`
+	CategoryScores            map[string]float64  `json:"category_scores"`
+	CategoryAppliedInputTypes map[string][]string `json:"category_applied_input_types,omitempty"`
+}
+
+// moderationUnsupported lists the OpenAI-format providers known to lack an
+// OpenAI-schema /moderations endpoint. Mistral's uses its own categories.
+var moderationUnsupported = map[string]bool{
+	"mistral":    true,
+	"perplexity": true,
+	"cohere":     true,
+	"xai":        true,
+	"lmstudio":   true,
+	"llamacpp":   true,
+}
+
+// doModerationRequest sends a moderation request and decodes a successful
+// body. Upstream error bodies are returned in the error.
+func doModerationRequest(client *http.Client, httpReq *http.Request) (*ModerationResponse, int, error) {
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, resp.StatusCode, fmt.Errorf("moderation request failed: %s", body)
+	}
+
+	var out ModerationResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse moderation results: %w", err)
+	}
+	return &out, resp.StatusCode, nil
+}
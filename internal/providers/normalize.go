@@ -0,0 +1,143 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ai-gateway/internal/jsonrepair"
+)
+
+// turnPart is one piece of a normalized turn: text, a tool call made by the
+// assistant, the result of one, or an assistant thinking block. CacheControl
+// is set on the last part of a message the client marked as a caching
+// breakpoint.
+type turnPart struct {
+	Text         string
+	Thinking     *ThinkingBlock
+	ToolCall     *ToolCall
+	Result       *toolResult
+	CacheControl *CacheControl
+}
+
+// toolResult is a tool message with the name of the function it answers,
+// which Gemini needs and OpenAI-format tool messages don't carry.
+type toolResult struct {
+	CallID  string
+	Name    string
+	Content string
+}
+
+// turn is a message of a conversation normalized for backends that require
+// user and assistant turns to alternate.
+type turn struct {
+	// Role is "user" or "assistant".
+	Role  string
+	Parts []turnPart
+}
+
+// normalizeMessages prepares messages for Gemini and Anthropic, which take the
+// system prompt separately and reject consecutive turns with the same role.
+// System (and developer) messages are collected, in order, into the returned
+// system parts wherever they appear. Tool results become user turns naming
+// the function they answer; calls without an id are matched to the oldest
+// unanswered call. Empty messages are dropped and adjacent turns with the same
+// role are merged.
+func normalizeMessages(messages []ChatMessage) ([]turnPart, []turn) {
+	var system []turnPart
+	var turns []turn
+	var pending []ToolCall
+	generated, added := 0, 0
+
+	add := func(role string, part turnPart) {
+		added++
+		if last := len(turns) - 1; last >= 0 && turns[last].Role == role {
+			turns[last].Parts = append(turns[last].Parts, part)
+			return
+		}
+		turns = append(turns, turn{Role: role, Parts: []turnPart{part}})
+	}
+
+	for _, m := range messages {
+		// The last part added for m carries its cache breakpoint.
+		before := added
+
+		switch m.Role {
+		case "system", "developer":
+			if strings.TrimSpace(m.Content) != "" {
+				system = append(system, turnPart{Text: m.Content, CacheControl: m.CacheControl})
+			}
+			continue
+		case "tool":
+			result := &toolResult{CallID: m.ToolCallID, Content: m.Content}
+			for i, tc := range pending {
+				if tc.ID == m.ToolCallID || m.ToolCallID == "" {
+					result.CallID, result.Name = tc.ID, tc.Name
+					pending = append(pending[:i], pending[i+1:]...)
+					break
+				}
+			}
+			add("user", turnPart{Result: result})
+		case "assistant":
+			// Thinking precedes the text and tool calls it led to.
+			for i := range m.ThinkingBlocks {
+				add("assistant", turnPart{Thinking: &m.ThinkingBlocks[i]})
+			}
+			if strings.TrimSpace(m.Content) != "" {
+				add("assistant", turnPart{Text: m.Content})
+			}
+			for i := range m.ToolCalls {
+				tc := m.ToolCalls[i]
+				if tc.ID == "" {
+					// Anthropic pairs calls and results by id.
+					generated++
+					tc.ID = fmt.Sprintf("call_%d", generated)
+				}
+				pending = append(pending, tc)
+				add("assistant", turnPart{ToolCall: &tc})
+			}
+		default:
+			if strings.TrimSpace(m.Content) != "" {
+				add("user", turnPart{Text: m.Content})
+			}
+		}
+
+		if m.CacheControl != nil && added > before {
+			last := &turns[len(turns)-1]
+			last.Parts[len(last.Parts)-1].CacheControl = m.CacheControl
+		}
+	}
+	return system, turns
+}
+
+// systemText joins system parts into one prompt, for backends that take it
+// as a single string.
+func systemText(parts []turnPart) string {
+	texts := make([]string, len(parts))
+	for i, part := range parts {
+		texts[i] = part.Text
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// ToolArgumentsObject returns tool call arguments as a JSON object, for APIs
+// that take them as one (Anthropic, Gemini, Ollama). Malformed arguments are
+// repaired; anything that is still not an object becomes {}.
+func ToolArgumentsObject(args string) json.RawMessage {
+	repaired, _, err := jsonrepair.Repair(args)
+	if err != nil || !strings.HasPrefix(repaired, "{") {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(repaired)
+}
+
+// toolResultObject returns a tool result as a JSON object: the result itself
+// if it is one, otherwise wrapped as {"content": ...}.
+func toolResultObject(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	data, _ := json.Marshal(map[string]string{"content": content})
+	return data
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"ai-gateway/internal/config"
@@ -69,6 +70,34 @@ func (p *OllamaProvider) ChatCompletion(req *ChatRequest) ([]byte, int, error) {
 	return respBody, resp.StatusCode, nil
 }
 
+func (p *OllamaProvider) Embed(req *EmbedRequest) (*EmbedResponse, int, error) {
+	body := map[string]interface{}{"model": req.Model, "input": req.Input}
+	if req.Dimensions != nil {
+		body["dimensions"] = *req.Dimensions
+	}
+	data, _ := json.Marshal(body)
+
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+"/api/embed", bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doEmbedRequest(client, httpReq, func(body []byte) (*EmbedResponse, error) {
+		var resp struct {
+			Model           string      `json:"model"`
+			Embeddings      [][]float64 `json:"embeddings"`
+			PromptEvalCount int         `json:"prompt_eval_count"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		return &EmbedResponse{Model: resp.Model, Embeddings: resp.Embeddings, InputTokens: resp.PromptEvalCount}, nil
+	})
+}
+
 func (p *OllamaProvider) ChatCompletionStream(req *ChatRequest) (*http.Response, error) {
 	body := p.buildRequestBody(req, true)
 	url := p.cfg.BaseURL + "/api/chat"
@@ -91,6 +120,20 @@ func (p *OllamaProvider) ChatCompletionStream(req *ChatRequest) (*http.Response,
 	return resp, nil
 }
 
+// ForwardCompletion uses Ollama's OpenAI-compatible /v1/completions, which
+// takes a suffix for fill-in-the-middle models.
+func (p *OllamaProvider) ForwardCompletion(body []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+"/v1/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return client.Do(httpReq)
+}
+
 func (p *OllamaProvider) setHeaders(req *http.Request) {
 	req.Header.Set("Content-Type", "application/json")
 	if p.cfg.APIKey != "" {
@@ -99,17 +142,27 @@ func (p *OllamaProvider) setHeaders(req *http.Request) {
 }
 
 func (p *OllamaProvider) buildRequestBody(req *ChatRequest, stream bool) []byte {
+	req = emulateTools(p.cfg, req)
 	model := req.Model
 	if model == "" {
 		model = p.cfg.DefaultModel
 	}
 
+	// Ollama has no tool call ids; tool results name the function instead.
+	callNames := make(map[string]string)
+	for _, m := range req.Messages {
+		for _, tc := range m.ToolCalls {
+			callNames[tc.ID] = tc.Name
+		}
+	}
+
 	messages := make([]map[string]interface{}, len(req.Messages))
 	for i, m := range req.Messages {
 		msg := map[string]interface{}{"role": m.Role, "content": m.Content}
-		if m.Role == "tool" && m.ToolCallID != "" {
-			// Ollama doesn't explicitly mention tool_call_id in its messages spec, 
-			// but for chat history it's often needed.
+		if m.Role == "tool" {
+			if name := callNames[m.ToolCallID]; name != "" {
+				msg["tool_name"] = name
+			}
 		}
 		if m.Role == "assistant" && len(m.ToolCalls) > 0 {
 			toolCalls := make([]map[string]interface{}, len(m.ToolCalls))
@@ -118,7 +171,7 @@ func (p *OllamaProvider) buildRequestBody(req *ChatRequest, stream bool) []byte
 				toolCalls[j] = map[string]interface{}{
 					"function": map[string]interface{}{
 						"name":      tc.Name,
-						"arguments": p.parseArguments(tc.Arguments),
+						"arguments": ToolArgumentsObject(tc.Arguments),
 					},
 				}
 			}
@@ -128,11 +181,26 @@ func (p *OllamaProvider) buildRequestBody(req *ChatRequest, stream bool) []byte
 	}
 
 	options := make(map[string]interface{})
-	if req.Temperature > 0 {
-		options["temperature"] = req.Temperature
+	if req.Temperature != nil {
+		options["temperature"] = *req.Temperature
+	}
+	if limit := req.OutputTokenLimit(); limit > 0 {
+		options["num_predict"] = limit
+	}
+	if req.TopP != nil {
+		options["top_p"] = *req.TopP
 	}
-	if req.MaxTokens > 0 {
-		options["num_predict"] = req.MaxTokens
+	if len(req.Stop) > 0 {
+		options["stop"] = req.Stop
+	}
+	if req.Seed != nil {
+		options["seed"] = *req.Seed
+	}
+	if req.PresencePenalty != nil {
+		options["presence_penalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *req.FrequencyPenalty
 	}
 
 	body := map[string]interface{}{
@@ -144,8 +212,14 @@ func (p *OllamaProvider) buildRequestBody(req *ChatRequest, stream bool) []byte
 	if len(options) > 0 {
 		body["options"] = options
 	}
+	if budget, ok := req.ReasoningBudget(); ok {
+		// Ollama only switches thinking on or off; it has no budget.
+		body["think"] = budget > 0
+	}
 
-	if len(req.Tools) > 0 {
+	// /api/chat has no tool_choice; "none" is honoured by not offering tools and
+	// the stricter modes are rejected in ValidateRequest.
+	if len(req.Tools) > 0 && (req.ToolChoice == nil || req.ToolChoice.Mode != ToolChoiceNone) {
 		ollamaTools := make([]map[string]interface{}, len(req.Tools))
 		for i, t := range req.Tools {
 			if t.Function != nil {
@@ -171,12 +245,27 @@ func (p *OllamaProvider) buildRequestBody(req *ChatRequest, stream bool) []byte
 	return data
 }
 
-func (p *OllamaProvider) parseArguments(args string) interface{} {
-	var result interface{}
-	if err := json.Unmarshal([]byte(args), &result); err != nil {
-		return args
+// ValidateRequest rejects options that /api/chat cannot express: forcing a tool
+// call or limiting a turn to a single call (unless tools are emulated),
+// logprobs and logit_bias. The user field is informational and simply not
+// forwarded; n > 1 is fanned out by the gateway.
+func (p *OllamaProvider) ValidateRequest(req *ChatRequest) error {
+	// Emulated tools are described in the prompt, which can ask for both.
+	if !p.cfg.ToolEmulation {
+		if tc := req.ToolChoice; tc != nil && (tc.Mode == ToolChoiceRequired || tc.Mode == ToolChoiceFunction) {
+			return unsupportedParam(p, fmt.Sprintf("tool_choice %q", tc.Mode))
+		}
+		if req.ParallelToolCalls != nil && !*req.ParallelToolCalls && len(req.Tools) > 0 {
+			return unsupportedParam(p, "parallel_tool_calls=false")
+		}
 	}
-	return result
+	if req.Logprobs {
+		return unsupportedParam(p, "logprobs")
+	}
+	if len(req.LogitBias) > 0 {
+		return unsupportedParam(p, "logit_bias")
+	}
+	return nil
 }
 
 func (p *OllamaProvider) ParseResponse(body []byte) (string, int, int, error) {
@@ -192,28 +281,82 @@ func (p *OllamaProvider) ParseResponse(body []byte) (string, int, int, error) {
 		return "", 0, 0, err
 	}
 
-	return resp.Message.Content, resp.PromptEvalCount, resp.EvalCount, nil
+	text, _ := extractTextToolCalls(textToolFormat(p.cfg), resp.Message.Content)
+	return text, resp.PromptEvalCount, resp.EvalCount, nil
 }
 
-func (p *OllamaProvider) ParseStreamChunk(data []byte) (string, int, int) {
-	var chunk struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-		Done            bool `json:"done"`
-		PromptEvalCount int  `json:"prompt_eval_count"`
-		EvalCount       int  `json:"eval_count"`
-	}
+func (p *OllamaProvider) StreamEvents(resp *http.Response) *EventStream {
+	return newNDJSONStream(resp.Body, newOllamaStreamDecoder()).withTextToolCalls(textToolFormat(p.cfg))
+}
 
-	if err := json.Unmarshal(data, &chunk); err != nil {
-		return "", 0, 0
-	}
+// newOllamaStreamDecoder decodes Ollama's NDJSON chat stream. Tool calls arrive
+// whole, and usage and the finish reason come with the final done chunk.
+func newOllamaStreamDecoder() streamDecoder {
+	toolCalls := 0
+	var thinking strings.Builder
+
+	return func(_ string, data []byte) []StreamEvent {
+		var chunk struct {
+			Message struct {
+				Content   string `json:"content"`
+				Thinking  string `json:"thinking"`
+				ToolCalls []struct {
+					Function struct {
+						Name      string      `json:"name"`
+						Arguments interface{} `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			Done            bool            `json:"done"`
+			DoneReason      string          `json:"done_reason"`
+			PromptEvalCount int             `json:"prompt_eval_count"`
+			EvalCount       int             `json:"eval_count"`
+			Error           json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil
+		}
 
-	return chunk.Message.Content, chunk.PromptEvalCount, chunk.EvalCount
-}
+		var events []StreamEvent
+		if ev, ok := streamErrorEvent(chunk.Error); ok {
+			return append(events, ev)
+		}
+
+		if chunk.Message.Thinking != "" {
+			thinking.WriteString(chunk.Message.Thinking)
+			events = append(events, StreamEvent{Type: StreamEventReasoning, Text: chunk.Message.Thinking})
+		}
+		if chunk.Message.Content != "" {
+			events = append(events, StreamEvent{Type: StreamEventText, Text: chunk.Message.Content})
+		}
+		for _, tc := range chunk.Message.ToolCalls {
+			args, _ := json.Marshal(tc.Function.Arguments)
+			events = append(events, StreamEvent{Type: StreamEventToolCall, ToolCall: &StreamToolCall{
+				Name:      tc.Function.Name,
+				Arguments: string(args),
+				Index:     toolCalls,
+			}})
+			toolCalls++
+		}
+
+		if chunk.Done {
+			events = append(events, StreamEvent{
+				Type:            StreamEventUsage,
+				InputTokens:     chunk.PromptEvalCount,
+				OutputTokens:    chunk.EvalCount,
+				ReasoningTokens: estimateReasoningTokens(thinking.String()),
+			})
+			reason := chunk.DoneReason
+			if toolCalls > 0 {
+				reason = "tool_calls"
+			} else if reason != "length" {
+				reason = "stop"
+			}
+			events = append(events, StreamEvent{Type: StreamEventFinish, FinishReason: reason})
+		}
 
-func (p *OllamaProvider) StreamDataPrefix() string {
-	return ""
+		return events
+	}
 }
 
 func (p *OllamaProvider) Models() []string {
@@ -279,9 +422,44 @@ func (p *OllamaProvider) FetchModels() ([]string, error) {
 	return models, nil
 }
 
+func (p *OllamaProvider) ParseChoices(body []byte) ([]Choice, error) {
+	text, _, _, err := p.ParseResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	toolCalls, _ := p.ParseToolCalls(body)
+	var resp struct {
+		Message struct {
+			Thinking string `json:"thinking"`
+		} `json:"message"`
+		DoneReason string `json:"done_reason"`
+	}
+	json.Unmarshal(body, &resp)
+	reason := "stop"
+	if len(toolCalls) > 0 {
+		reason = "tool_calls"
+	} else if resp.DoneReason == "length" {
+		reason = "length"
+	}
+	return []Choice{{Text: text, Reasoning: resp.Message.Thinking, ToolCalls: toolCalls, FinishReason: reason}}, nil
+}
+
+// ParseUsageDetails estimates reasoning tokens from the thinking text, since
+// eval_count includes thinking but Ollama does not break it out.
+func (p *OllamaProvider) ParseUsageDetails(body []byte) UsageDetails {
+	var resp struct {
+		Message struct {
+			Thinking string `json:"thinking"`
+		} `json:"message"`
+	}
+	json.Unmarshal(body, &resp)
+	return UsageDetails{ReasoningTokens: estimateReasoningTokens(resp.Message.Thinking)}
+}
+
 func (p *OllamaProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 	var resp struct {
 		Message struct {
+			Content   string `json:"content"`
 			ToolCalls []struct {
 				Function struct {
 					Name      string      `json:"name"`
@@ -304,38 +482,6 @@ func (p *OllamaProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 			Arguments: string(args),
 		})
 	}
-
-	return toolCalls, nil
-}
-
-func (p *OllamaProvider) ParseStreamToolCall(data []byte) (interface{}, string) {
-	var chunk struct {
-		Message struct {
-			ToolCalls []struct {
-				Function struct {
-					Name      string      `json:"name"`
-					Arguments interface{} `json:"arguments"`
-				} `json:"function"`
-			} `json:"tool_calls"`
-		} `json:"message"`
-		DoneReason string `json:"done_reason"`
-	}
-
-	if err := json.Unmarshal(data, &chunk); err != nil {
-		return nil, ""
-	}
-
-	if len(chunk.Message.ToolCalls) == 0 {
-		return nil, chunk.DoneReason
-	}
-
-	tc := chunk.Message.ToolCalls[0]
-	args, _ := json.Marshal(tc.Function.Arguments)
-
-	return &StreamToolCall{
-		ID:        "",
-		Name:      tc.Function.Name,
-		Arguments: string(args),
-		Index:     0,
-	}, chunk.DoneReason
+	_, textCalls := extractTextToolCalls(textToolFormat(p.cfg), resp.Message.Content)
+	return append(toolCalls, textCalls...), nil
 }
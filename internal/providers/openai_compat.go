@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -76,6 +78,16 @@ func NewLMStudioProvider(name string, cfg config.ProviderConfig) *OpenAICompatPr
 	return &OpenAICompatProvider{name: name, cfg: cfg}
 }
 
+func NewLlamaCppProvider(name string, cfg config.ProviderConfig) *OpenAICompatProvider {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://localhost:8080/v1"
+	}
+	if name == "" {
+		name = "llamacpp"
+	}
+	return &OpenAICompatProvider{name: name, cfg: cfg}
+}
+
 func NewPerplexityProvider(cfg config.ProviderConfig) *OpenAICompatProvider {
 	if cfg.BaseURL == "" {
 		cfg.BaseURL = "https://api.perplexity.ai"
@@ -107,11 +119,8 @@ func (p *OpenAICompatProvider) WithBaseURL(url string) Provider {
 	return &OpenAICompatProvider{name: p.name, cfg: newCfg}
 }
 
-func (p *OpenAICompatProvider) ChatCompletion(req *ChatRequest) ([]byte, int, error) {
-	body := p.buildRequestBody(req, false)
-
-	// Determine the correct endpoint based on provider type
-	var url string
+// endpoint returns the URL of an API path such as /chat/completions.
+func (p *OpenAICompatProvider) endpoint(path string) string {
 	switch p.name {
 	case "lmstudio":
 		// LM Studio - ensure /v1 is in the URL
@@ -119,11 +128,17 @@ func (p *OpenAICompatProvider) ChatCompletion(req *ChatRequest) ([]byte, int, er
 		if !strings.HasSuffix(baseURL, "/v1") {
 			baseURL = strings.TrimSuffix(baseURL, "/") + "/v1"
 		}
-		url = baseURL + "/chat/completions"
+		return baseURL + path
 	default:
 		// OpenAI, Mistral, etc. - base URL already includes /v1
-		url = p.cfg.BaseURL + "/chat/completions"
+		return p.cfg.BaseURL + path
 	}
+}
+
+func (p *OpenAICompatProvider) ChatCompletion(req *ChatRequest) ([]byte, int, error) {
+	body := p.buildRequestBody(req, false)
+
+	url := p.endpoint("/chat/completions")
 
 	if isDebug() {
 		log.Printf("[%s] Request to %s: %s", p.name, url, string(body))
@@ -155,6 +170,278 @@ func (p *OpenAICompatProvider) ChatCompletion(req *ChatRequest) ([]byte, int, er
 	return respBody, resp.StatusCode, nil
 }
 
+func (p *OpenAICompatProvider) Embed(req *EmbedRequest) (*EmbedResponse, int, error) {
+	httpReq, err := http.NewRequest("POST", p.endpoint("/embeddings"), bytes.NewReader(openAIEmbedBody(req)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doEmbedRequest(client, httpReq, parseOpenAIEmbeddings)
+}
+
+func (p *OpenAICompatProvider) Transcribe(req *TranscriptionRequest) (*AudioResponse, error) {
+	contentType, body, err := transcriptionBody(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	httpReq, err := http.NewRequest("POST", p.endpoint(audioPath(req.Translate)), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	httpReq.Header.Set("Content-Type", contentType)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doAudioRequest(client, httpReq)
+}
+
+func (p *OpenAICompatProvider) Speech(req *SpeechRequest) (*AudioResponse, error) {
+	httpReq, err := http.NewRequest("POST", p.endpoint("/audio/speech"), bytes.NewReader(speechBody(req)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doAudioRequest(client, httpReq)
+}
+
+func (p *OpenAICompatProvider) ForwardCompletion(body []byte) (*http.Response, error) {
+	if completionUnsupported[p.name] {
+		return nil, ErrCompletionsUnsupported
+	}
+	httpReq, err := http.NewRequest("POST", p.endpoint("/completions"), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return client.Do(httpReq)
+}
+
+func (p *OpenAICompatProvider) Moderate(model string, input json.RawMessage) (*ModerationResponse, int, error) {
+	if moderationUnsupported[p.name] {
+		return nil, 0, ErrModerationUnsupported
+	}
+	body := map[string]interface{}{"input": input}
+	if model != "" {
+		body["model"] = model
+	}
+	data, _ := json.Marshal(body)
+	httpReq, err := http.NewRequest("POST", p.endpoint("/moderations"), bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doModerationRequest(client, httpReq)
+}
+
+func (p *OpenAICompatProvider) GenerateImages(req *ImageRequest) (*ImageResponse, int, error) {
+	httpReq, err := http.NewRequest("POST", p.endpoint("/images/generations"), bytes.NewReader(imageGenerationBody(req)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doImageRequest(client, httpReq, req.Model)
+}
+
+func (p *OpenAICompatProvider) EditImages(req *ImageRequest) (*ImageResponse, int, error) {
+	contentType, body, err := imageEditBody(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encode request: %w", err)
+	}
+	httpReq, err := http.NewRequest("POST", p.endpoint("/images/edits"), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	httpReq.Header.Set("Content-Type", contentType)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doImageRequest(client, httpReq, req.Model)
+}
+
+// Rerank calls the backend's /rerank, which Cohere, Jina and llama.cpp's
+// server implement in the same schema.
+func (p *OpenAICompatProvider) Rerank(req *RerankRequest) (*RerankResponse, int, error) {
+	httpReq, err := http.NewRequest("POST", p.endpoint("/rerank"), bytes.NewReader(rerankBody(req)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	return doRerankRequest(client, httpReq, req)
+}
+
+// SubmitBatch uploads the requests as a JSONL file and starts an OpenAI
+// batch. Only OpenAI itself has this API among the OpenAI-format backends.
+func (p *OpenAICompatProvider) SubmitBatch(items []BatchItem) (string, error) {
+	if p.name != "openai" {
+		return "", ErrNativeBatchUnsupported
+	}
+
+	var input bytes.Buffer
+	for _, item := range items {
+		line, _ := json.Marshal(map[string]interface{}{
+			"custom_id": item.CustomID,
+			"method":    "POST",
+			"url":       "/v1/chat/completions",
+			"body":      json.RawMessage(p.buildRequestBody(item.Request, false)),
+		})
+		input.Write(line)
+		input.WriteByte('\n')
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("purpose", "batch")
+	part, _ := mw.CreateFormFile("file", "batch.jsonl")
+	part.Write(input.Bytes())
+	mw.Close()
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+
+	httpReq, err := http.NewRequest("POST", p.endpoint("/files"), &form)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	httpReq.Header.Set("Content-Type", mw.FormDataContentType())
+	body, err := doBatchRequest(client, httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload batch input: %w", err)
+	}
+	var file struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(body, &file)
+
+	create, _ := json.Marshal(map[string]string{
+		"input_file_id":     file.ID,
+		"endpoint":          "/v1/chat/completions",
+		"completion_window": "24h",
+	})
+	httpReq, err = http.NewRequest("POST", p.endpoint("/batches"), bytes.NewReader(create))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	body, err = doBatchRequest(client, httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to create batch: %w", err)
+	}
+	var batch struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(body, &batch)
+	return batch.ID, nil
+}
+
+func (p *OpenAICompatProvider) PollBatch(id string) (*BatchStatus, error) {
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+
+	httpReq, err := http.NewRequest("GET", p.endpoint("/batches/"+id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	body, err := doBatchRequest(client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	var batch struct {
+		Status        string `json:"status"`
+		OutputFileID  string `json:"output_file_id"`
+		ErrorFileID   string `json:"error_file_id"`
+		RequestCounts struct {
+			Completed int `json:"completed"`
+			Failed    int `json:"failed"`
+		} `json:"request_counts"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("failed to parse batch: %w", err)
+	}
+
+	status := &BatchStatus{Completed: batch.RequestCounts.Completed, Failed: batch.RequestCounts.Failed}
+	switch batch.Status {
+	case "completed", "failed", "expired", "cancelled":
+		status.Done = true
+	default:
+		return status, nil
+	}
+
+	for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		httpReq, err := http.NewRequest("GET", p.endpoint("/files/"+fileID+"/content"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		p.setHeaders(httpReq)
+		content, err := doBatchRequest(client, httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download batch results: %w", err)
+		}
+		for _, line := range jsonLines(content) {
+			var result struct {
+				CustomID string `json:"custom_id"`
+				Response *struct {
+					StatusCode int             `json:"status_code"`
+					Body       json.RawMessage `json:"body"`
+				} `json:"response"`
+				Error *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(line, &result) != nil {
+				continue
+			}
+			r := BatchResult{CustomID: result.CustomID}
+			if result.Response != nil {
+				r.StatusCode = result.Response.StatusCode
+				r.Body = result.Response.Body
+			}
+			if result.Error != nil {
+				r.Error = result.Error.Message
+			}
+			status.Results = append(status.Results, r)
+		}
+	}
+	return status, nil
+}
+
+func (p *OpenAICompatProvider) CancelBatch(id string) error {
+	httpReq, err := http.NewRequest("POST", p.endpoint("/batches/"+id+"/cancel"), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	client := getHTTPClient()
+	client.Timeout = time.Duration(p.cfg.TimeoutSeconds) * time.Second
+	_, err = doBatchRequest(client, httpReq)
+	return err
+}
+
 func isDebug() bool {
 	return os.Getenv("DEBUG") == "1" || os.Getenv("DEBUG") == "true"
 }
@@ -162,20 +449,7 @@ func isDebug() bool {
 func (p *OpenAICompatProvider) ChatCompletionStream(req *ChatRequest) (*http.Response, error) {
 	body := p.buildRequestBody(req, true)
 
-	// Determine the correct endpoint based on provider type
-	var url string
-	switch p.name {
-	case "lmstudio":
-		// LM Studio - ensure /v1 is in the URL
-		baseURL := p.cfg.BaseURL
-		if !strings.HasSuffix(baseURL, "/v1") {
-			baseURL = strings.TrimSuffix(baseURL, "/") + "/v1"
-		}
-		url = baseURL + "/chat/completions"
-	default:
-		// OpenAI, Mistral, etc. - base URL already includes /v1
-		url = p.cfg.BaseURL + "/chat/completions"
-	}
+	url := p.endpoint("/chat/completions")
 
 	log.Printf("[%s] Stream request to %s", p.name, url)
 
@@ -209,13 +483,23 @@ func (p *OpenAICompatProvider) setHeaders(req *http.Request) {
 }
 
 func (p *OpenAICompatProvider) buildRequestBody(req *ChatRequest, stream bool) []byte {
+	req = emulateTools(p.cfg, req)
 	model := req.Model
 	if model == "" {
 		model = p.cfg.DefaultModel
 	}
 
-	messages := make([]map[string]interface{}, len(req.Messages))
-	for i, m := range req.Messages {
+	// Perplexity accepts json_schema but has no json_object mode, so that is
+	// asked for in the system prompt.
+	responseFormat := req.ResponseFormat
+	chatMessages := req.Messages
+	if schema, wantsJSON := ResponseSchema(responseFormat); p.name == "perplexity" && wantsJSON && schema == nil {
+		responseFormat = nil
+		chatMessages = withJSONInstruction(chatMessages, nil)
+	}
+
+	messages := make([]map[string]interface{}, len(chatMessages))
+	for i, m := range chatMessages {
 		msg := map[string]interface{}{"role": m.Role, "content": m.Content}
 		if m.Role == "tool" && m.ToolCallID != "" {
 			msg["tool_call_id"] = m.ToolCallID
@@ -243,18 +527,19 @@ func (p *OpenAICompatProvider) buildRequestBody(req *ChatRequest, stream bool) [
 		"stream":   stream,
 	}
 
-	if req.MaxTokens > 0 {
-		body["max_tokens"] = req.MaxTokens
-	}
-	if req.Temperature > 0 {
-		body["temperature"] = req.Temperature
-	}
+	applyOpenAISampling(body, req)
 	if len(req.Tools) > 0 {
 		body["tools"] = req.Tools
 		log.Printf("[%s] Tools included in request: %d tools", p.name, len(req.Tools))
 	}
-	if req.ResponseFormat != nil {
-		body["response_format"] = req.ResponseFormat
+	if req.ToolChoice != nil {
+		body["tool_choice"] = req.ToolChoice.OpenAI()
+	}
+	if req.ParallelToolCalls != nil {
+		body["parallel_tool_calls"] = *req.ParallelToolCalls
+	}
+	if responseFormat != nil {
+		body["response_format"] = responseFormat
 	}
 	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
 		body["stream_options"] = map[string]interface{}{"include_usage": true}
@@ -278,6 +563,7 @@ func (p *OpenAICompatProvider) ParseResponse(body []byte) (string, int, int, err
 			}
 		}
 	}
+	text, _ = extractTextToolCalls(textToolFormat(p.cfg), text)
 
 	inputTokens, outputTokens := 0, 0
 	if usage, ok := resp["usage"].(map[string]interface{}); ok {
@@ -292,97 +578,10 @@ func (p *OpenAICompatProvider) ParseResponse(body []byte) (string, int, int, err
 	return text, inputTokens, outputTokens, nil
 }
 
-func (p *OpenAICompatProvider) ParseStreamChunk(data []byte) (string, int, int) {
-	var chunk map[string]interface{}
-	if err := json.Unmarshal(data, &chunk); err != nil {
-		return "", 0, 0
-	}
-
-	text := ""
-	if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
-		if choice, ok := choices[0].(map[string]interface{}); ok {
-			if delta, ok := choice["delta"].(map[string]interface{}); ok {
-				text, _ = delta["content"].(string)
-			}
-		}
-	}
-
-	inputTokens, outputTokens := 0, 0
-	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
-		log.Printf("[%s] Stream usage chunk: %+v", p.name, usage)
-		if pt, ok := usage["prompt_tokens"].(float64); ok {
-			inputTokens = int(pt)
-		}
-		if ct, ok := usage["completion_tokens"].(float64); ok {
-			outputTokens = int(ct)
-		}
-	}
-
-	return text, inputTokens, outputTokens
-}
-
-type StreamToolCall struct {
-	ID        string
-	Name      string
-	Arguments string
-	Index     int
-}
-
-func (p *OpenAICompatProvider) ParseStreamToolCall(data []byte) (interface{}, string) {
-	var chunk map[string]interface{}
-	if err := json.Unmarshal(data, &chunk); err != nil {
-		return nil, ""
-	}
-
-	choices, ok := chunk["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		return nil, ""
-	}
-
-	choice, ok := choices[0].(map[string]interface{})
-	if !ok {
-		return nil, ""
-	}
-
-	finishReason, _ := choice["finish_reason"].(string)
-
-	delta, ok := choice["delta"].(map[string]interface{})
-	if !ok {
-		return nil, finishReason
-	}
-
-	toolCalls, ok := delta["tool_calls"].([]interface{})
-	if !ok || len(toolCalls) == 0 {
-		return nil, finishReason
-	}
-
-	tc, ok := toolCalls[0].(map[string]interface{})
-	if !ok {
-		return nil, finishReason
-	}
-
-	id, _ := tc["id"].(string)
-	indexFloat, _ := tc["index"].(float64)
-	index := int(indexFloat)
-
-	fn, ok := tc["function"].(map[string]interface{})
-	if !ok {
-		return nil, finishReason
-	}
-
-	name, _ := fn["name"].(string)
-	args, _ := fn["arguments"].(string)
-
-	return interface{}(&StreamToolCall{
-		ID:        id,
-		Name:      name,
-		Arguments: args,
-		Index:     index,
-	}), finishReason
+func (p *OpenAICompatProvider) StreamEvents(resp *http.Response) *EventStream {
+	return newSSEStream(resp.Body, decodeOpenAIStreamChunk).withTextToolCalls(textToolFormat(p.cfg))
 }
 
-func (p *OpenAICompatProvider) StreamDataPrefix() string { return "data: " }
-
 func (p *OpenAICompatProvider) Models() []string     { return p.cfg.AllowedModels }
 func (p *OpenAICompatProvider) DefaultModel() string { return p.cfg.DefaultModel }
 
@@ -488,6 +687,74 @@ func (p *OpenAICompatProvider) FetchModels() ([]string, error) {
 	return models, nil
 }
 
+func (p *OpenAICompatProvider) ParseChoices(body []byte) ([]Choice, error) {
+	choices, err := parseOpenAIChoices(body)
+	return withTextToolChoices(textToolFormat(p.cfg), choices, err)
+}
+
+func (p *OpenAICompatProvider) SupportsChoiceCount() bool { return true }
+
+func (p *OpenAICompatProvider) ParseUsageDetails(body []byte) UsageDetails {
+	return parseOpenAIUsageDetails(body)
+}
+
+// parseOpenAIUsageDetails reads completion_tokens_details from an OpenAI-format
+// response.
+func parseOpenAIUsageDetails(body []byte) UsageDetails {
+	var resp struct {
+		Usage openAIUsage `json:"usage"`
+	}
+	json.Unmarshal(body, &resp)
+	return UsageDetails{ReasoningTokens: resp.Usage.CompletionTokensDetails.ReasoningTokens}
+}
+
+// parseOpenAIChoices extracts all choices from a chat.completion response, the
+// format shared by every OpenAI-compatible backend.
+func parseOpenAIChoices(body []byte) ([]Choice, error) {
+	var resp struct {
+		Choices []struct {
+			Index   int `json:"index"`
+			Message struct {
+				Content          string `json:"content"`
+				ReasoningContent string `json:"reasoning_content"`
+				Reasoning        string `json:"reasoning"`
+				ToolCalls        []struct {
+					ID       string `json:"id"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			FinishReason string          `json:"finish_reason"`
+			Logprobs     json.RawMessage `json:"logprobs"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	choices := make([]Choice, len(resp.Choices))
+	for i, c := range resp.Choices {
+		choices[i] = Choice{
+			Index:        c.Index,
+			Text:         c.Message.Content,
+			Reasoning:    c.Message.ReasoningContent + c.Message.Reasoning,
+			FinishReason: c.FinishReason,
+			Logprobs:     nonNullJSON(c.Logprobs),
+		}
+		for _, tc := range c.Message.ToolCalls {
+			choices[i].ToolCalls = append(choices[i].ToolCalls, ToolCall{
+				ID:        tc.ID,
+				Name:      tc.Function.Name,
+				Arguments: tc.Function.Arguments,
+			})
+		}
+	}
+	sort.Slice(choices, func(i, j int) bool { return choices[i].Index < choices[j].Index })
+	return choices, nil
+}
+
 func (p *OpenAICompatProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(body, &resp); err != nil {
@@ -509,11 +776,7 @@ func (p *OpenAICompatProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 		return nil, nil
 	}
 
-	toolCallsRaw, ok := msg["tool_calls"].([]interface{})
-	if !ok || len(toolCallsRaw) == 0 {
-		return nil, nil
-	}
-
+	toolCallsRaw, _ := msg["tool_calls"].([]interface{})
 	var toolCalls []ToolCall
 	for _, tcRaw := range toolCallsRaw {
 		tc, ok := tcRaw.(map[string]interface{})
@@ -537,5 +800,7 @@ func (p *OpenAICompatProvider) ParseToolCalls(body []byte) ([]ToolCall, error) {
 		})
 	}
 
-	return toolCalls, nil
+	content, _ := msg["content"].(string)
+	_, textCalls := extractTextToolCalls(textToolFormat(p.cfg), content)
+	return append(toolCalls, textCalls...), nil
 }
@@ -1,10 +1,13 @@
 package providers
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 
 	"ai-gateway/internal/config"
+	"ai-gateway/internal/tokenizer"
 )
 
 // Provider is the interface all upstream AI backends implement.
@@ -24,12 +27,10 @@ type Provider interface {
 	// ParseResponse extracts the generated text from a non-streaming response body.
 	ParseResponse(body []byte) (text string, inputTokens int, outputTokens int, err error)
 
-	// ParseStreamChunk extracts text and token counts from a single SSE chunk.
-	// Returns the text delta and updated token counts.
-	ParseStreamChunk(data []byte) (text string, inputTokens int, outputTokens int)
-
-	// StreamDataPrefix returns the SSE line prefix this provider uses (e.g. "data: ")
-	StreamDataPrefix() string
+	// StreamEvents wraps a successful streaming response in an iterator that yields
+	// typed events (text, tool call fragments, usage, finish, errors). The returned
+	// stream owns the response body.
+	StreamEvents(resp *http.Response) *EventStream
 
 	// Models returns the list of allowed/available models for this provider.
 	Models() []string
@@ -48,10 +49,90 @@ type Provider interface {
 	// Returns nil if no tool calls are present.
 	ParseToolCalls(body []byte) ([]ToolCall, error)
 
-	// ParseStreamToolCall extracts tool call and finish_reason from a streaming chunk.
-	// Returns (toolCall, finishReason). If no tool call in chunk, returns (nil, finishReason).
-	// The toolCall is provider-specific (e.g., *StreamToolCall for OpenAICompatProvider)
-	ParseStreamToolCall(data []byte) (interface{}, string)
+	// ParseChoices extracts every choice of a non-streaming response body, in
+	// index order. Providers without native multi-choice support return one.
+	ParseChoices(body []byte) ([]Choice, error)
+}
+
+// Choice is one completion alternative of a non-streaming response.
+type Choice struct {
+	Index     int
+	Text      string
+	Reasoning string
+	// ThinkingBlocks are the signed thinking blocks behind Reasoning, for
+	// backends that need them sent back with a tool-use turn.
+	ThinkingBlocks []ThinkingBlock
+	ToolCalls      []ToolCall
+	FinishReason   string
+	// Logprobs is the choice's logprobs object in OpenAI format
+	// ({"content":[...]}), or nil if none were requested.
+	Logprobs json.RawMessage
+	// Safety explains a content_filter finish, when the backend says why.
+	Safety *SafetyFeedback
+}
+
+// SafetyFeedback is why a backend's safety filters stopped a response: the
+// upstream block or finish reason (e.g. Gemini's SAFETY or RECITATION) and
+// the safety ratings it reported, in the backend's format.
+type SafetyFeedback struct {
+	BlockReason string          `json:"block_reason"`
+	Ratings     json.RawMessage `json:"safety_ratings,omitempty"`
+	// PromptBlocked is set when the prompt was blocked before any output.
+	PromptBlocked bool `json:"prompt_blocked,omitempty"`
+}
+
+// UsageDetails breaks token usage down beyond the input/output totals that
+// ParseResponse returns.
+type UsageDetails struct {
+	// ReasoningTokens is the part of the output spent on reasoning/thinking.
+	ReasoningTokens int
+	// CacheCreationTokens and CacheReadTokens are input tokens written to and
+	// served from the provider's prompt cache, which are billed differently
+	// and not included in the input total.
+	CacheCreationTokens int
+	CacheReadTokens     int
+}
+
+// UsageDetailParser is implemented by providers that report a usage breakdown
+// in non-streaming responses.
+type UsageDetailParser interface {
+	ParseUsageDetails(body []byte) UsageDetails
+}
+
+// ParseUsageDetails returns p's usage breakdown for body, or zero values if p
+// does not report one.
+func ParseUsageDetails(p Provider, body []byte) UsageDetails {
+	if d, ok := p.(UsageDetailParser); ok {
+		return d.ParseUsageDetails(body)
+	}
+	return UsageDetails{}
+}
+
+// estimateReasoningTokens approximates the token count of reasoning text for
+// backends that bill thinking as output but don't count it separately.
+func estimateReasoningTokens(text string) int {
+	n, _ := tokenizer.Count("", text)
+	return n
+}
+
+// GeminiForwarder is implemented by providers that speak the Gemini API
+// natively. Gemini-format requests to them are forwarded as-is instead of being
+// translated through ChatRequest. With stream set, the response is SSE.
+type GeminiForwarder interface {
+	ForwardGemini(model string, body []byte, stream bool) (*http.Response, error)
+}
+
+// ChoiceCounter is implemented by providers whose API returns several choices
+// for one request (OpenAI n, Gemini candidateCount). Requests with N > 1 to any
+// other provider are fanned out by the gateway as N concurrent upstream calls.
+type ChoiceCounter interface {
+	SupportsChoiceCount() bool
+}
+
+// SupportsChoiceCount reports whether p can honour ChatRequest.N natively.
+func SupportsChoiceCount(p Provider) bool {
+	c, ok := p.(ChoiceCounter)
+	return ok && c.SupportsChoiceCount()
 }
 
 // ChatMessage represents a single message in a conversation.
@@ -60,6 +141,33 @@ type ChatMessage struct {
 	Content    string     `json:"content"`
 	ToolCallID string     `json:"tool_call_id,omitempty"`
 	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	// ThinkingBlocks are an assistant message's signed thinking blocks, replayed
+	// to backends that require them.
+	ThinkingBlocks []ThinkingBlock `json:"thinking_blocks,omitempty"`
+	// CacheControl marks a prompt caching breakpoint after this message.
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// ThinkingBlock is an extended thinking block, in Anthropic's format. With
+// thinking enabled, Anthropic requires the blocks of an assistant tool-use turn
+// to be sent back unchanged, signature included, alongside the tool results.
+type ThinkingBlock struct {
+	// Type is "thinking" or "redacted_thinking".
+	Type      string `json:"type"`
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// Data is the encrypted content of a redacted_thinking block.
+	Data string `json:"data,omitempty"`
+}
+
+// CacheControl is a prompt caching breakpoint, in Anthropic's format: the
+// prompt up to and including the marked message or tool is cached. Providers
+// without explicit caching ignore it.
+type CacheControl struct {
+	// Type is "ephemeral", the only type Anthropic defines.
+	Type string `json:"type"`
+	// TTL is "5m" (the default) or "1h".
+	TTL string `json:"ttl,omitempty"`
 }
 
 // ChatRequest is the internal representation of a chat completion request
@@ -67,12 +175,148 @@ type ChatMessage struct {
 type ChatRequest struct {
 	Model          string         `json:"model"`
 	Messages       []ChatMessage  `json:"messages"`
-	MaxTokens      int            `json:"max_tokens,omitempty"`
-	Temperature    float64        `json:"temperature,omitempty"`
 	Stream         bool           `json:"stream,omitempty"`
 	Tools          []Tool         `json:"tools,omitempty"`
 	ResponseFormat any            `json:"response_format,omitempty"`
 	StreamOptions  *StreamOptions `json:"stream_options,omitempty"`
+
+	// Sampling parameters. Pointers are nil when the client did not send the
+	// field, so an explicit zero (e.g. temperature 0) is forwarded as such.
+	MaxTokens           *int               `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int               `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64           `json:"temperature,omitempty"`
+	TopP                *float64           `json:"top_p,omitempty"`
+	Stop                []string           `json:"stop,omitempty"`
+	Seed                *int64             `json:"seed,omitempty"`
+	PresencePenalty     *float64           `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64           `json:"frequency_penalty,omitempty"`
+	N                   *int               `json:"n,omitempty"`
+	LogitBias           map[string]float64 `json:"logit_bias,omitempty"`
+	User                string             `json:"user,omitempty"`
+
+	// ReasoningEffort is OpenAI's reasoning_effort ("none", "minimal", "low",
+	// "medium" or "high"). ThinkingBudget is an explicit reasoning token budget
+	// and takes precedence where the backend accepts one.
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	ThinkingBudget  *int   `json:"thinking_budget,omitempty"`
+
+	// Logprobs requests log probabilities of the output tokens, TopLogprobs
+	// the number of most likely alternatives returned for each position.
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs *int `json:"top_logprobs,omitempty"`
+
+	// ToolChoice restricts how the model may use Tools; nil means provider default.
+	ToolChoice *ToolChoice `json:"-"`
+	// ParallelToolCalls, when set to false, asks for at most one tool call per turn.
+	ParallelToolCalls *bool `json:"parallel_tool_calls,omitempty"`
+
+	// SafetySettings maps Gemini harm categories to block thresholds. It comes
+	// from the client's configuration, not the request; only Gemini uses it.
+	SafetySettings map[string]string `json:"-"`
+}
+
+// OutputTokenLimit returns the requested completion length cap, preferring
+// max_completion_tokens over the legacy max_tokens. It returns 0 when neither
+// was set.
+func (r *ChatRequest) OutputTokenLimit() int {
+	if r.MaxCompletionTokens != nil {
+		return *r.MaxCompletionTokens
+	}
+	if r.MaxTokens != nil {
+		return *r.MaxTokens
+	}
+	return 0
+}
+
+// reasoningBudgets maps reasoning_effort levels to thinking token budgets for
+// backends that take a budget rather than a level.
+var reasoningBudgets = map[string]int{
+	"none":    0,
+	"minimal": 512,
+	"low":     1024,
+	"medium":  8192,
+	"high":    24576,
+}
+
+// ReasoningBudget returns the thinking token budget requested via
+// thinking_budget or reasoning_effort. ok is false when neither was set.
+func (r *ChatRequest) ReasoningBudget() (budget int, ok bool) {
+	if r.ThinkingBudget != nil {
+		return *r.ThinkingBudget, true
+	}
+	if r.ReasoningEffort != "" {
+		return reasoningBudgets[r.ReasoningEffort], true
+	}
+	return 0, false
+}
+
+// ReasoningEffortLevel returns the requested reasoning_effort, deriving one
+// from thinking_budget when only a budget was given.
+func (r *ChatRequest) ReasoningEffortLevel() string {
+	if r.ReasoningEffort != "" || r.ThinkingBudget == nil {
+		return r.ReasoningEffort
+	}
+	switch b := *r.ThinkingBudget; {
+	case b <= reasoningBudgets["minimal"]:
+		return "minimal"
+	case b <= reasoningBudgets["low"]:
+		return "low"
+	case b <= reasoningBudgets["medium"]:
+		return "medium"
+	}
+	return "high"
+}
+
+// applyOpenAISampling copies every sampling parameter the client set onto an
+// OpenAI-format request body, unchanged, along with the reasoning effort.
+func applyOpenAISampling(body map[string]interface{}, req *ChatRequest) {
+	if effort := req.ReasoningEffortLevel(); effort != "" {
+		body["reasoning_effort"] = effort
+	}
+	if req.MaxTokens != nil {
+		body["max_tokens"] = *req.MaxTokens
+	}
+	if req.MaxCompletionTokens != nil {
+		body["max_completion_tokens"] = *req.MaxCompletionTokens
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		body["top_p"] = *req.TopP
+	}
+	if len(req.Stop) > 0 {
+		body["stop"] = req.Stop
+	}
+	if req.Seed != nil {
+		body["seed"] = *req.Seed
+	}
+	if req.PresencePenalty != nil {
+		body["presence_penalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		body["frequency_penalty"] = *req.FrequencyPenalty
+	}
+	if req.N != nil {
+		body["n"] = *req.N
+	}
+	if len(req.LogitBias) > 0 {
+		body["logit_bias"] = req.LogitBias
+	}
+	if req.User != "" {
+		body["user"] = req.User
+	}
+	if req.Logprobs {
+		body["logprobs"] = true
+	}
+	if req.TopLogprobs != nil {
+		body["top_logprobs"] = *req.TopLogprobs
+	}
+}
+
+// unsupportedParam reports a sampling parameter the provider cannot honour.
+func unsupportedParam(p Provider, param string) error {
+	return fmt.Errorf("%s is not supported by the %s provider", param, p.Name())
 }
 
 type StreamOptions struct {
@@ -83,6 +327,9 @@ type StreamOptions struct {
 type Tool struct {
 	Type     string        `json:"type"`
 	Function *ToolFunction `json:"function,omitempty"`
+	// CacheControl marks a prompt caching breakpoint after this tool. It is
+	// not serialized, as OpenAI-format backends are sent tools as they are.
+	CacheControl *CacheControl `json:"-"`
 }
 
 type ToolFunction struct {
@@ -91,6 +338,57 @@ type ToolFunction struct {
 	Parameters  any    `json:"parameters"`
 }
 
+// Tool choice modes, matching OpenAI's tool_choice values.
+const (
+	ToolChoiceAuto     = "auto"
+	ToolChoiceNone     = "none"
+	ToolChoiceRequired = "required"
+	ToolChoiceFunction = "function"
+)
+
+// ToolChoice is the normalized form of OpenAI's tool_choice.
+type ToolChoice struct {
+	// Mode is one of the ToolChoice* constants.
+	Mode string
+	// Function is the forced function name when Mode is ToolChoiceFunction.
+	Function string
+}
+
+// ParseToolChoice normalizes an OpenAI tool_choice value, which is either one of
+// "auto", "none", "required" or {"type":"function","function":{"name":"..."}}.
+// It returns nil for an absent value.
+func ParseToolChoice(v any) (*ToolChoice, error) {
+	switch tc := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		switch tc {
+		case ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+			return &ToolChoice{Mode: tc}, nil
+		}
+		return nil, fmt.Errorf("invalid tool_choice %q: must be auto, none, required or a function object", tc)
+	case map[string]interface{}:
+		fn, _ := tc["function"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		if t, _ := tc["type"].(string); t != "function" || name == "" {
+			return nil, fmt.Errorf("invalid tool_choice: object form must be {\"type\":\"function\",\"function\":{\"name\":...}}")
+		}
+		return &ToolChoice{Mode: ToolChoiceFunction, Function: name}, nil
+	}
+	return nil, fmt.Errorf("invalid tool_choice: unsupported type %T", v)
+}
+
+// OpenAI renders the choice back into OpenAI's wire format.
+func (tc *ToolChoice) OpenAI() any {
+	if tc.Mode == ToolChoiceFunction {
+		return map[string]interface{}{
+			"type":     "function",
+			"function": map[string]interface{}{"name": tc.Function},
+		}
+	}
+	return tc.Mode
+}
+
 // ToolCall represents a tool call requested by the model
 type ToolCall struct {
 	ID        string
@@ -154,11 +452,66 @@ type URLOverridable interface {
 	WithBaseURL(url string) Provider
 }
 
+// maxTopLogprobs is the largest top_logprobs OpenAI and Gemini accept.
+const maxTopLogprobs = 20
+
+// RequestValidator is implemented by providers that cannot honour every
+// ChatRequest option. ValidateRequest returns an error naming the first option
+// the backend cannot support.
+type RequestValidator interface {
+	ValidateRequest(req *ChatRequest) error
+}
+
+// ValidateRequest checks options that are invalid regardless of backend, then
+// defers to the provider's own RequestValidator if it has one.
+func ValidateRequest(p Provider, req *ChatRequest) error {
+	if tc := req.ToolChoice; tc != nil && tc.Mode != ToolChoiceAuto && tc.Mode != ToolChoiceNone {
+		if len(req.Tools) == 0 {
+			return fmt.Errorf("tool_choice %q requires tools", tc.Mode)
+		}
+		if tc.Mode == ToolChoiceFunction && !hasTool(req.Tools, tc.Function) {
+			return fmt.Errorf("tool_choice references unknown function %q", tc.Function)
+		}
+	}
+	if req.ReasoningEffort != "" {
+		if _, ok := reasoningBudgets[req.ReasoningEffort]; !ok {
+			return fmt.Errorf("invalid reasoning_effort %q: must be none, minimal, low, medium or high", req.ReasoningEffort)
+		}
+	}
+	if req.ThinkingBudget != nil && *req.ThinkingBudget < 0 {
+		return fmt.Errorf("thinking_budget must not be negative")
+	}
+	if req.TopLogprobs != nil {
+		if !req.Logprobs {
+			return fmt.Errorf("top_logprobs requires logprobs to be true")
+		}
+		if *req.TopLogprobs < 0 || *req.TopLogprobs > maxTopLogprobs {
+			return fmt.Errorf("top_logprobs must be between 0 and %d", maxTopLogprobs)
+		}
+	}
+	if v, ok := p.(RequestValidator); ok {
+		return v.ValidateRequest(req)
+	}
+	return nil
+}
+
+func hasTool(tools []Tool, name string) bool {
+	for _, t := range tools {
+		if t.Function != nil && t.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // BuildRegistry creates a provider registry from the config's providers section.
 func BuildRegistry(cfg *config.Config) *Registry {
 	reg := NewRegistry()
 
 	for name, pcfg := range cfg.Providers {
+		if f := pcfg.ToolCallParser; f != "" && textToolMarkers[f] == nil {
+			log.Printf("[%s] Unknown tool_call_parser %q; tool calls in text will not be parsed", name, f)
+		}
 		p := buildProvider(name, pcfg)
 		reg.Register(name, p)
 	}
@@ -200,6 +553,8 @@ func buildProvider(name string, pcfg config.ProviderConfig) Provider {
 		return NewAzureOpenAIProvider(pcfg)
 	case "vllm":
 		return NewVLLMProvider(pcfg)
+	case "llamacpp":
+		return NewLlamaCppProvider(name, pcfg)
 	case "openrouter":
 		return NewOpenRouterProvider(pcfg)
 	default:
@@ -0,0 +1,127 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// Reranker is implemented by providers with a rerank endpoint in the
+// Cohere/Jina schema (Cohere, vLLM, and OpenAI-format servers such as
+// llama.cpp or Jina).
+type Reranker interface {
+	// Rerank scores each document against the query and returns the results
+	// best first, along with the upstream HTTP status code.
+	Rerank(req *RerankRequest) (*RerankResponse, int, error)
+}
+
+type RerankRequest struct {
+	Model     string
+	Query     string
+	Documents []string
+	// TopN limits the results to the best n; 0 returns all.
+	TopN int
+}
+
+type RerankResponse struct {
+	Results []RerankResult
+	// InputTokens is the upstream's usage, or 0 if it reports none.
+	InputTokens int
+}
+
+type RerankResult struct {
+	// Index is the document's position in the request.
+	Index          int
+	RelevanceScore float64
+}
+
+func rerankBody(req *RerankRequest) []byte {
+	body := map[string]interface{}{
+		"model":     req.Model,
+		"query":     req.Query,
+		"documents": req.Documents,
+	}
+	if req.TopN > 0 {
+		body["top_n"] = req.TopN
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+// doRerankRequest sends a rerank request in the Cohere/Jina schema and
+// decodes a successful body. Upstream error bodies are returned in the error.
+func doRerankRequest(client *http.Client, httpReq *http.Request, req *RerankRequest) (*RerankResponse, int, error) {
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, resp.StatusCode, fmt.Errorf("rerank request failed: %s", body)
+	}
+
+	out, err := parseRerank(body, len(req.Documents))
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse rerank results: %w", err)
+	}
+	if req.TopN > 0 && len(out.Results) > req.TopN {
+		out.Results = out.Results[:req.TopN]
+	}
+	return out, resp.StatusCode, nil
+}
+
+// parseRerank reads results and usage from Cohere ("meta"), Jina and vLLM
+// ("usage") responses, sorting the results best first.
+func parseRerank(body []byte, documents int) (*RerankResponse, error) {
+	var resp struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+			TotalTokens  int `json:"total_tokens"`
+		} `json:"usage"`
+		Meta struct {
+			Tokens struct {
+				InputTokens float64 `json:"input_tokens"`
+			} `json:"tokens"`
+			BilledUnits struct {
+				InputTokens float64 `json:"input_tokens"`
+			} `json:"billed_units"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	out := &RerankResponse{Results: make([]RerankResult, len(resp.Results))}
+	for i, r := range resp.Results {
+		if r.Index < 0 || r.Index >= documents {
+			return nil, fmt.Errorf("result index %d out of range", r.Index)
+		}
+		out.Results[i] = RerankResult{Index: r.Index, RelevanceScore: r.RelevanceScore}
+	}
+	sort.SliceStable(out.Results, func(i, j int) bool {
+		return out.Results[i].RelevanceScore > out.Results[j].RelevanceScore
+	})
+
+	switch {
+	case resp.Usage.PromptTokens > 0:
+		out.InputTokens = resp.Usage.PromptTokens
+	case resp.Usage.TotalTokens > 0:
+		out.InputTokens = resp.Usage.TotalTokens
+	case resp.Meta.Tokens.InputTokens > 0:
+		out.InputTokens = int(resp.Meta.Tokens.InputTokens)
+	default:
+		out.InputTokens = int(resp.Meta.BilledUnits.InputTokens)
+	}
+	return out, nil
+}
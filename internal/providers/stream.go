@@ -0,0 +1,320 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"ai-gateway/internal/sse"
+)
+
+// StreamEventType identifies the kind of event yielded by an EventStream.
+type StreamEventType int
+
+const (
+	// StreamEventText carries a content text delta.
+	StreamEventText StreamEventType = iota
+	// StreamEventToolCall carries a fragment of a tool call, keyed by its index.
+	StreamEventToolCall
+	// StreamEventUsage carries token counts reported by the upstream.
+	StreamEventUsage
+	// StreamEventFinish carries the normalized (OpenAI-style) finish reason.
+	StreamEventFinish
+	// StreamEventError reports an error the upstream sent inside the stream.
+	StreamEventError
+	// StreamEventReasoning carries a reasoning/thinking text delta, kept apart
+	// from the answer text.
+	StreamEventReasoning
+	// StreamEventThinkingBlock carries a complete signed thinking block once
+	// its reasoning has streamed.
+	StreamEventThinkingBlock
+)
+
+// StreamEvent is a single typed event decoded from a provider stream.
+// Only the fields relevant to Type are set. Choice is the index of the choice
+// the event belongs to; it is 0 unless several choices were requested.
+type StreamEvent struct {
+	Type          StreamEventType
+	Choice        int
+	Text          string
+	ToolCall      *StreamToolCall
+	ThinkingBlock *ThinkingBlock
+	InputTokens   int
+	OutputTokens  int
+	// ReasoningTokens is the part of OutputTokens spent on reasoning.
+	ReasoningTokens int
+	FinishReason    string
+	Err             error
+	// Logprobs accompanies a text event when logprobs were requested, in
+	// OpenAI format ({"content":[...]}). The text may then be empty.
+	Logprobs json.RawMessage
+	// CacheCreationTokens and CacheReadTokens are prompt cache usage, on top
+	// of InputTokens.
+	CacheCreationTokens int
+	CacheReadTokens     int
+	// Safety accompanies a content_filter finish event when the backend
+	// says why.
+	Safety *SafetyFeedback
+}
+
+// StreamToolCall is a fragment of a streamed tool call. The first fragment for
+// an index usually carries ID and Name; later fragments append to Arguments.
+type StreamToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+	Index     int
+}
+
+// streamDecoder turns one stream payload into zero or more events. The event
+// name is the SSE event type ("message" when unnamed, "" for NDJSON). Decoders
+// may keep state between payloads, so every stream gets its own decoder.
+type streamDecoder func(event string, data []byte) []StreamEvent
+
+// streamSource yields raw payloads from a response body.
+type streamSource interface {
+	next() (event string, data []byte, err error)
+}
+
+// sseSource reads Server-Sent Events.
+type sseSource struct {
+	dec *sse.Decoder
+}
+
+func (s *sseSource) next() (string, []byte, error) {
+	ev, err := s.dec.Next()
+	if err != nil {
+		return "", nil, err
+	}
+	return ev.Type, ev.Data, nil
+}
+
+// ndjsonSource reads newline-delimited JSON, as used by Ollama.
+type ndjsonSource struct {
+	r       *bufio.Reader
+	maxSize int
+}
+
+func (s *ndjsonSource) next() (string, []byte, error) {
+	var line []byte
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		if len(line)+len(chunk) > s.maxSize {
+			return "", nil, fmt.Errorf("stream line exceeds %d bytes", s.maxSize)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return "", nil, err
+		}
+		return "", line, nil
+	}
+}
+
+// EventStream iterates over the typed events of a streaming response:
+//
+//	stream := provider.StreamEvents(resp)
+//	defer stream.Close()
+//	for stream.Next() {
+//		ev := stream.Event()
+//	}
+//	if err := stream.Err(); err != nil { ... }
+type EventStream struct {
+	body    io.Closer
+	source  streamSource
+	decode  streamDecoder
+	pending []StreamEvent
+	current StreamEvent
+	done    bool
+	err     error
+	// flush, if set, yields the events still held back when the body ends.
+	flush func() []StreamEvent
+}
+
+// newSSEStream decodes a text/event-stream body.
+func newSSEStream(body io.ReadCloser, decode streamDecoder) *EventStream {
+	return &EventStream{
+		body:   body,
+		source: &sseSource{dec: sse.NewDecoder(body, sse.DefaultMaxEventSize)},
+		decode: decode,
+	}
+}
+
+// newNDJSONStream decodes a body with one JSON object per line.
+func newNDJSONStream(body io.ReadCloser, decode streamDecoder) *EventStream {
+	return &EventStream{
+		body:   body,
+		source: &ndjsonSource{r: bufio.NewReader(body), maxSize: sse.DefaultMaxEventSize},
+		decode: decode,
+	}
+}
+
+// Next advances to the next event. It returns false at the end of the stream or
+// on a read error, which is then available from Err. An oversized event is a
+// read error rather than a silent end of stream.
+func (s *EventStream) Next() bool {
+	for len(s.pending) == 0 {
+		if s.done {
+			return false
+		}
+		event, data, err := s.source.next()
+		if err != nil {
+			s.done = true
+			if err != io.EOF {
+				s.err = err
+			}
+			s.pending = s.drain()
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		if string(data) == "[DONE]" {
+			s.done = true
+			s.pending = s.drain()
+			continue
+		}
+		s.pending = s.decode(event, data)
+	}
+	s.current = s.pending[0]
+	s.pending = s.pending[1:]
+	return true
+}
+
+// drain returns the events flush holds back, once.
+func (s *EventStream) drain() []StreamEvent {
+	if s.flush == nil {
+		return nil
+	}
+	flush := s.flush
+	s.flush = nil
+	return flush()
+}
+
+// Event returns the event Next advanced to.
+func (s *EventStream) Event() StreamEvent {
+	return s.current
+}
+
+// Err returns the read error that ended the stream, if any.
+func (s *EventStream) Err() error {
+	return s.err
+}
+
+// Close releases the underlying response body.
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
+
+// streamErrorEvent builds an error event from an upstream error payload, which
+// is either a plain string or an object with a message field.
+func streamErrorEvent(raw json.RawMessage) (StreamEvent, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return StreamEvent{}, false
+	}
+	var msg string
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		var obj struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(raw, &obj)
+		msg = obj.Message
+	}
+	if msg == "" {
+		msg = "upstream stream error"
+	}
+	return StreamEvent{Type: StreamEventError, Err: errors.New(msg)}, true
+}
+
+// nonNullJSON returns raw, or nil if it is absent or JSON null.
+func nonNullJSON(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return raw
+}
+
+// openAIStreamChunk is the chat.completion.chunk format shared by OpenAI,
+// Azure, vLLM and every other OpenAI-compatible backend.
+type openAIStreamChunk struct {
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Content string `json:"content"`
+			// reasoning_content is used by DeepSeek and vLLM reasoning parsers,
+			// reasoning by OpenRouter and newer vLLM releases.
+			ReasoningContent string `json:"reasoning_content"`
+			Reasoning        string `json:"reasoning"`
+			ToolCalls        []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string          `json:"finish_reason"`
+		Logprobs     json.RawMessage `json:"logprobs"`
+	} `json:"choices"`
+	Usage *openAIUsage    `json:"usage"`
+	Error json.RawMessage `json:"error"`
+}
+
+// openAIUsage is the usage object of OpenAI-format responses and chunks.
+type openAIUsage struct {
+	PromptTokens            int `json:"prompt_tokens"`
+	CompletionTokens        int `json:"completion_tokens"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+}
+
+func decodeOpenAIStreamChunk(_ string, data []byte) []StreamEvent {
+	var chunk openAIStreamChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil
+	}
+
+	var events []StreamEvent
+	if ev, ok := streamErrorEvent(chunk.Error); ok {
+		return append(events, ev)
+	}
+
+	for _, choice := range chunk.Choices {
+		if reasoning := choice.Delta.ReasoningContent + choice.Delta.Reasoning; reasoning != "" {
+			events = append(events, StreamEvent{Type: StreamEventReasoning, Choice: choice.Index, Text: reasoning})
+		}
+		if logprobs := nonNullJSON(choice.Logprobs); choice.Delta.Content != "" || logprobs != nil {
+			events = append(events, StreamEvent{Type: StreamEventText, Choice: choice.Index, Text: choice.Delta.Content, Logprobs: logprobs})
+		}
+		for _, tc := range choice.Delta.ToolCalls {
+			events = append(events, StreamEvent{Type: StreamEventToolCall, Choice: choice.Index, ToolCall: &StreamToolCall{
+				ID:        tc.ID,
+				Name:      tc.Function.Name,
+				Arguments: tc.Function.Arguments,
+				Index:     tc.Index,
+			}})
+		}
+		if choice.FinishReason != "" {
+			events = append(events, StreamEvent{Type: StreamEventFinish, Choice: choice.Index, FinishReason: choice.FinishReason})
+		}
+	}
+
+	if chunk.Usage != nil {
+		events = append(events, StreamEvent{
+			Type:            StreamEventUsage,
+			InputTokens:     chunk.Usage.PromptTokens,
+			OutputTokens:    chunk.Usage.CompletionTokens,
+			ReasoningTokens: chunk.Usage.CompletionTokensDetails.ReasoningTokens,
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"encoding/json"
+)
+
+// jsonResponseTool is the tool Anthropic is forced to call to emulate a
+// json_schema response_format; its input is returned as the message text.
+const jsonResponseTool = "json_response"
+
+// ResponseSchema reads an OpenAI response_format. It reports whether JSON
+// output was requested (json_object or json_schema) and returns the schema
+// of a json_schema format, or nil.
+func ResponseSchema(responseFormat any) (map[string]interface{}, bool) {
+	rf, ok := responseFormat.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	switch rf["type"] {
+	case "json_object":
+		return nil, true
+	case "json_schema":
+		js, _ := rf["json_schema"].(map[string]interface{})
+		schema, _ := js["schema"].(map[string]interface{})
+		return schema, true
+	}
+	return nil, false
+}
+
+// jsonInstruction is the system prompt addition that emulates JSON mode on
+// backends without one, asking for JSON only, matching the schema if given.
+func jsonInstruction(schema map[string]interface{}) string {
+	if schema == nil {
+		return "Respond only with a valid JSON object, without markdown code fences or any other text."
+	}
+	data, _ := json.Marshal(schema)
+	return "Respond only with valid JSON matching this JSON Schema, without markdown code fences or any other text:\n" + string(data)
+}
+
+// withJSONInstruction adds jsonInstruction to the system prompt.
+func withJSONInstruction(messages []ChatMessage, schema map[string]interface{}) []ChatMessage {
+	return withSystemInstruction(messages, jsonInstruction(schema))
+}
+
+// withSystemInstruction adds instruction to the first system message, or
+// prepends one.
+func withSystemInstruction(messages []ChatMessage, instruction string) []ChatMessage {
+	out := make([]ChatMessage, 0, len(messages)+1)
+	for i, m := range messages {
+		if m.Role == "system" {
+			m.Content += "\n\n" + instruction
+			out = append(out, m)
+			return append(out, messages[i+1:]...)
+		}
+		out = append(out, m)
+	}
+	return append([]ChatMessage{{Role: "system", Content: instruction}}, messages...)
+}
@@ -0,0 +1,464 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ai-gateway/internal/config"
+	"ai-gateway/internal/jsonrepair"
+)
+
+// Tool call text formats accepted by ProviderConfig.ToolCallParser.
+const (
+	ToolCallParserHermes  = "hermes"
+	ToolCallParserMistral = "mistral"
+	ToolCallParserAuto    = "auto"
+)
+
+const (
+	hermesCallStart = "<tool_call>"
+	hermesCallEnd   = "</tool_call>"
+	mistralCalls    = "[TOOL_CALLS]"
+	mistralArgs     = "[ARGS]"
+	mistralCallID   = "[CALL_ID]"
+)
+
+// textToolMarkers are the markers that open a tool call in each format.
+var textToolMarkers = map[string][]string{
+	ToolCallParserHermes:  {hermesCallStart},
+	ToolCallParserMistral: {mistralCalls},
+	ToolCallParserAuto:    {hermesCallStart, mistralCalls},
+}
+
+// textToolFormat returns the tool call text format to parse for a provider,
+// or "" for none. Emulated tools are called in Hermes format.
+func textToolFormat(cfg config.ProviderConfig) string {
+	switch {
+	case !cfg.ToolEmulation:
+		return cfg.ToolCallParser
+	case cfg.ToolCallParser == "" || cfg.ToolCallParser == ToolCallParserHermes:
+		return ToolCallParserHermes
+	}
+	return ToolCallParserAuto
+}
+
+// textToolScanner finds tool calls written into a choice's text. Text is fed
+// in as it streams; anything that could be the start of a marker is held back
+// until it can be told apart from ordinary content.
+type textToolScanner struct {
+	markers []string
+	buf     string
+	// open is the marker of the call being read, "" outside a call.
+	open string
+	// next is the index given to the next call, after any native ones.
+	next  int
+	calls int
+}
+
+func newTextToolScanner(format string) *textToolScanner {
+	return &textToolScanner{markers: textToolMarkers[format]}
+}
+
+// feed adds text and returns the content and tool calls that are complete.
+// With final set, everything left is returned.
+func (s *textToolScanner) feed(text string, final bool) (string, []ToolCall) {
+	s.buf += text
+	var content strings.Builder
+	var calls []ToolCall
+	for {
+		if s.open == "" {
+			i, marker := s.findMarker()
+			if i < 0 {
+				keep := 0
+				if !final {
+					keep = s.partialMarker()
+				}
+				content.WriteString(s.buf[:len(s.buf)-keep])
+				s.buf = s.buf[len(s.buf)-keep:]
+				break
+			}
+			content.WriteString(strings.TrimRight(s.buf[:i], " \t\r\n"))
+			s.buf = s.buf[i+len(marker):]
+			s.open = marker
+			continue
+		}
+
+		var body, raw string
+		if s.open == hermesCallStart {
+			end := strings.Index(s.buf, hermesCallEnd)
+			if end < 0 && !final {
+				break
+			}
+			if end < 0 {
+				// The output ended inside the call, e.g. at max_tokens. Its
+				// arguments may be cut short, so it isn't run.
+				content.WriteString(hermesCallStart + s.buf)
+				s.buf, s.open = "", ""
+				continue
+			}
+			body, raw = s.buf[:end], hermesCallStart+s.buf[:end+len(hermesCallEnd)]
+			s.buf = s.buf[end+len(hermesCallEnd):]
+		} else {
+			// Mistral calls run to the end of the output.
+			if !final {
+				break
+			}
+			body, raw, s.buf = s.buf, mistralCalls+s.buf, ""
+		}
+
+		var parsed []ToolCall
+		var ok bool
+		if s.open == hermesCallStart {
+			parsed, ok = parseJSONToolCalls(body, true)
+		} else {
+			parsed, ok = parseMistralToolCalls(body)
+		}
+		s.open = ""
+		if !ok {
+			// Not a call after all; leave the text as the model wrote it.
+			content.WriteString(raw)
+			continue
+		}
+		calls = append(calls, parsed...)
+		s.calls += len(parsed)
+		s.buf = strings.TrimLeft(s.buf, " \t\r\n")
+	}
+	return content.String(), calls
+}
+
+// findMarker returns the position and marker of the first marker in buf.
+func (s *textToolScanner) findMarker() (int, string) {
+	pos, found := -1, ""
+	for _, m := range s.markers {
+		if i := strings.Index(s.buf, m); i >= 0 && (pos < 0 || i < pos) {
+			pos, found = i, m
+		}
+	}
+	return pos, found
+}
+
+// partialMarker returns the length of the longest suffix of buf that is the
+// beginning of a marker.
+func (s *textToolScanner) partialMarker() int {
+	longest := 0
+	for _, m := range s.markers {
+		for n := min(len(m)-1, len(s.buf)); n > longest; n-- {
+			if strings.HasSuffix(s.buf, m[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// textToolCall is a tool call as models write it: Hermes and JSON-form
+// Mistral calls use arguments, some fine-tunes parameters.
+type textToolCall struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments"`
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+// parseJSONToolCalls parses one call object, or an array of them. Each call
+// needs a name, and arguments that are an object or absent. Unless closed is
+// set, i.e. the markup had a closing marker, JSON that was cut short is not
+// taken as a call.
+func parseJSONToolCalls(body string, closed bool) ([]ToolCall, bool) {
+	repaired, repairs, err := jsonrepair.Repair(strings.TrimSpace(body))
+	if err != nil || (!closed && truncatedJSON(repairs)) {
+		return nil, false
+	}
+	var list []textToolCall
+	if strings.HasPrefix(repaired, "[") {
+		if json.Unmarshal([]byte(repaired), &list) != nil {
+			return nil, false
+		}
+	} else {
+		var one textToolCall
+		if json.Unmarshal([]byte(repaired), &one) != nil {
+			return nil, false
+		}
+		list = []textToolCall{one}
+	}
+	if len(list) == 0 {
+		return nil, false
+	}
+
+	calls := make([]ToolCall, len(list))
+	for i, c := range list {
+		if c.Name == "" {
+			return nil, false
+		}
+		args := c.Arguments
+		if len(args) == 0 {
+			args = c.Parameters
+		}
+		arguments := textToolArguments(args)
+		if !strings.HasPrefix(arguments, "{") {
+			return nil, false
+		}
+		calls[i] = ToolCall{ID: c.ID, Name: c.Name, Arguments: arguments}
+	}
+	return calls, true
+}
+
+// truncatedJSON reports whether jsonrepair had to close strings or brackets,
+// i.e. the JSON was cut short.
+func truncatedJSON(repairs []string) bool {
+	for _, r := range repairs {
+		if strings.HasPrefix(r, "closed unterminated") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMistralToolCalls parses what follows [TOOL_CALLS]: a JSON array of
+// calls in older Mistral templates, name[ARGS]{...} in newer ones, with each
+// further call opened by another [TOOL_CALLS]. The calls have no closing
+// marker, so arguments cut short by the end of the output are rejected.
+func parseMistralToolCalls(body string) ([]ToolCall, bool) {
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "[") || strings.HasPrefix(body, "{") {
+		return parseJSONToolCalls(body, false)
+	}
+
+	var calls []ToolCall
+	for _, segment := range strings.Split(body, mistralCalls) {
+		head, args, ok := strings.Cut(segment, mistralArgs)
+		if !ok {
+			return nil, false
+		}
+		name, id, _ := strings.Cut(head, mistralCallID)
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, false
+		}
+		repaired, repairs, err := jsonrepair.Repair(strings.TrimSpace(args))
+		if err != nil || truncatedJSON(repairs) || !strings.HasPrefix(repaired, "{") {
+			return nil, false
+		}
+		calls = append(calls, ToolCall{ID: strings.TrimSpace(id), Name: name, Arguments: repaired})
+	}
+	return calls, len(calls) > 0
+}
+
+// textToolArguments renders written arguments as the JSON string of an
+// OpenAI tool call. Arguments written as a string are taken as its content.
+func textToolArguments(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "{}"
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if repaired, _, err := jsonrepair.Repair(s); err == nil {
+			return repaired
+		}
+		return s
+	}
+	return string(raw)
+}
+
+// extractTextToolCalls returns text without the tool calls written into it in
+// format, and the calls. Text is returned unchanged when format is "".
+func extractTextToolCalls(format, text string) (string, []ToolCall) {
+	if textToolMarkers[format] == nil || text == "" {
+		return text, nil
+	}
+	content, calls := newTextToolScanner(format).feed(text, true)
+	if len(calls) == 0 {
+		return text, nil
+	}
+	return strings.TrimSpace(content), calls
+}
+
+// withTextToolChoices adds the tool calls written into each choice's text to
+// its parsed calls.
+func withTextToolChoices(format string, choices []Choice, err error) ([]Choice, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range choices {
+		text, calls := extractTextToolCalls(format, choices[i].Text)
+		if len(calls) > 0 {
+			choices[i].Text = text
+			choices[i].ToolCalls = append(choices[i].ToolCalls, calls...)
+			choices[i].FinishReason = "tool_calls"
+		}
+	}
+	return choices, nil
+}
+
+// withTextToolCalls makes the stream turn tool calls written into its text in
+// format into tool call events, removing the markup from the text. Each
+// choice's text is flushed before its finish event, whose reason becomes
+// tool_calls if any were found.
+func (s *EventStream) withTextToolCalls(format string) *EventStream {
+	if textToolMarkers[format] == nil {
+		return s
+	}
+	scanners := make(map[int]*textToolScanner)
+	scanner := func(choice int) *textToolScanner {
+		sc, ok := scanners[choice]
+		if !ok {
+			sc = newTextToolScanner(format)
+			scanners[choice] = sc
+		}
+		return sc
+	}
+	emit := func(events []StreamEvent, choice int, text string, calls []ToolCall) []StreamEvent {
+		if text != "" {
+			events = append(events, StreamEvent{Type: StreamEventText, Choice: choice, Text: text})
+		}
+		sc := scanner(choice)
+		for _, tc := range calls {
+			events = append(events, StreamEvent{Type: StreamEventToolCall, Choice: choice, ToolCall: &StreamToolCall{
+				ID: tc.ID, Name: tc.Name, Arguments: tc.Arguments, Index: sc.next,
+			}})
+			sc.next++
+		}
+		return events
+	}
+
+	decode := s.decode
+	s.decode = func(event string, data []byte) []StreamEvent {
+		var out []StreamEvent
+		for _, ev := range decode(event, data) {
+			switch ev.Type {
+			case StreamEventText:
+				text, calls := scanner(ev.Choice).feed(ev.Text, false)
+				n := len(out)
+				out = emit(out, ev.Choice, text, calls)
+				if ev.Logprobs != nil {
+					if len(out) > n && out[n].Type == StreamEventText {
+						out[n].Logprobs = ev.Logprobs
+					} else {
+						out = append(out, StreamEvent{Type: StreamEventText, Choice: ev.Choice, Logprobs: ev.Logprobs})
+					}
+				}
+			case StreamEventToolCall:
+				sc := scanner(ev.Choice)
+				sc.next = max(sc.next, ev.ToolCall.Index+1)
+				out = append(out, ev)
+			case StreamEventFinish:
+				sc := scanner(ev.Choice)
+				text, calls := sc.feed("", true)
+				out = emit(out, ev.Choice, text, calls)
+				if sc.calls > 0 && (ev.FinishReason == "stop" || ev.FinishReason == "") {
+					ev.FinishReason = "tool_calls"
+				}
+				out = append(out, ev)
+			default:
+				out = append(out, ev)
+			}
+		}
+		return out
+	}
+	s.flush = func() []StreamEvent {
+		// Streams that end without a finish event.
+		var out []StreamEvent
+		for choice, sc := range scanners {
+			text, calls := sc.feed("", true)
+			out = emit(out, choice, text, calls)
+		}
+		return out
+	}
+	return s
+}
+
+// emulateTools rewrites a request for a provider with tool emulation: tools
+// are described in the system prompt with instructions to call them in Hermes
+// format, earlier calls become that markup in assistant turns, and tool
+// results become <tool_response> user turns.
+func emulateTools(cfg config.ProviderConfig, req *ChatRequest) *ChatRequest {
+	if !cfg.ToolEmulation {
+		return req
+	}
+	history := false
+	for _, m := range req.Messages {
+		if m.Role == "tool" || len(m.ToolCalls) > 0 {
+			history = true
+			break
+		}
+	}
+	if len(req.Tools) == 0 && !history {
+		return req
+	}
+
+	out := *req
+	out.Tools, out.ToolChoice, out.ParallelToolCalls = nil, nil, nil
+	names := make(map[string]string)
+	messages := make([]ChatMessage, 0, len(req.Messages)+1)
+	for _, m := range req.Messages {
+		switch {
+		case m.Role == "assistant" && len(m.ToolCalls) > 0:
+			parts := []string{}
+			if m.Content != "" {
+				parts = append(parts, m.Content)
+			}
+			for _, tc := range m.ToolCalls {
+				names[tc.ID] = tc.Name
+				call, _ := json.Marshal(map[string]interface{}{"name": tc.Name, "arguments": argumentsJSON(tc.Arguments)})
+				parts = append(parts, hermesCallStart+"\n"+string(call)+"\n"+hermesCallEnd)
+			}
+			messages = append(messages, ChatMessage{Role: "assistant", Content: strings.Join(parts, "\n")})
+		case m.Role == "tool":
+			result, _ := json.Marshal(map[string]string{"name": names[m.ToolCallID], "content": m.Content})
+			response := "<tool_response>\n" + string(result) + "\n</tool_response>"
+			// Results of parallel calls share one user turn.
+			if last := len(messages) - 1; last >= 0 && messages[last].Role == "user" && strings.HasSuffix(messages[last].Content, "</tool_response>") {
+				messages[last].Content += "\n" + response
+			} else {
+				messages = append(messages, ChatMessage{Role: "user", Content: response})
+			}
+		default:
+			messages = append(messages, m)
+		}
+	}
+	if len(req.Tools) > 0 && (req.ToolChoice == nil || req.ToolChoice.Mode != ToolChoiceNone) {
+		messages = withSystemInstruction(messages, toolsInstruction(req.Tools, req.ToolChoice, req.ParallelToolCalls))
+	}
+	out.Messages = messages
+	return &out
+}
+
+// argumentsJSON returns a tool call's arguments as a JSON value, repaired if
+// need be, or as a JSON string if they can't be.
+func argumentsJSON(args string) json.RawMessage {
+	if repaired, _, err := jsonrepair.Repair(args); err == nil {
+		return json.RawMessage(repaired)
+	}
+	data, _ := json.Marshal(args)
+	return data
+}
+
+// toolsInstruction is the system prompt that offers tools to a model without
+// native tool support, in the format of the Hermes chat template.
+func toolsInstruction(tools []Tool, choice *ToolChoice, parallel *bool) string {
+	var b strings.Builder
+	b.WriteString("You may call one or more functions to assist with the user query. The functions are described by these JSON signatures:\n<tools>\n")
+	for _, t := range tools {
+		data, _ := json.Marshal(t)
+		b.Write(data)
+		b.WriteString("\n")
+	}
+	b.WriteString("</tools>\n")
+	b.WriteString("To call a function, reply with a JSON object with its name and arguments inside <tool_call></tool_call> tags:\n")
+	b.WriteString("<tool_call>\n{\"name\": <function-name>, \"arguments\": <arguments-object>}\n</tool_call>\n")
+	b.WriteString("Function results are returned inside <tool_response></tool_response> tags.")
+	if choice != nil {
+		switch choice.Mode {
+		case ToolChoiceRequired:
+			b.WriteString("\nYou must call at least one function.")
+		case ToolChoiceFunction:
+			fmt.Fprintf(&b, "\nYou must call the %s function.", choice.Function)
+		}
+	}
+	if parallel != nil && !*parallel {
+		b.WriteString("\nCall at most one function per reply.")
+	}
+	return b.String()
+}
@@ -72,15 +72,7 @@ func (p *VLLMProvider) WithBaseURL(url string) Provider {
 func (p *VLLMProvider) ChatCompletion(req *ChatRequest) ([]byte, int, error) {
 	url := p.cfg.BaseURL + "/chat/completions"
 
-	reqBody := map[string]interface{}{
-		"model":       req.Model,
-		"messages":    p.convertMessages(req.Messages),
-		"temperature": req.Temperature,
-		"max_tokens":  req.MaxTokens,
-		"stream":      false,
-	}
-
-	body, _ := json.Marshal(reqBody)
+	body := p.buildRequestBody(req, false)
 
 	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
@@ -99,18 +91,45 @@ func (p *VLLMProvider) ChatCompletion(req *ChatRequest) ([]byte, int, error) {
 	return respBody, resp.StatusCode, nil
 }
 
-func (p *VLLMProvider) ChatCompletionStream(req *ChatRequest) (*http.Response, error) {
-	url := p.cfg.BaseURL + "/chat/completions"
+func (p *VLLMProvider) Embed(req *EmbedRequest) (*EmbedResponse, int, error) {
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+"/embeddings", bytes.NewReader(openAIEmbedBody(req)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
 
-	reqBody := map[string]interface{}{
-		"model":       req.Model,
-		"messages":    p.convertMessages(req.Messages),
-		"temperature": req.Temperature,
-		"max_tokens":  req.MaxTokens,
-		"stream":      true,
+	return doEmbedRequest(getVLLMHTTPClient(), httpReq, parseOpenAIEmbeddings)
+}
+
+func (p *VLLMProvider) Rerank(req *RerankRequest) (*RerankResponse, int, error) {
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+"/rerank", bytes.NewReader(rerankBody(req)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
+	p.setHeaders(httpReq)
 
-	body, _ := json.Marshal(reqBody)
+	return doRerankRequest(getVLLMHTTPClient(), httpReq, req)
+}
+
+func (p *VLLMProvider) Transcribe(req *TranscriptionRequest) (*AudioResponse, error) {
+	contentType, body, err := transcriptionBody(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+audioPath(req.Translate), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	httpReq.Header.Set("Content-Type", contentType)
+
+	return doAudioRequest(getVLLMHTTPClient(), httpReq)
+}
+
+func (p *VLLMProvider) ChatCompletionStream(req *ChatRequest) (*http.Response, error) {
+	url := p.cfg.BaseURL + "/chat/completions"
+
+	body := p.buildRequestBody(req, true)
 
 	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
@@ -122,6 +141,16 @@ func (p *VLLMProvider) ChatCompletionStream(req *ChatRequest) (*http.Response, e
 	return getVLLMHTTPClient().Do(httpReq)
 }
 
+func (p *VLLMProvider) ForwardCompletion(body []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequest("POST", p.cfg.BaseURL+"/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	return getVLLMHTTPClient().Do(httpReq)
+}
+
 func (p *VLLMProvider) setHeaders(req *http.Request) {
 	req.Header.Set("Content-Type", "application/json")
 	if p.cfg.APIKey != "" {
@@ -130,6 +159,28 @@ func (p *VLLMProvider) setHeaders(req *http.Request) {
 	req.Header.Set("User-Agent", "ai-gateway/vllm")
 }
 
+func (p *VLLMProvider) buildRequestBody(req *ChatRequest, stream bool) []byte {
+	req = emulateTools(p.cfg, req)
+	reqBody := map[string]interface{}{
+		"model":    req.Model,
+		"messages": p.convertMessages(req.Messages),
+		"stream":   stream,
+	}
+	applyOpenAISampling(reqBody, req)
+	if len(req.Tools) > 0 {
+		reqBody["tools"] = req.Tools
+	}
+	if req.ToolChoice != nil {
+		reqBody["tool_choice"] = req.ToolChoice.OpenAI()
+	}
+	if req.ParallelToolCalls != nil {
+		reqBody["parallel_tool_calls"] = *req.ParallelToolCalls
+	}
+
+	body, _ := json.Marshal(reqBody)
+	return body
+}
+
 func (p *VLLMProvider) convertMessages(messages []ChatMessage) []map[string]interface{} {
 	result := make([]map[string]interface{}, len(messages))
 	for i, msg := range messages {
@@ -181,50 +232,14 @@ func (p *VLLMProvider) ParseResponse(respBody []byte) (string, int, int, error)
 
 	content := ""
 	if len(response.Choices) > 0 {
-		content = response.Choices[0].Message.Content
+		content, _ = extractTextToolCalls(textToolFormat(p.cfg), response.Choices[0].Message.Content)
 	}
 
 	return content, response.Usage.PromptTokens, response.Usage.CompletionTokens, nil
 }
 
-func (p *VLLMProvider) ParseStreamChunk(data []byte) (string, int, int) {
-	text := ""
-	inputTokens := 0
-	outputTokens := 0
-
-	line := strings.TrimSpace(string(data))
-	if !strings.HasPrefix(line, "data: ") {
-		return text, inputTokens, outputTokens
-	}
-
-	line = strings.TrimPrefix(line, "data: ")
-	if line == "[DONE]" {
-		return text, inputTokens, outputTokens
-	}
-
-	var chunk struct {
-		Choices []struct {
-			Delta struct {
-				Content string `json:"content"`
-				Role    string `json:"role"`
-			} `json:"delta"`
-			FinishReason string `json:"finish_reason"`
-		} `json:"choices"`
-		Usage struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-		} `json:"usage"`
-	}
-
-	if err := json.Unmarshal([]byte(line), &chunk); err != nil {
-		return text, inputTokens, outputTokens
-	}
-
-	if len(chunk.Choices) > 0 {
-		text = chunk.Choices[0].Delta.Content
-	}
-
-	return text, inputTokens, outputTokens
+func (p *VLLMProvider) StreamEvents(resp *http.Response) *EventStream {
+	return newSSEStream(resp.Body, decodeOpenAIStreamChunk).withTextToolCalls(textToolFormat(p.cfg))
 }
 
 func (p *VLLMProvider) ListModels() ([]string, error) {
@@ -309,14 +324,22 @@ func (p *VLLMProvider) SupportsStreaming() bool {
 	return true
 }
 
-func (p *VLLMProvider) StreamDataPrefix() string {
-	return "data: "
+func (p *VLLMProvider) ParseChoices(body []byte) ([]Choice, error) {
+	choices, err := parseOpenAIChoices(body)
+	return withTextToolChoices(textToolFormat(p.cfg), choices, err)
+}
+
+func (p *VLLMProvider) SupportsChoiceCount() bool { return true }
+
+func (p *VLLMProvider) ParseUsageDetails(body []byte) UsageDetails {
+	return parseOpenAIUsageDetails(body)
 }
 
 func (p *VLLMProvider) ParseToolCalls(respBody []byte) ([]ToolCall, error) {
 	var response struct {
 		Choices []struct {
 			Message struct {
+				Content   string `json:"content"`
 				ToolCalls []struct {
 					ID       string `json:"id"`
 					Type     string `json:"type"`
@@ -342,82 +365,171 @@ func (p *VLLMProvider) ParseToolCalls(respBody []byte) ([]ToolCall, error) {
 				Arguments: tc.Function.Arguments,
 			})
 		}
+		_, textCalls := extractTextToolCalls(textToolFormat(p.cfg), choice.Message.Content)
+		toolCalls = append(toolCalls, textCalls...)
 	}
 
 	return toolCalls, nil
 }
 
-func (p *VLLMProvider) ParseStreamToolCall(data []byte) (interface{}, string) {
-	line := strings.TrimSpace(string(data))
-	if !strings.HasPrefix(line, "data: ") {
-		return nil, ""
+func (p *VLLMProvider) Models() []string {
+	models, err := p.FetchModels()
+	if err != nil {
+		return []string{p.DefaultModel()}
 	}
+	return models
+}
 
-	line = strings.TrimPrefix(line, "data: ")
-	if line == "[DONE]" {
-		return nil, "stop"
-	}
+func (p *VLLMProvider) FetchModels() ([]string, error) {
+	return p.ListModels()
+}
 
-	var chunk struct {
-		Choices []struct {
-			Delta struct {
-				ToolCalls []struct {
-					ID       string `json:"id"`
-					Type     string `json:"type"`
-					Function struct {
-						Name      string `json:"name"`
-						Arguments string `json:"arguments"`
-					} `json:"function"`
-				} `json:"tool_calls"`
-				Content string `json:"content"`
-			} `json:"delta"`
-			FinishReason string `json:"finish_reason"`
-		} `json:"choices"`
+func (p *VLLMProvider) TestConnection() (string, bool, error) {
+	models, err := p.ListModels()
+	if err != nil {
+		return "", false, err
 	}
+	return fmt.Sprintf("Connected to vLLM with %d models", len(models)), true, nil
+}
 
-	if err := json.Unmarshal([]byte(line), &chunk); err != nil {
-		return nil, ""
+// runpodEndpoint returns the RunPod serverless endpoint
+// (https://api.runpod.ai/v2/<id>) when the base URL is the OpenAI-compatible
+// route of one, whose queue API runs requests as jobs.
+func (p *VLLMProvider) runpodEndpoint() (string, bool) {
+	const prefix = "https://api.runpod.ai/v2/"
+	if !strings.HasPrefix(p.cfg.BaseURL, prefix) {
+		return "", false
 	}
-
-	if len(chunk.Choices) == 0 {
-		return nil, ""
+	id, _, _ := strings.Cut(strings.TrimPrefix(p.cfg.BaseURL, prefix), "/")
+	if id == "" {
+		return "", false
 	}
+	return prefix + id, true
+}
 
-	finishReason := chunk.Choices[0].FinishReason
+// SubmitAsync queues a request with RunPod's /run, passing it to the vLLM
+// worker's OpenAI route.
+func (p *VLLMProvider) SubmitAsync(req *ChatRequest) (string, error) {
+	endpoint, ok := p.runpodEndpoint()
+	if !ok {
+		return "", ErrAsyncUnsupported
+	}
 
-	if len(chunk.Choices[0].Delta.ToolCalls) > 0 {
-		tc := chunk.Choices[0].Delta.ToolCalls[0]
-		return StreamToolCall{
-			ID:        tc.ID,
-			Name:      tc.Function.Name,
-			Arguments: tc.Function.Arguments,
-		}, finishReason
+	body, _ := json.Marshal(map[string]interface{}{
+		"input": map[string]interface{}{
+			"openai_route": "/v1/chat/completions",
+			"openai_input": json.RawMessage(p.buildRequestBody(req, false)),
+		},
+	})
+	httpReq, err := http.NewRequest("POST", endpoint+"/run", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
+	p.setHeaders(httpReq)
 
-	return nil, finishReason
+	respBody, err := doBatchRequest(getVLLMHTTPClient(), httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to submit job: %w", err)
+	}
+	var job struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &job); err != nil || job.ID == "" {
+		return "", fmt.Errorf("failed to parse job: %s", respBody)
+	}
+	return job.ID, nil
 }
 
-func (p *VLLMProvider) Models() []string {
-	models, err := p.FetchModels()
+// PollAsync reads a job's state from RunPod's /status.
+func (p *VLLMProvider) PollAsync(id string) (*AsyncResult, error) {
+	endpoint, ok := p.runpodEndpoint()
+	if !ok {
+		return nil, ErrAsyncUnsupported
+	}
+
+	httpReq, err := http.NewRequest("GET", endpoint+"/status/"+id, nil)
 	if err != nil {
-		return []string{p.DefaultModel()}
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	return models
+	p.setHeaders(httpReq)
+
+	respBody, err := doBatchRequest(getVLLMHTTPClient(), httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job status: %w", err)
+	}
+	var job struct {
+		Status string          `json:"status"`
+		Output json.RawMessage `json:"output"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &job); err != nil {
+		return nil, fmt.Errorf("failed to parse job status: %w", err)
+	}
+
+	result := &AsyncResult{Status: job.Status}
+	switch job.Status {
+	case "COMPLETED":
+		result.Done = true
+		result.StatusCode = http.StatusOK
+		result.Body = runpodOutput(job.Output)
+	case "FAILED":
+		result.Done = true
+		result.Error = "job failed: " + runpodError(job.Error)
+	case "CANCELLED", "TIMED_OUT":
+		result.Done = true
+		result.Error = "job " + strings.ToLower(job.Status)
+	}
+	return result, nil
 }
 
-func (p *VLLMProvider) FetchModels() ([]string, error) {
-	return p.ListModels()
+// runpodOutput unwraps a job's output: the worker returns what its handler
+// yielded, a list holding the chat completion.
+func runpodOutput(output json.RawMessage) []byte {
+	var items []json.RawMessage
+	if json.Unmarshal(output, &items) == nil && len(items) > 0 {
+		return items[0]
+	}
+	return output
 }
 
-func (p *VLLMProvider) TestConnection() (string, bool, error) {
-	models, err := p.ListModels()
-	if err != nil {
-		return "", false, err
+// runpodError reads a job error, a string or an object with a message.
+func runpodError(raw json.RawMessage) string {
+	var msg string
+	if json.Unmarshal(raw, &msg) == nil {
+		return msg
 	}
-	return fmt.Sprintf("Connected to vLLM with %d models", len(models)), true, nil
+	var obj struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(raw, &obj) == nil {
+		if obj.Message != "" {
+			return obj.Message
+		}
+		if obj.Error != "" {
+			return obj.Error
+		}
+	}
+	return string(raw)
 }
 
+// CancelRequest cancels a RunPod job. Plain vLLM servers have no way to
+// cancel a request from outside its connection.
 func (p *VLLMProvider) CancelRequest(requestID string) error {
+	endpoint, ok := p.runpodEndpoint()
+	if !ok {
+		return ErrAsyncUnsupported
+	}
+
+	httpReq, err := http.NewRequest("POST", endpoint+"/cancel/"+requestID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+
+	if _, err := doBatchRequest(getVLLMHTTPClient(), httpReq); err != nil {
+		return fmt.Errorf("failed to cancel job: %w", err)
+	}
 	return nil
 }
 
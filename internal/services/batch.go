@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ai-gateway/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BatchService stores /v1/files content on disk and batch jobs in the
+// database.
+type BatchService struct {
+	db  *gorm.DB
+	dir string
+}
+
+func NewBatchService(db *gorm.DB, dir string) *BatchService {
+	return &BatchService{db: db, dir: dir}
+}
+
+func newObjectID(prefix string) string {
+	return prefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// FilePath returns where a file's content is stored.
+func (s *BatchService) FilePath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// PartialPath returns the file a running batch appends its output ("output")
+// or errors ("errors") to before they become files.
+func (s *BatchService) PartialPath(batchID, kind string) string {
+	return filepath.Join(s.dir, batchID+"."+kind+".partial")
+}
+
+// CreateFile stores content as a new file for the client.
+func (s *BatchService) CreateFile(clientID, filename, purpose string, content io.Reader) (*models.File, error) {
+	file := &models.File{
+		ID:        newObjectID("file-"),
+		ClientID:  clientID,
+		Filename:  filename,
+		Purpose:   purpose,
+		CreatedAt: time.Now(),
+	}
+
+	out, err := os.OpenFile(s.FilePath(file.ID), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	file.Bytes, err = io.Copy(out, content)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(s.FilePath(file.ID))
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := s.db.Create(file).Error; err != nil {
+		os.Remove(s.FilePath(file.ID))
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	return file, nil
+}
+
+// AdoptFile turns a file already on disk (a batch's partial output) into a
+// stored file, moving it into place.
+func (s *BatchService) AdoptFile(clientID, filename, purpose, path string) (*models.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	file := &models.File{
+		ID:        newObjectID("file-"),
+		ClientID:  clientID,
+		Filename:  filename,
+		Purpose:   purpose,
+		Bytes:     info.Size(),
+		CreatedAt: time.Now(),
+	}
+	if err := os.Rename(path, s.FilePath(file.ID)); err != nil {
+		return nil, fmt.Errorf("failed to move file: %w", err)
+	}
+	if err := s.db.Create(file).Error; err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	return file, nil
+}
+
+// GetFile returns a client's file, or nil if it doesn't exist.
+func (s *BatchService) GetFile(clientID, id string) (*models.File, error) {
+	var file models.File
+	err := s.db.Where("id = ? AND client_id = ?", id, clientID).First(&file).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (s *BatchService) ListFiles(clientID, purpose string) ([]models.File, error) {
+	query := s.db.Where("client_id = ?", clientID)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	var files []models.File
+	err := query.Order("created_at DESC").Find(&files).Error
+	return files, err
+}
+
+func (s *BatchService) DeleteFile(file *models.File) error {
+	if err := s.db.Delete(&models.File{}, "id = ?", file.ID).Error; err != nil {
+		return err
+	}
+	if err := os.Remove(s.FilePath(file.ID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove file content: %w", err)
+	}
+	return nil
+}
+
+// CreateBatch assigns the batch an ID and saves it.
+func (s *BatchService) CreateBatch(batch *models.Batch) error {
+	batch.ID = newObjectID("batch_")
+	batch.CreatedAt = time.Now()
+	return s.db.Create(batch).Error
+}
+
+func (s *BatchService) SaveBatch(batch *models.Batch) error {
+	return s.db.Save(batch).Error
+}
+
+// GetBatch returns a client's batch, or nil if it doesn't exist.
+func (s *BatchService) GetBatch(clientID, id string) (*models.Batch, error) {
+	var batch models.Batch
+	err := s.db.Where("id = ? AND client_id = ?", id, clientID).First(&batch).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListBatches returns up to limit of a client's batches, newest first,
+// starting after the batch with ID after if given.
+func (s *BatchService) ListBatches(clientID, after string, limit int) ([]models.Batch, error) {
+	query := s.db.Where("client_id = ?", clientID)
+	if after != "" {
+		var cursor models.Batch
+		if err := s.db.Where("id = ? AND client_id = ?", after, clientID).First(&cursor).Error; err == nil {
+			query = query.Where("created_at < ?", cursor.CreatedAt)
+		}
+	}
+	var batches []models.Batch
+	err := query.Order("created_at DESC").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// UnfinishedBatches returns the batches of all clients that haven't reached
+// a final status, to resume them after a restart.
+func (s *BatchService) UnfinishedBatches() ([]models.Batch, error) {
+	var batches []models.Batch
+	err := s.db.Where("status IN ?", []string{"validating", "in_progress", "finalizing", "cancelling"}).
+		Order("created_at").Find(&batches).Error
+	return batches, err
+}
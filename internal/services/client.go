@@ -42,6 +42,9 @@ func (s *ClientService) CreateClient(name, description, keyType, keyPrefix strin
 		QuotaRequestsDay:     cfg.Defaults.Quota.MaxRequestsPerDay,
 		MaxInputTokens:       cfg.Defaults.Quota.MaxInputTokens,
 		MaxOutputTokens:      cfg.Defaults.Quota.MaxOutputTokens,
+		QuotaAudioSecondsDay: cfg.Defaults.Quota.MaxAudioSecondsPerDay,
+		MaxAudioUploadMB:     cfg.Defaults.Quota.MaxAudioUploadMB,
+		QuotaImagesDay:       cfg.Defaults.Quota.MaxImagesPerDay,
 		CreatedAt:            time.Now(),
 		UpdatedAt:            time.Now(),
 	}
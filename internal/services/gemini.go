@@ -181,7 +181,7 @@ func (s *GeminiService) ForwardStreamRequest(model string, body []byte) (*http.R
 }
 
 func (s *GeminiService) LogRequest(clientID, model string, statusCode int, inputTokens, outputTokens int, latencyMs int, errMsg string, requestBody string, isStreaming bool, hasTools bool, toolNames string) error {
-	log := &models.RequestLog{
+	return s.LogRequestEntry(&models.RequestLog{
 		ClientID:     clientID,
 		Model:        model,
 		StatusCode:   statusCode,
@@ -193,14 +193,23 @@ func (s *GeminiService) LogRequest(clientID, model string, statusCode int, input
 		IsStreaming:  isStreaming,
 		HasTools:     hasTools,
 		ToolNames:    toolNames,
-		CreatedAt:    time.Now(),
+	})
+}
+
+// LogRequestEntry stores a request log with any of its fields set and updates
+// daily usage. CreatedAt defaults to now. Base64 data in the request body is
+// replaced with placeholders.
+func (s *GeminiService) LogRequestEntry(log *models.RequestLog) error {
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now()
 	}
+	log.RequestBody = redactBinary(log.RequestBody)
 
 	if err := s.db.Create(log).Error; err != nil {
 		return fmt.Errorf("failed to log request: %w", err)
 	}
 
-	err := s.updateDailyUsage(clientID, inputTokens, outputTokens, statusCode)
+	err := s.updateDailyUsage(log)
 
 	// Notify dashboard hub about the new request
 	if s.onRequestLogged != nil {
@@ -210,7 +219,8 @@ func (s *GeminiService) LogRequest(clientID, model string, statusCode int, input
 	return err
 }
 
-func (s *GeminiService) updateDailyUsage(clientID string, inputTokens, outputTokens, statusCode int) error {
+func (s *GeminiService) updateDailyUsage(log *models.RequestLog) error {
+	clientID := log.ClientID
 	today := time.Now().Truncate(24 * time.Hour)
 
 	var usage models.DailyUsage
@@ -228,8 +238,12 @@ func (s *GeminiService) updateDailyUsage(clientID string, inputTokens, outputTok
 	}
 
 	usage.TotalRequests++
-	usage.TotalInputTokens += inputTokens
-	usage.TotalOutputTokens += outputTokens
+	usage.TotalInputTokens += log.InputTokens
+	usage.TotalOutputTokens += log.OutputTokens
+	usage.TotalAudioSeconds += log.AudioSeconds
+	usage.TotalImages += log.ImageCount
+	usage.TotalCacheCreationTokens += log.CacheCreationTokens
+	usage.TotalCacheReadTokens += log.CacheReadTokens
 
 	if err := s.db.Save(&usage).Error; err != nil {
 		return fmt.Errorf("failed to update daily usage: %w", err)
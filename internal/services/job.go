@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"ai-gateway/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// JobService stores asynchronous chat completion jobs.
+type JobService struct {
+	db *gorm.DB
+}
+
+func NewJobService(db *gorm.DB) *JobService {
+	return &JobService{db: db}
+}
+
+// CreateJob assigns the job an ID and saves it.
+func (s *JobService) CreateJob(job *models.Job) error {
+	job.ID = newObjectID("job_")
+	job.CreatedAt = time.Now()
+	return s.db.Create(job).Error
+}
+
+func (s *JobService) SaveJob(job *models.Job) error {
+	return s.db.Save(job).Error
+}
+
+// GetJob returns a client's job, or nil if it doesn't exist.
+func (s *JobService) GetJob(clientID, id string) (*models.Job, error) {
+	var job models.Job
+	err := s.db.Where("id = ? AND client_id = ?", id, clientID).First(&job).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobs returns up to limit of a client's jobs, newest first, starting
+// after the job with ID after if given.
+func (s *JobService) ListJobs(clientID, after string, limit int) ([]models.Job, error) {
+	query := s.db.Where("client_id = ?", clientID)
+	if after != "" {
+		var cursor models.Job
+		if err := s.db.Where("id = ? AND client_id = ?", after, clientID).First(&cursor).Error; err == nil {
+			query = query.Where("created_at < ?", cursor.CreatedAt)
+		}
+	}
+	var jobs []models.Job
+	err := query.Order("created_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+// UnfinishedJobs returns the jobs of all clients that haven't reached a final
+// status, to resume them after a restart.
+func (s *JobService) UnfinishedJobs() ([]models.Job, error) {
+	var jobs []models.Job
+	err := s.db.Where("status IN ?", []string{"queued", "in_progress"}).Order("created_at").Find(&jobs).Error
+	return jobs, err
+}
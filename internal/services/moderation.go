@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"ai-gateway/internal/config"
+)
+
+// ModerationCategories are OpenAI's moderation categories, always present in
+// a result so clients can index them without checking.
+var ModerationCategories = []string{
+	"harassment", "harassment/threatening",
+	"hate", "hate/threatening",
+	"illicit", "illicit/violent",
+	"self-harm", "self-harm/intent", "self-harm/instructions",
+	"sexual", "sexual/minors",
+	"violence", "violence/graphic",
+}
+
+// ModerationService is the local moderation rule engine behind
+// /v1/moderations.
+type ModerationService struct {
+	mode      string
+	threshold float64
+	rules     []moderationRule
+}
+
+type moderationRule struct {
+	name       string
+	categories []string
+	patterns   []*regexp.Regexp
+	score      float64
+}
+
+// ModerationCheck is the rule engine's verdict on one input.
+type ModerationCheck struct {
+	Flagged    bool
+	Categories map[string]bool
+	Scores     map[string]float64
+	// Rules names the rules that matched.
+	Rules []string
+}
+
+// NewModerationService compiles the configured rules. Keywords become one
+// case-insensitive whole-word expression per rule.
+func NewModerationService(cfg config.ModerationConfig) (*ModerationService, error) {
+	s := &ModerationService{mode: cfg.Mode, threshold: cfg.Threshold}
+	if s.mode == "" {
+		s.mode = "auto"
+	}
+	if s.mode != "auto" && s.mode != "local" && s.mode != "upstream" {
+		return nil, fmt.Errorf("invalid moderation mode %q", cfg.Mode)
+	}
+	if s.threshold == 0 {
+		s.threshold = 0.5
+	}
+
+	for i, r := range cfg.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(r.Categories) == 0 {
+			return nil, fmt.Errorf("moderation %s has no categories", name)
+		}
+		rule := moderationRule{name: name, categories: r.Categories, score: r.Score}
+		if rule.score == 0 {
+			rule.score = 1
+		}
+		var words []string
+		for _, k := range r.Keywords {
+			if k = strings.TrimSpace(k); k != "" {
+				words = append(words, regexp.QuoteMeta(k))
+			}
+		}
+		if len(words) > 0 {
+			rule.patterns = append(rule.patterns, regexp.MustCompile(`(?i)\b(?:`+strings.Join(words, "|")+`)\b`))
+		}
+		for _, p := range r.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("moderation %s: invalid pattern %q: %w", name, p, err)
+			}
+			rule.patterns = append(rule.patterns, re)
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return s, nil
+}
+
+// Mode is "auto", "local" or "upstream".
+func (s *ModerationService) Mode() string {
+	return s.mode
+}
+
+// Check scores text against the rules. A category's score is the highest
+// score of the rules matching in it.
+func (s *ModerationService) Check(text string) ModerationCheck {
+	check := ModerationCheck{
+		Categories: make(map[string]bool, len(ModerationCategories)),
+		Scores:     make(map[string]float64, len(ModerationCategories)),
+	}
+	for _, c := range ModerationCategories {
+		check.Categories[c] = false
+		check.Scores[c] = 0
+	}
+
+	for _, rule := range s.rules {
+		if !rule.matches(text) {
+			continue
+		}
+		check.Rules = append(check.Rules, rule.name)
+		for _, c := range rule.categories {
+			check.Scores[c] = max(check.Scores[c], rule.score)
+		}
+	}
+	for c, score := range check.Scores {
+		check.Categories[c] = score >= s.threshold
+		if check.Categories[c] {
+			check.Flagged = true
+		}
+	}
+	return check
+}
+
+func (r *moderationRule) matches(text string) bool {
+	for _, re := range r.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// minRedactedLength is the shortest string redactBinary replaces; shorter
+// base64 strings (IDs, signatures) are kept.
+const minRedactedLength = 512
+
+// redactBinary replaces base64 data in a JSON request body — data: URIs and
+// long base64 strings such as Gemini inlineData — with a placeholder giving
+// its size, so request logs don't store uploaded images and audio. Bodies
+// that aren't JSON or hold no such data are returned unchanged.
+func redactBinary(body string) string {
+	if len(body) < minRedactedLength {
+		return body
+	}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	v, changed := redactValue(v)
+	if !changed {
+		return body
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return body
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func redactValue(v interface{}) (interface{}, bool) {
+	changed := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			if r, ok := redactValue(item); ok {
+				t[k] = r
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			if r, ok := redactValue(item); ok {
+				t[i] = r
+				changed = true
+			}
+		}
+	case string:
+		if len(t) < minRedactedLength {
+			return t, false
+		}
+		if strings.HasPrefix(t, "data:") {
+			if i := strings.Index(t, ";base64,"); i > 0 {
+				return fmt.Sprintf("%s;base64,<%d chars omitted>", t[:i], len(t)-i-len(";base64,")), true
+			}
+		}
+		if isBase64(t) {
+			return fmt.Sprintf("<base64, %d chars omitted>", len(t)), true
+		}
+	}
+	return v, changed
+}
+
+// isBase64 reports whether s uses only the standard or URL-safe base64
+// alphabet.
+func isBase64(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '+', c == '/', c == '=', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -38,7 +38,9 @@ func (s *StatsService) GetGlobalStats() (*models.Stats, error) {
 	err := s.db.Model(&models.DailyUsage{}).
 		Select(`COALESCE(SUM(total_requests), 0) as total_requests_today,
 				COALESCE(SUM(total_input_tokens), 0) as total_input_tokens_today,
-				COALESCE(SUM(total_output_tokens), 0) as total_output_tokens_today`).
+				COALESCE(SUM(total_output_tokens), 0) as total_output_tokens_today,
+				COALESCE(SUM(total_cache_creation_tokens), 0) as total_cache_creation_tokens_today,
+				COALESCE(SUM(total_cache_read_tokens), 0) as total_cache_read_tokens_today`).
 		Where("date = ?", today).
 		Scan(&stats).Error
 
@@ -104,6 +106,8 @@ func (s *StatsService) GetClientStats(clientID string) (*models.ClientStats, err
 		OutputTokensLimit: client.QuotaOutputTokensDay,
 		MaxInputTokens:    client.MaxInputTokens,
 		MaxOutputTokens:   client.MaxOutputTokens,
+		AudioSecondsLimit: client.QuotaAudioSecondsDay,
+		ImagesLimit:       client.QuotaImagesDay,
 		ErrorRate:         errorRate,
 	}
 
@@ -111,11 +115,21 @@ func (s *StatsService) GetClientStats(clientID string) (*models.ClientStats, err
 		clientStats.RequestsToday = usage.TotalRequests
 		clientStats.InputTokensToday = usage.TotalInputTokens
 		clientStats.OutputTokensToday = usage.TotalOutputTokens
+		clientStats.AudioSecondsToday = usage.TotalAudioSeconds
+		clientStats.ImagesToday = usage.TotalImages
 	}
 
 	return clientStats, nil
 }
 
+// UsageToday returns the client's usage today, zero if it has none.
+func (s *StatsService) UsageToday(clientID string) models.DailyUsage {
+	today := time.Now().Truncate(24 * time.Hour)
+	var usage models.DailyUsage
+	s.db.Where("client_id = ? AND date = ?", clientID, today).First(&usage)
+	return usage
+}
+
 func (s *StatsService) GetAllClientStats() ([]models.ClientStats, error) {
 	clients, err := NewClientService(s.db).GetAllClients()
 	if err != nil {
@@ -147,6 +161,8 @@ func (s *StatsService) GetAllClientStats() ([]models.ClientStats, error) {
 			RequestsLimit:     client.QuotaRequestsDay,
 			InputTokensLimit:  client.QuotaInputTokensDay,
 			OutputTokensLimit: client.QuotaOutputTokensDay,
+			AudioSecondsLimit: client.QuotaAudioSecondsDay,
+			ImagesLimit:       client.QuotaImagesDay,
 			ErrorRate:         errorRate,
 		}
 
@@ -154,6 +170,8 @@ func (s *StatsService) GetAllClientStats() ([]models.ClientStats, error) {
 			stats.RequestsToday = usage.TotalRequests
 			stats.InputTokensToday = usage.TotalInputTokens
 			stats.OutputTokensToday = usage.TotalOutputTokens
+			stats.AudioSecondsToday = usage.TotalAudioSeconds
+			stats.ImagesToday = usage.TotalImages
 		}
 
 		result = append(result, stats)
@@ -207,6 +225,9 @@ type DailyStats struct {
 	TotalInputTokens  int       `json:"total_input_tokens"`
 	TotalOutputTokens int       `json:"total_output_tokens"`
 	UniqueClients     int       `json:"unique_clients"`
+	// Prompt cache usage, on top of TotalInputTokens
+	TotalCacheCreationTokens int `json:"total_cache_creation_tokens"`
+	TotalCacheReadTokens     int `json:"total_cache_read_tokens"`
 }
 
 func (s *StatsService) GetHistoricalStats(days int) ([]DailyStats, error) {
@@ -214,7 +235,7 @@ func (s *StatsService) GetHistoricalStats(days int) ([]DailyStats, error) {
 
 	var results []DailyStats
 	err := s.db.Model(&models.DailyUsage{}).
-		Select("date, COALESCE(SUM(total_requests), 0) as total_requests, COALESCE(SUM(total_input_tokens), 0) as total_input_tokens, COALESCE(SUM(total_output_tokens), 0) as total_output_tokens, COUNT(DISTINCT client_id) as unique_clients").
+		Select("date, COALESCE(SUM(total_requests), 0) as total_requests, COALESCE(SUM(total_input_tokens), 0) as total_input_tokens, COALESCE(SUM(total_output_tokens), 0) as total_output_tokens, COUNT(DISTINCT client_id) as unique_clients, COALESCE(SUM(total_cache_creation_tokens), 0) as total_cache_creation_tokens, COALESCE(SUM(total_cache_read_tokens), 0) as total_cache_read_tokens").
 		Where("date >= ?", startDate).
 		Group("date").
 		Order("date ASC").
@@ -258,6 +279,9 @@ type ModelStats struct {
 	TotalTokens   int     `json:"total_tokens"`
 	AvgLatencyMs  float64 `json:"avg_latency_ms"`
 	SuccessRate   float64 `json:"success_rate"`
+	// Prompt cache usage, not included in TotalTokens
+	CacheCreationTokens int `json:"cache_creation_tokens"`
+	CacheReadTokens     int `json:"cache_read_tokens"`
 }
 
 func (s *StatsService) GetModelStats(days int) ([]ModelStats, error) {
@@ -269,11 +293,13 @@ func (s *StatsService) GetModelStats(days int) ([]ModelStats, error) {
 		TotalTokens   int
 		AvgLatency    float64
 		ErrorCount    int
+		CacheCreation int
+		CacheRead     int
 	}
 
 	var results []Result
 	err := s.db.Model(&models.RequestLog{}).
-		Select("model, COUNT(*) as total_requests, COALESCE(SUM(input_tokens + output_tokens), 0) as total_tokens, COALESCE(AVG(latency_ms), 0) as avg_latency, SUM(CASE WHEN status_code >= 400 THEN 1 ELSE 0 END) as error_count").
+		Select("model, COUNT(*) as total_requests, COALESCE(SUM(input_tokens + output_tokens), 0) as total_tokens, COALESCE(AVG(latency_ms), 0) as avg_latency, SUM(CASE WHEN status_code >= 400 THEN 1 ELSE 0 END) as error_count, COALESCE(SUM(cache_creation_tokens), 0) as cache_creation, COALESCE(SUM(cache_read_tokens), 0) as cache_read").
 		Where("created_at >= ?", startDate).
 		Group("model").
 		Order("total_requests DESC").
@@ -290,11 +316,13 @@ func (s *StatsService) GetModelStats(days int) ([]ModelStats, error) {
 			successRate = float64(r.TotalRequests-r.ErrorCount) / float64(r.TotalRequests) * 100
 		}
 		modelStats = append(modelStats, ModelStats{
-			Model:         r.Model,
-			TotalRequests: r.TotalRequests,
-			TotalTokens:   r.TotalTokens,
-			AvgLatencyMs:  r.AvgLatency,
-			SuccessRate:   successRate,
+			Model:               r.Model,
+			TotalRequests:       r.TotalRequests,
+			TotalTokens:         r.TotalTokens,
+			AvgLatencyMs:        r.AvgLatency,
+			SuccessRate:         successRate,
+			CacheCreationTokens: r.CacheCreation,
+			CacheReadTokens:     r.CacheRead,
 		})
 	}
 
@@ -307,6 +335,9 @@ type ClientStats2 struct {
 	TotalRequests int     `json:"total_requests"`
 	TotalTokens   int     `json:"total_tokens"`
 	SuccessRate   float64 `json:"success_rate"`
+	// Prompt cache usage, not included in TotalTokens
+	CacheCreationTokens int `json:"cache_creation_tokens"`
+	CacheReadTokens     int `json:"cache_read_tokens"`
 }
 
 func (s *StatsService) GetClientStats2(days int) ([]ClientStats2, error) {
@@ -317,11 +348,13 @@ func (s *StatsService) GetClientStats2(days int) ([]ClientStats2, error) {
 		TotalRequests int
 		TotalTokens   int
 		ErrorCount    int
+		CacheCreation int
+		CacheRead     int
 	}
 
 	var results []Result
 	err := s.db.Model(&models.RequestLog{}).
-		Select("client_id, COUNT(*) as total_requests, COALESCE(SUM(input_tokens + output_tokens), 0) as total_tokens, SUM(CASE WHEN status_code >= 400 THEN 1 ELSE 0 END) as error_count").
+		Select("client_id, COUNT(*) as total_requests, COALESCE(SUM(input_tokens + output_tokens), 0) as total_tokens, SUM(CASE WHEN status_code >= 400 THEN 1 ELSE 0 END) as error_count, COALESCE(SUM(cache_creation_tokens), 0) as cache_creation, COALESCE(SUM(cache_read_tokens), 0) as cache_read").
 		Where("created_at >= ?", startDate).
 		Group("client_id").
 		Order("total_requests DESC").
@@ -345,11 +378,13 @@ func (s *StatsService) GetClientStats2(days int) ([]ClientStats2, error) {
 		}
 
 		clientStats = append(clientStats, ClientStats2{
-			ClientID:      r.ClientID,
-			ClientName:    clientName,
-			TotalRequests: r.TotalRequests,
-			TotalTokens:   r.TotalTokens,
-			SuccessRate:   successRate,
+			ClientID:            r.ClientID,
+			ClientName:          clientName,
+			TotalRequests:       r.TotalRequests,
+			TotalTokens:         r.TotalTokens,
+			SuccessRate:         successRate,
+			CacheCreationTokens: r.CacheCreation,
+			CacheReadTokens:     r.CacheRead,
 		})
 	}
 
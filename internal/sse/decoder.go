@@ -0,0 +1,162 @@
+// Package sse implements a Server-Sent Events decoder following the WHATWG
+// event stream format: event types, multi-line data, comments, ids, retry
+// hints and all three line terminators (LF, CRLF, CR).
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// DefaultMaxEventSize caps a single event when no explicit limit is given.
+// Large tool call arguments can be several hundred KB, so this is generous.
+const DefaultMaxEventSize = 8 << 20
+
+// ErrEventTooLarge is returned when an event grows past the decoder's limit.
+// The stream cannot be resumed after this error.
+var ErrEventTooLarge = errors.New("sse: event exceeds maximum size")
+
+// Event is a single dispatched event.
+type Event struct {
+	// Type is the value of the last "event" field, or "message" if none was set.
+	Type string
+	// Data is the concatenation of all "data" fields, joined by newlines.
+	Data []byte
+	// ID is the last event ID seen on the stream, carried across events.
+	ID string
+	// Retry is the reconnection time in milliseconds, or 0 if not sent.
+	Retry int
+}
+
+// Decoder reads events from an event stream.
+type Decoder struct {
+	r       *bufio.Reader
+	maxSize int
+	line    []byte
+	skipLF  bool
+	lastID  string
+	err     error
+}
+
+// NewDecoder returns a decoder reading from r. Events larger than maxEventSize
+// bytes fail with ErrEventTooLarge; a value <= 0 selects DefaultMaxEventSize.
+func NewDecoder(r io.Reader, maxEventSize int) *Decoder {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+	return &Decoder{r: bufio.NewReader(r), maxSize: maxEventSize}
+}
+
+// Next returns the next event. It returns io.EOF once the stream is exhausted.
+// Comment lines (keepalives) and blank events never surface as events.
+//
+// Unlike a browser, a final event that is not followed by a blank line is still
+// dispatched at EOF, since some upstreams close the connection without one.
+func (d *Decoder) Next() (*Event, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	var (
+		eventType string
+		data      bytes.Buffer
+		hasData   bool
+		retry     int
+	)
+
+	dispatch := func() *Event {
+		b := data.Bytes()
+		if len(b) > 0 && b[len(b)-1] == '\n' {
+			b = b[:len(b)-1]
+		}
+		if eventType == "" {
+			eventType = "message"
+		}
+		return &Event{Type: eventType, Data: append([]byte(nil), b...), ID: d.lastID, Retry: retry}
+	}
+
+	for {
+		line, err := d.readLine(d.maxSize - data.Len())
+		if err != nil {
+			if err == io.EOF && hasData {
+				d.err = io.EOF
+				return dispatch(), nil
+			}
+			d.err = err
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			if hasData {
+				return dispatch(), nil
+			}
+			eventType, retry = "", 0
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			if len(value) > 0 && value[0] == ' ' {
+				value = value[1:]
+			}
+		}
+
+		switch string(field) {
+		case "event":
+			eventType = string(value)
+		case "data":
+			data.Write(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				d.lastID = string(value)
+			}
+		case "retry":
+			if n, err := strconv.Atoi(string(value)); err == nil && n >= 0 {
+				retry = n
+			}
+		}
+	}
+}
+
+// readLine reads one line without its terminator. Lines longer than limit
+// fail with ErrEventTooLarge. A CR is treated as a terminator immediately, and
+// an LF directly following it is skipped on the next call, so a stream that
+// pauses after a CR does not block dispatch.
+func (d *Decoder) readLine(limit int) ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(d.line) > 0 {
+				return d.line, nil
+			}
+			return nil, err
+		}
+		if d.skipLF {
+			d.skipLF = false
+			if c == '\n' {
+				continue
+			}
+		}
+		switch c {
+		case '\n':
+			return d.line, nil
+		case '\r':
+			d.skipLF = true
+			return d.line, nil
+		}
+		if len(d.line) >= limit {
+			return nil, ErrEventTooLarge
+		}
+		d.line = append(d.line, c)
+	}
+}
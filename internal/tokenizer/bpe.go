@@ -0,0 +1,189 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxPieceBytes bounds the quadratic merge loop. Longer pieces (base64 blobs,
+// minified code) are merged in chunks, which can overcount by a token or two
+// at each chunk boundary.
+const maxPieceBytes = 512
+
+// tiktokenBPE is a byte-level BPE encoding in tiktoken's format: a rank per
+// byte sequence, where lower ranks merge first.
+type tiktokenBPE struct {
+	ranks map[string]int
+	split splitter
+}
+
+// parseTiktoken reads a .tiktoken file: one "<base64 bytes> <rank>" per line.
+func parseTiktoken(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int, 200000)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected token and rank", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
+
+func (b *tiktokenBPE) count(text string) int {
+	n := 0
+	for _, piece := range b.split(text) {
+		for len(piece) > 0 {
+			chunk := piece
+			if len(chunk) > maxPieceBytes {
+				chunk = chunk[:maxPieceBytes]
+			}
+			piece = piece[len(chunk):]
+			if _, ok := b.ranks[chunk]; ok {
+				n++
+				continue
+			}
+			n += b.merge(chunk)
+		}
+	}
+	return n
+}
+
+// merge applies the lowest-ranked adjacent merge until none applies and
+// returns the number of resulting tokens.
+func (b *tiktokenBPE) merge(piece string) int {
+	// bounds[i] is the start of the i-th part; the last entry is len(piece).
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+	for len(bounds) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+2 < len(bounds); i++ {
+			if rank, ok := b.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+	return len(bounds) - 1
+}
+
+// sentencePieceBPE is a SentencePiece BPE model as used by Llama 2 and
+// Mistral: merges are chosen by piece score, spaces become "▁", and
+// characters outside the vocabulary fall back to one token per byte.
+type sentencePieceBPE struct {
+	scores map[string]float64
+}
+
+const spaceSymbol = "▁"
+
+// parseSentencePieceVocab reads a SentencePiece .vocab export: one
+// "<piece>\t<score>" per line.
+func parseSentencePieceVocab(data []byte) (map[string]float64, error) {
+	scores := make(map[string]float64, 32000)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		piece, scoreText, ok := strings.Cut(text, "\t")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected piece and score", line)
+		}
+		score, err := strconv.ParseFloat(scoreText, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		scores[piece] = score
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+func (s *sentencePieceBPE) count(text string) int {
+	if text == "" {
+		return 0
+	}
+	normalized := spaceSymbol + strings.ReplaceAll(text, " ", spaceSymbol)
+
+	// Pieces never span a word boundary, so each "▁"-prefixed word is merged
+	// on its own.
+	n := 0
+	for i, word := range strings.Split(normalized, spaceSymbol) {
+		if i > 0 {
+			word = spaceSymbol + word
+		}
+		if word != "" {
+			n += s.merge(word)
+		}
+	}
+	return n
+}
+
+func (s *sentencePieceBPE) merge(word string) int {
+	symbols := make([]string, 0, len(word))
+	for _, r := range word {
+		sym := string(r)
+		if _, ok := s.scores[sym]; ok {
+			symbols = append(symbols, sym)
+			continue
+		}
+		// Byte-fallback pieces like <0xE4> never merge with neighbours.
+		for _, b := range []byte(sym) {
+			symbols = append(symbols, fmt.Sprintf("<0x%02X>", b))
+		}
+	}
+	n := 0
+	for len(symbols) > maxPieceBytes {
+		n += s.mergeSymbols(symbols[:maxPieceBytes])
+		symbols = symbols[maxPieceBytes:]
+	}
+	return n + s.mergeSymbols(symbols)
+}
+
+// mergeSymbols applies the highest-scoring adjacent merge until none applies
+// and returns the number of resulting pieces.
+func (s *sentencePieceBPE) mergeSymbols(symbols []string) int {
+	symbols = append([]string(nil), symbols...)
+	for len(symbols) > 1 {
+		best, bestScore := -1, math.Inf(-1)
+		for i := 0; i+1 < len(symbols); i++ {
+			if score, ok := s.scores[symbols[i]+symbols[i+1]]; ok && score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		symbols[best] += symbols[best+1]
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+	}
+	return len(symbols)
+}
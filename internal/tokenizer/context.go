@@ -0,0 +1,83 @@
+package tokenizer
+
+import "strings"
+
+// contextWindows lists context lengths by model name prefix. The first
+// matching prefix wins, so longer prefixes come before shorter ones.
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4.1", 1047576},
+	{"gpt-5", 400000},
+	{"gpt-4o", 128000},
+	{"chatgpt-4o", 128000},
+	{"gpt-4.5", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-0125", 128000},
+	{"gpt-4-1106", 128000},
+	{"gpt-4-vision", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5", 16385},
+	{"gpt-35", 16385},
+	{"gpt-oss", 131072},
+	{"o1-mini", 128000},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+	{"gemini-1.5-pro", 2097152},
+	{"gemini", 1048576},
+	{"llama-3.1", 131072},
+	{"llama-3.2", 131072},
+	{"llama-3.3", 131072},
+	{"llama3.1", 131072},
+	{"llama3.2", 131072},
+	{"llama3.3", 131072},
+	{"llama-4", 131072},
+	{"llama4", 131072},
+	{"llama-3", 8192},
+	{"llama3", 8192},
+	{"meta-llama-3", 8192},
+	{"llama-2", 4096},
+	{"llama2", 4096},
+	{"codestral", 256000},
+	{"mistral-large", 131072},
+	{"mistral-medium", 131072},
+	{"mistral-small", 32768},
+	{"mistral", 32768},
+	{"mixtral", 32768},
+	{"command-r", 128000},
+	{"command-a", 256000},
+	{"grok", 131072},
+	{"sonar", 127072},
+	{"deepseek", 65536},
+	{"qwen", 32768},
+}
+
+var contextOverrides map[string]int
+
+// SetContextWindows overrides or extends the built-in context lengths,
+// keyed by exact model name. It must be called before the first lookup.
+func SetContextWindows(windows map[string]int) {
+	contextOverrides = windows
+}
+
+// ContextWindow returns the context length of model in tokens, or 0 if it
+// isn't known.
+func ContextWindow(model string) int {
+	if n, ok := contextOverrides[model]; ok {
+		return n
+	}
+	m := strings.ToLower(model)
+	if i := strings.LastIndex(m, "/"); i >= 0 {
+		m = m[i+1:]
+	}
+	for _, w := range contextWindows {
+		if strings.HasPrefix(m, w.prefix) {
+			return w.tokens
+		}
+	}
+	return 0
+}
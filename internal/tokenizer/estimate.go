@@ -0,0 +1,53 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// estimate approximates a BPE count when no vocabulary is available. It
+// splits like cl100k and prices each piece by script: a short English word
+// is one token, while CJK runs cost about a token per character and other
+// non-Latin scripts about one per two characters. This tracks real counts
+// far better than a flat bytes/4 for non-English text.
+func estimate(text string) int {
+	n := 0
+	for _, piece := range splitCl100k(text) {
+		n += estimatePiece(piece)
+	}
+	return n
+}
+
+func estimatePiece(piece string) int {
+	r, _ := utf8.DecodeRuneInString(piece)
+	if utf8.RuneCountInString(piece) == 1 || unicode.IsSpace(r) {
+		return 1
+	}
+
+	ascii, wide, other := 0, 0, 0
+	for _, r := range piece {
+		switch {
+		case r < utf8.RuneSelf:
+			ascii++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			wide++
+		case unicode.Is(unicode.So, r) || r > 0xFFFF:
+			// Emoji and other symbols are usually split into byte tokens.
+			wide += 2
+		default:
+			other++
+		}
+	}
+
+	n := wide + (other+1)/2
+	if ascii > 0 {
+		if isNumber(r) {
+			n++
+		} else {
+			// English words up to ~6 characters (with their leading space)
+			// are a single token; longer ones split.
+			n += (ascii + 5) / 6
+		}
+	}
+	return max(n, 1)
+}
@@ -0,0 +1,229 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// The tiktoken encodings split text with a regex before applying BPE. Their
+// patterns use lookahead, which Go's regexp doesn't support, so the splits are
+// hand-written scanners that reproduce the regex alternation order:
+//
+//	cl100k: (?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}|
+//	        ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// o200k replaces the letter alternative with case-aware word rules that keep
+// the contraction suffix attached, and also lets '/' trail a punctuation run.
+
+type splitter func(text string) []string
+
+func splitCl100k(text string) []string {
+	return split(text, matchCl100kWord, "\r\n")
+}
+
+func splitO200k(text string) []string {
+	return split(text, matchO200kWord, "\r\n/")
+}
+
+// split runs the shared alternatives (numbers, punctuation, whitespace) and
+// defers letters to word, which returns the end of a word match at i or -1.
+func split(text string, word func(rs []rune, i int) int, punctTrail string) []string {
+	rs := []rune(text)
+	var pieces []string
+	for i := 0; i < len(rs); {
+		end := word(rs, i)
+		if end < 0 {
+			end = matchNumber(rs, i)
+		}
+		if end < 0 {
+			end = matchPunct(rs, i, punctTrail)
+		}
+		if end < 0 {
+			end = matchSpace(rs, i)
+		}
+		pieces = append(pieces, string(rs[i:end]))
+		i = end
+	}
+	return pieces
+}
+
+func isLetter(r rune) bool { return unicode.IsLetter(r) }
+func isNumber(r rune) bool { return unicode.IsNumber(r) }
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// isPrefix matches [^\r\n\p{L}\p{N}], the optional character before a word.
+func isPrefix(r rune) bool {
+	return !isNewline(r) && !isLetter(r) && !isNumber(r)
+}
+
+// isPunct matches [^\s\p{L}\p{N}].
+func isPunct(r rune) bool {
+	return !unicode.IsSpace(r) && !isLetter(r) && !isNumber(r)
+}
+
+// matchContraction matches (?i:'s|'t|'re|'ve|'m|'ll|'d) at i.
+func matchContraction(rs []rune, i int) int {
+	if i >= len(rs) || rs[i] != '\'' {
+		return -1
+	}
+	for _, suffix := range []string{"re", "ve", "ll", "s", "t", "m", "d"} {
+		n := utf8.RuneCountInString(suffix)
+		if i+1+n > len(rs) {
+			continue
+		}
+		ok := true
+		for k, c := range suffix {
+			if unicode.ToLower(rs[i+1+k]) != c {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return i + 1 + n
+		}
+	}
+	return -1
+}
+
+func matchCl100kWord(rs []rune, i int) int {
+	if end := matchContraction(rs, i); end >= 0 {
+		return end
+	}
+	j := i
+	if isPrefix(rs[j]) && j+1 < len(rs) && isLetter(rs[j+1]) {
+		j++
+	}
+	if !isLetter(rs[j]) {
+		return -1
+	}
+	for j < len(rs) && isLetter(rs[j]) {
+		j++
+	}
+	return j
+}
+
+// o200k letter classes: "upper" is [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}] and
+// "lower" is [\p{Ll}\p{Lm}\p{Lo}\p{M}]. Lm, Lo and marks are in both.
+func isUpperish(r rune) bool {
+	return unicode.In(r, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+func isLowerish(r rune) bool {
+	return unicode.In(r, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+func matchO200kWord(rs []rune, i int) int {
+	starts := []int{i}
+	if isPrefix(rs[i]) && i+1 < len(rs) {
+		// The optional prefix is greedy, so the match with it is tried first.
+		starts = []int{i + 1, i}
+	}
+
+	// upper*lower+ with backtracking over the upper run.
+	for _, s := range starts {
+		k := s
+		for k < len(rs) && isUpperish(rs[k]) {
+			k++
+		}
+		for ; k >= s; k-- {
+			if k < len(rs) && isLowerish(rs[k]) {
+				end := k
+				for end < len(rs) && isLowerish(rs[end]) {
+					end++
+				}
+				return withContraction(rs, end)
+			}
+		}
+	}
+
+	// upper+lower*
+	for _, s := range starts {
+		k := s
+		for k < len(rs) && isUpperish(rs[k]) {
+			k++
+		}
+		if k == s {
+			continue
+		}
+		for k < len(rs) && isLowerish(rs[k]) {
+			k++
+		}
+		return withContraction(rs, k)
+	}
+	return -1
+}
+
+func withContraction(rs []rune, end int) int {
+	if c := matchContraction(rs, end); c >= 0 {
+		return c
+	}
+	return end
+}
+
+// matchNumber matches \p{N}{1,3}.
+func matchNumber(rs []rune, i int) int {
+	j := i
+	for j < len(rs) && j-i < 3 && isNumber(rs[j]) {
+		j++
+	}
+	if j == i {
+		return -1
+	}
+	return j
+}
+
+// matchPunct matches " ?[^\s\p{L}\p{N}]+[trail]*".
+func matchPunct(rs []rune, i int, trail string) int {
+	j := i
+	if rs[j] == ' ' && j+1 < len(rs) && isPunct(rs[j+1]) {
+		j++
+	}
+	if !isPunct(rs[j]) {
+		return -1
+	}
+	for j < len(rs) && isPunct(rs[j]) {
+		j++
+	}
+	for j < len(rs) && containsRune(trail, rs[j]) {
+		j++
+	}
+	return j
+}
+
+func containsRune(s string, r rune) bool {
+	for _, c := range s {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSpace covers the three whitespace alternatives. It always consumes at
+// least one rune, so split makes progress on anything left over.
+func matchSpace(rs []rune, i int) int {
+	j := i
+	for j < len(rs) && unicode.IsSpace(rs[j]) {
+		j++
+	}
+	if j == i {
+		// Unreachable for valid input: every rune is a letter, number,
+		// punctuation or space. Consume it anyway.
+		return i + 1
+	}
+
+	// \s*[\r\n]+ ends after the last newline in the run.
+	for k := j - 1; k >= i; k-- {
+		if isNewline(rs[k]) {
+			return k + 1
+		}
+	}
+	// \s+(?!\S) leaves the last space for the next word, unless the run
+	// ends the text.
+	if j == len(rs) || j-i == 1 {
+		return j
+	}
+	return j - 1
+}
@@ -0,0 +1,196 @@
+// Package tokenizer counts tokens with the BPE vocabularies upstream models
+// use: cl100k_base and o200k_base for OpenAI models, Llama 3's tiktoken
+// vocabulary, and the SentencePiece vocabulary of Llama 2 and Mistral.
+//
+// Vocabularies are loaded lazily from the embedded vocab directory, or from a
+// directory set with SetVocabDir. When a vocabulary is missing, counts fall
+// back to a script-aware estimate and are reported as inexact.
+package tokenizer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//go:embed vocab
+var embedded embed.FS
+
+// Encoding names a vocabulary and, for tiktoken encodings, its split pattern.
+type Encoding string
+
+const (
+	Cl100k Encoding = "cl100k_base"
+	O200k  Encoding = "o200k_base"
+	Llama3 Encoding = "llama3"
+	// Llama is the 32k SentencePiece vocabulary of Llama 2, Mistral 7B and
+	// Mixtral.
+	Llama Encoding = "llama"
+)
+
+// messageOverhead is what the chat format adds per message (role and
+// separators) and once to prime the reply, as in OpenAI's counting guide.
+const messageOverhead = 3
+
+type encoder interface {
+	count(text string) int
+}
+
+type lazyEncoder struct {
+	once sync.Once
+	enc  encoder
+}
+
+var (
+	vocabDir string
+	encoders = map[Encoding]*lazyEncoder{Cl100k: {}, O200k: {}, Llama3: {}, Llama: {}}
+)
+
+// SetVocabDir adds a directory searched for vocabulary files after the
+// embedded ones. It must be called before the first count.
+func SetVocabDir(dir string) {
+	vocabDir = dir
+}
+
+// EncodingForModel picks the vocabulary for a model. native is false when the
+// model's own tokenizer isn't available (Claude, Gemini, ...) and cl100k
+// stands in as the closest public vocabulary.
+func EncodingForModel(model string) (enc Encoding, native bool) {
+	m := strings.ToLower(model)
+	if i := strings.LastIndex(m, "/"); i >= 0 {
+		m = m[i+1:]
+	}
+
+	switch {
+	case hasAnyPrefix(m, "gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4", "gpt-oss"):
+		return O200k, true
+	case hasAnyPrefix(m, "gpt-4", "gpt-3.5", "gpt-35", "text-embedding-3", "text-embedding-ada"):
+		return Cl100k, true
+	case hasAnyPrefix(m, "llama-3", "llama3", "meta-llama-3"):
+		return Llama3, true
+	case hasAnyPrefix(m, "llama-2", "llama2", "codellama", "mistral-7b", "mixtral", "tinyllama", "vicuna"):
+		return Llama, true
+	case m == "mistral":
+		return Llama, true
+	}
+	return Cl100k, false
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of tokens in text for model. exact is true only
+// when the model's own vocabulary was used; otherwise the count is an
+// estimate.
+func Count(model, text string) (n int, exact bool) {
+	enc, native := EncodingForModel(model)
+	e := load(enc)
+	if e == nil {
+		return estimate(text), false
+	}
+	return e.count(text), native
+}
+
+// CountMessage counts one chat message, including its share of the chat
+// format overhead.
+func CountMessage(model, text string) (n int, exact bool) {
+	n, exact = Count(model, text)
+	return n + messageOverhead, exact
+}
+
+// CountMessages counts a chat prompt given the text of each message,
+// including the per-message overhead of the chat format.
+func CountMessages(model string, messages []string) (n int, exact bool) {
+	exact = true
+	for _, text := range messages {
+		c, ok := CountMessage(model, text)
+		n += c
+		exact = exact && ok
+	}
+	return n + messageOverhead, exact
+}
+
+func load(enc Encoding) encoder {
+	l := encoders[enc]
+	l.once.Do(func() {
+		var err error
+		l.enc, err = buildEncoder(enc)
+		if err != nil {
+			log.Printf("[TOKENIZER] %s unavailable, using estimates: %v", enc, err)
+		}
+	})
+	return l.enc
+}
+
+func buildEncoder(enc Encoding) (encoder, error) {
+	if enc == Llama {
+		data, err := readVocab(string(enc) + ".vocab")
+		if err != nil {
+			return nil, err
+		}
+		scores, err := parseSentencePieceVocab(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s vocabulary: %w", enc, err)
+		}
+		return &sentencePieceBPE{scores: scores}, nil
+	}
+
+	data, err := readVocab(string(enc) + ".tiktoken")
+	if err != nil {
+		return nil, err
+	}
+	ranks, err := parseTiktoken(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s vocabulary: %w", enc, err)
+	}
+	split := splitCl100k
+	if enc == O200k {
+		split = splitO200k
+	}
+	return &tiktokenBPE{ranks: ranks, split: split}, nil
+}
+
+// readVocab looks for name, or name.gz, in the embedded vocab directory and
+// then in the configured directory.
+func readVocab(name string) ([]byte, error) {
+	var sources []fs.FS
+	if sub, err := fs.Sub(embedded, "vocab"); err == nil {
+		sources = append(sources, sub)
+	}
+	if vocabDir != "" {
+		sources = append(sources, os.DirFS(vocabDir))
+	}
+
+	for _, src := range sources {
+		if data, err := fs.ReadFile(src, name); err == nil {
+			return data, nil
+		}
+		if data, err := fs.ReadFile(src, name+".gz"); err == nil {
+			return gunzip(data)
+		}
+	}
+	return nil, fmt.Errorf("vocabulary file %s not found", filepath.Join("vocab", name))
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress vocabulary: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,230 @@
+package tokenizer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+func TestEncodingForModel(t *testing.T) {
+	tests := []struct {
+		model  string
+		enc    Encoding
+		native bool
+	}{
+		{"gpt-4o-mini", O200k, true},
+		{"o3-mini", O200k, true},
+		{"gpt-4-turbo", Cl100k, true},
+		{"gpt-3.5-turbo", Cl100k, true},
+		{"meta-llama/Llama-3.1-8B-Instruct", Llama3, true},
+		{"llama3.2:3b", Llama3, true},
+		{"llama2:13b", Llama, true},
+		{"mistral", Llama, true},
+		{"mistral-7b-instruct", Llama, true},
+		{"claude-3-5-sonnet", Cl100k, false},
+		{"gemini-2.0-flash", Cl100k, false},
+	}
+	for _, tt := range tests {
+		enc, native := EncodingForModel(tt.model)
+		if enc != tt.enc || native != tt.native {
+			t.Errorf("EncodingForModel(%q) = %s, %v; want %s, %v", tt.model, enc, native, tt.enc, tt.native)
+		}
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model  string
+		tokens int
+	}{
+		{"gpt-4.1-mini", 1047576},
+		{"gpt-4.5-preview", 128000},
+		{"gpt-4o-2024-08-06", 128000},
+		{"gpt-4-turbo-2024-04-09", 128000},
+		{"gpt-4-0125-preview", 128000},
+		{"gpt-4-1106-preview", 128000},
+		{"gpt-4-vision-preview", 128000},
+		{"gpt-4-32k-0613", 32768},
+		{"gpt-4-0613", 8192},
+		{"gpt-4", 8192},
+		{"o1-mini", 128000},
+		{"o1-preview", 200000},
+		{"openai/gpt-4.5-preview", 128000},
+		{"meta-llama/Llama-3.1-8B-Instruct", 131072},
+		{"llama3:8b", 8192},
+		{"unknown-model", 0},
+	}
+	for _, tt := range tests {
+		if got := ContextWindow(tt.model); got != tt.tokens {
+			t.Errorf("ContextWindow(%q) = %d; want %d", tt.model, got, tt.tokens)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		split splitter
+		text  string
+		want  []string
+	}{
+		{"cl100k words", splitCl100k, "Hello world", []string{"Hello", " world"}},
+		{"cl100k contraction", splitCl100k, "don't", []string{"don", "'t"}},
+		{"cl100k numbers", splitCl100k, "12345", []string{"123", "45"}},
+		{"cl100k double space", splitCl100k, "a  b", []string{"a", " ", " b"}},
+		{"cl100k trailing space", splitCl100k, "a  ", []string{"a", "  "}},
+		{"cl100k punctuation", splitCl100k, "hi!\n\nthere", []string{"hi", "!\n\n", "there"}},
+		{"cl100k prefixed word", splitCl100k, "(foo)", []string{"(foo", ")"}},
+		{"o200k contraction", splitO200k, "don't", []string{"don't"}},
+		{"o200k camel case", splitO200k, "HelloWorld", []string{"Hello", "World"}},
+		{"o200k acronym", splitO200k, "HTTPServer", []string{"HTTPServer"}},
+		{"o200k prefixed word", splitO200k, "a.b/c", []string{"a", ".b", "/c"}},
+	}
+	for _, tt := range tests {
+		if got := tt.split(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: split(%q) = %q; want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTiktokenBPE(t *testing.T) {
+	b := &tiktokenBPE{
+		ranks: map[string]int{"a": 0, "b": 1, "c": 2, "ab": 3, "bc": 4, "abc": 5},
+		split: splitCl100k,
+	}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"abc", 1},
+		// a b c a b -> ab c ab -> abc ab
+		{"abcab", 2},
+		// "d" has no rank of its own and stays a single byte.
+		{"abd", 2},
+		{"ab ab", 3},
+	}
+	for _, tt := range tests {
+		if got := b.count(tt.text); got != tt.want {
+			t.Errorf("count(%q) = %d; want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSentencePieceBPE(t *testing.T) {
+	s := &sentencePieceBPE{scores: map[string]float64{
+		"▁": -1, "h": -2, "i": -3, "hi": -1.5, "▁hi": -0.5, "<0xC3>": 0, "<0xA9>": 0,
+	}}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hi", 1},
+		{"hi hi", 2},
+		// "é" isn't in the vocabulary and falls back to its two bytes.
+		{"é", 3},
+	}
+	for _, tt := range tests {
+		if got := s.count(tt.text); got != tt.want {
+			t.Errorf("count(%q) = %d; want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseVocab(t *testing.T) {
+	ranks, err := parseTiktoken([]byte("aGVsbG8= 0\nIHdvcmxk 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"hello": 0, " world": 1}; !reflect.DeepEqual(ranks, want) {
+		t.Errorf("parseTiktoken = %v; want %v", ranks, want)
+	}
+	if _, err := parseTiktoken([]byte("aGVsbG8=\n")); err == nil {
+		t.Error("parseTiktoken accepted a line without a rank")
+	}
+
+	scores, err := parseSentencePieceVocab([]byte("▁the\t-1.5\n<0x0A>\t0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"▁the": -1.5, "<0x0A>": 0}; !reflect.DeepEqual(scores, want) {
+		t.Errorf("parseSentencePieceVocab = %v; want %v", scores, want)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("aGVsbG8= 0\n"))
+	zw.Close()
+	data, err := gunzip(buf.Bytes())
+	if err != nil || string(data) != "aGVsbG8= 0\n" {
+		t.Errorf("gunzip = %q, %v", data, err)
+	}
+}
+
+// TestCountReference checks counts against the reference tokenizers (tiktoken
+// and SentencePiece). Encodings whose vocabulary isn't embedded are skipped.
+func TestCountReference(t *testing.T) {
+	tests := []struct {
+		model string
+		text  string
+		want  int
+	}{
+		{"gpt-4", "hello world", 2},
+		{"gpt-4", "tiktoken is great!", 6},
+		{"gpt-4", "antidisestablishmentarianism", 6},
+		{"gpt-4", "2 + 2 = 4", 7},
+		{"gpt-4", "お誕生日おめでとう", 9},
+		{"gpt-4o", "tiktoken is great!", 6},
+		{"gpt-4o", "antidisestablishmentarianism", 6},
+		{"gpt-4o", "2 + 2 = 4", 7},
+		{"gpt-4o", "お誕生日おめでとう", 8},
+		{"llama3", "Hello world", 2},
+		{"llama3", "hello world", 2},
+		{"llama3", "00000", 2},
+		{"llama3", "0000000", 3},
+		{"llama2", "Hello world", 2},
+		{"llama2", "こんにちは", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model+"/"+tt.text, func(t *testing.T) {
+			enc, _ := EncodingForModel(tt.model)
+			if load(enc) == nil {
+				t.Skipf("vocabulary %s not available", enc)
+			}
+			n, exact := Count(tt.model, tt.text)
+			if n != tt.want || !exact {
+				t.Errorf("Count(%q, %q) = %d, %v; want %d, true", tt.model, tt.text, n, exact, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountInexact(t *testing.T) {
+	// Claude's tokenizer isn't public, so its counts are never exact.
+	n, exact := Count("claude-3-5-sonnet", "Hello, world!")
+	if exact || n <= 0 {
+		t.Errorf("Count = %d, %v; want a positive estimate", n, exact)
+	}
+	if n, _ := Count("claude-3-5-sonnet", ""); n != 0 {
+		t.Errorf("Count of empty text = %d; want 0", n)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		text     string
+		min, max int
+	}{
+		{"hello world", 2, 2},
+		{"The quick brown fox jumps over the lazy dog.", 9, 12},
+		// Nine CJK characters: cl100k gives 9, o200k 8.
+		{"お誕生日おめでとう", 7, 11},
+		{"Привет, мир!", 4, 8},
+	}
+	for _, tt := range tests {
+		if got := estimate(tt.text); got < tt.min || got > tt.max {
+			t.Errorf("estimate(%q) = %d; want %d-%d", tt.text, got, tt.min, tt.max)
+		}
+	}
+}